@@ -13,11 +13,27 @@ import (
 	"github.com/dosquad/go-cliversion"
 	"github.com/na4ma4/go-contextual"
 	"github.com/na4ma4/go-slogtool"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/dedup"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/downlink"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/fanout"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/filter"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/health"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/homeassistant"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/influx"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mainconfig"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqttd"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/outbox"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/radio"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/relay"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/stream"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/topology"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/webhook"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -40,7 +56,7 @@ func init() {
 	_ = viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
 	_ = viper.BindEnv("debug", "DEBUG")
 
-	rootCmd.PersistentFlags().StringP("broker", "b", "tcp://localhost:1883", "Source MQTT broker URL")
+	rootCmd.PersistentFlags().StringP("broker", "b", "tcp://localhost:1883", "MQTT broker URL")
 	_ = viper.BindPFlag("broker.address", rootCmd.PersistentFlags().Lookup("broker"))
 	_ = viper.BindEnv("broker.address", "MQTT_BROKER")
 
@@ -56,14 +72,38 @@ func init() {
 	_ = viper.BindPFlag("broker.password", rootCmd.PersistentFlags().Lookup("password"))
 	_ = viper.BindEnv("broker.password", "MQTT_PASSWORD")
 
+	mainconfig.BindTLSFlags(rootCmd.PersistentFlags())
+	mainconfig.BindBrokerFlags(rootCmd.PersistentFlags(), mainconfig.BrokerSource, "Source")
+	mainconfig.BindBrokerFlags(rootCmd.PersistentFlags(), mainconfig.BrokerDest, "Destination")
+
+	rootCmd.PersistentFlags().String("protocol", "3.1.1", "MQTT protocol version (3.1.1 or 5)")
+	_ = viper.BindPFlag("broker.protocol", rootCmd.PersistentFlags().Lookup("protocol"))
+	_ = viper.BindEnv("broker.protocol", "MQTT_PROTOCOL")
+
+	rootCmd.PersistentFlags().String("shared-group", "", "Subscribe to the topic as a shared subscription in this group (optional)")
+	_ = viper.BindPFlag("broker.shared-group", rootCmd.PersistentFlags().Lookup("shared-group"))
+	_ = viper.BindEnv("broker.shared-group", "MQTT_SHARED_GROUP")
+
 	rootCmd.PersistentFlags().StringP("topic", "t", "msh/ANZ/2/e/#", "MQTT topic to subscribe to")
 	_ = viper.BindPFlag("broker.topic", rootCmd.PersistentFlags().Lookup("topic"))
 	_ = viper.BindEnv("broker.topic", "MQTT_TOPIC")
 
+	rootCmd.PersistentFlags().String("map-topic", "", "MQTT topic map reports are received on (optional)")
+	_ = viper.BindPFlag("broker.map-topic", rootCmd.PersistentFlags().Lookup("map-topic"))
+	_ = viper.BindEnv("broker.map-topic", "MQTT_MAP_TOPIC")
+
 	rootCmd.PersistentFlags().StringP("fanout-topic", "f", "msh/ANZ/fanout/", "Fanout MQTT topic parent (optional)")
 	_ = viper.BindPFlag("fanout.topic", rootCmd.PersistentFlags().Lookup("fanout-topic"))
 	_ = viper.BindEnv("fanout.topic", "FANOUT_TOPIC")
 
+	rootCmd.PersistentFlags().String("topology-topic", "", "Topic the mesh adjacency graph is published to (optional)")
+	_ = viper.BindPFlag("topology.topic", rootCmd.PersistentFlags().Lookup("topology-topic"))
+	_ = viper.BindEnv("topology.topic", "TOPOLOGY_TOPIC")
+
+	rootCmd.PersistentFlags().String("downlink-topic", "", "Topic JSON downlink requests are received on (optional)")
+	_ = viper.BindPFlag("downlink.topic", rootCmd.PersistentFlags().Lookup("downlink-topic"))
+	_ = viper.BindEnv("downlink.topic", "DOWNLINK_TOPIC")
+
 	rootCmd.PersistentFlags().BoolP("dry-run", "n", false, "Dry run mode (optional)")
 	_ = viper.BindPFlag("dry-run", rootCmd.PersistentFlags().Lookup("dry-run"))
 	_ = viper.BindEnv("dry-run", "MQTT_DRY_RUN")
@@ -102,26 +142,81 @@ func mainCmd(_ *cobra.Command, _ []string) error {
 	storeCfg.Logger = logger
 	logger.Debug("Debug logging enabled") // will only show if debug is enabled
 
+	source := mainconfig.GetBroker(mainconfig.BrokerSource)
+	dest := mainconfig.GetBroker(mainconfig.BrokerDest)
+
+	if viper.GetBool("features.embedded-broker") {
+		embedded := mqttd.New(mainconfig.GetEmbeddedBroker(), logger)
+		if err := embedded.Start(ctx); err != nil {
+			logger.ErrorContext(ctx, "Failed to start embedded broker", slogtool.ErrorAttr(err))
+			return fmt.Errorf("%w%w", ErrNoUsage, err)
+		}
+		defer embedded.Stop()
+
+		// Gateways publish to the embedded broker, outputs are published to it too unless a destination broker is
+		// set, so external subscribers can read them.
+		source = source.WithLocal(embedded)
+		if viper.GetString("broker.dest.address") == "" {
+			dest = dest.WithLocal(embedded)
+		}
+	}
+
 	logger.InfoContext(ctx, "Starting Meshtastic MQTT Relay",
-		slog.String("broker.address", viper.GetString("broker.address")),
+		slog.String("broker.source", source.SanitizedAddress()),
+		slog.String("broker.dest", dest.SanitizedAddress()),
 		slog.String("broker.topic", viper.GetString("broker.topic")),
+		slog.String("broker.map-topic", mainconfig.GetMapTopic()),
 		slog.String("fanout.topic", viper.GetString("fanout.topic")),
 		slog.Any("features", getFeatures()),
 		slog.String("version", cliversion.Get().VersionString()),
 		// slog.String("config.file", viper.ConfigFileUsed()),
 	)
 
-	config := relay.Config{
-		Broker:     viper.GetString("broker.address"),
-		ClientID:   viper.GetString("broker.clientid"),
-		Username:   viper.GetString("broker.username"),
-		Password:   viper.GetString("broker.password"),
-		Topic:      viper.GetString("broker.topic"),
-		DryRun:     viper.GetBool("dry-run"),
-		Keepalive:  viper.GetDuration("broker.keepalive"),
-		RetainFlag: viper.GetBool("features.relay-set-retain-flag"),
+	config := pipeline.Config{
+		Source:      source,
+		Dest:        dest,
+		Topic:       viper.GetString("broker.topic"),
+		MapTopic:    mainconfig.GetMapTopic(),
+		SharedGroup: viper.GetString("broker.shared-group"),
+		DryRun:      viper.GetBool("dry-run"),
+		Keyring:     getKeyring(ctx, logger),
+		Metrics:     metrics.New(metrics.WithNodeTTL(viper.GetDuration("metrics.node-ttl"))),
+		Retry: pipeline.RetryConfig{
+			Attempts:   viper.GetInt("publish.retry-attempts"),
+			Backoff:    viper.GetDuration("publish.retry-backoff"),
+			MaxBackoff: viper.GetDuration("publish.retry-max-backoff"),
+		},
+		DeadLetter: pipeline.DeadLetterConfig{
+			Topic: viper.GetString("dead-letter.topic"),
+		},
+	}
+
+	{
+		mode, err := dedup.ParseMode(viper.GetString("dedup.mode"))
+		if err != nil {
+			logger.ErrorContext(ctx, "Invalid de-duplication mode", slogtool.ErrorAttr(err))
+			return fmt.Errorf("%w%w", ErrNoUsage, err)
+		}
+		config.Dedup = dedup.Config{
+			Mode:   mode,
+			Window: viper.GetDuration("dedup.window"),
+		}
+	}
+
+	{
+		policy, err := pipeline.ParseQueuePolicy(viper.GetString("workers.queue-policy"))
+		if err != nil {
+			logger.ErrorContext(ctx, "Invalid worker queue policy", slogtool.ErrorAttr(err))
+			return fmt.Errorf("%w%w", ErrNoUsage, err)
+		}
+		config.Workers = pipeline.WorkerConfig{
+			Workers:   viper.GetInt("workers.count"),
+			QueueSize: viper.GetInt("workers.queue-size"),
+			Policy:    policy,
+		}
 	}
 
+	var messageStore store.Store
 	//nolint:nestif // TODO refactor for simplicity
 	if viper.GetBool("features.message-store") {
 		if st, err := getStore(viper.GetString("store.dsn"), storeCfg); err != nil && !errors.Is(err, ErrEmptyDSN) {
@@ -130,7 +225,7 @@ func mainCmd(_ *cobra.Command, _ []string) error {
 		} else if errors.Is(err, ErrEmptyDSN) {
 			logger.DebugContext(ctx, "No Store DSN set, not archiving messages")
 		} else if st != nil {
-			config.Store = st
+			messageStore = st
 			if logger.Enabled(ctx, slog.LevelInfo) {
 				sanitizedDSN := store.SanitizeURL(store.MustURL(viper.GetString("store.dsn")))
 				logger.InfoContext(ctx, "Store DSN set",
@@ -142,65 +237,179 @@ func mainCmd(_ *cobra.Command, _ []string) error {
 		logger.InfoContext(ctx, "Message store feature disabled, not archiving messages")
 	}
 
-	var foClient *fanout.Fanout
-	if viper.GetBool("features.fanout-relay") {
-		foConfig := fanout.Config{
-			TargetBaseTopic: viper.GetString("fanout.topic"),
-		}
-		foConfig.CopyFromRelayConfig(config)
-		foConfig.ClientID += "-fanout"
-		foConfig.RetainFlag = viper.GetBool("features.fanout-set-retain-flag")
+	if deadLetterStore, ok := messageStore.(store.DeadLetterStore); ok {
+		config.DeadLetter.Store = deadLetterStore
+	}
 
-		var err error
-		foClient, err = fanout.NewFanout(ctx, foConfig, logger)
+	if dir := viper.GetString("outbox.dir"); dir != "" {
+		ob, err := outbox.Open(outbox.Config{
+			Dir:         dir,
+			MaxMessages: viper.GetInt("outbox.max-messages"),
+		})
 		if err != nil {
-			logger.ErrorContext(ctx, "Failed to create fanout relay", slogtool.ErrorAttr(err))
+			logger.ErrorContext(ctx, "Failed to open outbox", slogtool.ErrorAttr(err))
 			return fmt.Errorf("%w%w", ErrNoUsage, err)
 		}
+		config.Outbox = ob
+		logger.InfoContext(ctx, "Outbox enabled",
+			slog.String("outbox.dir", dir),
+			slog.Int("outbox.queued", ob.Len()),
+		)
 	}
 
-	var client *relay.Relay
-	{
-		var err error
-		client, err = relay.NewRelay(ctx, config, logger)
-		if err != nil {
-			logger.ErrorContext(ctx, "Failed to create relay", slogtool.ErrorAttr(err))
+	config.NodeDB = getNodeDB(ctx, logger, messageStore, config.Keyring)
+	go config.NodeDB.Run(ctx)
+	defer func() {
+		if err := config.NodeDB.Flush(context.Background()); err != nil {
+			logger.ErrorContext(ctx, "Failed to flush node database", slogtool.ErrorAttr(err))
+		}
+	}()
+
+	if radioConfig := mainconfig.GetRadio(); radioConfig.Address != "" {
+		// Packets are received from the node instead of the source broker, nodes known to it seed the node database.
+		radioConfig.NodeDB = config.NodeDB
+		config.Source = config.Source.WithLocal(radio.New(radioConfig, logger))
+		logger.InfoContext(ctx, "Radio source enabled",
+			slog.String("radio.address", radioConfig.Address),
+			slog.String("radio.topic", radioConfig.Topic),
+		)
+	}
+
+	config.Topology = topology.NewGraph(topology.Config{
+		NodeDB:   config.NodeDB,
+		Topic:    viper.GetString("topology.topic"),
+		Interval: viper.GetDuration("topology.interval"),
+		MaxAge:   viper.GetDuration("topology.max-age"),
+	})
+
+	if viper.GetString("downlink.topic") != "" {
+		if viper.GetString("downlink.gateway") == "" {
+			logger.ErrorContext(ctx, "Downlink requires a gateway ID", slogtool.ErrorAttr(downlink.ErrNoGateway))
+			return fmt.Errorf("%w%w", ErrNoUsage, downlink.ErrNoGateway)
+		}
+
+		config.Downlink = downlink.NewDownlink(downlink.Config{
+			Topic:     viper.GetString("downlink.topic"),
+			RootTopic: downlink.RootTopic(config.Topic),
+			Channel:   viper.GetString("downlink.channel"),
+			Gateway:   viper.GetString("downlink.gateway"),
+			HopLimit:  viper.GetUint32("downlink.hop-limit"),
+			Keyring:   config.Keyring,
+		})
+		logger.InfoContext(ctx, "Downlink enabled",
+			slog.String("downlink.topic", config.Downlink.Config.Topic),
+			slog.String("downlink.root-topic", config.Downlink.Config.RootTopic),
+		)
+	}
+
+	p, err := pipeline.NewPipeline(ctx, config, logger)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create pipeline", slogtool.ErrorAttr(err))
+		return fmt.Errorf("%w%w", ErrNoUsage, err)
+	}
+
+	telemetryExpiry := viper.GetDuration("broker.telemetry-expiry")
+	var outputs []*pipeline.Output
+	if messageStore != nil {
+		outputs = append(outputs, pipeline.NewStoreOutput(messageStore, config.Metrics))
+	}
+	outputs = append(outputs, relay.NewOutput(p, relay.Config{
+		TelemetryExpiry: telemetryExpiry,
+		RetainFlag:      viper.GetBool("features.relay-set-retain-flag"),
+	}))
+	if viper.GetBool("features.fanout-relay") {
+		outputs = append(outputs, fanout.NewOutput(p, fanout.Config{
+			TelemetryExpiry: telemetryExpiry,
+			TargetBaseTopic: viper.GetString("fanout.topic"),
+			RetainFlag:      viper.GetBool("features.fanout-set-retain-flag"),
+		}))
+	}
+	if viper.GetBool("features.homeassistant-discovery") {
+		if !viper.GetBool("features.fanout-relay") {
+			logger.WarnContext(ctx, "Home Assistant discovery enabled without fanout, entities will have no state")
+		}
+		outputs = append(outputs, homeassistant.NewOutput(p, homeassistant.Config{
+			DiscoveryPrefix: viper.GetString("homeassistant.discovery-prefix"),
+			FanoutTopic:     viper.GetString("fanout.topic"),
+			NodeDB:          config.NodeDB,
+		}))
+	}
+	if viper.GetString("influxdb.url") != "" {
+		w := influx.NewWriter(influx.Config{
+			URL:           viper.GetString("influxdb.url"),
+			Org:           viper.GetString("influxdb.org"),
+			Bucket:        viper.GetString("influxdb.bucket"),
+			Token:         viper.GetString("influxdb.token"),
+			BatchSize:     viper.GetInt("influxdb.batch-size"),
+			FlushInterval: viper.GetDuration("influxdb.flush-interval"),
+			Retry:         config.Retry,
+		}, logger)
+		go w.Run(ctx)
+		defer func() {
+			if err := w.Flush(context.Background()); err != nil {
+				logger.ErrorContext(ctx, "Failed to flush InfluxDB writes", slogtool.ErrorAttr(err))
+			}
+		}()
+		outputs = append(outputs, influx.NewOutput(w))
+		logger.InfoContext(ctx, "InfluxDB output enabled",
+			slog.String("influxdb.url", w.Config.URL),
+			slog.String("influxdb.bucket", w.Config.Bucket),
+		)
+	}
+	var hub *stream.Hub
+	if viper.GetBool("features.stream") {
+		hub = stream.NewHub()
+		outputs = append(outputs, stream.NewOutput(hub))
+	}
+	for _, hook := range mainconfig.GetWebhooks() {
+		h, hookErr := getWebhook(ctx, logger, hook, config.Retry)
+		if hookErr != nil {
+			logger.ErrorContext(ctx, "Invalid webhook", slog.String("webhook", hook.Name), slogtool.ErrorAttr(hookErr))
+			return fmt.Errorf("%w%w", ErrNoUsage, hookErr)
+		}
+		defer func() {
+			if flushErr := h.Flush(context.Background()); flushErr != nil {
+				logger.ErrorContext(ctx, "Failed to flush webhook",
+					slog.String("webhook", hook.Name),
+					slogtool.ErrorAttr(flushErr),
+				)
+			}
+		}()
+		outputs = append(outputs, webhook.NewOutput(h))
+	}
+	for _, out := range outputs {
+		if err = addFilter(ctx, logger, out); err != nil {
+			logger.ErrorContext(ctx, "Invalid filter", slog.String("output", out.Name), slogtool.ErrorAttr(err))
 			return fmt.Errorf("%w%w", ErrNoUsage, err)
 		}
+		p.AddOutput(out)
 	}
 
-	healthErrChan, stopHealthServer := getHealthServer(ctx, logger, client, foClient)
+	healthErrChan, stopHealthServer := getHealthServer(ctx, logger, p,
+		health.WithStore(messageStore),
+		health.WithNodeDB(config.NodeDB),
+		health.WithTopology(config.Topology),
+		health.WithMetrics(config.Metrics),
+		health.WithStream(hub),
+		health.WithStreamOrigins(mainconfig.GetStreamOrigins()...),
+	)
 	defer stopHealthServer()
 
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	clientErrChan := client.Run(ctx)
-	var foClientErrChan <-chan error
-	if foClient != nil {
-		foClientErrChan = foClient.Run(ctx)
-	}
-	defer client.Stop(ctx)
-	if foClient != nil {
-		defer foClient.Stop(ctx)
-	}
+	pipelineErrChan := p.Run(ctx)
+	defer p.Stop(ctx)
 	defer logger.InfoContext(ctx, "Shutting down Meshtastic MQTT Relay")
 
 	for {
 		select {
 		case <-sigChan:
 			return nil
-		case err := <-clientErrChan:
-			if err != nil {
-				logger.ErrorContext(ctx, "Relay error", slogtool.ErrorAttr(err))
-				return fmt.Errorf("%w%w", ErrNoUsage, err)
-			}
-
-			return nil
-		case err := <-foClientErrChan:
+		case err := <-pipelineErrChan:
 			if err != nil {
-				logger.ErrorContext(ctx, "Fanout client error", slogtool.ErrorAttr(err))
+				logger.ErrorContext(ctx, "Pipeline error", slogtool.ErrorAttr(err))
 				return fmt.Errorf("%w%w", ErrNoUsage, err)
 			}
 
@@ -234,11 +443,11 @@ func getStore(dsn string, cfg store.Config) (store.Store, error) {
 func getHealthServer(
 	ctx context.Context,
 	logger *slog.Logger,
-	client *relay.Relay,
-	fanout *fanout.Fanout,
+	p *pipeline.Pipeline,
+	opts ...health.OptionFunc,
 ) (<-chan error, func()) {
 	if viper.GetInt("healthcheck.port") > 0 {
-		healthServer := health.NewServer(viper.GetInt("healthcheck.port"), logger, client, fanout)
+		healthServer := health.NewServer(viper.GetInt("healthcheck.port"), logger, p, opts...)
 		return healthServer.Start(), func() {
 			if err := healthServer.Stop(ctx); err != nil {
 				logger.ErrorContext(ctx, "Failed to stop health server", slogtool.ErrorAttr(err))
@@ -252,9 +461,130 @@ func getHealthServer(
 	}
 }
 
+func getKeyring(ctx context.Context, logger *slog.Logger) *meshcrypto.Keyring {
+	keyring := meshcrypto.NewKeyring()
+	for _, channel := range mainconfig.GetChannels() {
+		if err := keyring.AddChannel(channel.Name, channel.Key); err != nil {
+			logger.ErrorContext(ctx, "Failed to add channel key",
+				slog.String("channel", channel.Name),
+				slogtool.ErrorAttr(err),
+			)
+			continue
+		}
+		logger.DebugContext(ctx, "Added channel key", slog.String("channel", channel.Name))
+	}
+
+	if viper.GetString("pki.private-key") != "" {
+		node, err := mtypes.ParseNodeID(viper.GetString("pki.node-id"))
+		if err != nil {
+			logger.ErrorContext(ctx, "Failed to parse PKI node ID", slogtool.ErrorAttr(err))
+			return keyring
+		}
+
+		if err = keyring.AddPrivateKey(node, viper.GetString("pki.private-key")); err != nil {
+			logger.ErrorContext(ctx, "Failed to add PKI private key", slogtool.ErrorAttr(err))
+			return keyring
+		}
+
+		logger.InfoContext(ctx, "Added PKI private key", slog.String("pki.node-id", mtypes.FormatNodeID(node)))
+	}
+
+	return keyring
+}
+
+func getNodeDB(
+	ctx context.Context,
+	logger *slog.Logger,
+	st store.Store,
+	keyring *meshcrypto.Keyring,
+) *nodedb.DB {
+	cfg := nodedb.Config{
+		FlushInterval: viper.GetDuration("nodedb.flush-interval"),
+	}
+	if nodeStore, ok := st.(store.NodeStore); ok {
+		cfg.Store = nodeStore
+	}
+
+	db := nodedb.NewDB(cfg, logger)
+	if err := db.Load(ctx); err != nil {
+		logger.ErrorContext(ctx, "Failed to load node database", slogtool.ErrorAttr(err))
+	}
+
+	for _, node := range db.List() {
+		keyring.SetPublicKey(node.Num, node.PublicKey)
+	}
+
+	return db
+}
+
+// addFilter appends the filter configured for the output (e.g. filter.relay) to its stages.
+func addFilter(ctx context.Context, logger *slog.Logger, out *pipeline.Output) error {
+	expr := viper.GetString("filter." + out.Name)
+	if expr == "" {
+		return nil
+	}
+
+	f, err := filter.Compile(expr)
+	if err != nil {
+		return err
+	}
+
+	out.Stages = append(out.Stages, pipeline.Filter(f))
+	logger.InfoContext(ctx, "Output filter enabled",
+		slog.String("output", out.Name),
+		slog.String("filter", f.String()),
+	)
+
+	return nil
+}
+
+// getWebhook returns the webhook, running in the background until the context is done.
+func getWebhook(
+	ctx context.Context,
+	logger *slog.Logger,
+	hook mainconfig.Webhook,
+	retry pipeline.RetryConfig,
+) (*webhook.Webhook, error) {
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+
+	var f *filter.Filter
+	if hook.Filter != "" {
+		if f, err = filter.Compile(hook.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	h := webhook.New(webhook.Config{
+		Name:          hook.Name,
+		URL:           hook.URL,
+		Headers:       hook.Headers,
+		Secret:        hook.Secret,
+		Filter:        f,
+		Timeout:       hook.Timeout,
+		QueueSize:     hook.QueueSize,
+		BatchSize:     hook.BatchSize,
+		BatchInterval: hook.BatchInterval,
+		Retry:         retry,
+	}, logger)
+	go h.Run(ctx)
+
+	logger.InfoContext(ctx, "Webhook enabled",
+		slog.String("webhook", hook.Name),
+		slog.String("url", store.SanitizeURL(u).String()),
+	)
+
+	return h, nil
+}
+
 func getFeatures() map[string]bool {
 	features := make(map[string]bool)
 	features["fanout-relay"] = viper.GetBool("features.fanout-relay")
 	features["message-store"] = viper.GetBool("features.message-store")
+	features["homeassistant-discovery"] = viper.GetBool("features.homeassistant-discovery")
+	features["stream"] = viper.GetBool("features.stream")
+	features["embedded-broker"] = viper.GetBool("features.embedded-broker")
 	return features
 }
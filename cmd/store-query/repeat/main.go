@@ -9,9 +9,9 @@ import (
 	"os"
 
 	"github.com/dosquad/go-cliversion"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/na4ma4/go-contextual"
 	"github.com/na4ma4/go-slogtool"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/cmdconst"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mainconfig"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
@@ -37,7 +37,7 @@ func init() {
 	_ = viper.BindPFlag("debug", CmdRepeat.PersistentFlags().Lookup("debug"))
 	_ = viper.BindEnv("debug", "DEBUG")
 
-	CmdRepeat.PersistentFlags().StringP("broker", "b", "tcp://localhost:1883", "Source MQTT broker URL")
+	CmdRepeat.PersistentFlags().StringP("broker", "b", "tcp://localhost:1883", "MQTT broker URL")
 	_ = viper.BindPFlag("broker.address", CmdRepeat.PersistentFlags().Lookup("broker"))
 	_ = viper.BindEnv("broker.address", "MQTT_BROKER")
 
@@ -53,6 +53,9 @@ func init() {
 	_ = viper.BindPFlag("broker.password", CmdRepeat.PersistentFlags().Lookup("password"))
 	_ = viper.BindEnv("broker.password", "MQTT_PASSWORD")
 
+	mainconfig.BindTLSFlags(CmdRepeat.PersistentFlags())
+	mainconfig.BindBrokerFlags(CmdRepeat.PersistentFlags(), mainconfig.BrokerDest, "Destination")
+
 	CmdRepeat.PersistentFlags().StringP("topic", "t", "msh/ANZ/2/e/#", "MQTT topic to subscribe to")
 	_ = viper.BindPFlag("broker.topic", CmdRepeat.PersistentFlags().Lookup("topic"))
 	_ = viper.BindEnv("broker.topic", "MQTT_TOPIC")
@@ -117,19 +120,17 @@ func repeatCmd(_ *cobra.Command, args []string) error {
 	}
 	defer func() {
 		if destClient != nil && destClient.IsConnected() {
-			destClient.Disconnect(cmdconst.DefaultQuiesceInMilliseconds)
+			destClient.Disconnect()
 			logger.InfoContext(ctx, "Disconnected from destination MQTT broker")
 		}
 	}()
 
-	token := destClient.Publish(
-		viper.GetString("broker.topic"),
-		0, false, msg,
-	)
-
-	if token.Wait() && token.Error() != nil {
-		logger.ErrorContext(ctx, "Failed to publish to destination", slogtool.ErrorAttr(token.Error()))
-		return fmt.Errorf("failed to publish to destination: %w", token.Error())
+	if err = destClient.Publish(ctx, &broker.Message{
+		Topic:   viper.GetString("broker.topic"),
+		Payload: msg,
+	}); err != nil {
+		logger.ErrorContext(ctx, "Failed to publish to destination", slogtool.ErrorAttr(err))
+		return fmt.Errorf("failed to publish to destination: %w", err)
 	}
 
 	logger.InfoContext(ctx, "Published message to destination broker",
@@ -158,37 +159,24 @@ func getStore(dsn string, cfg store.Config) (store.Store, error) {
 func connectDest(
 	ctx context.Context,
 	logger *slog.Logger,
-) (mqtt.Client, error) {
+) (broker.Client, error) {
 	defer logger.DebugContext(ctx, "connectDest(): finished")
 	logger.DebugContext(ctx, "connectDest(): starting")
 
-	destOpts := mqtt.NewClientOptions().
-		AddBroker(viper.GetString("broker.address")).
-		SetClientID(viper.GetString("broker.clientid") + "-dest").
-		SetAutoReconnect(true).
-		SetOrderMatters(false).
-		SetKeepAlive(viper.GetDuration("broker.keepalive"))
-
-	if viper.GetString("broker.username") != "" {
-		destOpts.SetUsername(viper.GetString("broker.username"))
-		destOpts.SetPassword(viper.GetString("broker.password"))
-		destOpts.SetAutoReconnect(true)
+	destClient, err := mainconfig.GetBroker(mainconfig.BrokerDest).NewClient(broker.Hooks{})
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to configure destination broker", slogtool.ErrorAttr(err))
+		return nil, err
 	}
 
-	destClient := mqtt.NewClient(destOpts)
-	token := destClient.Connect()
-
-	select {
-	case <-ctx.Done():
-		logger.InfoContext(ctx, "Context done before source broker connected")
-		return nil, ctx.Err()
-	case <-token.Done():
-		// continue
-	}
+	if err = destClient.Connect(ctx); err != nil {
+		if ctx.Err() != nil {
+			logger.InfoContext(ctx, "Context done before destination broker connected")
+			return nil, ctx.Err()
+		}
 
-	if token.Error() != nil {
-		logger.ErrorContext(ctx, "Failed to connect to destination broker", slogtool.ErrorAttr(token.Error()))
-		return nil, token.Error()
+		logger.ErrorContext(ctx, "Failed to connect to destination broker", slogtool.ErrorAttr(err))
+		return nil, err
 	}
 
 	return destClient, nil
@@ -0,0 +1,261 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/cmdconst"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqtt5"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// ProtocolV311 is MQTT 3.1.1, the default protocol.
+	ProtocolV311 = "3.1.1"
+	// ProtocolV5 is MQTT 5.
+	ProtocolV5 = "5"
+)
+
+// ErrUnknownProtocol is returned for a protocol other than ProtocolV311 or ProtocolV5.
+var ErrUnknownProtocol = errors.New("unknown MQTT protocol version")
+
+// UserProperty is an MQTT 5 user property.
+type UserProperty = mqtt5.UserProperty
+
+// Properties are the MQTT 5 properties of a message, they are not sent by MQTT 3.1.1 clients.
+type Properties = mqtt5.Properties
+
+// Message is a message received from or published to a broker.
+type Message struct {
+	Topic      string
+	Payload    []byte
+	Retain     bool
+	Properties Properties
+}
+
+// Handler handles a message received from a broker.
+type Handler func(msg *Message)
+
+// Hooks are called on connection events, all are optional.
+type Hooks struct {
+	// OnConnect is called each time the client connects, including reconnects.
+	OnConnect func(client Client)
+	// OnReconnecting is called before each reconnection attempt.
+	OnReconnecting func()
+	// OnMessage is called for each message received on a subscription, one at a time in the order they are received.
+	// Blocking slows the client down, so it applies backpressure to the broker.
+	OnMessage Handler
+	// Concurrent calls OnMessage in a new goroutine for each message instead, so a slow handler does not stall the
+	// client, messages may then be handled out of order.
+	Concurrent bool
+}
+
+// Deliver passes a message received on a subscription to OnMessage, in a new goroutine if Concurrent is set.
+func (h Hooks) Deliver(msg *Message) {
+	switch {
+	case h.OnMessage == nil:
+	case h.Concurrent:
+		go h.OnMessage(msg)
+	default:
+		h.OnMessage(msg)
+	}
+}
+
+// Client is a connection to an MQTT broker, it reconnects automatically once connected.
+type Client interface {
+	// Connect connects to the broker.
+	Connect(ctx context.Context) error
+	// IsConnected returns true if the client is connected to the broker.
+	IsConnected() bool
+	// Subscribe subscribes to a topic at QoS 0, messages are passed to Hooks.OnMessage.
+	Subscribe(ctx context.Context, topic string) error
+	// Publish publishes a message at QoS 0.
+	Publish(ctx context.Context, msg *Message) error
+	// Disconnect disconnects from the broker and stops reconnecting.
+	Disconnect()
+}
+
+// Local is an in-process broker, its clients are connected without a network hop.
+type Local interface {
+	// NewClient returns a client of the broker.
+	NewClient(clientID string, hooks Hooks) Client
+	// Address returns the address reported for broker configs using the broker, e.g. LocalAddress.
+	Address() string
+}
+
+// NewClient returns a client for the broker using the configured protocol, or a client of the local broker if set.
+func (c Config) NewClient(hooks Hooks) (Client, error) {
+	if c.Local != nil {
+		return c.Local.NewClient(c.ClientID, hooks), nil
+	}
+
+	switch c.Protocol {
+	case "", ProtocolV311:
+		return c.newPahoClient(hooks)
+	case ProtocolV5:
+		return c.newV5Client(hooks)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProtocol, c.Protocol)
+	}
+}
+
+// pahoClient is an MQTT 3.1.1 client.
+type pahoClient struct {
+	client mqtt.Client
+	hooks  Hooks
+}
+
+func (c Config) newPahoClient(hooks Hooks) (Client, error) {
+	opts, err := c.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	// paho calls the subscription handler in a new goroutine for each message unless order matters.
+	opts.SetOrderMatters(!hooks.Concurrent)
+
+	p := &pahoClient{hooks: hooks}
+	if hooks.OnConnect != nil {
+		opts.SetOnConnectHandler(func(mqtt.Client) {
+			hooks.OnConnect(p)
+		})
+	}
+	if hooks.OnReconnecting != nil {
+		opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+			hooks.OnReconnecting()
+		})
+	}
+
+	p.client = mqtt.NewClient(opts)
+
+	return p, nil
+}
+
+func (p *pahoClient) Connect(ctx context.Context) error {
+	return wait(ctx, p.client.Connect())
+}
+
+func (p *pahoClient) IsConnected() bool {
+	return p.client.IsConnected()
+}
+
+func (p *pahoClient) Subscribe(ctx context.Context, topic string) error {
+	return wait(ctx, p.client.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+		defer msg.Ack()
+		if p.hooks.OnMessage != nil {
+			p.hooks.OnMessage(&Message{
+				Topic:   msg.Topic(),
+				Payload: msg.Payload(),
+				Retain:  msg.Retained(),
+			})
+		}
+	}))
+}
+
+func (p *pahoClient) Publish(ctx context.Context, msg *Message) error {
+	return wait(ctx, p.client.Publish(msg.Topic, 0, msg.Retain, msg.Payload))
+}
+
+func (p *pahoClient) Disconnect() {
+	if p.client.IsConnected() {
+		p.client.Disconnect(cmdconst.DefaultQuiesceInMilliseconds)
+	}
+}
+
+// wait waits for the token to complete or the context to be done.
+func wait(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-token.Done():
+		return token.Error()
+	}
+}
+
+// v5Client is an MQTT 5 client.
+type v5Client struct {
+	client *mqtt5.Client
+}
+
+func (c Config) newV5Client(hooks Hooks) (Client, error) {
+	opts := mqtt5.Options{
+		Address:        c.Address,
+		ClientID:       c.ClientID,
+		Username:       c.Username,
+		Password:       c.Password,
+		Keepalive:      c.Keepalive,
+		OnReconnecting: hooks.OnReconnecting,
+	}
+
+	u, err := url.Parse(c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse broker address: %w", err)
+	}
+
+	if c.TLS.Enabled() {
+		if opts.TLSConfig, err = c.TLS.Build(u.Hostname()); err != nil {
+			return nil, fmt.Errorf("unable to configure TLS for %s: %w", c.SanitizedAddress(), err)
+		}
+	}
+
+	v := &v5Client{}
+	if hooks.OnConnect != nil {
+		opts.OnConnect = func() {
+			hooks.OnConnect(v)
+		}
+	}
+	if hooks.OnMessage != nil {
+		opts.OnMessage = func(pub *mqtt5.Publish) {
+			hooks.Deliver(&Message{
+				Topic:      pub.Topic,
+				Payload:    pub.Payload,
+				Retain:     pub.Retain,
+				Properties: pub.Properties,
+			})
+		}
+	}
+
+	v.client = mqtt5.NewClient(opts)
+
+	return v, nil
+}
+
+func (v *v5Client) Connect(ctx context.Context) error {
+	return v.client.Connect(ctx)
+}
+
+func (v *v5Client) IsConnected() bool {
+	return v.client.IsConnected()
+}
+
+func (v *v5Client) Subscribe(ctx context.Context, topic string) error {
+	return v.client.Subscribe(ctx, topic, 0)
+}
+
+func (v *v5Client) Publish(ctx context.Context, msg *Message) error {
+	return v.client.Publish(ctx, &mqtt5.Publish{
+		Topic:      msg.Topic,
+		Payload:    msg.Payload,
+		Retain:     msg.Retain,
+		Properties: msg.Properties,
+	})
+}
+
+func (v *v5Client) Disconnect() {
+	v.client.Disconnect()
+}
+
+// SharedTopic returns the topic for a shared subscription in group, or topic if group is empty.
+//
+// Messages on a shared subscription are delivered to one subscriber in the group, so multiple instances can split
+// the messages on a topic between them.
+func SharedTopic(group, topic string) string {
+	if group == "" {
+		return topic
+	}
+
+	return "$share/" + group + "/" + topic
+}
@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Config holds the connection configuration for a single MQTT broker.
+type Config struct {
+	Address   string
+	ClientID  string
+	Username  string
+	Password  string
+	Keepalive time.Duration
+	TLS       TLSConfig
+	// Protocol is the MQTT protocol version, ProtocolV311 (default) or ProtocolV5.
+	Protocol string
+	// Local is an in-process broker used instead of connecting to Address, optional.
+	Local Local
+}
+
+// LocalAddress is the address of a broker config using the embedded broker.
+const LocalAddress = "embedded"
+
+// WithLocal returns a copy of the config connecting to the local broker, the address is set to the address of the
+// local broker.
+func (c Config) WithLocal(local Local) Config {
+	c.Address = local.Address()
+	c.Local = local
+	return c
+}
+
+// WithClientIDSuffix returns a copy of the config with suffix appended to the client ID.
+func (c Config) WithClientIDSuffix(suffix string) Config {
+	c.ClientID += suffix
+	return c
+}
+
+// ClientOptions returns the MQTT 3.1.1 client options for connecting to the broker.
+func (c Config) ClientOptions() (*mqtt.ClientOptions, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(c.Address).
+		SetClientID(c.ClientID).
+		SetAutoReconnect(true).
+		SetKeepAlive(c.Keepalive)
+
+	if c.Username != "" {
+		opts.SetUsername(c.Username)
+		opts.SetPassword(c.Password)
+	}
+
+	if c.TLS.Enabled() {
+		var serverName string
+		if u, err := url.Parse(c.Address); err == nil {
+			serverName = u.Hostname()
+		}
+
+		tlsConfig, err := c.TLS.Build(serverName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure TLS for %s: %w", c.SanitizedAddress(), err)
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return opts, nil
+}
+
+// SanitizedAddress returns the broker address with any password in the URL masked.
+func (c Config) SanitizedAddress() string {
+	u, err := url.Parse(c.Address)
+	if err != nil || u.User == nil {
+		return c.Address
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "****")
+	}
+
+	return u.String()
+}
+
+// Status is the connection status of a broker.
+type Status struct {
+	Address   string `json:"address"`
+	ClientID  string `json:"client_id"`
+	Protocol  string `json:"protocol"`
+	Connected bool   `json:"connected"`
+}
+
+// Status returns the connection status of client, which is expected to be connected to this broker.
+func (c Config) Status(client Client) Status {
+	return Status{
+		Address:   c.SanitizedAddress(),
+		ClientID:  c.ClientID,
+		Protocol:  cmp.Or(c.Protocol, ProtocolV311),
+		Connected: client != nil && client.IsConnected(),
+	}
+}
@@ -0,0 +1,192 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoCertificates is returned when a CA file contains no PEM encoded certificates.
+	ErrNoCertificates = errors.New("no certificates found")
+	// ErrCertKeyPair is returned when only one of the client certificate and key is set.
+	ErrCertKeyPair = errors.New("TLS client certificate and key must be set together")
+	// ErrNoPeerCertificates is returned when the broker does not present a certificate.
+	ErrNoPeerCertificates = errors.New("broker presented no certificates")
+)
+
+// TLSConfig holds the TLS settings for a broker connection, used with ssl://, tls://, mqtts:// and wss:// addresses.
+type TLSConfig struct {
+	// CAFile is the PEM encoded CA bundle used to verify the broker, the system roots are used if empty.
+	CAFile string
+	// CertFile is the PEM encoded client certificate for mutual TLS, optional.
+	CertFile string
+	// KeyFile is the PEM encoded client key for mutual TLS, required with CertFile.
+	KeyFile string
+	// ServerName overrides the server name used to verify the broker certificate, optional.
+	ServerName string
+	// InsecureSkipVerify disables verification of the broker certificate.
+	InsecureSkipVerify bool
+}
+
+// Enabled returns true if any TLS settings are set.
+func (c TLSConfig) Enabled() bool {
+	return c != TLSConfig{}
+}
+
+// Build returns the TLS configuration for connecting to a broker, serverName is used to verify the broker
+// certificate unless overridden by ServerName.
+//
+// The CA bundle and client certificate are re-read on the next handshake after the files change, so rotated
+// certificates are used when the client reconnects without restarting.
+func (c TLSConfig) Build(serverName string) (*tls.Config, error) {
+	if c.CertFile != "" && c.KeyFile == "" || c.CertFile == "" && c.KeyFile != "" {
+		return nil, ErrCertKeyPair
+	}
+
+	if c.ServerName != "" {
+		serverName = c.ServerName
+	}
+
+	r := &certReloader{config: c}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec // explicitly configured.
+	}
+
+	if c.CertFile != "" {
+		tlsConfig.GetClientCertificate = r.getClientCertificate
+	}
+
+	if c.CAFile != "" && !c.InsecureSkipVerify {
+		// Verification is done in VerifyConnection so the reloaded CA bundle is used for each handshake.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verifyConnection(cs, serverName)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// certReloader holds the CA bundle and client certificate, reloading them when the files are modified.
+type certReloader struct {
+	config  TLSConfig
+	lock    sync.Mutex
+	modTime map[string]time.Time
+	roots   *x509.CertPool
+	cert    *tls.Certificate
+}
+
+// changed returns true if any of the files have been modified since they were last loaded.
+func (r *certReloader) changed() bool {
+	for _, name := range []string{r.config.CAFile, r.config.CertFile, r.config.KeyFile} {
+		if name == "" {
+			continue
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			// keep using the loaded files until they are replaced.
+			continue
+		}
+
+		if !info.ModTime().Equal(r.modTime[name]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reload loads the CA bundle and client certificate, the lock is expected to be held or not yet shared.
+func (r *certReloader) reload() error {
+	modTime := map[string]time.Time{}
+	for _, name := range []string{r.config.CAFile, r.config.CertFile, r.config.KeyFile} {
+		if name == "" {
+			continue
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("unable to read TLS file: %w", err)
+		}
+		modTime[name] = info.ModTime()
+	}
+
+	if r.config.CAFile != "" {
+		data, err := os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read TLS CA file: %w", err)
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("unable to load TLS CA file %s: %w", r.config.CAFile, ErrNoCertificates)
+		}
+		r.roots = roots
+	}
+
+	if r.config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load TLS client certificate: %w", err)
+		}
+		r.cert = &cert
+	}
+
+	r.modTime = modTime
+
+	return nil
+}
+
+// current returns the CA bundle and client certificate, reloading them if the files have changed.
+//
+// If reloading fails, for example when only one of the certificate and key has been replaced so far, the
+// previously loaded files are used.
+func (r *certReloader) current() (*x509.CertPool, *tls.Certificate) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.changed() {
+		_ = r.reload()
+	}
+
+	return r.roots, r.cert
+}
+
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	_, cert := r.current()
+	return cert, nil
+}
+
+func (r *certReloader) verifyConnection(cs tls.ConnectionState, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return ErrNoPeerCertificates
+	}
+
+	roots, _ := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return fmt.Errorf("unable to verify broker certificate: %w", err)
+	}
+
+	return nil
+}
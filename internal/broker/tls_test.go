@@ -0,0 +1,221 @@
+package broker_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, serial int64, parent *testCert, isCA bool, dnsNames ...string) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              dnsNames,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error: %v", err)
+	}
+
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// write writes the certificate and key to dir, setting the modification time to modTime.
+func (c *testCert) write(t *testing.T, dir, name string, modTime time.Time) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error: %v", err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: c.cert.Raw},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err = os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatalf("WriteFile() error: %v", err)
+		}
+		if err = os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatalf("Chtimes() error: %v", err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+// startServer starts a TLS server requiring a client certificate signed by ca, returning its address and a
+// channel of the client certificate serial numbers.
+func startServer(t *testing.T, ca, server *testCert) (string, <-chan int64) {
+	t.Helper()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	serials := make(chan int64, 10)
+	go func() {
+		for {
+			conn, acceptErr := ln.Accept()
+			if acceptErr != nil {
+				return
+			}
+
+			tlsConn, _ := conn.(*tls.Conn)
+			if tlsConn.Handshake() == nil {
+				serials <- tlsConn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return ln.Addr().String(), serials
+}
+
+func dial(addr string, cfg *tls.Config) error {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Handshake()
+}
+
+func TestTLSMutualAuthAndReload(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Minute)
+
+	ca := newTestCert(t, 1, nil, true)
+	caFile, _ := ca.write(t, dir, "ca", modTime)
+	addr, serials := startServer(t, ca, newTestCert(t, 2, ca, false, "broker.example.com"))
+
+	certFile, keyFile := newTestCert(t, 10, ca, false).write(t, dir, "client", modTime)
+
+	cfg, err := broker.TLSConfig{
+		CAFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "broker.example.com",
+	}.Build("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Build() error: %v", err)
+	}
+
+	if err = dial(addr, cfg); err != nil {
+		t.Fatalf("dial() error: %v", err)
+	}
+	if serial := <-serials; serial != 10 {
+		t.Errorf("client certificate serial: got %d, want 10", serial)
+	}
+
+	// Rotate the client certificate, the next handshake should use it without rebuilding the config.
+	newTestCert(t, 11, ca, false).write(t, dir, "client", time.Now())
+
+	if err = dial(addr, cfg); err != nil {
+		t.Fatalf("dial() after rotation error: %v", err)
+	}
+	if serial := <-serials; serial != 11 {
+		t.Errorf("client certificate serial after rotation: got %d, want 11", serial)
+	}
+}
+
+func TestTLSUnknownCA(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, 1, nil, true)
+	addr, _ := startServer(t, ca, newTestCert(t, 2, ca, false, "broker.example.com"))
+
+	otherCAFile, _ := newTestCert(t, 3, nil, true).write(t, dir, "other-ca", time.Now())
+
+	cfg, err := broker.TLSConfig{CAFile: otherCAFile}.Build("broker.example.com")
+	if err != nil {
+		t.Fatalf("Build() error: %v", err)
+	}
+
+	var unknown x509.UnknownAuthorityError
+	if err = dial(addr, cfg); !errors.As(err, &unknown) {
+		t.Errorf("dial() error: got %v, want x509.UnknownAuthorityError", err)
+	}
+}
+
+func TestTLSServerNameMismatch(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, 1, nil, true)
+	caFile, _ := ca.write(t, dir, "ca", time.Now())
+	addr, _ := startServer(t, ca, newTestCert(t, 2, ca, false, "broker.example.com"))
+
+	cfg, err := broker.TLSConfig{CAFile: caFile}.Build("other.example.com")
+	if err != nil {
+		t.Fatalf("Build() error: %v", err)
+	}
+
+	var hostname x509.HostnameError
+	if err = dial(addr, cfg); !errors.As(err, &hostname) {
+		t.Errorf("dial() error: got %v, want x509.HostnameError", err)
+	}
+}
+
+func TestTLSCertKeyPair(t *testing.T) {
+	if _, err := (broker.TLSConfig{CertFile: "client.crt"}).Build(""); !errors.Is(err, broker.ErrCertKeyPair) {
+		t.Errorf("Build() error: got %v, want %v", err, broker.ErrCertKeyPair)
+	}
+}
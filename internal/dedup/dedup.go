@@ -0,0 +1,229 @@
+package dedup
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+// Mode is the de-duplication mode.
+type Mode string
+
+const (
+	// ModeOff disables de-duplication, every copy of a packet is emitted.
+	ModeOff Mode = ""
+	// ModeImmediate emits the first copy of a packet immediately and suppresses repeats.
+	ModeImmediate Mode = "immediate"
+	// ModeSettle emits a packet once, after the window, with every gateway that received it.
+	ModeSettle Mode = "settle"
+)
+
+const (
+	// DefaultWindow is the default time a packet is tracked for duplicates.
+	DefaultWindow = 10 * time.Second
+
+	// minSweepInterval is the minimum interval between sweeps of the cache.
+	minSweepInterval = 100 * time.Millisecond
+	// sweepsPerWindow is the number of sweeps of the cache per window.
+	sweepsPerWindow = 4
+)
+
+// ErrInvalidMode is returned when parsing an unknown de-duplication mode.
+var ErrInvalidMode = errors.New("invalid de-duplication mode")
+
+// ParseMode parses a de-duplication mode, accepting "off" or "" to disable de-duplication.
+func ParseMode(in string) (Mode, error) {
+	switch Mode(in) {
+	case ModeOff, "off":
+		return ModeOff, nil
+	case ModeImmediate, ModeSettle:
+		return Mode(in), nil
+	default:
+		return ModeOff, fmt.Errorf("%w: %q", ErrInvalidMode, in)
+	}
+}
+
+// Config holds the de-duplication configuration.
+type Config struct {
+	// Mode is the de-duplication mode.
+	Mode Mode
+	// Window is the time a packet is tracked for duplicates after the first copy is received.
+	Window time.Duration
+}
+
+// Packet is a decoded packet received from a gateway.
+type Packet struct {
+	Topic    string
+	Payload  []byte
+	Envelope *meshtastic.ServiceEnvelope
+	Message  *mtypes.Message
+
+	genericOnce    sync.Once
+	genericMessage map[string]any
+	genericPayload any
+}
+
+// Generic returns the message and its payload as the generic types produced by decoding JSON.
+//
+// The conversion is done once per packet and shared by every output, so the message must not be changed after it
+// is first called.
+func (p *Packet) Generic() (map[string]any, any) {
+	p.genericOnce.Do(func() {
+		p.genericMessage, _ = mtypes.Generic(p.Message).(map[string]any)
+		if p.genericMessage == nil {
+			p.genericMessage = map[string]any{}
+		}
+		p.genericPayload = p.genericMessage["payload"]
+	})
+
+	return p.genericMessage, p.genericPayload
+}
+
+// EmitFunc is called with a packet when it is ready to be published.
+type EmitFunc func(ctx context.Context, pkt *Packet)
+
+type key struct {
+	from uint32
+	id   uint32
+}
+
+type entry struct {
+	packet  *Packet
+	seq     uint64
+	expires time.Time
+	emitted bool
+}
+
+// Cache is a time-windowed cache of packets keyed on the sending node and packet ID.
+type Cache struct {
+	Config  Config
+	emit    EmitFunc
+	lock    sync.Mutex
+	seq     uint64
+	entries map[key]*entry
+}
+
+// NewCache creates a new de-duplication cache that calls emit for every packet to publish.
+func NewCache(config Config, emit EmitFunc) *Cache {
+	if config.Window <= 0 {
+		config.Window = DefaultWindow
+	}
+
+	return &Cache{
+		Config:  config,
+		emit:    emit,
+		entries: map[key]*entry{},
+	}
+}
+
+// Add records a packet, returning false if it is a duplicate of a packet already seen within the window.
+//
+// In settle mode the gateway details of duplicates are added to the first copy before it is emitted.
+func (c *Cache) Add(ctx context.Context, pkt *Packet) bool {
+	msg := pkt.Message
+	if msg.ID == 0 {
+		msg.Gateways = []mtypes.Gateway{gateway(msg)}
+		c.emit(ctx, pkt)
+		return true
+	}
+
+	k := key{from: msg.From, id: msg.ID}
+	now := time.Now()
+
+	c.lock.Lock()
+	if e, ok := c.entries[k]; ok && now.Before(e.expires) {
+		if !e.emitted {
+			e.packet.Message.Gateways = append(e.packet.Message.Gateways, gateway(msg))
+		}
+		c.lock.Unlock()
+		return false
+	}
+
+	msg.Gateways = []mtypes.Gateway{gateway(msg)}
+	c.seq++
+	e := &entry{
+		packet:  pkt,
+		seq:     c.seq,
+		expires: now.Add(c.Config.Window),
+		emitted: c.Config.Mode == ModeImmediate,
+	}
+	c.entries[k] = e
+	c.lock.Unlock()
+
+	if e.emitted {
+		c.emit(ctx, pkt)
+	}
+
+	return true
+}
+
+// Len returns the number of packets being tracked.
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.entries)
+}
+
+// Sweep removes packets whose window has passed at the given time, emitting them in settle mode.
+//
+// Packets are emitted in the order they were first received, so packets from a node keep their order.
+func (c *Cache) Sweep(ctx context.Context, now time.Time) {
+	c.lock.Lock()
+	var pending []*entry
+	for k, e := range c.entries {
+		if now.Before(e.expires) {
+			continue
+		}
+		if !e.emitted {
+			pending = append(pending, e)
+		}
+		delete(c.entries, k)
+	}
+	c.lock.Unlock()
+
+	slices.SortFunc(pending, func(a, b *entry) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	for _, e := range pending {
+		c.emit(ctx, e.packet)
+	}
+}
+
+// Flush emits every packet still waiting for its window to pass and clears the cache.
+func (c *Cache) Flush(ctx context.Context) {
+	c.Sweep(ctx, time.Now().Add(c.Config.Window))
+}
+
+// Run periodically sweeps the cache until the context is done.
+func (c *Cache) Run(ctx context.Context) {
+	ticker := time.NewTicker(max(c.Config.Window/sweepsPerWindow, minSweepInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.Sweep(ctx, now)
+		}
+	}
+}
+
+// gateway returns the details of the gateway that received the message.
+func gateway(msg *mtypes.Message) mtypes.Gateway {
+	return mtypes.Gateway{
+		ID:       msg.Sender,
+		RSSI:     msg.RSSI,
+		SNR:      msg.SNR,
+		HopsAway: msg.HopsAway,
+	}
+}
@@ -0,0 +1,207 @@
+package dedup_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/dedup"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+)
+
+type recorder struct {
+	lock    sync.Mutex
+	packets []*dedup.Packet
+}
+
+func (r *recorder) emit(_ context.Context, pkt *dedup.Packet) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.packets = append(r.packets, pkt)
+}
+
+func (r *recorder) len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.packets)
+}
+
+func packet(from, id uint32, gateway string, rssi int32) *dedup.Packet {
+	return &dedup.Packet{
+		Message: &mtypes.Message{
+			From:     from,
+			ID:       id,
+			Sender:   gateway,
+			RSSI:     rssi,
+			SNR:      5.5,
+			HopsAway: 1,
+		},
+	}
+}
+
+func TestImmediate(t *testing.T) {
+	rec := &recorder{}
+	cache := dedup.NewCache(dedup.Config{Mode: dedup.ModeImmediate, Window: time.Minute}, rec.emit)
+
+	if !cache.Add(t.Context(), packet(1, 100, "!aaaaaaaa", -80)) {
+		t.Error("Add() first copy = false, want true")
+	}
+	if cache.Add(t.Context(), packet(1, 100, "!bbbbbbbb", -90)) {
+		t.Error("Add() second copy = true, want false")
+	}
+	if !cache.Add(t.Context(), packet(2, 100, "!aaaaaaaa", -80)) {
+		t.Error("Add() other sender = false, want true")
+	}
+
+	if rec.len() != 2 {
+		t.Fatalf("Emitted %d packets, want 2", rec.len())
+	}
+	if gw := rec.packets[0].Message.Gateways; len(gw) != 1 || gw[0].ID != "!aaaaaaaa" {
+		t.Errorf("Gateways = %+v, want only the first gateway", gw)
+	}
+
+	cache.Sweep(t.Context(), time.Now().Add(time.Minute))
+	if rec.len() != 2 {
+		t.Errorf("Sweep() emitted already emitted packets, got %d packets", rec.len())
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len() after Sweep() = %d, want 0", cache.Len())
+	}
+
+	if !cache.Add(t.Context(), packet(1, 100, "!bbbbbbbb", -90)) {
+		t.Error("Add() after window = false, want true")
+	}
+}
+
+func TestSettle(t *testing.T) {
+	rec := &recorder{}
+	cache := dedup.NewCache(dedup.Config{Mode: dedup.ModeSettle, Window: time.Minute}, rec.emit)
+
+	cache.Add(t.Context(), packet(1, 100, "!aaaaaaaa", -80))
+	cache.Add(t.Context(), packet(1, 100, "!bbbbbbbb", -90))
+	cache.Add(t.Context(), packet(1, 100, "!cccccccc", -100))
+
+	if rec.len() != 0 {
+		t.Fatalf("Emitted %d packets before the window passed, want 0", rec.len())
+	}
+
+	cache.Sweep(t.Context(), time.Now())
+	if rec.len() != 0 {
+		t.Fatalf("Sweep() before the window passed emitted %d packets, want 0", rec.len())
+	}
+
+	cache.Sweep(t.Context(), time.Now().Add(time.Minute))
+	if rec.len() != 1 {
+		t.Fatalf("Emitted %d packets, want 1", rec.len())
+	}
+
+	gateways := rec.packets[0].Message.Gateways
+	want := []mtypes.Gateway{
+		{ID: "!aaaaaaaa", RSSI: -80, SNR: 5.5, HopsAway: 1},
+		{ID: "!bbbbbbbb", RSSI: -90, SNR: 5.5, HopsAway: 1},
+		{ID: "!cccccccc", RSSI: -100, SNR: 5.5, HopsAway: 1},
+	}
+	if len(gateways) != len(want) {
+		t.Fatalf("Gateways = %+v, want %+v", gateways, want)
+	}
+	for i := range want {
+		if gateways[i] != want[i] {
+			t.Errorf("Gateways[%d] = %+v, want %+v", i, gateways[i], want[i])
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	rec := &recorder{}
+	cache := dedup.NewCache(dedup.Config{Mode: dedup.ModeSettle, Window: time.Hour}, rec.emit)
+
+	cache.Add(t.Context(), packet(1, 100, "!aaaaaaaa", -80))
+	cache.Add(t.Context(), packet(1, 101, "!aaaaaaaa", -80))
+	cache.Flush(t.Context())
+
+	if rec.len() != 2 {
+		t.Errorf("Flush() emitted %d packets, want 2", rec.len())
+	}
+}
+
+func TestSweepOrder(t *testing.T) {
+	rec := &recorder{}
+	cache := dedup.NewCache(dedup.Config{Mode: dedup.ModeSettle, Window: time.Minute}, rec.emit)
+
+	// Packet IDs are random, so the order packets were received in is unrelated to their IDs.
+	ids := []uint32{907, 12, 5530, 431, 88, 2761, 19, 640, 3301, 75}
+	for _, id := range ids {
+		cache.Add(t.Context(), packet(1, id, "!aaaaaaaa", -80))
+	}
+
+	cache.Sweep(t.Context(), time.Now().Add(time.Minute))
+	if rec.len() != len(ids) {
+		t.Fatalf("Emitted %d packets, want %d", rec.len(), len(ids))
+	}
+
+	for i, id := range ids {
+		if got := rec.packets[i].Message.ID; got != id {
+			t.Errorf("Packet %d ID = %d, want %d", i, got, id)
+		}
+	}
+}
+
+func TestZeroPacketID(t *testing.T) {
+	rec := &recorder{}
+	cache := dedup.NewCache(dedup.Config{Mode: dedup.ModeSettle}, rec.emit)
+
+	cache.Add(t.Context(), packet(1, 0, "!aaaaaaaa", -80))
+	cache.Add(t.Context(), packet(1, 0, "!bbbbbbbb", -80))
+
+	if rec.len() != 2 {
+		t.Errorf("Emitted %d packets without an ID, want 2", rec.len())
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    dedup.Mode
+		wantErr error
+	}{
+		{"", dedup.ModeOff, nil},
+		{"off", dedup.ModeOff, nil},
+		{"immediate", dedup.ModeImmediate, nil},
+		{"settle", dedup.ModeSettle, nil},
+		{"sometimes", dedup.ModeOff, dedup.ErrInvalidMode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := dedup.ParseMode(tt.in)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ParseMode(%q) error = %v, want %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMode(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPacketGeneric(t *testing.T) {
+	pkt := packet(1, 100, "!aaaaaaaa", -80)
+	pkt.Message.Payload = map[string]int{"battery_level": 87}
+
+	message, payload := pkt.Generic()
+	if message["from"] != float64(1) {
+		t.Errorf("Generic() from: got %v, want 1", message["from"])
+	}
+	if p, _ := payload.(map[string]any); p["battery_level"] != float64(87) {
+		t.Errorf("Generic() payload: got %v", payload)
+	}
+
+	pkt.Message.From = 2
+	if message, _ = pkt.Generic(); message["from"] != float64(1) {
+		t.Errorf("Generic() is not converted once per packet, from: got %v", message["from"])
+	}
+}
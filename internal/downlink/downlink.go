@@ -0,0 +1,301 @@
+// Package downlink encodes JSON downlink requests into encrypted ServiceEnvelopes that gateway nodes transmit
+// on the mesh.
+package downlink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultHopLimit is the default hop limit of packets sent to the mesh.
+const DefaultHopLimit = 3
+
+// Request types, matching the firmware JSON downlink.
+const (
+	TypeSendText     = "sendtext"
+	TypeSendPosition = "sendposition"
+	TypeSendWaypoint = "sendwaypoint"
+)
+
+var (
+	// ErrUnknownType is returned for a request type other than sendtext, sendposition or sendwaypoint.
+	ErrUnknownType = errors.New("unknown downlink type")
+	// ErrNoSender is returned when a request does not set the sending node.
+	ErrNoSender = errors.New("downlink request has no sender")
+	// ErrNoPayload is returned when a request has an empty payload.
+	ErrNoPayload = errors.New("downlink request has no payload")
+	// ErrNoChannel is returned when neither the request nor the configuration name a channel.
+	ErrNoChannel = errors.New("downlink request has no channel")
+	// ErrNoGateway is returned when the configuration does not set the gateway ID envelopes are published as.
+	ErrNoGateway = errors.New("downlink gateway is not set")
+	// ErrGatewayIsSender is returned when the gateway ID is the ID of the sending node, the node would ignore the
+	// envelope.
+	ErrGatewayIsSender = errors.New("downlink gateway is the sending node")
+)
+
+// Config holds the downlink configuration.
+type Config struct {
+	// Topic is the topic JSON downlink requests are received on, downlink is disabled if empty.
+	Topic string
+	// RootTopic is the topic prefix envelopes are published under, e.g. msh/ANZ.
+	RootTopic string
+	// Channel is the channel used when a request does not name one.
+	Channel string
+	// Gateway is the gateway ID envelopes are published as, required.
+	//
+	// Gateway nodes ignore envelopes published with their own ID, so this must not be the ID of a gateway.
+	Gateway string
+	// HopLimit is the hop limit of packets sent to the mesh.
+	HopLimit uint32
+	// Keyring holds the channel keys packets are encrypted with.
+	Keyring *meshcrypto.Keyring
+}
+
+// Downlink encodes JSON downlink requests into ServiceEnvelopes.
+type Downlink struct {
+	Config Config
+}
+
+// NewDownlink creates a new downlink encoder.
+func NewDownlink(config Config) *Downlink {
+	if config.HopLimit == 0 {
+		config.HopLimit = DefaultHopLimit
+	}
+	if config.Keyring == nil {
+		config.Keyring = meshcrypto.NewKeyring()
+	}
+
+	return &Downlink{Config: config}
+}
+
+// RootTopic returns the topic prefix of a subscription topic, e.g. msh/ANZ for msh/ANZ/2/e/#.
+func RootTopic(topic string) string {
+	root, _, _ := strings.Cut(topic, "/2/")
+	return root
+}
+
+// Request is a JSON downlink request.
+type Request struct {
+	From NodeNum `json:"from"`
+	To   NodeNum `json:"to,omitempty"`
+	// ChannelID is the name of the channel the packet is sent on, optional.
+	ChannelID string          `json:"channel_id,omitempty"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// NodeNum is a node number, decoded from either a JSON number or a node ID string (e.g. "!44be043f").
+type NodeNum uint32
+
+// UnmarshalJSON decodes a node number from a JSON number or string.
+func (n *NodeNum) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var v uint32
+		if err = json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("invalid node number %s: %w", data, err)
+		}
+		*n = NodeNum(v)
+		return nil
+	}
+
+	v, err := mtypes.ParseNodeID(s)
+	if err != nil {
+		return err
+	}
+	*n = NodeNum(v)
+	return nil
+}
+
+// PositionPayload is the payload of a sendposition request.
+type PositionPayload struct {
+	LatitudeI  int32  `json:"latitude_i"`
+	LongitudeI int32  `json:"longitude_i"`
+	Altitude   int32  `json:"altitude,omitempty"`
+	Time       uint32 `json:"time,omitempty"`
+}
+
+// WaypointPayload is the payload of a sendwaypoint request.
+type WaypointPayload struct {
+	ID          uint32 `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Expire      uint32 `json:"expire,omitempty"`
+	LockedTo    uint32 `json:"locked_to,omitempty"`
+	LatitudeI   int32  `json:"latitude_i"`
+	LongitudeI  int32  `json:"longitude_i"`
+	Icon        uint32 `json:"icon,omitempty"`
+}
+
+// Encode decodes a JSON downlink request and returns the message publishing it to the mesh.
+func (d *Downlink) Encode(payload []byte) (*broker.Message, error) {
+	var req Request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, fmt.Errorf("unable to decode downlink request: %w", err)
+	}
+
+	envelope, err := d.Envelope(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := proto.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode ServiceEnvelope: %w", err)
+	}
+
+	return &broker.Message{
+		Topic:   d.Config.RootTopic + "/2/e/" + envelope.GetChannelId() + "/" + envelope.GetGatewayId(),
+		Payload: out,
+	}, nil
+}
+
+// Envelope builds the encrypted ServiceEnvelope for a downlink request.
+func (d *Downlink) Envelope(req *Request) (*meshtastic.ServiceEnvelope, error) {
+	if req.From == 0 {
+		return nil, ErrNoSender
+	}
+
+	if d.Config.Gateway == "" {
+		return nil, ErrNoGateway
+	}
+	if strings.EqualFold(d.Config.Gateway, mtypes.FormatNodeID(uint32(req.From))) {
+		return nil, fmt.Errorf("%w: %s", ErrGatewayIsSender, d.Config.Gateway)
+	}
+
+	channelID := req.ChannelID
+	if channelID == "" {
+		channelID = d.Config.Channel
+	}
+	if channelID == "" {
+		return nil, ErrNoChannel
+	}
+
+	ch, ok := d.Config.Keyring.Channel(channelID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", meshcrypto.ErrUnknownChannel, channelID)
+	}
+
+	data, err := requestData(req)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := proto.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode packet data: %w", err)
+	}
+
+	to := uint32(req.To)
+	if to == 0 {
+		to = mtypes.BroadcastNode
+	}
+
+	packet := &meshtastic.MeshPacket{
+		From:     uint32(req.From),
+		To:       to,
+		Id:       packetID(),
+		Channel:  ch.Hash,
+		HopLimit: d.Config.HopLimit,
+		HopStart: d.Config.HopLimit,
+	}
+
+	encrypted, err := meshcrypto.TransformCTR(ch.Key, packet.GetId(), packet.GetFrom(), plain)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt packet: %w", err)
+	}
+	packet.PayloadVariant = &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted}
+
+	return &meshtastic.ServiceEnvelope{
+		Packet:    packet,
+		ChannelId: ch.Name,
+		GatewayId: d.Config.Gateway,
+	}, nil
+}
+
+// requestData returns the decoded packet payload for a request.
+func requestData(req *Request) (*meshtastic.Data, error) {
+	if len(req.Payload) == 0 || string(req.Payload) == "null" {
+		return nil, ErrNoPayload
+	}
+
+	switch req.Type {
+	case TypeSendText:
+		var text string
+		if err := json.Unmarshal(req.Payload, &text); err != nil {
+			return nil, fmt.Errorf("unable to decode sendtext payload: %w", err)
+		}
+		if text == "" {
+			return nil, ErrNoPayload
+		}
+
+		return &meshtastic.Data{Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP, Payload: []byte(text)}, nil
+	case TypeSendPosition:
+		var p PositionPayload
+		if err := json.Unmarshal(req.Payload, &p); err != nil {
+			return nil, fmt.Errorf("unable to decode sendposition payload: %w", err)
+		}
+
+		position := &meshtastic.Position{
+			LatitudeI:  proto.Int32(p.LatitudeI),
+			LongitudeI: proto.Int32(p.LongitudeI),
+			Time:       p.Time,
+		}
+		if p.Altitude != 0 {
+			position.Altitude = proto.Int32(p.Altitude)
+		}
+
+		return marshalData(meshtastic.PortNum_POSITION_APP, position)
+	case TypeSendWaypoint:
+		var w WaypointPayload
+		if err := json.Unmarshal(req.Payload, &w); err != nil {
+			return nil, fmt.Errorf("unable to decode sendwaypoint payload: %w", err)
+		}
+
+		waypoint := &meshtastic.Waypoint{
+			Id:          w.ID,
+			LatitudeI:   proto.Int32(w.LatitudeI),
+			LongitudeI:  proto.Int32(w.LongitudeI),
+			Expire:      w.Expire,
+			LockedTo:    w.LockedTo,
+			Name:        w.Name,
+			Description: w.Description,
+			Icon:        w.Icon,
+		}
+		if waypoint.Id == 0 {
+			waypoint.Id = packetID()
+		}
+
+		return marshalData(meshtastic.PortNum_WAYPOINT_APP, waypoint)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, req.Type)
+	}
+}
+
+func marshalData(portnum meshtastic.PortNum, msg proto.Message) (*meshtastic.Data, error) {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode %s payload: %w", portnum, err)
+	}
+
+	return &meshtastic.Data{Portnum: portnum, Payload: payload}, nil
+}
+
+// packetID returns a random, non-zero, packet ID.
+func packetID() uint32 {
+	for {
+		if id := rand.Uint32(); id != 0 { //nolint:gosec // packet IDs are not secret.
+			return id
+		}
+	}
+}
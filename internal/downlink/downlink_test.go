@@ -0,0 +1,163 @@
+package downlink_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/downlink"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func newDownlink(t *testing.T, gateway string) (*downlink.Downlink, *meshcrypto.Keyring) {
+	t.Helper()
+
+	keyring := meshcrypto.NewKeyring()
+	if err := keyring.AddChannel("MediumFast", "AQ=="); err != nil {
+		t.Fatalf("AddChannel() error: %v", err)
+	}
+	if err := keyring.AddChannel("Private", "1PG7OiApB1nwvP+rz05pAQ=="); err != nil {
+		t.Fatalf("AddChannel() error: %v", err)
+	}
+
+	return downlink.NewDownlink(downlink.Config{
+		RootTopic: downlink.RootTopic("msh/ANZ/2/e/#"),
+		Channel:   "MediumFast",
+		Gateway:   gateway,
+		Keyring:   keyring,
+	}), keyring
+}
+
+// decode decodes and decrypts a published envelope.
+func decode(t *testing.T, keyring *meshcrypto.Keyring, payload []byte) (*meshtastic.ServiceEnvelope, *meshtastic.Data) {
+	t.Helper()
+
+	envelope := &meshtastic.ServiceEnvelope{}
+	if err := proto.Unmarshal(payload, envelope); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+
+	data, err := keyring.DecryptPacket(envelope.GetChannelId(), envelope.GetPacket())
+	if err != nil {
+		t.Fatalf("DecryptPacket() error: %v", err)
+	}
+
+	return envelope, data
+}
+
+func TestEncodeText(t *testing.T) {
+	d, keyring := newDownlink(t, "!deadbeef")
+
+	msg, err := d.Encode([]byte(`{"from":2697708456,"type":"sendtext","payload":"hello mesh"}`))
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	if msg.Topic != "msh/ANZ/2/e/MediumFast/!deadbeef" {
+		t.Errorf("Encode() topic: got %q", msg.Topic)
+	}
+
+	envelope, data := decode(t, keyring, msg.Payload)
+	packet := envelope.GetPacket()
+	if packet.GetFrom() != 2697708456 || packet.GetTo() != 0xffffffff || packet.GetId() == 0 {
+		t.Errorf("Unexpected packet: %v", packet)
+	}
+	if packet.GetHopLimit() != downlink.DefaultHopLimit || packet.GetHopStart() != downlink.DefaultHopLimit {
+		t.Errorf("Unexpected hop limit: got %d/%d", packet.GetHopLimit(), packet.GetHopStart())
+	}
+	if envelope.GetGatewayId() != "!deadbeef" {
+		t.Errorf("Unexpected gateway: got %q", envelope.GetGatewayId())
+	}
+	if data.GetPortnum() != meshtastic.PortNum_TEXT_MESSAGE_APP || string(data.GetPayload()) != "hello mesh" {
+		t.Errorf("Unexpected data: %v", data)
+	}
+}
+
+func TestEncodePosition(t *testing.T) {
+	d, keyring := newDownlink(t, "!deadbeef")
+
+	msg, err := d.Encode([]byte(`{"from":"!a0cbc3a8","to":"!44be043f","channel_id":"Private","type":"sendposition",` +
+		`"payload":{"latitude_i":-274700000,"longitude_i":1530200000,"altitude":12}}`))
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	if msg.Topic != "msh/ANZ/2/e/Private/!deadbeef" {
+		t.Errorf("Encode() topic: got %q", msg.Topic)
+	}
+
+	envelope, data := decode(t, keyring, msg.Payload)
+	if envelope.GetPacket().GetTo() != 0x44be043f {
+		t.Errorf("Unexpected destination: got %d", envelope.GetPacket().GetTo())
+	}
+	if data.GetPortnum() != meshtastic.PortNum_POSITION_APP {
+		t.Fatalf("Unexpected portnum: got %s", data.GetPortnum())
+	}
+
+	position := &meshtastic.Position{}
+	if err = proto.Unmarshal(data.GetPayload(), position); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	if position.GetLatitudeI() != -274700000 || position.GetLongitudeI() != 1530200000 || position.GetAltitude() != 12 {
+		t.Errorf("Unexpected position: %v", position)
+	}
+}
+
+func TestEncodeWaypoint(t *testing.T) {
+	d, keyring := newDownlink(t, "!deadbeef")
+
+	msg, err := d.Encode([]byte(`{"from":2697708456,"type":"sendwaypoint",` +
+		`"payload":{"name":"Camp","description":"Base camp","latitude_i":-274700000,"longitude_i":1530200000}}`))
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	_, data := decode(t, keyring, msg.Payload)
+	if data.GetPortnum() != meshtastic.PortNum_WAYPOINT_APP {
+		t.Fatalf("Unexpected portnum: got %s", data.GetPortnum())
+	}
+
+	waypoint := &meshtastic.Waypoint{}
+	if err = proto.Unmarshal(data.GetPayload(), waypoint); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	if waypoint.GetName() != "Camp" || waypoint.GetDescription() != "Base camp" || waypoint.GetId() == 0 {
+		t.Errorf("Unexpected waypoint: %v", waypoint)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	d, _ := newDownlink(t, "!deadbeef")
+
+	tests := []struct {
+		name    string
+		payload string
+		err     error
+	}{
+		{"no sender", `{"type":"sendtext","payload":"hi"}`, downlink.ErrNoSender},
+		{"no payload", `{"from":1,"type":"sendtext"}`, downlink.ErrNoPayload},
+		{"empty text", `{"from":1,"type":"sendtext","payload":""}`, downlink.ErrNoPayload},
+		{"unknown type", `{"from":1,"type":"sendfile","payload":"hi"}`, downlink.ErrUnknownType},
+		{"unknown channel", `{"from":1,"channel_id":"Missing","type":"sendtext","payload":"hi"}`, meshcrypto.ErrUnknownChannel},
+		{"gateway is sender", `{"from":"!DEADBEEF","type":"sendtext","payload":"hi"}`, downlink.ErrGatewayIsSender},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := d.Encode([]byte(tt.payload)); !errors.Is(err, tt.err) {
+				t.Errorf("Encode() error: got %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := d.Encode([]byte(`{"from":"!zz"}`)); err == nil {
+		t.Error("Encode() with invalid node ID: expected error")
+	}
+
+	d, _ = newDownlink(t, "")
+	if _, err := d.Encode([]byte(`{"from":1,"type":"sendtext","payload":"hi"}`)); !errors.Is(err, downlink.ErrNoGateway) {
+		t.Errorf("Encode() without a gateway error: got %v, want %v", err, downlink.ErrNoGateway)
+	}
+}
@@ -1,33 +1,11 @@
 package fanout
 
-import (
-	"time"
+import "time"
 
-	"github.com/na4ma4/meshtastic-mqtt-translate/internal/relay"
-	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
-)
-
-// Config holds the relay configuration.
+// Config holds the fanout output configuration.
 type Config struct {
-	Broker          string
-	ClientID        string
-	SourceTopic     string
-	Username        string
-	Password        string
-	Store           store.Store
-	DryRun          bool
-	Keepalive       time.Duration
+	// TelemetryExpiry is the MQTT 5 message expiry of published telemetry, optional.
+	TelemetryExpiry time.Duration
 	TargetBaseTopic string
 	RetainFlag      bool
 }
-
-func (c *Config) CopyFromRelayConfig(src relay.Config) {
-	c.Broker = src.Broker
-	c.ClientID = src.ClientID
-	c.Username = src.Username
-	c.Password = src.Password
-	c.SourceTopic = src.Topic
-	c.Store = src.Store
-	c.DryRun = src.DryRun
-	c.Keepalive = src.Keepalive
-}
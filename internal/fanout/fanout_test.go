@@ -8,11 +8,12 @@ import (
 	"math"
 	"os"
 	"path"
+	"sync"
 	"testing"
 
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/fanout"
-	"github.com/na4ma4/meshtastic-mqtt-translate/internal/parser"
-	"github.com/na4ma4/meshtastic-mqtt-translate/internal/relay"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/na4ma4/go-contextual"
@@ -20,24 +21,54 @@ import (
 
 const testTopic = "msh/ANZ/2/json/MediumFast/!44be043f"
 
-func TestNewRelay(t *testing.T) {
-	config := fanout.Config{
-		Broker:      "tcp://localhost:1883",
-		ClientID:    "test-client",
-		SourceTopic: "test/topic",
-	}
+// recorder is a pipeline.Publisher recording the published messages.
+type recorder struct {
+	lock sync.Mutex
+	msgs []*broker.Message
+}
+
+func (r *recorder) Publish(_ context.Context, msg *broker.Message) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+// translate passes a payload through a pipeline with only the fanout output, returning the published message.
+func translate(t *testing.T, config pipeline.Config, payload []byte, topic string) ([]byte, string) {
+	t.Helper()
 
-	fanoutClient, err := fanout.NewFanout(contextual.New(context.TODO()), config, slog.New(slog.DiscardHandler))
+	p, err := pipeline.NewPipeline(contextual.New(t.Context()), config, slog.New(slog.DiscardHandler))
 	if err != nil {
-		t.Fatalf("Failed to create relay: %v", err)
+		t.Fatalf("Failed to create pipeline: %v", err)
 	}
 
-	if fanoutClient == nil {
-		t.Fatal("Expected relay to be non-nil")
+	rec := &recorder{}
+	p.AddOutput(fanout.NewOutput(rec, fanout.Config{TargetBaseTopic: "meshtastic/fanout"}))
+	p.HandleMessage(&broker.Message{Topic: topic, Payload: payload})
+
+	if len(rec.msgs) == 0 {
+		return nil, ""
+	}
+
+	return rec.msgs[0].Payload, rec.msgs[0].Topic
+}
+
+func TestNewOutput(t *testing.T) {
+	rec := &recorder{}
+	out := fanout.NewOutput(rec, fanout.Config{TargetBaseTopic: "meshtastic/fanout", RetainFlag: true})
+
+	if out.Name != fanout.OutputName {
+		t.Errorf("Expected name %q, got %q", fanout.OutputName, out.Name)
 	}
 
-	if fanoutClient.Config.Broker != config.Broker {
-		t.Errorf("Expected Broker to be %s, got %s", config.Broker, fanoutClient.Config.Broker)
+	sink, ok := out.Sink.(*pipeline.MQTTSink)
+	if !ok {
+		t.Fatalf("Expected MQTT sink, got %T", out.Sink)
+	}
+	if !sink.Retain || sink.Publisher != rec {
+		t.Errorf("Unexpected sink: %+v", sink)
 	}
 }
 
@@ -46,18 +77,14 @@ func TestConvertToJSONExamples(t *testing.T) {
 	// const encodedMessage = `Ck4NoBJToBX/////IiEIQxIdDbxCFGkSFghlFTeJhUAdTxtYQCVhxI08KLb50gE1VKDu4z22QRRpRQAA0EBIAmDC//////////8BeAeYATgSCk1lZGl1bUZhc3QaCSE0NGJlMDQzZg==`
 	// const encodedMessage = `Co8BDVBWMEoV/////yJiCAQSXAoJITRhMzA1NjUwEhxUZXN0IFNlbWktUGVybWFuZW50IFJlcGVhdGVyGgNsbzAiBiTsSjBWUCgQQiCcQ4D2/JjAwtC31HoIzbngJRmLWcsdxcO043jvDPRdf0gASAA1243IIz2lQhRpRQAAMEFIB2DO//////////8BeAeYAVASCk1lZGl1bUZhc3QaCSE0NGJlMDQzZg==`
 	const encodedMessage = `CooBDSDPWnwVSASN9yJdCAQSVAoJITdjNWFjZjIwEhVBbm5lcmxleSBKdW5jdGlvbiBXU0waBGNmMjAiBiRYfFrPICgsQiBy9dXwrv1cheaZadLd6mkQc8qaIOyVAbhtziuwmcQTfjVCWQJ6NYe3B1A9YEMUaUUAADRBSAFg0P//////////AXgFmAFQEgpNZWRpdW1GYXN0GgkhNDRiZTA0M2Y=`
-	fanoutClient := &fanout.Fanout{
-		Logger: slog.New(slog.DiscardHandler),
-		Parser: parser.NewParser(slog.New(slog.DiscardHandler)),
-	}
-
 	data, err := base64.StdEncoding.DecodeString(encodedMessage)
 	if err != nil {
 		t.Fatalf("Failed to decode base64 message: %v", err)
 	}
 
-	fanoutClient.HandleMessagePayload(t.Context(), data, "msh/ANZ/2/e/MediumFast/!44be043f")
-	// t.Fail()
+	if payload, _ := translate(t, pipeline.Config{}, data, "msh/ANZ/2/e/MediumFast/!44be043f"); payload == nil {
+		t.Error("Expected payload to be non-nil")
+	}
 }
 
 type convertJSONTestCase struct {
@@ -102,6 +129,14 @@ func TestConvertToJSONTable(t *testing.T) {
 		loadTestCase(t, "message-08"),
 		loadTestCase(t, "message-09"),
 		loadTestCase(t, "message-10"),
+		loadTestCase(t, "message-12"),
+		loadTestCase(t, "message-13"),
+		loadTestCase(t, "message-14"),
+		loadTestCase(t, "message-15"),
+		loadTestCase(t, "message-16"),
+		loadTestCase(t, "message-17"),
+		loadTestCase(t, "message-18"),
+		loadTestCase(t, "message-19"),
 	}
 
 	transformJSON := []cmp.Option{
@@ -126,21 +161,12 @@ func TestConvertToJSONTable(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			logger := slog.New(slog.NewTextHandler(t.Output(), &slog.HandlerOptions{
-				Level: slog.LevelDebug,
-			}))
-			fanoutClient := &relay.Relay{
-				// Logger: slog.New(slog.DiscardHandler),
-				Logger: logger,
-				Parser: parser.NewParser(logger),
-			}
-
 			data, err := base64.StdEncoding.DecodeString(tt.encodedMessage)
 			if err != nil {
 				t.Fatalf("Failed to decode base64 message: %v", err)
 			}
 
-			payload, _ := fanoutClient.HandleMessagePayload(t.Context(), data, testTopic)
+			payload, _ := translate(t, pipeline.Config{}, data, testTopic)
 
 			// log.Printf("Converted JSON: %s", payload)
 			// log.Printf("Expected JSON: %s", tt.expectedJSON)
@@ -151,3 +177,62 @@ func TestConvertToJSONTable(t *testing.T) {
 		})
 	}
 }
+
+func TestWaypointTopicSuffix(t *testing.T) {
+	tt := loadTestCase(t, "message-12")
+
+	data, err := base64.StdEncoding.DecodeString(tt.encodedMessage)
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	_, topic := translate(t, pipeline.Config{}, data, testTopic)
+
+	const want = "meshtastic/fanout/2697708456/WAYPOINT_APP/2847561"
+	if topic != want {
+		t.Errorf("Topic = %q, want %q", topic, want)
+	}
+}
+
+func TestTopicSuffix(t *testing.T) {
+	tests := []struct {
+		file  string
+		topic string
+		want  string
+	}{
+		{"message-14", "msh/ANZ/2/map/", "meshtastic/fanout/2697708456/MAP_REPORT_APP/MapReport"},
+		{"message-15", testTopic, "meshtastic/fanout/2697708456/PAXCOUNTER_APP/Paxcount"},
+		{"message-16", testTopic, "meshtastic/fanout/2697708456/DETECTION_SENSOR_APP/DetectionSensor"},
+		{"message-17", testTopic, "meshtastic/fanout/2697708456/ALERT_APP/Alert"},
+		{"message-18", testTopic, "meshtastic/fanout/2697708456/RANGE_TEST_APP/RangeTest"},
+		{"message-19", testTopic, "meshtastic/fanout/2697708456/TELEMETRY_APP/HealthMetrics"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			tc := loadTestCase(t, tt.file)
+
+			data, err := base64.StdEncoding.DecodeString(tc.encodedMessage)
+			if err != nil {
+				t.Fatalf("Failed to decode base64 message: %v", err)
+			}
+
+			if _, topic := translate(t, pipeline.Config{}, data, tt.topic); topic != tt.want {
+				t.Errorf("Topic = %q, want %q", topic, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropsEncrypted(t *testing.T) {
+	tt := loadTestCase(t, "message-11")
+
+	data, err := base64.StdEncoding.DecodeString(tt.encodedMessage)
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	if payload, topic := translate(t, pipeline.Config{}, data, testTopic); payload != nil {
+		t.Errorf("Expected encrypted packet to be dropped, got %q: %s", topic, payload)
+	}
+}
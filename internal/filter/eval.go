@@ -0,0 +1,228 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+)
+
+// node is a node of the parsed expression, evaluated to a value against the fields of a packet.
+//
+// Values are nil, bool, float64, string, []any or map[string]any, the types produced by decoding JSON.
+type node interface {
+	eval(f *fields) any
+}
+
+type literalNode struct {
+	v any
+}
+
+func (n literalNode) eval(*fields) any {
+	return n.v
+}
+
+type fieldNode struct {
+	path []string
+}
+
+func (n fieldNode) eval(f *fields) any {
+	return f.lookup(n.path)
+}
+
+type listNode []node
+
+func (n listNode) eval(f *fields) any {
+	out := make([]any, len(n))
+	for i, item := range n {
+		out[i] = item.eval(f)
+	}
+	return out
+}
+
+type notNode struct {
+	x node
+}
+
+func (n notNode) eval(f *fields) any {
+	return !truthy(n.x.eval(f))
+}
+
+type andNode struct {
+	left, right node
+}
+
+func (n andNode) eval(f *fields) any {
+	return truthy(n.left.eval(f)) && truthy(n.right.eval(f))
+}
+
+type orNode struct {
+	left, right node
+}
+
+func (n orNode) eval(f *fields) any {
+	return truthy(n.left.eval(f)) || truthy(n.right.eval(f))
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(f *fields) any {
+	l, r := n.left.eval(f), n.right.eval(f)
+
+	switch n.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+
+	c, ok := compare(l, r)
+	if !ok {
+		return false
+	}
+
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	default:
+		return false
+	}
+}
+
+// inNode is true when the list contains the value, or the string contains the substring.
+type inNode struct {
+	value, in node
+}
+
+func (n inNode) eval(f *fields) any {
+	v := n.value.eval(f)
+
+	switch in := n.in.eval(f).(type) {
+	case []any:
+		for _, item := range in {
+			if equal(v, item) {
+				return true
+			}
+		}
+	case string:
+		if s, ok := v.(string); ok {
+			return strings.Contains(in, s)
+		}
+	}
+
+	return false
+}
+
+type matchesNode struct {
+	value node
+	re    *regexp.Regexp
+}
+
+func (n matchesNode) eval(f *fields) any {
+	switch v := n.value.eval(f).(type) {
+	case string:
+		return n.re.MatchString(v)
+	case nil:
+		return false
+	default:
+		return n.re.MatchString(fmt.Sprint(v))
+	}
+}
+
+// truthy reports whether a value is true, non-zero, non-empty or present.
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
+
+// equal reports whether two values are equal, a node ID string (e.g. "!44be043f") equals its node number.
+func equal(l, r any) bool {
+	if c, ok := compare(l, r); ok {
+		return c == 0
+	}
+
+	switch l := l.(type) {
+	case nil:
+		return r == nil
+	case bool:
+		rb, ok := r.(bool)
+		return ok && l == rb
+	default:
+		return false
+	}
+}
+
+// compare orders two numbers or two strings, a node ID string is compared as its node number.
+func compare(l, r any) (int, bool) {
+	switch l := l.(type) {
+	case float64:
+		if rn, ok := number(r); ok {
+			return cmpFloat(l, rn), true
+		}
+	case string:
+		switch r := r.(type) {
+		case string:
+			return strings.Compare(l, r), true
+		case float64:
+			if ln, ok := number(l); ok {
+				return cmpFloat(ln, r), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// number returns a number, or the node number of a node ID string.
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		if !strings.HasPrefix(v, "!") {
+			return 0, false
+		}
+		n, err := mtypes.ParseNodeID(v)
+		if err != nil {
+			return 0, false
+		}
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func cmpFloat(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	default:
+		return 0
+	}
+}
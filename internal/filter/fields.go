@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"strconv"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/dedup"
+)
+
+// fields resolves the fields of a packet referenced by an expression.
+//
+// The payload and the full translated message are only converted to generic values when an
+// expression references them, the conversion is shared by every filter matched against the packet.
+type fields struct {
+	pkt *dedup.Packet
+}
+
+// lookup returns the value of the field at the path, or nil if it is not present.
+func (f *fields) lookup(path []string) any {
+	msg := f.pkt.Message
+
+	var v any
+	switch path[0] {
+	case "type", "portnum":
+		v = msg.Type
+	case "from":
+		v = float64(msg.From)
+	case "to":
+		v = float64(msg.To)
+	case "id":
+		v = float64(msg.ID)
+	case "channel":
+		v = float64(msg.Channel)
+	case "channel_id":
+		v = f.pkt.Envelope.GetChannelId()
+	case "gateway", "sender":
+		v = msg.Sender
+		if gw := f.pkt.Envelope.GetGatewayId(); gw != "" {
+			v = gw
+		}
+	case "topic":
+		v = f.pkt.Topic
+	case "hop_start":
+		v = float64(msg.HopStart)
+	case "hops_away":
+		v = float64(msg.HopsAway)
+	case "rssi":
+		v = float64(msg.RSSI)
+	case "snr":
+		v = float64(msg.SNR)
+	case "timestamp":
+		v = float64(msg.Timestamp)
+	case "encrypted":
+		v = f.pkt.Envelope.GetPacket().GetDecoded() == nil
+	case "payload":
+		_, v = f.pkt.Generic()
+	default:
+		message, _ := f.pkt.Generic()
+		v = message[path[0]]
+	}
+
+	return walk(v, path[1:])
+}
+
+// walk follows the path into maps and lists, list elements are selected by index.
+func walk(v any, path []string) any {
+	for _, key := range path {
+		switch c := v.(type) {
+		case map[string]any:
+			v = c[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil
+			}
+			v = c[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+// Package filter implements the expressions used to select the packets written to an output.
+//
+// An expression compares the fields of a translated message and its envelope, for example:
+//
+//	type == "TEXT_MESSAGE_APP" || (type == "TELEMETRY_APP" && from in ["!a0cbc3a8", "!44be043f"])
+//	hops_away <= 2 && rssi > -110 && channel_id != "LongFast"
+//	payload.device_metrics.battery_level < 20
+//
+// Fields are the JSON fields of the message (type, from, to, id, channel, hop_start, hops_away, rssi,
+// snr, timestamp, from_node, gateways, ...), the payload by path (payload.text), and the envelope's
+// channel_id, gateway and topic. portnum is an alias of type, encrypted is true if the packet could
+// not be decrypted. Node numbers compare equal to their node ID, so from == "!a0cbc3a8" works.
+//
+// Operators are ==, !=, <, <=, >, >=, in, not in, contains, matches (a regular expression),
+// && (and), || (or) and ! (not). A missing field is null, and a bare field is true if it is present
+// and not false, zero or empty.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/dedup"
+)
+
+var (
+	// ErrSyntax is returned when an expression can not be parsed.
+	ErrSyntax = errors.New("invalid filter expression")
+
+	errUnterminatedString = errors.New("unterminated string")
+)
+
+// Filter is a compiled filter expression.
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses a filter expression.
+func Compile(expr string) (*Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("%w: empty expression", ErrSyntax)
+	}
+
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// MustCompile is like Compile but panics if the expression can not be parsed.
+func MustCompile(expr string) *Filter {
+	f, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// String returns the source of the expression.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Match reports whether the packet matches the expression.
+func (f *Filter) Match(pkt *dedup.Packet) bool {
+	if pkt == nil || pkt.Message == nil {
+		return false
+	}
+
+	return truthy(f.root.eval(&fields{pkt: pkt}))
+}
@@ -0,0 +1,161 @@
+package filter_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/dedup"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/filter"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+type deviceMetrics struct {
+	BatteryLevel uint32  `json:"battery_level"`
+	Voltage      float64 `json:"voltage"`
+}
+
+type telemetry struct {
+	DeviceMetrics *deviceMetrics `json:"device_metrics,omitempty"`
+}
+
+func textPacket() *dedup.Packet {
+	return &dedup.Packet{
+		Topic: "msh/ANZ/2/e/MediumFast/!44be043f",
+		Envelope: &meshtastic.ServiceEnvelope{
+			ChannelId: "MediumFast",
+			GatewayId: "!44be043f",
+			Packet: &meshtastic.MeshPacket{
+				PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{}},
+			},
+		},
+		Message: &mtypes.Message{
+			From:     2697708456,
+			To:       mtypes.BroadcastNode,
+			ID:       3100022602,
+			HopStart: 7,
+			HopsAway: 0,
+			RSSI:     -50,
+			SNR:      10.75,
+			Sender:   "!44be043f",
+			Type:     "TEXT_MESSAGE_APP",
+			Payload:  "Maybe Ping",
+			FromNode: &mtypes.NodeSummary{ID: "!a0cbc3a8", LongName: "Base Station"},
+		},
+	}
+}
+
+func telemetryPacket() *dedup.Packet {
+	return &dedup.Packet{
+		Topic: "msh/ANZ/2/e/LongFast/!44be043f",
+		Envelope: &meshtastic.ServiceEnvelope{
+			ChannelId: "LongFast",
+			GatewayId: "!44be043f",
+		},
+		Message: &mtypes.Message{
+			From:     1244681808,
+			To:       mtypes.BroadcastNode,
+			HopStart: 3,
+			HopsAway: 2,
+			RSSI:     -112,
+			SNR:      -7.5,
+			Type:     "TELEMETRY_APP",
+			Payload:  &telemetry{DeviceMetrics: &deviceMetrics{BatteryLevel: 15, Voltage: 3.61}},
+		},
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		expr      string
+		text      bool
+		telemetry bool
+	}{
+		{`type == "TEXT_MESSAGE_APP"`, true, false},
+		{`portnum != "TEXT_MESSAGE_APP"`, false, true},
+		{`type in ["TEXT_MESSAGE_APP", "TELEMETRY_APP"]`, true, true},
+		{`type not in ["TEXT_MESSAGE_APP"]`, false, true},
+		{`from == "!a0cbc3a8"`, true, false},
+		{`from == 2697708456`, true, false},
+		{`from in ["!a0cbc3a8", "!4a305650"]`, true, true},
+		{`to == "!ffffffff"`, true, true},
+		{`channel_id == 'LongFast'`, false, true},
+		{`gateway == "!44be043f" && topic matches "/MediumFast/"`, true, false},
+		{`hops_away <= 1`, true, false},
+		{`rssi > -100 and snr >= 10`, true, false},
+		{`rssi < -110 || snr < 0`, false, true},
+		{`payload == "Maybe Ping"`, true, false},
+		{`payload contains "Ping"`, true, false},
+		{`payload matches "^maybe"`, false, false},
+		{`payload matches "(?i)^maybe"`, true, false},
+		{`payload.device_metrics.battery_level < 20`, false, true},
+		{`payload.device_metrics.voltage > 3.5 && payload.device_metrics.voltage < 4.2`, false, true},
+		{`payload.device_metrics`, false, true},
+		{`!payload.device_metrics`, true, false},
+		{`payload.device_metrics.battery_level == null`, true, false},
+		{`from_node.long_name == "Base Station"`, true, false},
+		{`encrypted`, false, true},
+		{`not encrypted`, true, false},
+		{`type == "TEXT_MESSAGE_APP" || (type == "TELEMETRY_APP" && from == "!a0cbc3a8")`, true, false},
+		{`!(type == "TEXT_MESSAGE_APP" || hops_away > 1)`, false, false},
+		{`missing.field == "x"`, false, false},
+		{`true`, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := filter.Compile(tt.expr)
+			if err != nil {
+				t.Fatalf("Compile(): error got '%v', want 'nil'", err)
+			}
+
+			if got := f.Match(textPacket()); got != tt.text {
+				t.Errorf("Match(text): got '%t', want '%t'", got, tt.text)
+			}
+			if got := f.Match(telemetryPacket()); got != tt.telemetry {
+				t.Errorf("Match(telemetry): got '%t', want '%t'", got, tt.telemetry)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`   `,
+		`type ==`,
+		`type = "TEXT_MESSAGE_APP"`,
+		`(type == "TEXT_MESSAGE_APP"`,
+		`type == "TEXT_MESSAGE_APP`,
+		`type in ["a", "b"`,
+		`type matches from`,
+		`type matches "("`,
+		`type == "a" "b"`,
+		`&& type`,
+		`type == and`,
+	}
+
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := filter.Compile(expr); !errors.Is(err, filter.ErrSyntax) {
+				t.Errorf("Compile(): error got '%v', want '%v'", err, filter.ErrSyntax)
+			}
+		})
+	}
+}
+
+func TestMatchNil(t *testing.T) {
+	f := filter.MustCompile(`true`)
+
+	if f.Match(nil) {
+		t.Error("Match(nil): got 'true', want 'false'")
+	}
+
+	if f.Match(&dedup.Packet{}) {
+		t.Error("Match(no message): got 'true', want 'false'")
+	}
+
+	if got, want := f.String(), "true"; got != want {
+		t.Errorf("String(): got '%s', want '%s'", got, want)
+	}
+}
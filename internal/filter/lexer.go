@@ -0,0 +1,145 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind is the kind of a lexical token.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+	tokenComma
+)
+
+// token is a lexical token and its offset in the expression.
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// operators are the symbolic operators, longest first so "==" is matched before "=".
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"}
+
+// lex splits the expression into tokens.
+func lex(expr string) ([]token, error) {
+	var tokens []token
+
+	for pos := 0; pos < len(expr); {
+		c := expr[pos]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: pos})
+			pos++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: pos})
+			pos++
+		case c == '[':
+			tokens = append(tokens, token{kind: tokenLBracket, text: "[", pos: pos})
+			pos++
+		case c == ']':
+			tokens = append(tokens, token{kind: tokenRBracket, text: "]", pos: pos})
+			pos++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: pos})
+			pos++
+		case c == '"' || c == '\'':
+			s, n, err := lexString(expr[pos:])
+			if err != nil {
+				return nil, fmt.Errorf("%w at offset %d: %w", ErrSyntax, pos, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s, pos: pos})
+			pos += n
+		case isDigit(c) || (c == '-' && pos+1 < len(expr) && isDigit(expr[pos+1])):
+			end := pos + 1
+			for end < len(expr) && (isDigit(expr[end]) || expr[end] == '.') {
+				end++
+			}
+			v, err := strconv.ParseFloat(expr[pos:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w at offset %d: invalid number %q", ErrSyntax, pos, expr[pos:end])
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expr[pos:end], num: v, pos: pos})
+			pos = end
+		case isIdentStart(c):
+			end := pos + 1
+			for end < len(expr) && (isIdentStart(expr[end]) || isDigit(expr[end]) || expr[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: expr[pos:end], pos: pos})
+			pos = end
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(expr[pos:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w at offset %d: unexpected character %q", ErrSyntax, pos, c)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: pos})
+			pos += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+// lexString reads a quoted string, returning the unescaped value and the number of bytes consumed.
+func lexString(in string) (string, int, error) {
+	quote := in[0]
+
+	var sb strings.Builder
+	for i := 1; i < len(in); i++ {
+		switch c := in[i]; c {
+		case quote:
+			return sb.String(), i + 1, nil
+		case '\\':
+			if i+1 == len(in) {
+				return "", 0, errUnterminatedString
+			}
+			i++
+			sb.WriteByte(in[i])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return "", 0, errUnterminatedString
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,230 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// parser is a recursive descent parser over the tokens of an expression.
+//
+//	or         = and { ("||" | "or") and }
+//	and        = unary { ("&&" | "and") unary }
+//	unary      = ("!" | "not") unary | comparison
+//	comparison = operand [ op operand ]
+//	op         = "==" | "!=" | "<" | "<=" | ">" | ">=" | "in" | "not in" | "contains" | "matches"
+//	operand    = string | number | "true" | "false" | "null" | field | list | "(" or ")"
+//	list       = "[" [ operand { "," operand } ] "]"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is one of the operators or keywords.
+func (p *parser) accept(words ...string) bool {
+	t := p.peek()
+	if t.kind != tokenOp && t.kind != tokenIdent {
+		return false
+	}
+	for _, w := range words {
+		if t.text == w {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, what string) error {
+	if t := p.next(); t.kind != kind {
+		return p.errorf(t, "expected %s", what)
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("%w at offset %d: %s, found %s", ErrSyntax, t.pos, fmt.Sprintf(format, args...), t)
+}
+
+func (p *parser) parse() (node, error) {
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "expected end of expression")
+	}
+	return n, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&", "and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!", "not") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokenOp && isComparison(t.text):
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{op: t.text, left: left, right: right}, nil
+	case p.accept("in"):
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return inNode{left, right}, nil
+	case t.kind == tokenIdent && t.text == "not" && p.tokens[p.pos+1].text == "in":
+		p.pos += 2
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inNode{left, right}}, nil
+	case p.accept("contains"):
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return inNode{right, left}, nil
+	case p.accept("matches"):
+		pt := p.next()
+		if pt.kind != tokenString {
+			return nil, p.errorf(pt, "expected regular expression string")
+		}
+		re, err := regexp.Compile(pt.text)
+		if err != nil {
+			return nil, fmt.Errorf("%w at offset %d: %w", ErrSyntax, pt.pos, err)
+		}
+		return matchesNode{left, re}, nil
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenString:
+		return literalNode{t.text}, nil
+	case tokenNumber:
+		return literalNode{t.num}, nil
+	case tokenLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(tokenRParen, "\")\""); err != nil {
+			return nil, err
+		}
+		return n, nil
+	case tokenLBracket:
+		return p.parseList()
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		case "and", "or", "not", "in", "contains", "matches":
+			return nil, p.errorf(t, "expected value")
+		}
+		return fieldNode{strings.Split(t.text, ".")}, nil
+	default:
+		return nil, p.errorf(t, "expected value")
+	}
+}
+
+func (p *parser) parseList() (node, error) {
+	var items listNode
+	if p.peek().kind == tokenRBracket {
+		p.next()
+		return items, nil
+	}
+
+	for {
+		n, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+
+		t := p.next()
+		switch t.kind {
+		case tokenComma:
+		case tokenRBracket:
+			return items, nil
+		default:
+			return nil, p.errorf(t, "expected \",\" or \"]\"")
+		}
+	}
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,188 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+
+	"github.com/na4ma4/go-slogtool"
+)
+
+const (
+	// defaultMessageLimit is the number of messages returned when no limit is requested.
+	defaultMessageLimit = 100
+	// maxMessageLimit is the maximum number of messages returned by a single request.
+	maxMessageLimit = 1000
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func (s *WebServer) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.Logger.Error("Failed to encode API response", slogtool.ErrorAttr(err))
+	}
+}
+
+// writeError writes an error as a JSON response with the given status code.
+func (s *WebServer) writeError(w http.ResponseWriter, code int, err error) {
+	s.writeJSON(w, code, apiError{Error: err.Error()})
+}
+
+func (s *WebServer) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	status, _ := s.status()
+	s.writeJSON(w, http.StatusOK, status)
+}
+
+func (s *WebServer) handleNodes(w http.ResponseWriter, _ *http.Request) {
+	if s.NodeDB == nil {
+		s.writeError(w, http.StatusServiceUnavailable, errors.New("node database not available"))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.NodeDB.List())
+}
+
+func (s *WebServer) handleNode(w http.ResponseWriter, r *http.Request) {
+	if s.NodeDB == nil {
+		s.writeError(w, http.StatusServiceUnavailable, errors.New("node database not available"))
+		return
+	}
+
+	num, err := mtypes.ParseNodeID(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	node, ok := s.NodeDB.Get(num)
+	if !ok {
+		s.writeError(w, http.StatusNotFound, errors.New("node not found"))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, node)
+}
+
+func (s *WebServer) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if s.Store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, errors.New("message store not available"))
+		return
+	}
+
+	q, err := parseMessageQuery(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	messages, err := s.Store.Query(r.Context(), q)
+	if errors.Is(err, store.ErrNotImplemented) {
+		s.writeError(w, http.StatusNotImplemented, errors.New("message store does not support queries"))
+		return
+	} else if err != nil {
+		s.Logger.ErrorContext(r.Context(), "Failed to query messages", slogtool.ErrorAttr(err))
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, messages)
+}
+
+func (s *WebServer) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if s.Store == nil {
+		s.writeError(w, http.StatusServiceUnavailable, errors.New("message store not available"))
+		return
+	}
+
+	messageID := r.PathValue("messageID")
+	msg, err := s.Store.Get(r.Context(), messageID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && msg == nil) {
+		s.writeError(w, http.StatusNotFound, errors.New("message not found"))
+		return
+	} else if errors.Is(err, store.ErrNotImplemented) {
+		s.writeError(w, http.StatusNotImplemented, errors.New("message store does not support lookups"))
+		return
+	} else if err != nil {
+		s.Logger.ErrorContext(r.Context(), "Failed to get message",
+			slog.String("message_id", messageID),
+			slogtool.ErrorAttr(err),
+		)
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, msg)
+}
+
+func (s *WebServer) handleTopology(w http.ResponseWriter, _ *http.Request) {
+	if s.Topology == nil {
+		s.writeError(w, http.StatusServiceUnavailable, errors.New("topology not available"))
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.Topology.Snapshot())
+}
+
+// parseMessageQuery parses the from, port, since and limit query parameters.
+func parseMessageQuery(r *http.Request) (store.Query, error) {
+	values := r.URL.Query()
+	q := store.Query{Limit: defaultMessageLimit}
+
+	if from := values.Get("from"); from != "" {
+		num, err := mtypes.ParseNodeID(from)
+		if err != nil {
+			return q, err
+		}
+		q.From = &num
+	}
+
+	q.PortNum = strings.ToUpper(values.Get("port"))
+
+	if since := values.Get("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			return q, err
+		}
+		q.Since = t
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = min(n, maxMessageLimit)
+	}
+
+	return q, nil
+}
+
+// parseSince parses an RFC 3339 timestamp, a unix timestamp or a duration before now (e.g. 1h).
+func parseSince(in string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, in); err == nil {
+		return t, nil
+	}
+
+	if unix, err := strconv.ParseInt(in, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+
+	if d, err := time.ParseDuration(in); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Time{}, errors.New("invalid since, expected RFC 3339 timestamp, unix timestamp or duration")
+}
@@ -0,0 +1,236 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/health"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/topology"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+)
+
+type stubStore struct {
+	messages  map[string]*mtypes.Message
+	lastQuery store.Query
+	queryErr  error
+}
+
+func (s *stubStore) Save(_ context.Context, _, _ string, _ []byte, _ *mtypes.Message) error {
+	return nil
+}
+
+func (s *stubStore) Get(_ context.Context, messageID string) (*mtypes.Message, error) {
+	if msg, ok := s.messages[messageID]; ok {
+		return msg, nil
+	}
+	return nil, fmt.Errorf("get message %s: %w", messageID, store.ErrNotFound)
+}
+
+func (s *stubStore) GetPayload(_ context.Context, _ string) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *stubStore) Iterate(_ context.Context, _ func(*mtypes.Message) error) error {
+	return errors.New("not implemented")
+}
+
+func (s *stubStore) Query(_ context.Context, q store.Query) ([]*mtypes.Message, error) {
+	s.lastQuery = q
+	if s.queryErr != nil {
+		return nil, s.queryErr
+	}
+	out := []*mtypes.Message{}
+	for _, msg := range s.messages {
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+func (s *stubStore) Close() error {
+	return nil
+}
+
+func newTestServer(t *testing.T) (*health.WebServer, *stubStore) {
+	t.Helper()
+
+	st := &stubStore{messages: map[string]*mtypes.Message{
+		"1234": {ID: 1234, From: 0x44be043f, Type: "TEXT_MESSAGE_APP", Payload: "hello"},
+	}}
+
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+	db.Observe(&mtypes.Message{
+		From:    0x44be043f,
+		Payload: &translator.User{LongName: "Gateway Node"},
+	})
+
+	return health.NewServer(0, slog.New(slog.DiscardHandler), nil,
+		health.WithStore(st),
+		health.WithNodeDB(db),
+	), st
+}
+
+func TestAPINodes(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/nodes", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/nodes status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var nodes []mtypes.Node
+	if err := json.Unmarshal(rec.Body.Bytes(), &nodes); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].LongName != "Gateway Node" {
+		t.Errorf("GET /api/nodes = %+v, want one Gateway Node", nodes)
+	}
+
+	for _, tt := range []struct {
+		id   string
+		code int
+	}{
+		{"!44be043f", http.StatusOK},
+		{"1153303615", http.StatusOK},
+		{"!00000001", http.StatusNotFound},
+		{"not-a-node", http.StatusBadRequest},
+	} {
+		rec = httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/nodes/"+tt.id, nil))
+		if rec.Code != tt.code {
+			t.Errorf("GET /api/nodes/%s status = %d, want %d", tt.id, rec.Code, tt.code)
+		}
+	}
+}
+
+func TestAPIMessages(t *testing.T) {
+	srv, st := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(
+		http.MethodGet, "/api/messages?from=!44be043f&port=text_message_app&since=1h&limit=5000", nil,
+	))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/messages status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if st.lastQuery.From == nil || *st.lastQuery.From != 0x44be043f {
+		t.Errorf("Query.From = %v, want %d", st.lastQuery.From, 0x44be043f)
+	}
+	if st.lastQuery.PortNum != "TEXT_MESSAGE_APP" {
+		t.Errorf("Query.PortNum = %q, want %q", st.lastQuery.PortNum, "TEXT_MESSAGE_APP")
+	}
+	if st.lastQuery.Since.IsZero() {
+		t.Error("Query.Since is zero, want time an hour ago")
+	}
+	if st.lastQuery.Limit != 1000 {
+		t.Errorf("Query.Limit = %d, want 1000", st.lastQuery.Limit)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/messages?limit=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /api/messages?limit=-1 status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/messages/1234", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/messages/1234 status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var msg mtypes.Message
+	if err := json.Unmarshal(rec.Body.Bytes(), &msg); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if msg.ID != 1234 {
+		t.Errorf("GET /api/messages/1234 ID = %d, want 1234", msg.ID)
+	}
+}
+
+func TestAPIMessageErrors(t *testing.T) {
+	srv, st := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/messages/9999", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /api/messages/9999 status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	st.queryErr = fmt.Errorf("query messages: %w", store.ErrNotImplemented)
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/messages", nil))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("GET /api/messages status = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+
+	st.queryErr = errors.New("database unavailable")
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/messages", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("GET /api/messages status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestAPITopology(t *testing.T) {
+	g := topology.NewGraph(topology.Config{})
+	g.Observe(&mtypes.Message{
+		From: 0x44be043f,
+		Payload: &translator.NeighborInfoApp{
+			NodeID:    0x44be043f,
+			Neighbors: []translator.Neighbor{{NodeID: 0xa0cbc3a8, Snr: 6.25}},
+		},
+	})
+
+	srv := health.NewServer(0, slog.New(slog.DiscardHandler), nil, health.WithTopology(g))
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/topology", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/topology status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var snap topology.Snapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(snap.Nodes) != 2 || len(snap.Edges) != 1 || snap.Edges[0].Target != "!a0cbc3a8" {
+		t.Errorf("GET /api/topology = %+v, want two nodes joined by one edge", snap)
+	}
+}
+
+func TestHealthFallback(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/anything", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /anything status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	srv := health.NewServer(0, slog.New(slog.DiscardHandler), nil,
+		health.WithMetrics(metrics.New()),
+	)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "go_goroutines") {
+		t.Error("GET /metrics missing Go runtime metrics")
+	}
+}
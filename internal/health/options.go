@@ -0,0 +1,53 @@
+package health
+
+import (
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/stream"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/topology"
+)
+
+type OptionFunc func(*WebServer)
+
+// WithStore sets the message store queried by the API.
+func WithStore(st store.Store) OptionFunc {
+	return func(s *WebServer) {
+		s.Store = st
+	}
+}
+
+// WithNodeDB sets the node database queried by the API.
+func WithNodeDB(db *nodedb.DB) OptionFunc {
+	return func(s *WebServer) {
+		s.NodeDB = db
+	}
+}
+
+// WithTopology sets the mesh adjacency graph served by the API.
+func WithTopology(g *topology.Graph) OptionFunc {
+	return func(s *WebServer) {
+		s.Topology = g
+	}
+}
+
+// WithMetrics sets the metrics served on /metrics.
+func WithMetrics(m *metrics.Metrics) OptionFunc {
+	return func(s *WebServer) {
+		s.Metrics = m
+	}
+}
+
+// WithStream sets the hub messages are streamed from on /ws and /events.
+func WithStream(h *stream.Hub) OptionFunc {
+	return func(s *WebServer) {
+		s.Stream = h
+	}
+}
+
+// WithStreamOrigins sets the origins, besides the server's own, allowed to connect to /ws and /events.
+func WithStreamOrigins(origins ...string) OptionFunc {
+	return func(s *WebServer) {
+		s.StreamOrigins = origins
+	}
+}
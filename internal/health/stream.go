@@ -0,0 +1,315 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/stream"
+
+	"github.com/gorilla/websocket"
+	"github.com/na4ma4/go-slogtool"
+)
+
+const (
+	// keepaliveInterval is the interval between keepalives sent to idle stream clients.
+	keepaliveInterval = 30 * time.Second
+	// streamWriteTimeout is the time allowed to write a message to a stream client.
+	streamWriteTimeout = 10 * time.Second
+)
+
+// checkOrigin reports whether the request may connect to the stream, requests without an Origin header are not made
+// by a browser page and are allowed, as are pages served from the same host or from an allowed origin.
+func (s *WebServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if s.allowedOrigin(origin) {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// allowedOrigin reports whether origin is in the allow-list.
+func (s *WebServer) allowedOrigin(origin string) bool {
+	return slices.ContainsFunc(s.StreamOrigins, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin)
+	})
+}
+
+// parseStreamQuery parses the port, node, channel and replay query parameters.
+func parseStreamQuery(r *http.Request) (stream.Filter, int, error) {
+	values := r.URL.Query()
+	f := stream.Filter{
+		PortNum: strings.ToUpper(values.Get("port")),
+		Channel: values.Get("channel"),
+	}
+
+	if node := values.Get("node"); node != "" {
+		num, err := mtypes.ParseNodeID(node)
+		if err != nil {
+			return f, 0, err
+		}
+		f.Node = &num
+	}
+
+	var replay int
+	if n := values.Get("replay"); n != "" {
+		var err error
+		if replay, err = strconv.Atoi(n); err != nil || replay < 0 {
+			return f, 0, errors.New("invalid replay")
+		}
+		replay = min(replay, maxMessageLimit)
+	}
+
+	return f, replay, nil
+}
+
+// messageKey identifies a message by its sender and packet ID.
+type messageKey struct {
+	From uint32 `json:"from"`
+	ID   uint32 `json:"id"`
+}
+
+// replayed holds the messages replayed to a stream client.
+//
+// The client subscribes before the store is queried, so a message received in between is both replayed and
+// streamed, live messages that were replayed are skipped.
+type replayed struct {
+	payloads [][]byte
+	seen     map[messageKey]struct{}
+}
+
+// duplicate returns true if the live payload was already replayed.
+func (r *replayed) duplicate(payload []byte) bool {
+	if len(r.seen) == 0 {
+		return false
+	}
+
+	var key messageKey
+	if err := json.Unmarshal(payload, &key); err != nil {
+		return false
+	}
+
+	if _, ok := r.seen[key]; !ok {
+		return false
+	}
+	delete(r.seen, key)
+
+	return true
+}
+
+// forget drops the replayed messages once live messages can no longer duplicate them.
+func (r *replayed) forget() {
+	clear(r.seen)
+}
+
+// replay returns the last n messages in the store matching the filter, oldest first.
+//
+// Stored messages have no channel ID, so they only match a channel filter by channel number.
+func (s *WebServer) replay(ctx context.Context, f stream.Filter, n int) *replayed {
+	out := &replayed{}
+	if s.Store == nil || n == 0 {
+		return out
+	}
+
+	messages, err := s.Store.Query(ctx, store.Query{From: f.Node, PortNum: f.PortNum, Limit: n})
+	if err != nil {
+		if !errors.Is(err, store.ErrNotImplemented) {
+			s.Logger.ErrorContext(ctx, "Failed to query messages to replay", slogtool.ErrorAttr(err))
+		}
+		return out
+	}
+	slices.Reverse(messages)
+
+	out.payloads = make([][]byte, 0, len(messages))
+	out.seen = make(map[messageKey]struct{}, len(messages))
+	for _, msg := range messages {
+		if !f.Match(msg, "") {
+			continue
+		}
+
+		payload, jsonErr := msg.ToJSON()
+		if jsonErr != nil {
+			continue
+		}
+		out.payloads = append(out.payloads, bytes.TrimSpace(payload))
+		out.seen[messageKey{From: msg.From, ID: msg.ID}] = struct{}{}
+	}
+
+	return out
+}
+
+// subscribe parses the query and subscribes to the stream, writing an error response if it fails.
+func (s *WebServer) subscribe(w http.ResponseWriter, r *http.Request) (*stream.Subscription, *replayed, bool) {
+	if s.Stream == nil {
+		s.writeError(w, http.StatusServiceUnavailable, errors.New("message stream not available"))
+		return nil, nil, false
+	}
+
+	if !s.checkOrigin(r) {
+		s.writeError(w, http.StatusForbidden, errors.New("origin not allowed"))
+		return nil, nil, false
+	}
+
+	f, n, err := parseStreamQuery(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return nil, nil, false
+	}
+
+	// Subscribe before replaying, so no message is missed between the two, duplicates are skipped by the handlers.
+	sub := s.Stream.Subscribe(f)
+	return sub, s.replay(r.Context(), f, n), true
+}
+
+// handleEvents streams messages as server-sent events, one JSON message per event.
+func (s *WebServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	sub, replayed, ok := s.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer s.unsubscribe(r.Context(), sub)
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	if origin := r.Header.Get("Origin"); origin != "" && s.allowedOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	write := func(format string, args ...any) bool {
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	for _, payload := range replayed.payloads {
+		if !write("data: %s\n\n", payload) {
+			return
+		}
+	}
+	if !write(": connected\n\n") {
+		return
+	}
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			replayed.forget()
+			if !write(": keepalive\n\n") {
+				return
+			}
+		case payload, open := <-sub.Messages():
+			if !open {
+				return
+			}
+			if !replayed.duplicate(payload) && !write("data: %s\n\n", payload) {
+				return
+			}
+		}
+	}
+}
+
+// handleWebSocket streams messages over a WebSocket, one JSON message per text frame.
+func (s *WebServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	sub, replayed, ok := s.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer s.unsubscribe(r.Context(), sub)
+
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.Logger.DebugContext(r.Context(), "Failed to upgrade WebSocket", slogtool.ErrorAttr(err))
+		return
+	}
+	defer conn.Close()
+
+	// Read until the client goes away, handling control frames, messages from the client are ignored.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, readErr := conn.NextReader(); readErr != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(messageType int, payload []byte) bool {
+		_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteMessage(messageType, payload) == nil
+	}
+
+	for _, payload := range replayed.payloads {
+		if !write(websocket.TextMessage, payload) {
+			return
+		}
+	}
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case <-keepalive.C:
+			replayed.forget()
+			if !write(websocket.PingMessage, nil) {
+				return
+			}
+		case payload, open := <-sub.Messages():
+			if !open {
+				return
+			}
+			if !replayed.duplicate(payload) && !write(websocket.TextMessage, payload) {
+				return
+			}
+		}
+	}
+}
+
+// unsubscribe closes the subscription, logging the number of messages the client missed by falling behind.
+func (s *WebServer) unsubscribe(ctx context.Context, sub *stream.Subscription) {
+	sub.Close()
+
+	if dropped := sub.Dropped(); dropped > 0 {
+		s.Logger.WarnContext(ctx, "Stream client fell behind, dropped messages", slog.Uint64("dropped", dropped))
+	}
+}
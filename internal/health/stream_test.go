@@ -0,0 +1,256 @@
+package health_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/health"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/stream"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"github.com/gorilla/websocket"
+)
+
+// newStreamServer returns a test server streaming from the hub, replaying from a store holding one text message.
+func newStreamServer(t *testing.T) (*httptest.Server, *stream.Hub, *stubStore) {
+	t.Helper()
+
+	st := &stubStore{messages: map[string]*mtypes.Message{
+		"1234": {ID: 1234, From: 0x44be043f, Type: "TEXT_MESSAGE_APP", Payload: "hello"},
+	}}
+	hub := stream.NewHub()
+
+	srv := httptest.NewServer(health.NewServer(0, slog.New(slog.DiscardHandler), nil,
+		health.WithStore(st),
+		health.WithStream(hub),
+	))
+	t.Cleanup(srv.Close)
+
+	return srv, hub, st
+}
+
+// publish waits for the hub to have a subscriber, then writes a position and a text message to it.
+func publish(t *testing.T, hub *stream.Hub) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, msg := range []*mtypes.Message{
+		{ID: 1, From: 0x44be043f, Type: "POSITION_APP"},
+		{ID: 2, From: 0x44be043f, Type: "TEXT_MESSAGE_APP", Payload: "live"},
+	} {
+		pkt := &pipeline.Packet{Message: msg, Envelope: &meshtastic.ServiceEnvelope{ChannelId: "LongFast"}}
+		if err := hub.Write(t.Context(), pkt); err != nil {
+			t.Fatalf("Write(): error got '%v', want 'nil'", err)
+		}
+	}
+}
+
+func decode(t *testing.T, data []byte) *mtypes.Message {
+	t.Helper()
+
+	msg := &mtypes.Message{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		t.Fatalf("invalid message '%s': %v", data, err)
+	}
+
+	return msg
+}
+
+func TestEvents(t *testing.T) {
+	srv, hub, st := newStreamServer(t)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	// Stored messages have no channel ID, so filter on the channel number to match the replayed message.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/events?port=text_message_app&node=!44be043f&channel=0&replay=5", nil,
+	)
+	if err != nil {
+		t.Fatalf("NewRequest(): %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type: got '%s', want 'text/event-stream'", got)
+	}
+	if st.lastQuery.Limit != 5 || st.lastQuery.From == nil || *st.lastQuery.From != 0x44be043f {
+		t.Errorf("replay query: got '%+v'", st.lastQuery)
+	}
+
+	publish(t, hub)
+
+	var payloads []string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(payloads) < 2 && scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			payloads = append(payloads, decode(t, []byte(data)).Payload.(string))
+		}
+	}
+
+	if len(payloads) != 2 || payloads[0] != "hello" || payloads[1] != "live" {
+		t.Errorf("events: got '%v', want '[hello live]'", payloads)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	srv, hub, _ := newStreamServer(t)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?port=TEXT_MESSAGE_APP&replay=1", nil,
+	)
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer resp.Body.Close()
+	defer conn.Close()
+
+	publish(t, hub)
+
+	var payloads []string
+	for range 2 {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, readErr := conn.ReadMessage()
+		if readErr != nil {
+			t.Fatalf("ReadMessage(): %v", readErr)
+		}
+		payloads = append(payloads, decode(t, data).Payload.(string))
+	}
+
+	if payloads[0] != "hello" || payloads[1] != "live" {
+		t.Errorf("messages: got '%v', want '[hello live]'", payloads)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := hub.Len(); got != 0 {
+		t.Errorf("Len() after close: got '%d', want '0'", got)
+	}
+}
+
+func TestWebSocketReplayDuplicate(t *testing.T) {
+	srv, hub, _ := newStreamServer(t)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?port=TEXT_MESSAGE_APP&replay=1", nil,
+	)
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+	defer resp.Body.Close()
+	defer conn.Close()
+
+	// The stored message is also received live, as it would be if it arrived between subscribing and replaying.
+	stored := &mtypes.Message{ID: 1234, From: 0x44be043f, Type: "TEXT_MESSAGE_APP", Payload: "hello"}
+	pkt := &pipeline.Packet{Message: stored, Envelope: &meshtastic.ServiceEnvelope{ChannelId: "LongFast"}}
+	deadline := time.Now().Add(5 * time.Second)
+	for hub.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err = hub.Write(t.Context(), pkt); err != nil {
+		t.Fatalf("Write(): error got '%v', want 'nil'", err)
+	}
+	publish(t, hub)
+
+	var payloads []string
+	for range 2 {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, readErr := conn.ReadMessage()
+		if readErr != nil {
+			t.Fatalf("ReadMessage(): %v", readErr)
+		}
+		payloads = append(payloads, decode(t, data).Payload.(string))
+	}
+
+	if payloads[0] != "hello" || payloads[1] != "live" {
+		t.Errorf("messages: got '%v', want '[hello live]'", payloads)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	for _, tt := range []struct {
+		target string
+		code   int
+	}{
+		{"/events", http.StatusServiceUnavailable},
+		{"/ws", http.StatusServiceUnavailable},
+	} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.code {
+			t.Errorf("GET %s status = %d, want %d", tt.target, rec.Code, tt.code)
+		}
+	}
+
+	withHub := health.NewServer(0, slog.New(slog.DiscardHandler), nil, health.WithStream(stream.NewHub()))
+	for _, target := range []string{"/events?node=not-a-node", "/ws?replay=-1", "/events?replay=x"} {
+		rec := httptest.NewRecorder()
+		withHub.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s status = %d, want %d", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestStreamOrigin(t *testing.T) {
+	srv := health.NewServer(0, slog.New(slog.DiscardHandler), nil,
+		health.WithStream(stream.NewHub()),
+		health.WithStreamOrigins("https://dash.example.com/"),
+	)
+
+	// The request context is cancelled, so an allowed request returns once connected.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	for _, tt := range []struct {
+		target string
+		origin string
+		code   int
+		cors   string
+	}{
+		{target: "/events", code: http.StatusOK},
+		{target: "/events", origin: "http://example.com", code: http.StatusOK},
+		{target: "/events", origin: "https://dash.example.com", code: http.StatusOK, cors: "https://dash.example.com"},
+		{target: "/events", origin: "https://evil.example.net", code: http.StatusForbidden},
+		{target: "/ws", origin: "https://evil.example.net", code: http.StatusForbidden},
+	} {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, tt.target, nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("GET %s from '%s' status = %d, want %d", tt.target, tt.origin, rec.Code, tt.code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.cors {
+			t.Errorf("GET %s from '%s' Access-Control-Allow-Origin: got '%s', want '%s'",
+				tt.target, tt.origin, got, tt.cors,
+			)
+		}
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"encoding/json"
 	"errors"
 	"log/slog"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/na4ma4/meshtastic-mqtt-translate/internal/fanout"
-	"github.com/na4ma4/meshtastic-mqtt-translate/internal/relay"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/stream"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/topology"
 )
 
 const (
@@ -18,20 +23,55 @@ const (
 )
 
 type WebServer struct {
-	Logger *slog.Logger
-	Port   int
-	Relay  *relay.Relay
-	Fanout *fanout.Fanout
-	srv    *http.Server
+	Logger   *slog.Logger
+	Port     int
+	Pipeline *pipeline.Pipeline
+	Store    store.Store
+	NodeDB   *nodedb.DB
+	Topology *topology.Graph
+	Metrics  *metrics.Metrics
+	Stream   *stream.Hub
+	// StreamOrigins lists the cross-origin pages allowed to connect to the stream, "*" allows any origin.
+	StreamOrigins []string
+	mux           *http.ServeMux
+	srv           *http.Server
 }
 
-func NewServer(port int, logger *slog.Logger, relay *relay.Relay, fanout *fanout.Fanout) *WebServer {
-	return &WebServer{
-		Logger: logger,
-		Port:   port,
-		Relay:  relay,
-		Fanout: fanout,
+func NewServer(
+	port int,
+	logger *slog.Logger,
+	p *pipeline.Pipeline,
+	opts ...OptionFunc,
+) *WebServer {
+	s := &WebServer{
+		Logger:   logger,
+		Port:     port,
+		Pipeline: p,
+		mux:      http.NewServeMux(),
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.routes()
+
+	return s
+}
+
+func (s *WebServer) routes() {
+	s.mux.HandleFunc("GET /api/status", s.handleStatus)
+	s.mux.HandleFunc("GET /api/nodes", s.handleNodes)
+	s.mux.HandleFunc("GET /api/nodes/{id}", s.handleNode)
+	s.mux.HandleFunc("GET /api/messages", s.handleMessages)
+	s.mux.HandleFunc("GET /api/messages/{messageID}", s.handleMessage)
+	s.mux.HandleFunc("GET /api/topology", s.handleTopology)
+	s.mux.HandleFunc("GET /ws", s.handleWebSocket)
+	s.mux.HandleFunc("GET /events", s.handleEvents)
+	if s.Metrics != nil {
+		s.mux.Handle("GET /metrics", s.Metrics.Handler())
+	}
+	s.mux.HandleFunc("/", s.handleHealth)
 }
 
 func (s *WebServer) Start() <-chan error {
@@ -40,13 +80,17 @@ func (s *WebServer) Start() <-chan error {
 		errChan <- errors.New("server already started")
 		return errChan
 	}
+	// Request contexts are cancelled on shutdown, so streams to clients end rather than hold the server open.
+	baseCtx, cancel := context.WithCancel(context.Background())
 	s.srv = &http.Server{
 		Addr:         ":" + strconv.Itoa(s.Port),
 		Handler:      s,
 		ReadTimeout:  defaultTimeout,
 		WriteTimeout: defaultTimeout,
 		IdleTimeout:  defaultTimeout,
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
 	}
+	s.srv.RegisterOnShutdown(cancel)
 	errChan := make(chan error, 1)
 	go func() {
 		defer close(errChan)
@@ -68,25 +112,30 @@ func (s *WebServer) Stop(ctx context.Context) error {
 }
 
 func (s *WebServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.Logger.Debug("Health check", slog.String("remote_addr", r.RemoteAddr))
+	s.mux.ServeHTTP(w, r)
+}
 
+// status returns the status of the pipeline broker clients, and whether they are all healthy.
+func (s *WebServer) status() (map[string]interface{}, bool) {
 	statusOK := true
 	status := map[string]interface{}{}
 
-	if s.Fanout != nil {
-		st := s.Fanout.GetStatus()
-		status["fanout"] = st
-		statusOK = statusOK && st.Status
-	}
-
-	if s.Relay != nil {
-		relayStatus := s.Relay.GetStatus()
-		status["relay"] = relayStatus
-		statusOK = statusOK && relayStatus.Status
+	if s.Pipeline != nil {
+		pipelineStatus := s.Pipeline.GetStatus()
+		status["pipeline"] = pipelineStatus
+		statusOK = statusOK && pipelineStatus.Status
 	}
 
 	status["status"] = statusOK
 
+	return status, statusOK
+}
+
+func (s *WebServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.Logger.Debug("Health check", slog.String("remote_addr", r.RemoteAddr))
+
+	status, statusOK := s.status()
+
 	w.Header().Set("Content-Type", "application/json")
 
 	if !statusOK {
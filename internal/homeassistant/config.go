@@ -0,0 +1,17 @@
+package homeassistant
+
+import "github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+
+// DefaultDiscoveryPrefix is the default Home Assistant MQTT discovery prefix.
+const DefaultDiscoveryPrefix = "homeassistant"
+
+// Config holds the Home Assistant discovery output configuration.
+type Config struct {
+	// DiscoveryPrefix is the topic prefix Home Assistant watches for discovery payloads, DefaultDiscoveryPrefix if
+	// empty.
+	DiscoveryPrefix string
+	// FanoutTopic is the base topic of the fanout output, the state topics of the entities are below it.
+	FanoutTopic string
+	// NodeDB provides the device details of each node, optional.
+	NodeDB *nodedb.DB
+}
@@ -0,0 +1,278 @@
+// Package homeassistant is the pipeline output announcing the sensors of each node to Home Assistant with retained
+// MQTT discovery payloads, <prefix>/<component>/<node>/<metric>/config, as the relay learns them. The state of each
+// entity is read from the fanout topics.
+package homeassistant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/fanout"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+// OutputName is the name of the Home Assistant discovery output.
+const OutputName = "homeassistant"
+
+const (
+	// manufacturer is the manufacturer of every device.
+	manufacturer = "Meshtastic"
+	// contentTypeJSON is the MQTT 5 content type of discovery payloads.
+	contentTypeJSON = "application/json"
+)
+
+// Device is the device an entity belongs to, one per node.
+type Device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+// Entity is the discovery payload of a Home Assistant MQTT entity.
+type Entity struct {
+	Name                   string `json:"name"`
+	UniqueID               string `json:"unique_id"`
+	ObjectID               string `json:"object_id"`
+	StateTopic             string `json:"state_topic,omitempty"`
+	ValueTemplate          string `json:"value_template,omitempty"`
+	JSONAttributesTopic    string `json:"json_attributes_topic,omitempty"`
+	JSONAttributesTemplate string `json:"json_attributes_template,omitempty"`
+	UnitOfMeasurement      string `json:"unit_of_measurement,omitempty"`
+	DeviceClass            string `json:"device_class,omitempty"`
+	StateClass             string `json:"state_class,omitempty"`
+	EntityCategory         string `json:"entity_category,omitempty"`
+	Icon                   string `json:"icon,omitempty"`
+	SourceType             string `json:"source_type,omitempty"`
+	Device                 Device `json:"device"`
+}
+
+// metric is a telemetry value announced as a sensor.
+type metric struct {
+	key            string
+	name           string
+	unit           string
+	deviceClass    string
+	entityCategory string
+	icon           string
+}
+
+// telemetry are the telemetry values announced as sensors, by the payload field they are reported in.
+//
+//nolint:gochecknoglobals // lookup table.
+var telemetry = []struct {
+	field   string
+	metrics []metric
+}{
+	{"device_metrics", []metric{
+		{key: "battery_level", name: "Battery", unit: "%", deviceClass: "battery"},
+		{key: "voltage", name: "Voltage", unit: "V", deviceClass: "voltage"},
+		{key: "channel_utilization", name: "Channel utilisation", unit: "%", entityCategory: "diagnostic", icon: "mdi:radio-tower"},
+		{key: "air_util_tx", name: "Airtime utilisation", unit: "%", entityCategory: "diagnostic", icon: "mdi:radio-tower"},
+		{key: "uptime_seconds", name: "Uptime", unit: "s", deviceClass: "duration", entityCategory: "diagnostic"},
+	}},
+	{"environment_metrics", []metric{
+		{key: "temperature", name: "Temperature", unit: "°C", deviceClass: "temperature"},
+		{key: "relative_humidity", name: "Humidity", unit: "%", deviceClass: "humidity"},
+		{key: "barometric_pressure", name: "Pressure", unit: "hPa", deviceClass: "atmospheric_pressure"},
+		{key: "lux", name: "Illuminance", unit: "lx", deviceClass: "illuminance"},
+		{key: "iaq", name: "Indoor air quality", deviceClass: "aqi"},
+	}},
+	{"air_quality_metrics", []metric{
+		{key: "pm10_standard", name: "PM1.0", unit: "µg/m³", deviceClass: "pm1"},
+		{key: "pm25_standard", name: "PM2.5", unit: "µg/m³", deviceClass: "pm25"},
+		{key: "pm100_standard", name: "PM10", unit: "µg/m³", deviceClass: "pm10"},
+		{key: "co2", name: "CO2", unit: "ppm", deviceClass: "carbon_dioxide"},
+	}},
+}
+
+// NewOutput returns the Home Assistant discovery output, publishing with pub.
+//
+// Packets that could not be decrypted are dropped, as the entities are found from the decoded payload.
+func NewOutput(pub pipeline.Publisher, config Config) *pipeline.Output {
+	return &pipeline.Output{
+		Name:   OutputName,
+		Stages: []pipeline.Stage{pipeline.DropEncrypted()},
+		Sink:   NewSink(pub, config),
+	}
+}
+
+// Sink publishes the discovery payloads of the entities found in each packet, each payload is published again only
+// when it changes, e.g. when the name of the node is learned.
+type Sink struct {
+	Publisher pipeline.Publisher
+	Config    Config
+
+	stateTopic pipeline.TopicFunc
+	lock       sync.Mutex
+	announced  map[string][]byte
+}
+
+// NewSink returns a sink publishing discovery payloads with pub.
+func NewSink(pub pipeline.Publisher, config Config) *Sink {
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = DefaultDiscoveryPrefix
+	}
+
+	return &Sink{
+		Publisher:  pub,
+		Config:     config,
+		stateTopic: fanout.TopicFunc(config.FanoutTopic),
+		announced:  make(map[string][]byte),
+	}
+}
+
+// Write publishes the discovery payloads of the entities in the packet that have not been announced.
+func (s *Sink) Write(ctx context.Context, pkt *pipeline.Packet) error {
+	var errs []error
+
+	for topic, entity := range s.entities(pkt) {
+		payload, err := json.Marshal(entity)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", pipeline.ErrEncodeJSON, err))
+			continue
+		}
+
+		if !s.announce(topic, payload) {
+			continue
+		}
+
+		if err = s.Publisher.Publish(ctx, &broker.Message{
+			Topic:      topic,
+			Payload:    payload,
+			Retain:     true,
+			Properties: broker.Properties{ContentType: contentTypeJSON},
+		}); err != nil {
+			s.forget(topic)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// announce records the payload published to the topic, returning false if it was already published.
+func (s *Sink) announce(topic string, payload []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if bytes.Equal(s.announced[topic], payload) {
+		return false
+	}
+
+	s.announced[topic] = payload
+	return true
+}
+
+// forget removes a payload that failed to publish, so it is published again with the next packet.
+func (s *Sink) forget(topic string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.announced, topic)
+}
+
+// entities returns the entities found in the packet, by discovery topic.
+func (s *Sink) entities(pkt *pipeline.Packet) map[string]*Entity {
+	node := strings.TrimPrefix(mtypes.FormatNodeID(pkt.Message.From), "!")
+	device := s.device(pkt.Message)
+	stateTopic := s.stateTopic(pkt)
+
+	entity := func(key, name string) *Entity {
+		id := "meshtastic_" + node + "_" + key
+		return &Entity{Name: name, UniqueID: id, ObjectID: id, Device: device}
+	}
+
+	out := make(map[string]*Entity)
+	switch pkt.Envelope.GetPacket().GetDecoded().GetPortnum() { //nolint:exhaustive // only these are announced.
+	case meshtastic.PortNum_TELEMETRY_APP:
+		_, generic := pkt.Generic()
+		payload, _ := generic.(map[string]any)
+		for _, group := range telemetry {
+			values, _ := payload[group.field].(map[string]any)
+			for _, m := range group.metrics {
+				if values[m.key] == nil {
+					continue
+				}
+
+				e := entity(m.key, m.name)
+				e.StateTopic = stateTopic
+				e.ValueTemplate = "{{ value_json.payload." + group.field + "." + m.key + " }}"
+				e.UnitOfMeasurement = m.unit
+				e.DeviceClass = m.deviceClass
+				e.StateClass = "measurement"
+				e.EntityCategory = m.entityCategory
+				e.Icon = m.icon
+				out[s.topic("sensor", node, m.key)] = e
+			}
+		}
+	case meshtastic.PortNum_POSITION_APP:
+		_, generic := pkt.Generic()
+		payload, _ := generic.(map[string]any)
+		if payload["latitude_i"] == nil || payload["longitude_i"] == nil {
+			break
+		}
+
+		e := entity("position", "Position")
+		e.JSONAttributesTopic = stateTopic
+		e.JSONAttributesTemplate = `{{ {"latitude": value_json.payload.latitude_i / 10000000, ` +
+			`"longitude": value_json.payload.longitude_i / 10000000, ` +
+			`"altitude": value_json.payload.altitude} | tojson }}`
+		e.SourceType = "gps"
+		e.Icon = "mdi:map-marker-radius"
+		out[s.topic("device_tracker", node, "position")] = e
+	case meshtastic.PortNum_TEXT_MESSAGE_APP:
+		e := entity("last_message", "Last message")
+		e.StateTopic = stateTopic
+		e.ValueTemplate = "{{ value_json.payload }}"
+		e.Icon = "mdi:message-text"
+		out[s.topic("sensor", node, "last_message")] = e
+	}
+
+	return out
+}
+
+// device returns the device of the node that sent the message, from the node database if it knows the node.
+func (s *Sink) device(msg *mtypes.Message) Device {
+	d := Device{
+		Identifiers:  []string{"meshtastic_" + strings.TrimPrefix(mtypes.FormatNodeID(msg.From), "!")},
+		Name:         mtypes.FormatNodeID(msg.From),
+		Manufacturer: manufacturer,
+	}
+
+	if msg.FromNode != nil {
+		d.Model = msg.FromNode.HwModel
+		if msg.FromNode.LongName != "" {
+			d.Name = msg.FromNode.LongName
+		}
+	}
+
+	if s.Config.NodeDB != nil {
+		if node, ok := s.Config.NodeDB.Get(msg.From); ok {
+			if node.LongName != "" {
+				d.Name = node.LongName
+			}
+			if node.HwModel != "" {
+				d.Model = node.HwModel
+			}
+			d.SWVersion = node.FirmwareVersion
+		}
+	}
+
+	return d
+}
+
+// topic returns the discovery topic of an entity.
+func (s *Sink) topic(component, node, objectID string) string {
+	return path.Join(s.Config.DiscoveryPrefix, component, node, objectID, "config")
+}
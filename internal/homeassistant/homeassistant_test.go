@@ -0,0 +1,207 @@
+package homeassistant_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/homeassistant"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/na4ma4/go-contextual"
+)
+
+const testTopic = "msh/ANZ/2/e/MediumFast/!44be043f"
+
+// recorder is a pipeline.Publisher recording the published messages.
+type recorder struct {
+	lock sync.Mutex
+	msgs []*broker.Message
+}
+
+func (r *recorder) Publish(_ context.Context, msg *broker.Message) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+// entities returns the published discovery payloads by topic.
+func (r *recorder) entities(t *testing.T) map[string]*homeassistant.Entity {
+	t.Helper()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	out := make(map[string]*homeassistant.Entity)
+	for _, msg := range r.msgs {
+		if !msg.Retain || msg.Properties.ContentType != "application/json" {
+			t.Errorf("Publish(%s): expected retained JSON, got '%+v'", msg.Topic, msg)
+		}
+
+		e := &homeassistant.Entity{}
+		if err := json.Unmarshal(msg.Payload, e); err != nil {
+			t.Fatalf("Publish(%s): invalid payload: %v", msg.Topic, err)
+		}
+		out[msg.Topic] = e
+	}
+	return out
+}
+
+func (r *recorder) reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.msgs = nil
+}
+
+func loadPayload(t *testing.T, filename string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path.Join("..", "..", "testdata", "msgs", filename+".enc"))
+	if err != nil {
+		t.Fatalf("Failed to read test case file %s: %v", filename, err)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	return payload
+}
+
+func newPipeline(t *testing.T, db *nodedb.DB, rec *recorder) *pipeline.Pipeline {
+	t.Helper()
+
+	p, err := pipeline.NewPipeline(contextual.New(t.Context()), pipeline.Config{NodeDB: db}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+
+	p.AddOutput(homeassistant.NewOutput(rec, homeassistant.Config{FanoutTopic: "meshtastic/fanout", NodeDB: db}))
+	return p
+}
+
+func TestDiscovery(t *testing.T) {
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+	rec := &recorder{}
+	p := newPipeline(t, db, rec)
+
+	for _, name := range []string{"message-01", "message-02", "message-04", "message-10"} {
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, name)})
+	}
+
+	entities := rec.entities(t)
+
+	var topics []string
+	for topic := range entities {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+
+	want := []string{
+		"homeassistant/device_tracker/ef91c82d/position/config",
+		"homeassistant/sensor/4a305650/last_message/config",
+		"homeassistant/sensor/a0c8f158/barometric_pressure/config",
+		"homeassistant/sensor/a0c8f158/temperature/config",
+		"homeassistant/sensor/b090085b/air_util_tx/config",
+		"homeassistant/sensor/b090085b/battery_level/config",
+		"homeassistant/sensor/b090085b/channel_utilization/config",
+		"homeassistant/sensor/b090085b/uptime_seconds/config",
+		"homeassistant/sensor/b090085b/voltage/config",
+	}
+	if diff := cmp.Diff(want, topics); diff != "" {
+		t.Errorf("discovery topics mismatch (-want +got):\n%s", diff)
+	}
+
+	battery := entities["homeassistant/sensor/b090085b/battery_level/config"]
+	if diff := cmp.Diff(&homeassistant.Entity{
+		Name:              "Battery",
+		UniqueID:          "meshtastic_b090085b_battery_level",
+		ObjectID:          "meshtastic_b090085b_battery_level",
+		StateTopic:        "meshtastic/fanout/2962229339/TELEMETRY_APP/DeviceMetrics",
+		ValueTemplate:     "{{ value_json.payload.device_metrics.battery_level }}",
+		UnitOfMeasurement: "%",
+		DeviceClass:       "battery",
+		StateClass:        "measurement",
+		Device: homeassistant.Device{
+			Identifiers:  []string{"meshtastic_b090085b"},
+			Name:         "!b090085b",
+			Manufacturer: "Meshtastic",
+		},
+	}, battery); diff != "" {
+		t.Errorf("battery entity mismatch (-want +got):\n%s", diff)
+	}
+
+	temperature := entities["homeassistant/sensor/a0c8f158/temperature/config"]
+	if temperature.StateTopic != "meshtastic/fanout/2697523544/TELEMETRY_APP/EnvironmentMetrics" ||
+		temperature.UnitOfMeasurement != "°C" {
+		t.Errorf("temperature entity: got '%+v'", temperature)
+	}
+
+	tracker := entities["homeassistant/device_tracker/ef91c82d/position/config"]
+	if tracker.JSONAttributesTopic != "meshtastic/fanout/4019308589/POSITION_APP" || tracker.SourceType != "gps" {
+		t.Errorf("device tracker entity: got '%+v'", tracker)
+	}
+
+	text := entities["homeassistant/sensor/4a305650/last_message/config"]
+	if text.StateTopic != "meshtastic/fanout/1244681808/TEXT_MESSAGE_APP" || text.ValueTemplate != "{{ value_json.payload }}" {
+		t.Errorf("last message entity: got '%+v'", text)
+	}
+}
+
+func TestDiscoveryRepublish(t *testing.T) {
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+	rec := &recorder{}
+	p := newPipeline(t, db, rec)
+
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-04")})
+	rec.reset()
+
+	// An unchanged entity is not announced again.
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-04")})
+	if got := len(rec.entities(t)); got != 0 {
+		t.Fatalf("Publish(): got '%d' messages, want '0'", got)
+	}
+
+	// Learning the node changes the device, so the entity is announced again.
+	db.Observe(&mtypes.Message{
+		From: 1244681808,
+		To:   mtypes.BroadcastNode,
+		Payload: &translator.MapReportApp{
+			LongName:        "Ping Node",
+			HwModel:         "RAK4631",
+			FirmwareVersion: "2.6.11.60ec05e",
+		},
+	})
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-04")})
+
+	entity := rec.entities(t)["homeassistant/sensor/4a305650/last_message/config"]
+	if entity == nil {
+		t.Fatal("Publish(): expected last message entity to be announced again")
+	}
+
+	want := homeassistant.Device{
+		Identifiers:  []string{"meshtastic_4a305650"},
+		Name:         "Ping Node",
+		Model:        "RAK4631",
+		SWVersion:    "2.6.11.60ec05e",
+		Manufacturer: "Meshtastic",
+	}
+	if diff := cmp.Diff(want, entity.Device); diff != "" {
+		t.Errorf("device mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,39 @@
+package influx
+
+import (
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+)
+
+const (
+	// DefaultBatchSize is the default number of lines written in one request.
+	DefaultBatchSize = 500
+	// DefaultFlushInterval is the default interval between writing partial batches.
+	DefaultFlushInterval = 10 * time.Second
+	// DefaultTimeout is the default timeout of a write request.
+	DefaultTimeout = 10 * time.Second
+	// DefaultMaxPending is the default number of lines held while InfluxDB is unavailable.
+	DefaultMaxPending = 10000
+)
+
+// Config holds the InfluxDB output configuration.
+type Config struct {
+	// URL is the base URL of the InfluxDB server, e.g. http://localhost:8086.
+	URL    string
+	Org    string
+	Bucket string
+	// Token is the API token, optional.
+	Token string
+	// BatchSize is the number of lines written in one request, DefaultBatchSize if zero.
+	BatchSize int
+	// FlushInterval is the interval between writing partial batches, DefaultFlushInterval if zero.
+	FlushInterval time.Duration
+	// Timeout is the timeout of a write request, DefaultTimeout if zero.
+	Timeout time.Duration
+	// MaxPending is the number of lines held while InfluxDB is unavailable before the oldest lines are dropped,
+	// DefaultMaxPending if zero.
+	MaxPending int
+	// Retry is the backoff applied when a write fails.
+	Retry pipeline.RetryConfig
+}
@@ -0,0 +1,43 @@
+// Package influx is the pipeline output writing telemetry to InfluxDB v2 as line protocol, one measurement per
+// metrics variant, in batches.
+package influx
+
+import (
+	"context"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+)
+
+// OutputName is the name of the InfluxDB output.
+const OutputName = "influx"
+
+// NewOutput returns the InfluxDB output, queueing lines on w.
+//
+// Packets without telemetry written to InfluxDB are dropped.
+func NewOutput(w *Writer) *pipeline.Output {
+	return &pipeline.Output{
+		Name: OutputName,
+		Stages: []pipeline.Stage{
+			pipeline.DropEncrypted(),
+			pipeline.StageFunc(func(_ context.Context, pkt *pipeline.Packet) bool {
+				measurement, _, _ := telemetry(pkt.Message.Payload)
+				return measurement != ""
+			}),
+		},
+		Sink: &Sink{Writer: w},
+	}
+}
+
+// Sink queues the line protocol of each packet on a writer.
+type Sink struct {
+	Writer *Writer
+}
+
+// Write queues the line protocol of the telemetry in the packet, it is written to InfluxDB in the background.
+func (s *Sink) Write(_ context.Context, pkt *pipeline.Packet) error {
+	if line, ok := Line(pkt); ok {
+		s.Writer.Add(line)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package influx_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/influx"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/na4ma4/go-contextual"
+)
+
+const testTopic = "msh/ANZ/2/e/MediumFast/!44be043f"
+
+var fastRetry = pipeline.RetryConfig{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+// server is an InfluxDB write API stand-in, responding with the queued status codes and then 204.
+type server struct {
+	*httptest.Server
+
+	statuses []int
+	lock     sync.Mutex
+	requests []*http.Request
+	bodies   []string
+	calls    atomic.Int32
+}
+
+func newServer(t *testing.T, statuses ...int) *server {
+	t.Helper()
+
+	s := &server{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(s.calls.Add(1)) - 1
+		if n < len(s.statuses) {
+			w.WriteHeader(s.statuses[n])
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *server) written() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var lines []string
+	for _, body := range s.bodies {
+		lines = append(lines, strings.Split(body, "\n")...)
+	}
+	return lines
+}
+
+func newWriter(s *server, config influx.Config) *influx.Writer {
+	config.URL = s.URL
+	config.Org = "mesh"
+	config.Bucket = "telemetry"
+	config.Retry = fastRetry
+	return influx.NewWriter(config, slog.New(slog.DiscardHandler))
+}
+
+func loadPayload(t *testing.T, filename string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path.Join("..", "..", "testdata", "msgs", filename+".enc"))
+	if err != nil {
+		t.Fatalf("Failed to read test case file %s: %v", filename, err)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	return payload
+}
+
+func TestOutput(t *testing.T) {
+	s := newServer(t)
+	w := newWriter(s, influx.Config{Token: "secret"})
+
+	p, err := pipeline.NewPipeline(contextual.New(t.Context()), pipeline.Config{}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	p.AddOutput(influx.NewOutput(w))
+
+	for _, name := range []string{"message-01", "message-02", "message-04", "message-10"} {
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, name)})
+	}
+
+	if err = w.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush(): error got '%v', want 'nil'", err)
+	}
+
+	want := []string{
+		"device_metrics,channel=MediumFast,gateway=!44be043f,node=!b090085b " +
+			"air_util_tx=0.4576944410800934,battery_level=101,channel_utilization=2.886666774749756," +
+			"uptime_seconds=2078083,voltage=4.60099983215332 1762936315",
+		"environment_metrics,channel=MediumFast,gateway=!44be043f,node=!a0c8f158 " +
+			"barometric_pressure=998.319091796875,temperature=26.670000076293945 1763267896",
+	}
+	if diff := cmp.Diff(want, s.written()); diff != "" {
+		t.Errorf("written lines mismatch (-want +got):\n%s", diff)
+	}
+
+	req := s.requests[0]
+	if req.URL.Path != "/api/v2/write" || req.URL.Query().Get("org") != "mesh" ||
+		req.URL.Query().Get("bucket") != "telemetry" || req.URL.Query().Get("precision") != "s" {
+		t.Errorf("request url: got '%s'", req.URL)
+	}
+	if got := req.Header.Get("Authorization"); got != "Token secret" {
+		t.Errorf("Authorization header: got '%s', want 'Token secret'", got)
+	}
+}
+
+func TestWriterBatches(t *testing.T) {
+	s := newServer(t)
+	w := newWriter(s, influx.Config{BatchSize: 2})
+
+	w.Add("m a=1 1", "m a=2 2", "m a=3 3", "m a=4 4", "m a=5 5")
+	if err := w.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush(): error got '%v', want 'nil'", err)
+	}
+
+	if diff := cmp.Diff([]string{"m a=1 1\nm a=2 2", "m a=3 3\nm a=4 4", "m a=5 5"}, s.bodies); diff != "" {
+		t.Errorf("batches mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriterRetry(t *testing.T) {
+	s := newServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	w := newWriter(s, influx.Config{})
+
+	w.Add("m a=1 1")
+	if err := w.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush(): error got '%v', want 'nil'", err)
+	}
+
+	if diff := cmp.Diff([]string{"m a=1 1"}, s.written()); diff != "" {
+		t.Errorf("written lines mismatch (-want +got):\n%s", diff)
+	}
+	if got := s.calls.Load(); got != 3 {
+		t.Errorf("requests: got '%d', want '3'", got)
+	}
+}
+
+func TestWriterUnavailable(t *testing.T) {
+	s := newServer(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	w := newWriter(s, influx.Config{})
+
+	w.Add("m a=1 1", "m a=2 2")
+	if err := w.Flush(t.Context()); !errors.Is(err, influx.ErrWrite) {
+		t.Fatalf("Flush(): error got '%v', want '%v'", err, influx.ErrWrite)
+	}
+	if got := w.Len(); got != 2 {
+		t.Fatalf("Len(): got '%d', want '2'", got)
+	}
+
+	// The lines are kept, in order, and written once InfluxDB recovers.
+	w.Add("m a=3 3")
+	if err := w.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush(): error got '%v', want 'nil'", err)
+	}
+
+	if diff := cmp.Diff([]string{"m a=1 1", "m a=2 2", "m a=3 3"}, s.written()); diff != "" {
+		t.Errorf("written lines mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriterRejected(t *testing.T) {
+	s := newServer(t, http.StatusBadRequest)
+	w := newWriter(s, influx.Config{BatchSize: 1})
+
+	w.Add("bad", "m a=1 1")
+	if err := w.Flush(t.Context()); !errors.Is(err, influx.ErrRejected) {
+		t.Fatalf("Flush(): error got '%v', want '%v'", err, influx.ErrRejected)
+	}
+
+	// The rejected batch is dropped without retrying, the batch after it is still written.
+	if diff := cmp.Diff([]string{"m a=1 1"}, s.written()); diff != "" {
+		t.Errorf("written lines mismatch (-want +got):\n%s", diff)
+	}
+	if got := s.calls.Load(); got != 2 {
+		t.Errorf("requests: got '%d', want '2'", got)
+	}
+}
+
+func TestWriterMaxPending(t *testing.T) {
+	s := newServer(t)
+	w := newWriter(s, influx.Config{MaxPending: 2})
+
+	w.Add("m a=1 1", "m a=2 2", "m a=3 3")
+	if err := w.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush(): error got '%v', want 'nil'", err)
+	}
+
+	if diff := cmp.Diff([]string{"m a=2 2", "m a=3 3"}, s.written()); diff != "" {
+		t.Errorf("written lines mismatch (-want +got):\n%s", diff)
+	}
+}
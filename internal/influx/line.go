@@ -0,0 +1,121 @@
+package influx
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+)
+
+//nolint:gochecknoglobals // escaping is fixed by the line protocol.
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// telemetry returns the measurement name, metrics and report time of a telemetry payload, or an empty measurement if
+// the payload is not written to InfluxDB.
+func telemetry(payload any) (string, any, *uint32) {
+	switch p := payload.(type) {
+	case *translator.TelemetryDeviceMetrics:
+		return "device_metrics", p.DeviceMetrics, p.Time
+	case *translator.TelemetryEnvironmentMetrics:
+		return "environment_metrics", p.EnvironmentMetrics, nil
+	case *translator.TelemetryAirQualityMetrics:
+		return "air_quality_metrics", p.AirQualityMetrics, nil
+	case *translator.TelemetryPowerMetrics:
+		return "power_metrics", p.PowerMetrics, nil
+	case *translator.TelemetryLocalStats:
+		return "local_stats", p.LocalStats, nil
+	case *translator.TelemetryHostMetrics:
+		return "host_metrics", p.HostMetrics, nil
+	default:
+		return "", nil, nil
+	}
+}
+
+// Line returns the line protocol of the telemetry in a packet, or false if the packet has no telemetry written to
+// InfluxDB.
+//
+// The measurement is the metrics variant, tagged with the node, channel and gateway, with a field per reported
+// metric. The timestamp, in seconds, is the time reported by the node, the time the packet was received, or now.
+func Line(pkt *pipeline.Packet) (string, bool) {
+	measurement, values, reported := telemetry(pkt.Message.Payload)
+	if measurement == "" {
+		return "", false
+	}
+
+	fields := fieldSet(values)
+	if fields == "" {
+		return "", false
+	}
+
+	ts := time.Now().Unix()
+	switch {
+	case reported != nil && *reported != 0:
+		ts = int64(*reported)
+	case pkt.Message.Timestamp != 0:
+		ts = int64(pkt.Message.Timestamp)
+	}
+
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(measurement))
+	for _, tag := range [][2]string{
+		{"channel", pkt.Envelope.GetChannelId()},
+		{"gateway", pkt.Envelope.GetGatewayId()},
+		{"node", mtypes.FormatNodeID(pkt.Message.From)},
+	} {
+		if tag[1] == "" {
+			continue
+		}
+		b.WriteString("," + tag[0] + "=" + tagEscaper.Replace(tag[1]))
+	}
+	b.WriteString(" " + fields + " " + strconv.FormatInt(ts, 10))
+
+	return b.String(), true
+}
+
+// fieldSet returns the fields of the reported metrics, sorted by name.
+//
+// Numbers are always written as floats, so a field keeps one type however the firmware reports it.
+func fieldSet(values any) string {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return ""
+	}
+
+	var m map[string]any
+	if err = json.Unmarshal(data, &m); err != nil {
+		return ""
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var value string
+		switch v := m[key].(type) {
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			value = strconv.FormatBool(v)
+		case string:
+			value = `"` + stringEscaper.Replace(v) + `"`
+		default:
+			continue
+		}
+		fields = append(fields, tagEscaper.Replace(key)+"="+value)
+	}
+
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,241 @@
+package influx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/go-slogtool"
+)
+
+// maxErrorBody is the number of bytes of an error response included in the error.
+const maxErrorBody = 512
+
+var (
+	// ErrWrite is returned when a write fails and may succeed if retried.
+	ErrWrite = errors.New("unable to write to influxdb")
+	// ErrRejected is returned when InfluxDB rejects a batch, it is dropped rather than retried.
+	ErrRejected = errors.New("influxdb rejected write")
+)
+
+// Writer buffers lines and writes them to the InfluxDB v2 write API in batches.
+type Writer struct {
+	Config Config
+	Logger *slog.Logger
+
+	client   *http.Client
+	full     chan struct{}
+	flushing sync.Mutex
+	lock     sync.Mutex
+	pending  []string
+}
+
+// NewWriter returns a writer, Run must be called to write batches in the background.
+func NewWriter(config Config, logger *slog.Logger) *Writer {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxPending <= 0 {
+		config.MaxPending = DefaultMaxPending
+	}
+
+	return &Writer{
+		Config: config,
+		Logger: logger,
+		client: &http.Client{Timeout: config.Timeout},
+		full:   make(chan struct{}, 1),
+	}
+}
+
+// Len returns the number of lines waiting to be written.
+func (w *Writer) Len() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return len(w.pending)
+}
+
+// Add queues lines to be written, once MaxPending lines are held the oldest are dropped.
+func (w *Writer) Add(lines ...string) {
+	w.lock.Lock()
+	w.pending = append(w.pending, lines...)
+	dropped := w.trim()
+	full := len(w.pending) >= w.Config.BatchSize
+	w.lock.Unlock()
+
+	if dropped > 0 {
+		w.Logger.Warn("InfluxDB buffer full, dropped oldest lines", slog.Int("dropped", dropped))
+	}
+
+	if full {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run writes a batch whenever one is full, and the pending lines every FlushInterval, until the context is done.
+//
+// Call Flush after Run returns to write the remaining lines.
+func (w *Writer) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.full:
+		}
+
+		if err := w.Flush(ctx); err != nil {
+			w.Logger.WarnContext(ctx, "Failed to write to InfluxDB",
+				slog.Int("pending", w.Len()),
+				slogtool.ErrorAttr(err),
+			)
+		}
+	}
+}
+
+// Flush writes the pending lines in batches of BatchSize.
+//
+// Flush stops at the first batch that fails, keeping it to be written by the next flush. A batch InfluxDB rejects
+// is dropped and the error returned once the other batches are written.
+func (w *Writer) Flush(ctx context.Context) error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	var errs []error
+	for {
+		batch := w.take()
+		if len(batch) == 0 {
+			return errors.Join(errs...)
+		}
+
+		if err := w.writeRetry(ctx, batch); err != nil {
+			if errors.Is(err, ErrRejected) {
+				errs = append(errs, err)
+				continue
+			}
+
+			w.requeue(batch)
+			return errors.Join(append(errs, err)...)
+		}
+	}
+}
+
+// take removes up to BatchSize lines from the front of the pending lines.
+func (w *Writer) take() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	n := min(len(w.pending), w.Config.BatchSize)
+	batch := make([]string, n)
+	copy(batch, w.pending)
+	w.pending = w.pending[n:]
+
+	return batch
+}
+
+// requeue returns a batch that failed to the front of the pending lines.
+func (w *Writer) requeue(batch []string) {
+	w.lock.Lock()
+	w.pending = append(batch, w.pending...)
+	dropped := w.trim()
+	w.lock.Unlock()
+
+	if dropped > 0 {
+		w.Logger.Warn("InfluxDB buffer full, dropped oldest lines", slog.Int("dropped", dropped))
+	}
+}
+
+// trim drops the oldest lines over MaxPending, returning the number dropped. The lock must be held.
+func (w *Writer) trim() int {
+	over := len(w.pending) - w.Config.MaxPending
+	if over <= 0 {
+		return 0
+	}
+
+	w.pending = w.pending[over:]
+	return over
+}
+
+// writeRetry writes a batch, retrying with exponential backoff until the attempts are exhausted, InfluxDB rejects
+// the batch or the context is done.
+func (w *Writer) writeRetry(ctx context.Context, batch []string) error {
+	for attempt := 0; ; attempt++ {
+		err := w.write(ctx, batch)
+		if err == nil || errors.Is(err, ErrRejected) || attempt+1 >= w.Config.Retry.MaxAttempts() {
+			return err
+		}
+
+		delay := w.Config.Retry.Delay(attempt)
+		w.Logger.WarnContext(ctx, "Retrying InfluxDB write",
+			slog.Int("lines", len(batch)),
+			slog.Int("attempt", attempt+1),
+			slog.Duration("delay", delay),
+			slogtool.ErrorAttr(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// write posts a batch to the write API, with a precision of seconds.
+func (w *Writer) write(ctx context.Context, batch []string) error {
+	u, err := url.Parse(w.Config.URL)
+	if err != nil {
+		return fmt.Errorf("%w: invalid url: %w", ErrRejected, err)
+	}
+	u = u.JoinPath("api", "v2", "write")
+	u.RawQuery = url.Values{
+		"org":       {w.Config.Org},
+		"bucket":    {w.Config.Bucket},
+		"precision": {"s"},
+	}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(strings.Join(batch, "\n")))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.Config.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.Config.Token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	switch {
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %s: %s", ErrWrite, resp.Status, strings.TrimSpace(string(body)))
+	default:
+		return fmt.Errorf("%w: %s: %s", ErrRejected, resp.Status, strings.TrimSpace(string(body)))
+	}
+}
@@ -0,0 +1,157 @@
+package mainconfig
+
+import (
+	"strings"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// BrokerSource is the name of the broker messages are received from.
+	BrokerSource = "source"
+	// BrokerDest is the name of the broker messages are published to.
+	BrokerDest = "dest"
+)
+
+// BindBrokerFlags adds the flags and environment variables for the named broker, overriding the shared broker
+// settings, e.g. --source-broker and MQTT_SOURCE_BROKER.
+func BindBrokerFlags(flags *pflag.FlagSet, name, description string) {
+	env := "MQTT_" + strings.ToUpper(name) + "_"
+
+	flags.String(name+"-broker", "", description+" MQTT broker URL (optional, defaults to --broker)")
+	_ = viper.BindPFlag("broker."+name+".address", flags.Lookup(name+"-broker"))
+	_ = viper.BindEnv("broker."+name+".address", env+"BROKER")
+
+	flags.String(name+"-clientid", "", description+" MQTT client ID (optional, defaults to --clientid)")
+	_ = viper.BindPFlag("broker."+name+".clientid", flags.Lookup(name+"-clientid"))
+	_ = viper.BindEnv("broker."+name+".clientid", env+"CLIENTID")
+
+	flags.String(name+"-username", "", description+" MQTT username (optional)")
+	_ = viper.BindPFlag("broker."+name+".username", flags.Lookup(name+"-username"))
+	_ = viper.BindEnv("broker."+name+".username", env+"USERNAME")
+
+	flags.String(name+"-password", "", description+" MQTT password (optional)")
+	_ = viper.BindPFlag("broker."+name+".password", flags.Lookup(name+"-password"))
+	_ = viper.BindEnv("broker."+name+".password", env+"PASSWORD")
+
+	_ = viper.BindEnv("broker."+name+".keepalive", env+"KEEPALIVE")
+	_ = viper.BindEnv("broker."+name+".protocol", env+"PROTOCOL")
+
+	for _, key := range tlsKeys {
+		_ = viper.BindEnv("broker."+name+".tls."+key, env+"TLS_"+envName(key))
+	}
+}
+
+// tlsKeys are the TLS settings available for the shared broker settings and each broker.
+var tlsKeys = []string{"ca-file", "cert-file", "key-file", "server-name", "insecure-skip-verify"}
+
+func envName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// BindTLSFlags adds the flags and environment variables for the shared broker TLS settings, e.g. --tls-ca-file
+// and MQTT_TLS_CA_FILE.
+func BindTLSFlags(flags *pflag.FlagSet) {
+	flags.String("tls-ca-file", "", "MQTT broker CA bundle (optional, defaults to the system roots)")
+	flags.String("tls-cert-file", "", "MQTT client certificate for mutual TLS (optional)")
+	flags.String("tls-key-file", "", "MQTT client key for mutual TLS (optional)")
+	flags.String("tls-server-name", "", "MQTT broker TLS server name override (optional)")
+	flags.Bool("tls-insecure-skip-verify", false, "Skip verification of the MQTT broker certificate")
+
+	for _, key := range tlsKeys {
+		_ = viper.BindPFlag("broker.tls."+key, flags.Lookup("tls-"+key))
+		_ = viper.BindEnv("broker.tls."+key, "MQTT_TLS_"+envName(key))
+	}
+}
+
+// GetBroker returns the configuration for the named broker.
+//
+// Settings not set for the broker fall back to the shared broker settings, the shared credentials and TLS settings
+// are only used when the broker also uses the shared address. The client ID defaults to the shared client ID suffixed with the
+// broker name.
+func GetBroker(name string) broker.Config {
+	prefix := "broker." + name + "."
+
+	cfg := broker.Config{
+		Address:   viper.GetString(prefix + "address"),
+		ClientID:  viper.GetString(prefix + "clientid"),
+		Username:  viper.GetString(prefix + "username"),
+		Password:  viper.GetString(prefix + "password"),
+		Keepalive: viper.GetDuration(prefix + "keepalive"),
+		Protocol:  viper.GetString(prefix + "protocol"),
+	}
+
+	if cfg.Address == "" {
+		cfg.Address = viper.GetString("broker.address")
+		if cfg.Username == "" {
+			cfg.Username = viper.GetString("broker.username")
+			cfg.Password = viper.GetString("broker.password")
+		}
+	}
+
+	if cfg.ClientID == "" {
+		cfg.ClientID = viper.GetString("broker.clientid") + "-" + name
+	}
+
+	if cfg.Keepalive <= 0 {
+		cfg.Keepalive = viper.GetDuration("broker.keepalive")
+	}
+
+	if cfg.Protocol == "" {
+		cfg.Protocol = viper.GetString("broker.protocol")
+	}
+
+	cfg.TLS = getTLS(prefix + "tls.")
+	if viper.GetString(prefix+"address") == "" {
+		cfg.TLS = mergeTLS(cfg.TLS, getTLS("broker.tls."))
+	}
+
+	return cfg
+}
+
+// GetMapTopic returns the topic map reports are received on, they are published unencrypted to
+// msh/<region>/2/map/ rather than a channel topic.
+//
+// The topic defaults to the source topic with the channel part replaced, e.g. msh/ANZ/2/map/ for msh/ANZ/2/e/#,
+// and is empty when the source topic has no channel part.
+func GetMapTopic() string {
+	if topic := viper.GetString("broker.map-topic"); topic != "" {
+		return topic
+	}
+
+	if root, _, ok := strings.Cut(viper.GetString("broker.topic"), "/e/"); ok {
+		return root + "/map/"
+	}
+
+	return ""
+}
+
+func getTLS(prefix string) broker.TLSConfig {
+	return broker.TLSConfig{
+		CAFile:             viper.GetString(prefix + "ca-file"),
+		CertFile:           viper.GetString(prefix + "cert-file"),
+		KeyFile:            viper.GetString(prefix + "key-file"),
+		ServerName:         viper.GetString(prefix + "server-name"),
+		InsecureSkipVerify: viper.GetBool(prefix + "insecure-skip-verify"),
+	}
+}
+
+// mergeTLS returns cfg with any settings not set taken from shared, the client certificate and key are taken
+// together.
+func mergeTLS(cfg, shared broker.TLSConfig) broker.TLSConfig {
+	if cfg.CAFile == "" {
+		cfg.CAFile = shared.CAFile
+	}
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		cfg.CertFile = shared.CertFile
+		cfg.KeyFile = shared.KeyFile
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = shared.ServerName
+	}
+	cfg.InsecureSkipVerify = cfg.InsecureSkipVerify || shared.InsecureSkipVerify
+
+	return cfg
+}
@@ -29,6 +29,99 @@ func ConfigInit() {
 	viper.SetDefault("broker.keepalive", "1m")
 	_ = viper.BindEnv("broker.keepalive", "BROKER_KEEPALIVE")
 
+	viper.SetDefault("broker.protocol", "3.1.1")
+	_ = viper.BindEnv("broker.protocol", "MQTT_PROTOCOL")
+
+	_ = viper.BindEnv("broker.shared-group", "MQTT_SHARED_GROUP")
+	_ = viper.BindEnv("broker.telemetry-expiry", "MQTT_TELEMETRY_EXPIRY")
+
+	viper.SetDefault("nodedb.flush-interval", "1m")
+	_ = viper.BindEnv("nodedb.flush-interval", "NODEDB_FLUSH_INTERVAL")
+
+	viper.SetDefault("metrics.node-ttl", "24h")
+	_ = viper.BindEnv("metrics.node-ttl", "METRICS_NODE_TTL")
+
+	viper.SetDefault("topology.interval", "1m")
+	_ = viper.BindEnv("topology.interval", "TOPOLOGY_INTERVAL")
+
+	viper.SetDefault("topology.max-age", "24h")
+	_ = viper.BindEnv("topology.max-age", "TOPOLOGY_MAX_AGE")
+
+	_ = viper.BindEnv("downlink.topic", "DOWNLINK_TOPIC")
+	_ = viper.BindEnv("downlink.channel", "DOWNLINK_CHANNEL")
+	_ = viper.BindEnv("downlink.gateway", "DOWNLINK_GATEWAY")
+
+	viper.SetDefault("downlink.hop-limit", 3) //nolint:mnd // default firmware hop limit.
+	_ = viper.BindEnv("downlink.hop-limit", "DOWNLINK_HOP_LIMIT")
+
+	_ = viper.BindEnv("filter.relay", "FILTER_RELAY")
+	_ = viper.BindEnv("filter.fanout", "FILTER_FANOUT")
+	_ = viper.BindEnv("filter.store", "FILTER_STORE")
+
+	viper.SetDefault("publish.retry-attempts", 3) //nolint:mnd // pipeline.DefaultRetryAttempts.
+	_ = viper.BindEnv("publish.retry-attempts", "PUBLISH_RETRY_ATTEMPTS")
+
+	viper.SetDefault("publish.retry-backoff", "500ms")
+	_ = viper.BindEnv("publish.retry-backoff", "PUBLISH_RETRY_BACKOFF")
+
+	viper.SetDefault("publish.retry-max-backoff", "10s")
+	_ = viper.BindEnv("publish.retry-max-backoff", "PUBLISH_RETRY_MAX_BACKOFF")
+
+	_ = viper.BindEnv("outbox.dir", "OUTBOX_DIR")
+
+	viper.SetDefault("outbox.max-messages", 10000) //nolint:mnd // outbox.DefaultMaxMessages.
+	_ = viper.BindEnv("outbox.max-messages", "OUTBOX_MAX_MESSAGES")
+
+	_ = viper.BindEnv("dead-letter.topic", "DEAD_LETTER_TOPIC")
+
+	_ = viper.BindEnv("influxdb.url", "INFLUXDB_URL")
+	_ = viper.BindEnv("influxdb.org", "INFLUXDB_ORG")
+	_ = viper.BindEnv("influxdb.bucket", "INFLUXDB_BUCKET")
+	_ = viper.BindEnv("influxdb.token", "INFLUXDB_TOKEN")
+
+	viper.SetDefault("influxdb.batch-size", 500) //nolint:mnd // influx.DefaultBatchSize.
+	_ = viper.BindEnv("influxdb.batch-size", "INFLUXDB_BATCH_SIZE")
+
+	viper.SetDefault("influxdb.flush-interval", "10s")
+	_ = viper.BindEnv("influxdb.flush-interval", "INFLUXDB_FLUSH_INTERVAL")
+
+	_ = viper.BindEnv("webhook.url", "WEBHOOK_URL")
+	_ = viper.BindEnv("webhook.secret", "WEBHOOK_SECRET")
+	_ = viper.BindEnv("webhook.filter", "WEBHOOK_FILTER")
+
+	viper.SetDefault("embedded-broker.address", ":1883")
+	_ = viper.BindEnv("embedded-broker.address", "EMBEDDED_BROKER_ADDRESS")
+
+	_ = viper.BindEnv("embedded-broker.tls-address", "EMBEDDED_BROKER_TLS_ADDRESS")
+	_ = viper.BindEnv("embedded-broker.cert-file", "EMBEDDED_BROKER_CERT_FILE")
+	_ = viper.BindEnv("embedded-broker.key-file", "EMBEDDED_BROKER_KEY_FILE")
+	_ = viper.BindEnv("embedded-broker.username", "EMBEDDED_BROKER_USERNAME")
+	_ = viper.BindEnv("embedded-broker.password", "EMBEDDED_BROKER_PASSWORD")
+
+	viper.SetDefault("embedded-broker.queue-size", 1000) //nolint:mnd // mqttd.DefaultQueueSize.
+	_ = viper.BindEnv("embedded-broker.queue-size", "EMBEDDED_BROKER_QUEUE_SIZE")
+
+	_ = viper.BindEnv("radio.address", "RADIO_ADDRESS")
+	_ = viper.BindEnv("radio.topic", "RADIO_TOPIC")
+
+	viper.SetDefault("radio.baud-rate", 115200) //nolint:mnd // radio.DefaultBaudRate.
+	_ = viper.BindEnv("radio.baud-rate", "RADIO_BAUD_RATE")
+
+	viper.SetDefault("workers.count", 4) //nolint:mnd // pipeline.DefaultWorkers.
+	_ = viper.BindEnv("workers.count", "WORKERS")
+
+	viper.SetDefault("workers.queue-size", 256) //nolint:mnd // pipeline.DefaultQueueSize.
+	_ = viper.BindEnv("workers.queue-size", "WORKER_QUEUE_SIZE")
+
+	viper.SetDefault("workers.queue-policy", "block")
+	_ = viper.BindEnv("workers.queue-policy", "WORKER_QUEUE_POLICY")
+
+	viper.SetDefault("dedup.mode", "off")
+	_ = viper.BindEnv("dedup.mode", "DEDUP_MODE")
+
+	viper.SetDefault("dedup.window", "10s")
+	_ = viper.BindEnv("dedup.window", "DEDUP_WINDOW")
+
 	viper.SetDefault("healthcheck.port", defaultHealthCheckPort)
 	_ = viper.BindEnv("healthcheck.port", "HEALTHCHECK_PORT")
 
@@ -44,6 +137,23 @@ func ConfigInit() {
 	viper.SetDefault("features.message-store", false)
 	_ = viper.BindEnv("features.message-store", "FEATURE_MESSAGE_STORE")
 
+	viper.SetDefault("features.stream", false)
+	_ = viper.BindEnv("features.stream", "FEATURE_STREAM")
+
+	_ = viper.BindEnv("stream.allowed-origins", "STREAM_ALLOWED_ORIGINS")
+
+	viper.SetDefault("features.embedded-broker", false)
+	_ = viper.BindEnv("features.embedded-broker", "FEATURE_EMBEDDED_BROKER")
+
+	viper.SetDefault("features.homeassistant-discovery", false)
+	_ = viper.BindEnv("features.homeassistant-discovery", "FEATURE_HOMEASSISTANT_DISCOVERY")
+
+	viper.SetDefault("homeassistant.discovery-prefix", "homeassistant")
+	_ = viper.BindEnv("homeassistant.discovery-prefix", "HOMEASSISTANT_DISCOVERY_PREFIX")
+
+	_ = viper.BindEnv("pki.node-id", "PKI_NODE_ID")
+	_ = viper.BindEnv("pki.private-key", "PKI_PRIVATE_KEY")
+
 	// check if /data/optons.json or ./testdata/options.json exists.
 	if _, err := os.Stat("./testdata/options.json"); err == nil {
 		viper.SetConfigFile("./testdata/options.json")
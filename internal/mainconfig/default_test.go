@@ -3,9 +3,14 @@ package mainconfig_test
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mainconfig"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqttd"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/radio"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/spf13/viper"
 )
 
@@ -26,3 +31,199 @@ func TestConfig(t *testing.T) {
 		t.Logf("Channel[%s]: %s", channel.Name, channel.Key)
 	}
 }
+
+func TestGetBroker(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("broker.address", "tcp://shared:1883")
+	viper.Set("broker.clientid", "relay")
+	viper.Set("broker.username", "shared-user")
+	viper.Set("broker.password", "shared-pass")
+	viper.Set("broker.keepalive", "1m")
+	viper.Set("broker.dest.address", "tcp://private:1883")
+	viper.Set("broker.dest.username", "private-user")
+	viper.Set("broker.dest.keepalive", "20s")
+
+	source := mainconfig.GetBroker(mainconfig.BrokerSource)
+	if source.Address != "tcp://shared:1883" || source.ClientID != "relay-source" ||
+		source.Username != "shared-user" || source.Password != "shared-pass" || source.Keepalive != time.Minute {
+		t.Errorf("source: got %+v", source)
+	}
+
+	dest := mainconfig.GetBroker(mainconfig.BrokerDest)
+	if dest.Address != "tcp://private:1883" || dest.ClientID != "relay-dest" ||
+		dest.Username != "private-user" || dest.Password != "" || dest.Keepalive != 20*time.Second {
+		t.Errorf("dest: got %+v", dest)
+	}
+}
+
+func TestGetBrokerWithoutCredentials(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("broker.address", "tcp://shared:1883")
+	viper.Set("broker.username", "shared-user")
+	viper.Set("broker.password", "shared-pass")
+	viper.Set("broker.dest.address", "tcp://private:1883")
+
+	dest := mainconfig.GetBroker(mainconfig.BrokerDest)
+	if dest.Username != "" || dest.Password != "" {
+		t.Errorf("dest credentials: got %q/%q, want none", dest.Username, dest.Password)
+	}
+}
+
+func TestGetBrokerTLS(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("broker.address", "ssl://shared:8883")
+	viper.Set("broker.tls.ca-file", "/etc/ssl/shared-ca.pem")
+	viper.Set("broker.tls.cert-file", "/etc/ssl/shared.crt")
+	viper.Set("broker.tls.key-file", "/etc/ssl/shared.key")
+	viper.Set("broker.source.tls.server-name", "mqtt.example.com")
+	viper.Set("broker.dest.address", "ssl://private:8883")
+	viper.Set("broker.dest.tls.ca-file", "/etc/ssl/private-ca.pem")
+
+	source := mainconfig.GetBroker(mainconfig.BrokerSource)
+	want := broker.TLSConfig{
+		CAFile:     "/etc/ssl/shared-ca.pem",
+		CertFile:   "/etc/ssl/shared.crt",
+		KeyFile:    "/etc/ssl/shared.key",
+		ServerName: "mqtt.example.com",
+	}
+	if source.TLS != want {
+		t.Errorf("source TLS: got %+v, want %+v", source.TLS, want)
+	}
+
+	dest := mainconfig.GetBroker(mainconfig.BrokerDest)
+	want = broker.TLSConfig{CAFile: "/etc/ssl/private-ca.pem"}
+	if dest.TLS != want {
+		t.Errorf("dest TLS: got %+v, want %+v", dest.TLS, want)
+	}
+}
+
+func TestGetWebhooks(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("webhooks", []any{
+		map[string]any{
+			"name":       "n8n",
+			"url":        "https://n8n.example.com/webhook/mesh",
+			"secret":     "secret",
+			"headers":    map[string]any{"Authorization": "Bearer token"},
+			"filter":     `type == "TEXT_MESSAGE_APP"`,
+			"timeout":    "5s",
+			"batch-size": 10,
+		},
+		map[string]any{"name": "no-url"},
+		map[string]any{"url": "https://tickets.example.com/hook"},
+	})
+	viper.Set("webhook.url", "https://example.com/hook")
+	viper.Set("webhook.filter", "from == \"!a0cbc3a8\"")
+
+	want := []mainconfig.Webhook{
+		{
+			Name:      "n8n",
+			URL:       "https://n8n.example.com/webhook/mesh",
+			Secret:    "secret",
+			Headers:   map[string]string{"Authorization": "Bearer token"},
+			Filter:    `type == "TEXT_MESSAGE_APP"`,
+			Timeout:   5 * time.Second,
+			BatchSize: 10,
+		},
+		{Name: "3", URL: "https://tickets.example.com/hook"},
+		{Name: "default", URL: "https://example.com/hook", Filter: `from == "!a0cbc3a8"`},
+	}
+	if diff := cmp.Diff(want, mainconfig.GetWebhooks()); diff != "" {
+		t.Errorf("GetWebhooks() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetEmbeddedBroker(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("embedded-broker.address", ":1884")
+	viper.Set("embedded-broker.users", map[string]any{"gateway": "gateway-pass"})
+	viper.Set("embedded-broker.username", "relay")
+	viper.Set("embedded-broker.password", "relay-pass")
+	viper.Set("embedded-broker.queue-size", 50)
+
+	want := mqttd.Config{
+		Address:   ":1884",
+		Users:     map[string]string{"gateway": "gateway-pass", "relay": "relay-pass"},
+		QueueSize: 50,
+	}
+	if diff := cmp.Diff(want, mainconfig.GetEmbeddedBroker()); diff != "" {
+		t.Errorf("GetEmbeddedBroker() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGetRadio(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	viper.Set("radio.address", "serial:///dev/ttyACM0")
+	viper.Set("radio.baud-rate", 921600)
+	viper.Set("broker.topic", "msh/ANZ/2/e/#")
+
+	want := radio.Config{
+		Address:  "serial:///dev/ttyACM0",
+		Topic:    "msh/ANZ/2/e/",
+		BaudRate: 921600,
+	}
+	if diff := cmp.Diff(want, mainconfig.GetRadio()); diff != "" {
+		t.Errorf("GetRadio() mismatch (-want +got):\n%s", diff)
+	}
+
+	viper.Set("radio.topic", "msh/US/2/e/")
+	if got := mainconfig.GetRadio().Topic; got != "msh/US/2/e/" {
+		t.Errorf("GetRadio() topic: got '%s', want 'msh/US/2/e/'", got)
+	}
+}
+
+func TestGetMapTopic(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	tests := []struct {
+		topic    string
+		mapTopic string
+		want     string
+	}{
+		{topic: "msh/ANZ/2/e/#", want: "msh/ANZ/2/map/"},
+		{topic: "msh/+/+/e/#", want: "msh/+/+/map/"},
+		{topic: "msh/US/2/e/LongFast/#", want: "msh/US/2/map/"},
+		{topic: "meshtastic/#", want: ""},
+		{topic: "msh/ANZ/2/e/#", mapTopic: "msh/ANZ/map/#", want: "msh/ANZ/map/#"},
+	}
+
+	for _, tt := range tests {
+		viper.Set("broker.topic", tt.topic)
+		viper.Set("broker.map-topic", tt.mapTopic)
+
+		if got := mainconfig.GetMapTopic(); got != tt.want {
+			t.Errorf("GetMapTopic() for topic %q: got '%s', want '%s'", tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestGetStreamOrigins(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	tests := []struct {
+		value any
+		want  []string
+	}{
+		{value: "", want: nil},
+		{value: "https://dash.example.com", want: []string{"https://dash.example.com"}},
+		{
+			value: "https://dash.example.com, http://localhost:3000",
+			want:  []string{"https://dash.example.com", "http://localhost:3000"},
+		},
+		{value: []string{"https://a.example.com", "*"}, want: []string{"https://a.example.com", "*"}},
+	}
+
+	for _, tt := range tests {
+		viper.Set("stream.allowed-origins", tt.value)
+
+		if diff := cmp.Diff(tt.want, mainconfig.GetStreamOrigins()); diff != "" {
+			t.Errorf("GetStreamOrigins() for %v mismatch (-want +got):\n%s", tt.value, diff)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package mainconfig
+
+import (
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqttd"
+	"github.com/spf13/viper"
+)
+
+// GetEmbeddedBroker returns the configuration for the embedded broker.
+//
+// Users are listed under embedded-broker.users in the config file, the user set by EMBEDDED_BROKER_USERNAME and
+// EMBEDDED_BROKER_PASSWORD is added to them.
+func GetEmbeddedBroker() mqttd.Config {
+	users := viper.GetStringMapString("embedded-broker.users")
+	if username := viper.GetString("embedded-broker.username"); username != "" {
+		users[username] = viper.GetString("embedded-broker.password")
+	}
+
+	return mqttd.Config{
+		Address:    viper.GetString("embedded-broker.address"),
+		TLSAddress: viper.GetString("embedded-broker.tls-address"),
+		CertFile:   viper.GetString("embedded-broker.cert-file"),
+		KeyFile:    viper.GetString("embedded-broker.key-file"),
+		Users:      users,
+		QueueSize:  viper.GetInt("embedded-broker.queue-size"),
+	}
+}
@@ -0,0 +1,22 @@
+package mainconfig
+
+import (
+	"cmp"
+	"strings"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/radio"
+	"github.com/spf13/viper"
+)
+
+// GetRadio returns the configuration for the radio source, packets are received from the node at radio.address
+// instead of the source broker when it is set.
+//
+// The topic defaults to the source topic without the trailing wildcard, e.g. msh/ANZ/2/e/ for msh/ANZ/2/e/#, so
+// packets from the node match the subscription of the pipeline.
+func GetRadio() radio.Config {
+	return radio.Config{
+		Address:  viper.GetString("radio.address"),
+		Topic:    cmp.Or(viper.GetString("radio.topic"), strings.TrimSuffix(viper.GetString("broker.topic"), "#")),
+		BaudRate: viper.GetInt("radio.baud-rate"),
+	}
+}
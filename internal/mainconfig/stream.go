@@ -0,0 +1,24 @@
+package mainconfig
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// GetStreamOrigins returns the cross-origin pages allowed to connect to the live stream.
+//
+// Origins are listed under stream.allowed-origins in the config file, or comma separated in
+// STREAM_ALLOWED_ORIGINS.
+func GetStreamOrigins() []string {
+	var origins []string
+	for _, value := range viper.GetStringSlice("stream.allowed-origins") {
+		for origin := range strings.SplitSeq(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+	}
+
+	return origins
+}
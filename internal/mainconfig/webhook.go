@@ -0,0 +1,51 @@
+package mainconfig
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Webhook is a webhook listed under webhooks in the config file, or set by the WEBHOOK_* environment variables.
+type Webhook struct {
+	Name          string            `mapstructure:"name"`
+	URL           string            `mapstructure:"url"`
+	Secret        string            `mapstructure:"secret"`
+	Headers       map[string]string `mapstructure:"headers"`
+	Filter        string            `mapstructure:"filter"`
+	Timeout       time.Duration     `mapstructure:"timeout"`
+	QueueSize     int               `mapstructure:"queue-size"`
+	BatchSize     int               `mapstructure:"batch-size"`
+	BatchInterval time.Duration     `mapstructure:"batch-interval"`
+}
+
+// GetWebhooks returns the webhooks listed in the config file followed by the webhook set by WEBHOOK_URL.
+//
+// Webhooks without a URL are skipped, a webhook without a name is named by its position in the list.
+func GetWebhooks() []Webhook {
+	var listed []Webhook
+	_ = viper.UnmarshalKey("webhooks", &listed)
+
+	if url := viper.GetString("webhook.url"); url != "" {
+		listed = append(listed, Webhook{
+			Name:   "default",
+			URL:    url,
+			Secret: viper.GetString("webhook.secret"),
+			Filter: viper.GetString("webhook.filter"),
+		})
+	}
+
+	hooks := make([]Webhook, 0, len(listed))
+	for i, hook := range listed {
+		if hook.URL == "" {
+			continue
+		}
+		if hook.Name == "" {
+			hook.Name = strconv.Itoa(i + 1)
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks
+}
@@ -0,0 +1,170 @@
+package meshcrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ccmMinNonceSize and ccmMaxNonceSize are the nonce sizes permitted by RFC 3610 (L = 2..8).
+	ccmMinNonceSize = 7
+	ccmMaxNonceSize = 13
+	// ccmMaxAADShortLength is the largest additional data length encoded in two bytes.
+	ccmMaxAADShortLength = 0xff00
+)
+
+// ErrAuthFailed is returned when the CCM authentication tag does not match.
+var ErrAuthFailed = errors.New("message authentication failed")
+
+// ccm implements AES-CCM (RFC 3610) as used by the firmware for PKI encrypted packets.
+type ccm struct {
+	block   cipher.Block
+	tagSize int
+	nonce   []byte
+}
+
+func newCCM(key, nonce []byte, tagSize int) (*ccm, error) {
+	if len(nonce) < ccmMinNonceSize || len(nonce) > ccmMaxNonceSize {
+		return nil, fmt.Errorf("invalid CCM nonce length %d", len(nonce))
+	}
+	if tagSize < 4 || tagSize > aes.BlockSize || tagSize%2 != 0 {
+		return nil, fmt.Errorf("invalid CCM tag size %d", tagSize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %w", err)
+	}
+
+	return &ccm{block: block, tagSize: tagSize, nonce: nonce}, nil
+}
+
+// lenSize returns L, the number of bytes used to encode the message length.
+func (c *ccm) lenSize() int {
+	return aes.BlockSize - 1 - len(c.nonce)
+}
+
+// counterBlock returns A_i, the CTR block for counter i.
+func (c *ccm) counterBlock(i uint64) []byte {
+	block := make([]byte, aes.BlockSize)
+	block[0] = byte(c.lenSize() - 1)
+	copy(block[1:], c.nonce)
+	c.putLength(block, i)
+	return block
+}
+
+// putLength writes v into the trailing L bytes of the block.
+func (c *ccm) putLength(block []byte, v uint64) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	copy(block[aes.BlockSize-c.lenSize():], buf[8-c.lenSize():])
+}
+
+// mac computes the CBC-MAC of the additional data and plaintext.
+func (c *ccm) mac(plain, aad []byte) []byte {
+	b0 := make([]byte, aes.BlockSize)
+	b0[0] = byte(((c.tagSize-2)/2)<<3 | (c.lenSize() - 1))
+	if len(aad) > 0 {
+		b0[0] |= 1 << 6
+	}
+	copy(b0[1:], c.nonce)
+	c.putLength(b0, uint64(len(plain)))
+
+	x := make([]byte, aes.BlockSize)
+	c.block.Encrypt(x, b0)
+
+	mix := func(data []byte) {
+		for len(data) > 0 {
+			n := subtle.XORBytes(x, x, data)
+			data = data[n:]
+			c.block.Encrypt(x, x)
+		}
+	}
+
+	if len(aad) > 0 {
+		header := make([]byte, 2, 2+len(aad))
+		binary.BigEndian.PutUint16(header, uint16(len(aad))) //nolint:gosec // length checked by caller
+		mix(padBlock(append(header, aad...)))
+	}
+	mix(padBlock(plain))
+
+	return x[:c.tagSize]
+}
+
+// ctr applies the CCM counter mode keystream starting at counter 1.
+func (c *ccm) ctr(out, in []byte) {
+	cipher.NewCTR(c.block, c.counterBlock(1)).XORKeyStream(out, in)
+}
+
+// seal encrypts and authenticates plain, returning the ciphertext followed by the tag.
+func (c *ccm) seal(plain, aad []byte) []byte {
+	tag := c.mac(plain, aad)
+	s0 := make([]byte, aes.BlockSize)
+	c.block.Encrypt(s0, c.counterBlock(0))
+
+	out := make([]byte, len(plain)+c.tagSize)
+	c.ctr(out, plain)
+	subtle.XORBytes(out[len(plain):], tag, s0)
+	return out
+}
+
+// open verifies and decrypts ciphertext using the detached tag.
+func (c *ccm) open(ciphertext, tag, aad []byte) ([]byte, error) {
+	if len(tag) != c.tagSize {
+		return nil, fmt.Errorf("invalid CCM tag length %d", len(tag))
+	}
+
+	plain := make([]byte, len(ciphertext))
+	c.ctr(plain, ciphertext)
+
+	s0 := make([]byte, aes.BlockSize)
+	c.block.Encrypt(s0, c.counterBlock(0))
+	expected := make([]byte, c.tagSize)
+	subtle.XORBytes(expected, c.mac(plain, aad), s0)
+
+	if subtle.ConstantTimeCompare(expected, tag) != 1 {
+		return nil, ErrAuthFailed
+	}
+
+	return plain, nil
+}
+
+// SealCCM encrypts and authenticates plain with AES-CCM, returning the ciphertext followed by the tag.
+func SealCCM(key, nonce []byte, tagSize int, plain, aad []byte) ([]byte, error) {
+	if len(aad) >= ccmMaxAADShortLength {
+		return nil, fmt.Errorf("additional data too long: %d", len(aad))
+	}
+
+	c, err := newCCM(key, nonce, tagSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.seal(plain, aad), nil
+}
+
+// OpenCCM verifies and decrypts AES-CCM ciphertext with a detached authentication tag.
+func OpenCCM(key, nonce []byte, ciphertext, tag, aad []byte) ([]byte, error) {
+	if len(aad) >= ccmMaxAADShortLength {
+		return nil, fmt.Errorf("additional data too long: %d", len(aad))
+	}
+
+	c, err := newCCM(key, nonce, len(tag))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.open(ciphertext, tag, aad)
+}
+
+// padBlock zero pads data to a multiple of the AES block size.
+func padBlock(data []byte) []byte {
+	if rem := len(data) % aes.BlockSize; rem != 0 {
+		return append(data[:len(data):len(data)], make([]byte, aes.BlockSize-rem)...)
+	}
+	return data
+}
@@ -0,0 +1,119 @@
+package meshcrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// aes128KeySize is the key size for AES-128 channel keys.
+	aes128KeySize = 16
+	// aes256KeySize is the key size for AES-256 channel keys.
+	aes256KeySize = 32
+	// nonceSize is the size of the AES-CTR nonce (one AES block).
+	nonceSize = aes.BlockSize
+)
+
+// ErrNoKey is returned when a channel has encryption disabled (PSK index 0 or empty key).
+var ErrNoKey = errors.New("channel has no encryption key")
+
+// defaultKey returns the well-known Meshtastic default PSK that the 1-byte PSK indexes refer to.
+func defaultKey() []byte {
+	return []byte{
+		0xd4, 0xf1, 0xbb, 0x3a, 0x20, 0x29, 0x07, 0x59,
+		0xf0, 0xbc, 0xff, 0xab, 0xcf, 0x4e, 0x69, 0x01,
+	}
+}
+
+// ExpandKey expands a channel PSK as the firmware does.
+//
+// A 1-byte PSK is an index into the default key (1 is the default key itself,
+// 2-255 increment the last byte), 16 and 32 byte keys are used as-is, and any
+// other length is zero-padded up to the next AES key size.
+func ExpandKey(psk []byte) ([]byte, error) {
+	switch {
+	case len(psk) == 0:
+		return nil, ErrNoKey
+	case len(psk) == 1:
+		if psk[0] == 0 {
+			return nil, ErrNoKey
+		}
+		key := defaultKey()
+		key[len(key)-1] += psk[0] - 1
+		return key, nil
+	case len(psk) <= aes128KeySize:
+		key := make([]byte, aes128KeySize)
+		copy(key, psk)
+		return key, nil
+	case len(psk) <= aes256KeySize:
+		key := make([]byte, aes256KeySize)
+		copy(key, psk)
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid key length %d", len(psk))
+	}
+}
+
+// ChannelHash returns the 8-bit channel hash the firmware places in MeshPacket.channel
+// for encrypted packets, the XOR of every byte of the channel name and the expanded key.
+func ChannelHash(name string, key []byte) uint32 {
+	var h byte
+	for i := range len(name) {
+		h ^= name[i]
+	}
+	for _, b := range key {
+		h ^= b
+	}
+	return uint32(h)
+}
+
+// Channel is a named channel and its expanded encryption key.
+type Channel struct {
+	Name string
+	Key  []byte
+	Hash uint32
+}
+
+// NewChannel creates a Channel from a channel name and base64 encoded PSK.
+func NewChannel(name, psk string) (*Channel, error) {
+	raw, err := base64.StdEncoding.DecodeString(psk)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode key for channel %s: %w", name, err)
+	}
+
+	key, err := ExpandKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to expand key for channel %s: %w", name, err)
+	}
+
+	return &Channel{
+		Name: name,
+		Key:  key,
+		Hash: ChannelHash(name, key),
+	}, nil
+}
+
+// packetNonce builds the AES-CTR nonce, the 64-bit packet ID followed by the 32-bit sender node number,
+// both little-endian, with the remaining bytes used as the block counter.
+func packetNonce(packetID, fromNode uint32) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.LittleEndian.PutUint64(nonce[0:8], uint64(packetID))
+	binary.LittleEndian.PutUint32(nonce[8:12], fromNode)
+	return nonce
+}
+
+// TransformCTR encrypts or decrypts (AES-CTR is symmetric) a packet payload with the channel key.
+func TransformCTR(key []byte, packetID, fromNode uint32, in []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %w", err)
+	}
+
+	out := make([]byte, len(in))
+	cipher.NewCTR(block, packetNonce(packetID, fromNode)).XORKeyStream(out, in)
+	return out, nil
+}
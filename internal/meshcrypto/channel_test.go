@@ -0,0 +1,114 @@
+package meshcrypto_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExpandKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		psk     []byte
+		lastKey byte
+		keyLen  int
+		err     error
+	}{
+		{"default", []byte{0x01}, 0x01, 16, nil},
+		{"simple2", []byte{0x02}, 0x02, 16, nil},
+		{"none", []byte{0x00}, 0, 0, meshcrypto.ErrNoKey},
+		{"empty", nil, 0, 0, meshcrypto.ErrNoKey},
+		{"short", []byte{0x01, 0x02, 0x03}, 0x00, 16, nil},
+		{"aes256", bytes.Repeat([]byte{0xaa}, 32), 0xaa, 32, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := meshcrypto.ExpandKey(tt.psk)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ExpandKey() error = %v, want %v", err, tt.err)
+			}
+			if len(key) != tt.keyLen {
+				t.Fatalf("ExpandKey() length = %d, want %d", len(key), tt.keyLen)
+			}
+			if tt.keyLen > 0 && key[len(key)-1] != tt.lastKey {
+				t.Errorf("ExpandKey() last byte = %#x, want %#x", key[len(key)-1], tt.lastKey)
+			}
+		})
+	}
+}
+
+func TestChannelHash(t *testing.T) {
+	ch, err := meshcrypto.NewChannel("LongFast", "AQ==")
+	if err != nil {
+		t.Fatalf("NewChannel() error = %v", err)
+	}
+
+	// LongFast with the default key is well known to hash to 8.
+	if ch.Hash != 8 {
+		t.Errorf("ChannelHash() = %d, want 8", ch.Hash)
+	}
+}
+
+func TestKeyringDecryptPacket(t *testing.T) {
+	keyring := meshcrypto.NewKeyring()
+	if err := keyring.AddChannel("LongFast", "AQ=="); err != nil {
+		t.Fatalf("AddChannel() error = %v", err)
+	}
+	if err := keyring.AddChannel("Private", "bXlzZWNyZXRrZXkxMjM0NQ=="); err != nil {
+		t.Fatalf("AddChannel() error = %v", err)
+	}
+
+	ch, _ := keyring.Channel("Private")
+	plain, err := proto.Marshal(&meshtastic.Data{
+		Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+		Payload: []byte("secret"),
+	})
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+
+	encrypted, err := meshcrypto.TransformCTR(ch.Key, 1234, 5678, plain)
+	if err != nil {
+		t.Fatalf("TransformCTR() error = %v", err)
+	}
+
+	packet := &meshtastic.MeshPacket{
+		From:           5678,
+		Id:             1234,
+		Channel:        ch.Hash,
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}
+
+	t.Run("by-name", func(t *testing.T) {
+		data, decErr := keyring.DecryptPacket("Private", packet)
+		if decErr != nil {
+			t.Fatalf("DecryptPacket() error = %v", decErr)
+		}
+		if string(data.GetPayload()) != "secret" {
+			t.Errorf("DecryptPacket() payload = %q, want %q", data.GetPayload(), "secret")
+		}
+	})
+
+	t.Run("by-hash", func(t *testing.T) {
+		data, decErr := keyring.DecryptPacket("", packet)
+		if decErr != nil {
+			t.Fatalf("DecryptPacket() error = %v", decErr)
+		}
+		if data.GetPortnum() != meshtastic.PortNum_TEXT_MESSAGE_APP {
+			t.Errorf("DecryptPacket() portnum = %s, want TEXT_MESSAGE_APP", data.GetPortnum())
+		}
+	})
+
+	t.Run("not-encrypted", func(t *testing.T) {
+		_, decErr := keyring.DecryptPacket("Private", &meshtastic.MeshPacket{})
+		if !errors.Is(decErr, meshcrypto.ErrNotEncrypted) {
+			t.Errorf("DecryptPacket() error = %v, want %v", decErr, meshcrypto.ErrNotEncrypted)
+		}
+	})
+}
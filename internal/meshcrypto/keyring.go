@@ -0,0 +1,117 @@
+package meshcrypto
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNotEncrypted is returned when a packet does not carry an encrypted payload.
+var ErrNotEncrypted = errors.New("packet is not encrypted")
+
+// ErrUnknownChannel is returned when no configured channel key can decrypt a packet.
+var ErrUnknownChannel = errors.New("no matching channel key")
+
+// Keyring holds the channel keys and node keys used to decrypt packets.
+type Keyring struct {
+	lock        sync.RWMutex
+	channels    map[string]*Channel
+	byHash      map[uint32][]*Channel
+	privateKeys map[uint32]*ecdh.PrivateKey
+	publicKeys  map[uint32][]byte
+}
+
+// NewKeyring creates an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{
+		channels:    map[string]*Channel{},
+		byHash:      map[uint32][]*Channel{},
+		privateKeys: map[uint32]*ecdh.PrivateKey{},
+		publicKeys:  map[uint32][]byte{},
+	}
+}
+
+// AddChannel adds a channel by name and base64 encoded PSK.
+func (k *Keyring) AddChannel(name, psk string) error {
+	ch, err := NewChannel(name, psk)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.channels[ch.Name] = ch
+	k.byHash[ch.Hash] = append(k.byHash[ch.Hash], ch)
+
+	return nil
+}
+
+// Channel returns the channel with the given name.
+func (k *Keyring) Channel(name string) (*Channel, bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	ch, ok := k.channels[name]
+	return ch, ok
+}
+
+// Len returns the number of channels in the keyring.
+func (k *Keyring) Len() int {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	return len(k.channels)
+}
+
+// candidates returns the channels that could have encrypted a packet, the channel named
+// by the envelope first, followed by any channels matching the packet channel hash.
+func (k *Keyring) candidates(channelID string, hash uint32) []*Channel {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	out := make([]*Channel, 0, 1+len(k.byHash[hash]))
+	named, ok := k.channels[channelID]
+	if ok {
+		out = append(out, named)
+	}
+	for _, ch := range k.byHash[hash] {
+		if ch != named {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// DecryptPacket decrypts the channel encrypted payload of a packet, resolving the channel by the
+// envelope channel ID and falling back to the packet channel hash.
+func (k *Keyring) DecryptPacket(channelID string, packet *meshtastic.MeshPacket) (*meshtastic.Data, error) {
+	encrypted := packet.GetEncrypted()
+	if encrypted == nil {
+		return nil, ErrNotEncrypted
+	}
+
+	for _, ch := range k.candidates(channelID, packet.GetChannel()) {
+		plain, err := TransformCTR(ch.Key, packet.GetId(), packet.GetFrom(), encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", ch.Name, err)
+		}
+
+		data := &meshtastic.Data{}
+		if err = proto.Unmarshal(plain, data); err != nil {
+			continue
+		}
+
+		if data.GetPortnum() == meshtastic.PortNum_UNKNOWN_APP { //nolint:staticcheck // deprecated field
+			continue
+		}
+
+		return data, nil
+	}
+
+	return nil, ErrUnknownChannel
+}
@@ -0,0 +1,205 @@
+package meshcrypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// PKIChannelID is the channel ID the firmware uses when uplinking PKI encrypted packets.
+	PKIChannelID = "PKI"
+
+	// pkiTagSize is the size of the AES-CCM authentication tag.
+	pkiTagSize = 8
+	// pkiExtraNonceSize is the size of the random extra nonce appended after the tag.
+	pkiExtraNonceSize = 4
+	// pkiOverhead is the number of bytes appended to the ciphertext (tag and extra nonce).
+	pkiOverhead = pkiTagSize + pkiExtraNonceSize
+	// pkiNonceSize is the size of the AES-CCM nonce used by the firmware (L = 2).
+	pkiNonceSize = 13
+	// pkiKeySize is the size of a Curve25519 key.
+	pkiKeySize = 32
+)
+
+// ErrUnknownPublicKey is returned when the public key of the sending node is not known.
+var ErrUnknownPublicKey = errors.New("unknown public key for sending node")
+
+// ErrNotRecipient is returned when the packet is not addressed to a node we hold the private key for.
+var ErrNotRecipient = errors.New("no private key for destination node")
+
+// pkiNonce builds the PKI nonce, the packet ID, extra nonce and sender node number, little-endian.
+func pkiNonce(packetID, fromNode, extraNonce uint32) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.LittleEndian.PutUint32(nonce[0:4], packetID)
+	binary.LittleEndian.PutUint32(nonce[4:8], extraNonce)
+	binary.LittleEndian.PutUint32(nonce[8:12], fromNode)
+	return nonce[:pkiNonceSize]
+}
+
+// sharedKey derives the AES-256 key from the X25519 shared secret of the two nodes.
+func sharedKey(privateKey *ecdh.PrivateKey, peerPublicKey []byte) ([]byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	secret, err := privateKey.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute shared secret: %w", err)
+	}
+
+	key := sha256.Sum256(secret)
+	return key[:], nil
+}
+
+// EncryptPKI encrypts a packet payload for a peer node, returning the ciphertext followed by
+// the authentication tag and extra nonce as the firmware expects.
+func EncryptPKI(
+	privateKey *ecdh.PrivateKey,
+	peerPublicKey []byte,
+	packetID, fromNode, extraNonce uint32,
+	plain []byte,
+) ([]byte, error) {
+	key, err := sharedKey(privateKey, peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := SealCCM(key, pkiNonce(packetID, fromNode, extraNonce), pkiTagSize, plain, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return binary.LittleEndian.AppendUint32(out, extraNonce), nil
+}
+
+// DecryptPKI decrypts a PKI encrypted packet payload sent by a peer node.
+func DecryptPKI(
+	privateKey *ecdh.PrivateKey,
+	peerPublicKey []byte,
+	packetID, fromNode uint32,
+	in []byte,
+) ([]byte, error) {
+	if len(in) < pkiOverhead {
+		return nil, fmt.Errorf("encrypted payload too short: %d", len(in))
+	}
+
+	key, err := sharedKey(privateKey, peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := in[:len(in)-pkiOverhead]
+	tag := in[len(in)-pkiOverhead : len(in)-pkiExtraNonceSize]
+	extraNonce := binary.LittleEndian.Uint32(in[len(in)-pkiExtraNonceSize:])
+
+	return OpenCCM(key, pkiNonce(packetID, fromNode, extraNonce), ciphertext, tag, nil)
+}
+
+// ParsePrivateKey parses a base64 encoded Curve25519 private key.
+func ParsePrivateKey(b64 string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode private key: %w", err)
+	}
+
+	if len(raw) != pkiKeySize {
+		return nil, fmt.Errorf("invalid private key length %d", len(raw))
+	}
+
+	return ecdh.X25519().NewPrivateKey(raw)
+}
+
+// AddPrivateKey adds the base64 encoded Curve25519 private key of a node we own.
+func (k *Keyring) AddPrivateKey(node uint32, b64 string) error {
+	privateKey, err := ParsePrivateKey(b64)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.privateKeys[node] = privateKey
+	k.publicKeys[node] = privateKey.PublicKey().Bytes()
+
+	return nil
+}
+
+// SetPublicKey records the public key advertised by a node.
+func (k *Keyring) SetPublicKey(node uint32, key []byte) {
+	if len(key) != pkiKeySize {
+		return
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.publicKeys[node] = key
+}
+
+// PublicKey returns the known public key for a node.
+func (k *Keyring) PublicKey(node uint32) ([]byte, bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	key, ok := k.publicKeys[node]
+	return key, ok
+}
+
+// OwnsNode returns true if the keyring holds the private key for the node.
+func (k *Keyring) OwnsNode(node uint32) bool {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	_, ok := k.privateKeys[node]
+	return ok
+}
+
+// DecryptPKIPacket decrypts a PKI encrypted packet addressed to a node we hold the private key for.
+func (k *Keyring) DecryptPKIPacket(packet *meshtastic.MeshPacket) (*meshtastic.Data, error) {
+	encrypted := packet.GetEncrypted()
+	if encrypted == nil {
+		return nil, ErrNotEncrypted
+	}
+
+	k.lock.RLock()
+	privateKey, ok := k.privateKeys[packet.GetTo()]
+	peerKey := k.publicKeys[packet.GetFrom()]
+	ownKey := k.publicKeys[packet.GetTo()]
+	k.lock.RUnlock()
+
+	if !ok {
+		return nil, ErrNotRecipient
+	}
+
+	// The packet public key is the sender key once decoded by a node, but the recipient key when
+	// the sender uplinks it, so it is only a fallback when it is not our own key.
+	if pk := packet.GetPublicKey(); peerKey == nil && len(pk) == pkiKeySize && !bytes.Equal(pk, ownKey) {
+		peerKey = pk
+	}
+
+	if peerKey == nil {
+		return nil, ErrUnknownPublicKey
+	}
+
+	plain, err := DecryptPKI(privateKey, peerKey, packet.GetId(), packet.GetFrom(), encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &meshtastic.Data{}
+	if err = proto.Unmarshal(plain, data); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal decrypted payload: %w", err)
+	}
+
+	return data, nil
+}
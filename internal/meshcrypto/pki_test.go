@@ -0,0 +1,121 @@
+package meshcrypto_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func mustHex(t *testing.T, in string) []byte {
+	t.Helper()
+	out, err := hex.DecodeString(in)
+	if err != nil {
+		t.Fatalf("hex.DecodeString() error = %v", err)
+	}
+	return out
+}
+
+// TestCCMVector uses RFC 3610 packet vector #1.
+func TestCCMVector(t *testing.T) {
+	key := mustHex(t, "c0c1c2c3c4c5c6c7c8c9cacbcccdcecf")
+	nonce := mustHex(t, "00000003020100a0a1a2a3a4a5")
+	aad := mustHex(t, "0001020304050607")
+	plain := mustHex(t, "08090a0b0c0d0e0f101112131415161718191a1b1c1d1e")
+	expected := mustHex(t, "588c979a61c663d2f066d0c2c0f989806d5f6b61dac38417e8d12cfdf926e0")
+
+	sealed, err := meshcrypto.SealCCM(key, nonce, 8, plain, aad)
+	if err != nil {
+		t.Fatalf("SealCCM() error = %v", err)
+	}
+	if !bytes.Equal(sealed, expected) {
+		t.Fatalf("SealCCM() = %x, want %x", sealed, expected)
+	}
+
+	opened, err := meshcrypto.OpenCCM(key, nonce, sealed[:len(plain)], sealed[len(plain):], aad)
+	if err != nil {
+		t.Fatalf("OpenCCM() error = %v", err)
+	}
+	if !bytes.Equal(opened, plain) {
+		t.Errorf("OpenCCM() = %x, want %x", opened, plain)
+	}
+
+	sealed[0] ^= 0xff
+	if _, err = meshcrypto.OpenCCM(key, nonce, sealed[:len(plain)], sealed[len(plain):], aad); !errors.Is(
+		err, meshcrypto.ErrAuthFailed,
+	) {
+		t.Errorf("OpenCCM() tampered error = %v, want %v", err, meshcrypto.ErrAuthFailed)
+	}
+}
+
+func TestKeyringDecryptPKIPacket(t *testing.T) {
+	const (
+		senderNode    = uint32(0x11223344)
+		recipientNode = uint32(0x44be043f)
+		packetID      = uint32(0xdeadbeef)
+	)
+
+	senderKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	recipientKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	plain, err := proto.Marshal(&meshtastic.Data{
+		Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+		Payload: []byte("direct message"),
+	})
+	if err != nil {
+		t.Fatalf("proto.Marshal() error = %v", err)
+	}
+
+	encrypted, err := meshcrypto.EncryptPKI(
+		senderKey, recipientKey.PublicKey().Bytes(), packetID, senderNode, 0x01020304, plain,
+	)
+	if err != nil {
+		t.Fatalf("EncryptPKI() error = %v", err)
+	}
+
+	packet := &meshtastic.MeshPacket{
+		From:           senderNode,
+		To:             recipientNode,
+		Id:             packetID,
+		PkiEncrypted:   true,
+		PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: encrypted},
+	}
+
+	keyring := meshcrypto.NewKeyring()
+	if err = keyring.AddPrivateKey(recipientNode, base64.StdEncoding.EncodeToString(recipientKey.Bytes())); err != nil {
+		t.Fatalf("AddPrivateKey() error = %v", err)
+	}
+
+	if _, err = keyring.DecryptPKIPacket(packet); !errors.Is(err, meshcrypto.ErrUnknownPublicKey) {
+		t.Fatalf("DecryptPKIPacket() error = %v, want %v", err, meshcrypto.ErrUnknownPublicKey)
+	}
+
+	keyring.SetPublicKey(senderNode, senderKey.PublicKey().Bytes())
+
+	data, err := keyring.DecryptPKIPacket(packet)
+	if err != nil {
+		t.Fatalf("DecryptPKIPacket() error = %v", err)
+	}
+	if string(data.GetPayload()) != "direct message" {
+		t.Errorf("DecryptPKIPacket() payload = %q, want %q", data.GetPayload(), "direct message")
+	}
+
+	packet.To = senderNode
+	if _, err = keyring.DecryptPKIPacket(packet); !errors.Is(err, meshcrypto.ErrNotRecipient) {
+		t.Errorf("DecryptPKIPacket() error = %v, want %v", err, meshcrypto.ErrNotRecipient)
+	}
+}
@@ -0,0 +1,383 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "meshtastic"
+
+const (
+	// StageEnvelope is the decode stage for unmarshalling the ServiceEnvelope.
+	StageEnvelope = "envelope"
+	// StageMessage is the decode stage for converting the packet, including its payload, to a Message.
+	StageMessage = "message"
+	// StageJSON is the decode stage for encoding the Message as JSON.
+	StageJSON = "json"
+	// StageDownlink is the decode stage for encoding a JSON downlink request as a ServiceEnvelope.
+	StageDownlink = "downlink"
+)
+
+// Labels identifies the kind of message a counter is recorded against.
+type Labels struct {
+	PortNum string
+	Channel string
+}
+
+// Metrics holds the Prometheus collectors for the relay and fanout clients.
+//
+// All methods are safe to call on a nil *Metrics, which records nothing.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	messagesReceived  *prometheus.CounterVec
+	messagesPublished *prometheus.CounterVec
+	messagesFailed    *prometheus.CounterVec
+	messagesDropped   *prometheus.CounterVec
+	decodeErrors      *prometheus.CounterVec
+	duplicates        *prometheus.CounterVec
+	storeSaveDuration prometheus.Histogram
+	reconnects        *prometheus.CounterVec
+	publishRetries    *prometheus.CounterVec
+	deadLetters       *prometheus.CounterVec
+	outboxMessages    prometheus.Gauge
+	queueDepth        *prometheus.GaugeVec
+	queueDropped      prometheus.Counter
+
+	nodeLastSeen           *prometheus.GaugeVec
+	nodeBatteryLevel       *prometheus.GaugeVec
+	nodeVoltage            *prometheus.GaugeVec
+	nodeChannelUtilization *prometheus.GaugeVec
+	nodeAirUtilTx          *prometheus.GaugeVec
+
+	nodeTTL   time.Duration
+	nodesLock sync.Mutex
+	nodes     map[string]time.Time
+}
+
+// New creates the metrics and registers them, along with the Go and process collectors, on a new registry.
+func New(opts ...OptionFunc) *Metrics {
+	messageLabels := []string{"type", "portnum", "channel"}
+	nodeLabels := []string{"node"}
+
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_received_total",
+			Help:      "Number of messages received from the source broker.",
+		}, messageLabels),
+		messagesPublished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_published_total",
+			Help:      "Number of messages published to the destination broker.",
+		}, messageLabels),
+		messagesFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_failed_total",
+			Help:      "Number of messages that failed to publish to the destination broker.",
+		}, messageLabels),
+		messagesDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "messages_dropped_total",
+			Help:      "Number of messages dropped by the stages of an output, e.g. a filter.",
+		}, messageLabels),
+		decodeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decode_errors_total",
+			Help:      "Number of messages that could not be decoded.",
+		}, []string{"type", "stage"}),
+		duplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "duplicates_total",
+			Help:      "Number of duplicate packets suppressed.",
+		}, []string{"type"}),
+		storeSaveDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_save_duration_seconds",
+			Help:      "Time taken to save a message to the store.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mqtt_reconnects_total",
+			Help:      "Number of MQTT reconnection attempts.",
+		}, []string{"type", "client"}),
+		publishRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "publish_retries_total",
+			Help:      "Number of retried publishes to the destination broker.",
+		}, []string{"type"}),
+		deadLetters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dead_letters_total",
+			Help:      "Number of messages that could not be decoded or published.",
+		}, []string{"reason"}),
+		outboxMessages: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "outbox_messages",
+			Help:      "Number of messages queued while the destination broker is disconnected.",
+		}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_queue_depth",
+			Help:      "Number of messages waiting in the queue of a worker.",
+		}, []string{"worker"}),
+		queueDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "worker_queue_dropped_total",
+			Help:      "Number of messages dropped because the queue of a worker was full.",
+		}),
+		nodeLastSeen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "node_last_seen_timestamp_seconds",
+			Help:      "Unix time a message was last received from the node.",
+		}, nodeLabels),
+		nodeBatteryLevel: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "node_battery_level_percent",
+			Help:      "Last reported battery level of the node.",
+		}, nodeLabels),
+		nodeVoltage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "node_voltage_volts",
+			Help:      "Last reported voltage of the node.",
+		}, nodeLabels),
+		nodeChannelUtilization: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "node_channel_utilization_percent",
+			Help:      "Last reported channel utilisation of the node.",
+		}, nodeLabels),
+		nodeAirUtilTx: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "node_air_util_tx_percent",
+			Help:      "Last reported transmit airtime utilisation of the node.",
+		}, nodeLabels),
+		nodes: map[string]time.Time{},
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.messagesReceived,
+		m.messagesPublished,
+		m.messagesFailed,
+		m.messagesDropped,
+		m.decodeErrors,
+		m.duplicates,
+		m.storeSaveDuration,
+		m.reconnects,
+		m.publishRetries,
+		m.deadLetters,
+		m.outboxMessages,
+		m.queueDepth,
+		m.queueDropped,
+		m.nodeLastSeen,
+		m.nodeBatteryLevel,
+		m.nodeVoltage,
+		m.nodeChannelUtilization,
+		m.nodeAirUtilTx,
+	)
+
+	return m
+}
+
+// Handler returns the HTTP handler that serves the metrics, expiring nodes not heard within the node TTL first.
+func (m *Metrics) Handler() http.Handler {
+	h := promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.ExpireNodes(time.Now())
+		h.ServeHTTP(w, r)
+	})
+}
+
+// MessageReceived counts a message received by the client type (relay or fanout).
+func (m *Metrics) MessageReceived(typ string, labels Labels) {
+	if m == nil {
+		return
+	}
+
+	m.messagesReceived.WithLabelValues(typ, labels.PortNum, labels.Channel).Inc()
+}
+
+// MessagePublished counts a message published by the client type (relay or fanout).
+func (m *Metrics) MessagePublished(typ string, labels Labels) {
+	if m == nil {
+		return
+	}
+
+	m.messagesPublished.WithLabelValues(typ, labels.PortNum, labels.Channel).Inc()
+}
+
+// MessageFailed counts a message that the client type (relay or fanout) failed to publish.
+func (m *Metrics) MessageFailed(typ string, labels Labels) {
+	if m == nil {
+		return
+	}
+
+	m.messagesFailed.WithLabelValues(typ, labels.PortNum, labels.Channel).Inc()
+}
+
+// MessageDropped counts a message dropped by the stages of the output type (relay, fanout or store).
+func (m *Metrics) MessageDropped(typ string, labels Labels) {
+	if m == nil {
+		return
+	}
+
+	m.messagesDropped.WithLabelValues(typ, labels.PortNum, labels.Channel).Inc()
+}
+
+// DecodeError counts a message that could not be decoded at the given stage.
+func (m *Metrics) DecodeError(typ, stage string) {
+	if m == nil {
+		return
+	}
+
+	m.decodeErrors.WithLabelValues(typ, stage).Inc()
+}
+
+// Duplicate counts a duplicate packet suppressed by the client type (relay or fanout).
+func (m *Metrics) Duplicate(typ string) {
+	if m == nil {
+		return
+	}
+
+	m.duplicates.WithLabelValues(typ).Inc()
+}
+
+// ObserveStoreSave records the time taken to save a message to the store.
+func (m *Metrics) ObserveStoreSave(d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.storeSaveDuration.Observe(d.Seconds())
+}
+
+// Reconnect counts a reconnection attempt of the named MQTT client (source or dest).
+func (m *Metrics) Reconnect(typ, client string) {
+	if m == nil {
+		return
+	}
+
+	m.reconnects.WithLabelValues(typ, client).Inc()
+}
+
+// PublishRetry counts a publish to the destination broker that is being retried.
+func (m *Metrics) PublishRetry(typ string) {
+	if m == nil {
+		return
+	}
+
+	m.publishRetries.WithLabelValues(typ).Inc()
+}
+
+// DeadLetter counts a message that could not be decoded or published, by reason.
+func (m *Metrics) DeadLetter(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.deadLetters.WithLabelValues(reason).Inc()
+}
+
+// SetOutboxSize records the number of messages queued in the outbox.
+func (m *Metrics) SetOutboxSize(n int) {
+	if m == nil {
+		return
+	}
+
+	m.outboxMessages.Set(float64(n))
+}
+
+// SetQueueDepth records the number of messages waiting in the queue of a worker.
+func (m *Metrics) SetQueueDepth(worker string, n int) {
+	if m == nil {
+		return
+	}
+
+	m.queueDepth.WithLabelValues(worker).Set(float64(n))
+}
+
+// QueueDropped counts a message dropped because the queue of a worker was full.
+func (m *Metrics) QueueDropped() {
+	if m == nil {
+		return
+	}
+
+	m.queueDropped.Inc()
+}
+
+// ObserveNode updates the per-node gauges from a decoded message.
+func (m *Metrics) ObserveNode(msg *mtypes.Message) {
+	if m == nil || msg == nil || msg.From == 0 || msg.From == mtypes.BroadcastNode {
+		return
+	}
+
+	node := mtypes.FormatNodeID(msg.From)
+
+	m.nodesLock.Lock()
+	m.nodes[node] = time.Now()
+	m.nodesLock.Unlock()
+
+	lastSeen := time.Now()
+	if msg.Timestamp > 0 {
+		lastSeen = time.Unix(int64(msg.Timestamp), 0)
+	}
+	m.nodeLastSeen.WithLabelValues(node).Set(float64(lastSeen.Unix()))
+
+	tm, ok := msg.Payload.(*translator.TelemetryDeviceMetrics)
+	if !ok || tm == nil || tm.DeviceMetrics == nil {
+		return
+	}
+
+	dm := tm.DeviceMetrics
+	if dm.BatteryLevel != nil {
+		m.nodeBatteryLevel.WithLabelValues(node).Set(float64(*dm.BatteryLevel))
+	}
+	if dm.Voltage != nil {
+		m.nodeVoltage.WithLabelValues(node).Set(float64(*dm.Voltage))
+	}
+	if dm.ChannelUtilization != nil {
+		m.nodeChannelUtilization.WithLabelValues(node).Set(float64(*dm.ChannelUtilization))
+	}
+	if dm.AirUtilTx != nil {
+		m.nodeAirUtilTx.WithLabelValues(node).Set(float64(*dm.AirUtilTx))
+	}
+}
+
+// ExpireNodes removes the per-node gauges of nodes not heard within the node TTL before the given time.
+func (m *Metrics) ExpireNodes(now time.Time) {
+	if m == nil || m.nodeTTL <= 0 {
+		return
+	}
+
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
+	for node, heard := range m.nodes {
+		if now.Sub(heard) < m.nodeTTL {
+			continue
+		}
+
+		delete(m.nodes, node)
+		m.nodeLastSeen.DeleteLabelValues(node)
+		m.nodeBatteryLevel.DeleteLabelValues(node)
+		m.nodeVoltage.DeleteLabelValues(node)
+		m.nodeChannelUtilization.DeleteLabelValues(node)
+		m.nodeAirUtilTx.DeleteLabelValues(node)
+	}
+}
@@ -0,0 +1,131 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := metrics.New()
+	labels := metrics.Labels{PortNum: "TEXT_MESSAGE_APP", Channel: "LongFast"}
+
+	m.MessageReceived("relay", labels)
+	m.MessageReceived("relay", labels)
+	m.MessagePublished("relay", labels)
+	m.MessageFailed("fanout", labels)
+	m.MessageDropped("store", labels)
+	m.DecodeError("relay", metrics.StageEnvelope)
+	m.Reconnect("relay", "source")
+	m.PublishRetry("pipeline")
+	m.DeadLetter("publish")
+	m.SetOutboxSize(3)
+	m.SetQueueDepth("1", 7)
+	m.QueueDropped()
+	m.ObserveStoreSave(10 * time.Millisecond)
+	m.ObserveNode(&mtypes.Message{
+		From:      0x44be043f,
+		Timestamp: 1762934875,
+		Payload: &translator.TelemetryDeviceMetrics{
+			DeviceMetrics: &translator.DeviceMetrics{
+				BatteryLevel:       ptr(uint32(87)),
+				Voltage:            ptr(translator.SpecialFloat64(4.1)),
+				ChannelUtilization: ptr(translator.SpecialFloat64(12.5)),
+				AirUtilTx:          ptr(translator.SpecialFloat64(1.5)),
+			},
+		},
+	})
+
+	body := scrape(t, m)
+
+	for _, want := range []string{
+		`meshtastic_messages_received_total{channel="LongFast",portnum="TEXT_MESSAGE_APP",type="relay"} 2`,
+		`meshtastic_messages_published_total{channel="LongFast",portnum="TEXT_MESSAGE_APP",type="relay"} 1`,
+		`meshtastic_messages_failed_total{channel="LongFast",portnum="TEXT_MESSAGE_APP",type="fanout"} 1`,
+		`meshtastic_messages_dropped_total{channel="LongFast",portnum="TEXT_MESSAGE_APP",type="store"} 1`,
+		`meshtastic_decode_errors_total{stage="envelope",type="relay"} 1`,
+		`meshtastic_mqtt_reconnects_total{client="source",type="relay"} 1`,
+		`meshtastic_publish_retries_total{type="pipeline"} 1`,
+		`meshtastic_dead_letters_total{reason="publish"} 1`,
+		`meshtastic_outbox_messages 3`,
+		`meshtastic_worker_queue_depth{worker="1"} 7`,
+		`meshtastic_worker_queue_dropped_total 1`,
+		`meshtastic_store_save_duration_seconds_count 1`,
+		`meshtastic_node_last_seen_timestamp_seconds{node="!44be043f"} 1.762934875e+09`,
+		`meshtastic_node_battery_level_percent{node="!44be043f"} 87`,
+		`meshtastic_node_voltage_volts{node="!44be043f"} 4.1`,
+		`meshtastic_node_channel_utilization_percent{node="!44be043f"} 12.5`,
+		`meshtastic_node_air_util_tx_percent{node="!44be043f"} 1.5`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics missing %q", want)
+		}
+	}
+}
+
+func TestNilMetrics(_ *testing.T) {
+	var m *metrics.Metrics
+
+	m.MessageReceived("relay", metrics.Labels{})
+	m.MessagePublished("relay", metrics.Labels{})
+	m.MessageFailed("relay", metrics.Labels{})
+	m.MessageDropped("relay", metrics.Labels{})
+	m.DecodeError("relay", metrics.StageMessage)
+	m.Reconnect("relay", "dest")
+	m.PublishRetry("pipeline")
+	m.DeadLetter("envelope")
+	m.SetOutboxSize(0)
+	m.SetQueueDepth("0", 0)
+	m.QueueDropped()
+	m.ObserveStoreSave(time.Second)
+	m.ObserveNode(&mtypes.Message{From: 1})
+}
+
+func TestExpireNodes(t *testing.T) {
+	m := metrics.New(metrics.WithNodeTTL(time.Hour))
+
+	m.ObserveNode(&mtypes.Message{
+		From: 0x44be043f,
+		Payload: &translator.TelemetryDeviceMetrics{
+			DeviceMetrics: &translator.DeviceMetrics{BatteryLevel: ptr(uint32(87))},
+		},
+	})
+
+	m.ExpireNodes(time.Now().Add(time.Minute))
+	if body := scrape(t, m); !strings.Contains(body, `meshtastic_node_battery_level_percent{node="!44be043f"} 87`) {
+		t.Errorf("Metrics missing node before its TTL:\n%s", body)
+	}
+
+	m.ExpireNodes(time.Now().Add(2 * time.Hour))
+	if body := scrape(t, m); strings.Contains(body, `node="!44be043f"`) {
+		t.Errorf("Metrics still contain node after its TTL:\n%s", body)
+	}
+}
@@ -0,0 +1,14 @@
+package metrics
+
+import "time"
+
+type OptionFunc func(*Metrics)
+
+// WithNodeTTL sets the time after which the per-node gauges of a node that has not been heard are removed.
+//
+// A TTL of zero keeps the gauges of every node heard since startup.
+func WithNodeTTL(ttl time.Duration) OptionFunc {
+	return func(m *Metrics) {
+		m.nodeTTL = ttl
+	}
+}
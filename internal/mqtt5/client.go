@@ -0,0 +1,415 @@
+// Package mqtt5 is a minimal MQTT 5 client, supporting QoS 0 publishing with properties and subscriptions.
+package mqtt5
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	minReconnectDelay     = time.Second
+	maxReconnectDelay     = 2 * time.Minute
+	// keepaliveGrace is the factor of the keepalive interval after which a silent connection is considered lost.
+	keepaliveGrace = 3 / 2.0
+)
+
+var (
+	// ErrNotConnected is returned when publishing or subscribing while not connected.
+	ErrNotConnected = errors.New("not connected")
+	// ErrUnsupportedScheme is returned for broker addresses that are not tcp, mqtt, ssl, tls, mqtts, ws or wss.
+	ErrUnsupportedScheme = errors.New("unsupported broker scheme")
+	// ErrUnsupportedQoS is returned when publishing with a QoS other than 0.
+	ErrUnsupportedQoS = errors.New("only QoS 0 publishing is supported")
+)
+
+// ReasonError is returned when the broker refuses a connection or subscription.
+type ReasonError struct {
+	Packet string
+	Code   byte
+}
+
+func (e *ReasonError) Error() string {
+	return fmt.Sprintf("%s refused with reason code 0x%02x", e.Packet, e.Code)
+}
+
+// Options are the options for a Client.
+type Options struct {
+	// Address is the broker URL, e.g. tcp://localhost:1883, ssl://localhost:8883 or ws://localhost:8080/mqtt.
+	Address   string
+	ClientID  string
+	Username  string
+	Password  string
+	Keepalive time.Duration
+	// TLSConfig is used for ssl, tls, mqtts and wss addresses.
+	TLSConfig *tls.Config
+	// ConnectTimeout is the time allowed to connect to the broker, defaults to 30 seconds.
+	ConnectTimeout time.Duration
+	// OnConnect is called each time the client connects, including reconnects.
+	OnConnect func()
+	// OnReconnecting is called before each reconnection attempt.
+	OnReconnecting func()
+	// OnMessage is called from the read loop for each message received, in the order they are received, it must not
+	// wait on the client.
+	OnMessage func(*Publish)
+}
+
+// Client is an MQTT 5 client that reconnects automatically until Disconnect is called.
+type Client struct {
+	opts Options
+
+	lock      sync.Mutex
+	writeLock sync.Mutex
+	conn      net.Conn
+	connected bool
+	closed    bool
+	done      chan struct{}
+	packetID  uint16
+	pending   map[uint16]chan *Packet
+}
+
+// NewClient creates a new client, it does not connect until Connect is called.
+func NewClient(opts Options) *Client {
+	if opts.ConnectTimeout <= 0 {
+		opts.ConnectTimeout = defaultConnectTimeout
+	}
+
+	return &Client{
+		opts:    opts,
+		done:    make(chan struct{}),
+		pending: map[uint16]chan *Packet{},
+	}
+}
+
+// Connect connects to the broker, once connected the client reconnects automatically if the connection is lost.
+func (c *Client) Connect(ctx context.Context) error {
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	if c.opts.OnConnect != nil {
+		go c.opts.OnConnect()
+	}
+
+	return nil
+}
+
+// IsConnected returns true if the client is connected to the broker.
+func (c *Client) IsConnected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.connected
+}
+
+// Subscribe subscribes to a topic filter and waits for the broker to acknowledge it.
+//
+// Subscriptions are not restored after a reconnect, use OnConnect to subscribe on each connection.
+func (c *Client) Subscribe(ctx context.Context, topic string, qos byte) error {
+	c.lock.Lock()
+	if !c.connected {
+		c.lock.Unlock()
+		return ErrNotConnected
+	}
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	packetID := c.packetID
+	ack := make(chan *Packet, 1)
+	c.pending[packetID] = ack
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, packetID)
+		c.lock.Unlock()
+	}()
+
+	if err := c.write(encodeSubscribe(packetID, topic, qos)); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case p, ok := <-ack:
+		if !ok {
+			return ErrNotConnected
+		}
+
+		_, code, err := decodeSuback(p)
+		if err != nil {
+			return err
+		}
+		if code >= 0x80 { //nolint:mnd // reason codes of 0x80 and above are failures.
+			return &ReasonError{Packet: "SUBSCRIBE", Code: code}
+		}
+	}
+
+	return nil
+}
+
+// Publish publishes a message at QoS 0.
+func (c *Client) Publish(_ context.Context, pub *Publish) error {
+	if pub.QoS != 0 {
+		return ErrUnsupportedQoS
+	}
+
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+
+	return c.write(EncodePublish(pub))
+}
+
+// Disconnect disconnects from the broker and stops reconnecting.
+func (c *Client) Disconnect() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.connected = false
+	c.lock.Unlock()
+
+	if conn != nil {
+		c.writeLock.Lock()
+		_, _ = conn.Write((&Packet{Type: TypeDisconnect}).Bytes())
+		c.writeLock.Unlock()
+		_ = conn.Close()
+	}
+}
+
+// dial opens the network connection to the broker.
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	u, err := url.Parse(c.opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse broker address: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: c.opts.ConnectTimeout}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		return dialer.DialContext(ctx, "tcp", u.Host)
+	case "ssl", "tls", "mqtts":
+		return (&tls.Dialer{NetDialer: dialer, Config: c.tlsConfig(u)}).DialContext(ctx, "tcp", u.Host)
+	case "ws":
+		return dialWebsocket(ctx, u, nil, c.opts.ConnectTimeout)
+	case "wss":
+		return dialWebsocket(ctx, u, c.tlsConfig(u), c.opts.ConnectTimeout)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, u.Scheme)
+	}
+}
+
+// tlsConfig returns the TLS configuration for the broker, verifying it against the system roots by default.
+func (c *Client) tlsConfig(u *url.URL) *tls.Config {
+	if c.opts.TLSConfig != nil {
+		return c.opts.TLSConfig
+	}
+
+	return &tls.Config{MinVersion: tls.VersionTLS12, ServerName: u.Hostname()}
+}
+
+// connect opens a connection and performs the CONNECT handshake.
+func (c *Client) connect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.ConnectTimeout)
+	defer cancel()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	packet := encodeConnect(c.opts.ClientID, c.opts.Username, c.opts.Password, c.opts.Keepalive)
+	if _, err = conn.Write(packet.Bytes()); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("unable to send CONNECT: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	p, err := ReadPacket(reader)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("unable to read CONNACK: %w", err)
+	}
+
+	code, err := decodeConnack(p)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if code != 0 {
+		_ = conn.Close()
+		return &ReasonError{Packet: "CONNECT", Code: code}
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		_ = conn.Close()
+		return ErrNotConnected
+	}
+	c.conn = conn
+	c.connected = true
+	c.lock.Unlock()
+
+	go c.readLoop(conn, reader)
+	if c.opts.Keepalive > 0 {
+		go c.pingLoop(conn)
+	}
+
+	return nil
+}
+
+func (c *Client) write(p *Packet) error {
+	c.lock.Lock()
+	conn := c.conn
+	c.lock.Unlock()
+
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if _, err := conn.Write(p.Bytes()); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("unable to write packet: %w", err)
+	}
+
+	return nil
+}
+
+// readLoop handles packets from the broker until the connection is closed, then starts reconnecting.
+func (c *Client) readLoop(conn net.Conn, reader *bufio.Reader) {
+	defer c.connectionLost(conn)
+
+	for {
+		if c.opts.Keepalive > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(time.Duration(float64(c.opts.Keepalive) * keepaliveGrace)))
+		}
+
+		p, err := ReadPacket(reader)
+		if err != nil {
+			return
+		}
+
+		switch p.Type {
+		case TypePublish:
+			pub, decodeErr := DecodePublish(p)
+			if decodeErr != nil {
+				return
+			}
+			if pub.QoS == 1 {
+				_ = c.write(encodePuback(pub.PacketID))
+			}
+			if c.opts.OnMessage != nil {
+				c.opts.OnMessage(pub)
+			}
+		case TypeSuback:
+			packetID, _, decodeErr := decodeSuback(p)
+			if decodeErr != nil {
+				return
+			}
+			c.lock.Lock()
+			if ack, ok := c.pending[packetID]; ok {
+				ack <- p
+			}
+			c.lock.Unlock()
+		case TypeDisconnect:
+			return
+		}
+	}
+}
+
+func (c *Client) pingLoop(conn net.Conn) {
+	ticker := time.NewTicker(c.opts.Keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.lock.Lock()
+			current := c.conn == conn
+			c.lock.Unlock()
+			if !current {
+				return
+			}
+
+			_ = c.write(&Packet{Type: TypePingreq})
+		}
+	}
+}
+
+// connectionLost marks the client as disconnected and reconnects unless Disconnect has been called.
+func (c *Client) connectionLost(conn net.Conn) {
+	_ = conn.Close()
+
+	c.lock.Lock()
+	if c.conn == conn {
+		c.conn = nil
+		c.connected = false
+	}
+	for id, ack := range c.pending {
+		close(ack)
+		delete(c.pending, id)
+	}
+	closed := c.closed
+	c.lock.Unlock()
+
+	if !closed {
+		go c.reconnect()
+	}
+}
+
+func (c *Client) reconnect() {
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
+		}
+
+		if c.opts.OnReconnecting != nil {
+			c.opts.OnReconnecting()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		err := c.Connect(ctx)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		delay = min(delay*2, maxReconnectDelay) //nolint:mnd // exponential backoff.
+	}
+}
@@ -0,0 +1,231 @@
+package mqtt5_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqtt5"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeBroker accepts a single MQTT 5 connection and passes the packets it receives to the test.
+type fakeBroker struct {
+	t       *testing.T
+	ln      net.Listener
+	conn    net.Conn
+	reader  *bufio.Reader
+	connack byte
+}
+
+func newFakeBroker(t *testing.T, connack byte) *fakeBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	return &fakeBroker{t: t, ln: ln, connack: connack}
+}
+
+func (b *fakeBroker) address() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// accept accepts the connection and responds to the CONNECT packet, returning it.
+func (b *fakeBroker) accept() *mqtt5.Packet {
+	b.t.Helper()
+
+	conn, err := b.ln.Accept()
+	if err != nil {
+		b.t.Fatalf("Accept() error: %v", err)
+	}
+	b.t.Cleanup(func() { _ = conn.Close() })
+	b.conn = conn
+	b.reader = bufio.NewReader(conn)
+
+	p := b.read()
+	if p.Type != mqtt5.TypeConnect {
+		b.t.Fatalf("first packet: got type %d, want CONNECT", p.Type)
+	}
+
+	// session present, reason code, no properties.
+	b.write(&mqtt5.Packet{Type: mqtt5.TypeConnack, Body: []byte{0, b.connack, 0}})
+
+	return p
+}
+
+func (b *fakeBroker) read() *mqtt5.Packet {
+	b.t.Helper()
+
+	_ = b.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := mqtt5.ReadPacket(b.reader)
+	if err != nil {
+		b.t.Fatalf("ReadPacket() error: %v", err)
+	}
+
+	return p
+}
+
+func (b *fakeBroker) write(p *mqtt5.Packet) {
+	b.t.Helper()
+
+	if _, err := b.conn.Write(p.Bytes()); err != nil {
+		b.t.Fatalf("Write() error: %v", err)
+	}
+}
+
+func TestConnectSubscribePublish(t *testing.T) {
+	b := newFakeBroker(t, 0)
+
+	received := make(chan *mqtt5.Publish, 1)
+	client := mqtt5.NewClient(mqtt5.Options{
+		Address:   b.address(),
+		ClientID:  "relay-source",
+		Username:  "user",
+		Password:  "secret",
+		Keepalive: time.Minute,
+		OnMessage: func(pub *mqtt5.Publish) { received <- pub },
+	})
+	t.Cleanup(client.Disconnect)
+
+	ctx := t.Context()
+
+	connected := make(chan error, 1)
+	go func() { connected <- client.Connect(ctx) }()
+
+	connect := b.accept()
+	// protocol name, protocol level 5, flags with username, password and clean start.
+	if connect.Body[6] != 5 || connect.Body[7] != 0xc2 {
+		t.Errorf("CONNECT: got level %d flags 0x%02x, want level 5 flags 0xc2", connect.Body[6], connect.Body[7])
+	}
+	if err := <-connected; err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if !client.IsConnected() {
+		t.Fatal("IsConnected(): got false, want true")
+	}
+
+	subscribed := make(chan error, 1)
+	go func() { subscribed <- client.Subscribe(ctx, "$share/relay/msh/ANZ/2/e/#", 0) }()
+
+	sub := b.read()
+	if sub.Type != mqtt5.TypeSubscribe {
+		t.Fatalf("got packet type %d, want SUBSCRIBE", sub.Type)
+	}
+	// packet ID, no properties, topic filter length and topic filter.
+	topicLen := binary.BigEndian.Uint16(sub.Body[3:5])
+	if got := string(sub.Body[5 : 5+topicLen]); got != "$share/relay/msh/ANZ/2/e/#" {
+		t.Errorf("SUBSCRIBE topic: got %q", got)
+	}
+	b.write(&mqtt5.Packet{Type: mqtt5.TypeSuback, Body: []byte{sub.Body[0], sub.Body[1], 0, 0}})
+	if err := <-subscribed; err != nil {
+		t.Fatalf("Subscribe() error: %v", err)
+	}
+
+	b.write(mqtt5.EncodePublish(&mqtt5.Publish{Topic: "msh/ANZ/2/e/MediumFast/!44be043f", Payload: []byte("data")}))
+	select {
+	case pub := <-received:
+		if pub.Topic != "msh/ANZ/2/e/MediumFast/!44be043f" || string(pub.Payload) != "data" {
+			t.Errorf("received: got %q %q", pub.Topic, pub.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	want := &mqtt5.Publish{
+		Topic:   "msh/ANZ/2/json/MediumFast/!44be043f",
+		Payload: []byte(`{"type":"TELEMETRY_APP"}`),
+		Retain:  true,
+		Properties: mqtt5.Properties{
+			ContentType:   "application/json",
+			MessageExpiry: time.Hour,
+			User: []mqtt5.UserProperty{
+				{Key: "portnum", Value: "TELEMETRY_APP"},
+				{Key: "from", Value: "!a0cbc3a8"},
+			},
+		},
+	}
+	if err := client.Publish(ctx, want); err != nil {
+		t.Fatalf("Publish() error: %v", err)
+	}
+
+	got, err := mqtt5.DecodePublish(b.read())
+	if err != nil {
+		t.Fatalf("DecodePublish() error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("published message mismatch (-want +got):\n%s", diff)
+	}
+
+	client.Disconnect()
+	if p := b.read(); p.Type != mqtt5.TypeDisconnect {
+		t.Errorf("got packet type %d, want DISCONNECT", p.Type)
+	}
+	if client.IsConnected() {
+		t.Error("IsConnected() after Disconnect(): got true, want false")
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	b := newFakeBroker(t, 0x87) // not authorized.
+
+	client := mqtt5.NewClient(mqtt5.Options{Address: b.address(), ClientID: "relay"})
+	t.Cleanup(client.Disconnect)
+
+	connected := make(chan error, 1)
+	go func() { connected <- client.Connect(t.Context()) }()
+	b.accept()
+
+	var reason *mqtt5.ReasonError
+	if err := <-connected; !errors.As(err, &reason) || reason.Code != 0x87 {
+		t.Errorf("Connect() error: got %v, want reason code 0x87", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	b := newFakeBroker(t, 0)
+
+	connects := make(chan struct{}, 2)
+	client := mqtt5.NewClient(mqtt5.Options{
+		Address:   b.address(),
+		ClientID:  "relay",
+		OnConnect: func() { connects <- struct{}{} },
+	})
+	t.Cleanup(client.Disconnect)
+
+	connected := make(chan error, 1)
+	go func() { connected <- client.Connect(context.Background()) }()
+	b.accept()
+	if err := <-connected; err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	<-connects
+
+	// drop the connection, the client should reconnect.
+	_ = b.conn.Close()
+	b.accept()
+
+	select {
+	case <-connects:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reconnect")
+	}
+}
+
+func TestPublishNotConnected(t *testing.T) {
+	client := mqtt5.NewClient(mqtt5.Options{Address: "tcp://127.0.0.1:1", ClientID: "relay"})
+	if err := client.Publish(t.Context(), &mqtt5.Publish{Topic: "test"}); !errors.Is(err, mqtt5.ErrNotConnected) {
+		t.Errorf("Publish() error: got %v, want %v", err, mqtt5.ErrNotConnected)
+	}
+	if err := client.Publish(t.Context(), &mqtt5.Publish{Topic: "test", QoS: 1}); !errors.Is(err, mqtt5.ErrUnsupportedQoS) {
+		t.Errorf("Publish() error: got %v, want %v", err, mqtt5.ErrUnsupportedQoS)
+	}
+}
@@ -0,0 +1,361 @@
+package mqtt5
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Packet types.
+const (
+	TypeConnect    byte = 1
+	TypeConnack    byte = 2
+	TypePublish    byte = 3
+	TypePuback     byte = 4
+	TypeSubscribe  byte = 8
+	TypeSuback     byte = 9
+	TypePingreq    byte = 12
+	TypePingresp   byte = 13
+	TypeDisconnect byte = 14
+)
+
+// Property identifiers.
+const (
+	propPayloadFormat byte = 0x01
+	propMessageExpiry byte = 0x02
+	propContentType   byte = 0x03
+	propUser          byte = 0x26
+)
+
+const (
+	protocolLevel = 5
+	// maxRemainingLength is the largest remaining length that can be encoded in a fixed header.
+	maxRemainingLength = 268435455
+)
+
+var (
+	// ErrMalformedPacket is returned when a packet can not be decoded.
+	ErrMalformedPacket = errors.New("malformed packet")
+	// ErrUnknownProperty is returned when a packet contains a property that can not be skipped.
+	ErrUnknownProperty = errors.New("unknown property")
+)
+
+// Packet is an MQTT control packet.
+type Packet struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+// ReadPacket reads a packet from r.
+func ReadPacket(r *bufio.Reader) (*Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read packet length: %w", err)
+	}
+	if length > maxRemainingLength {
+		return nil, fmt.Errorf("packet length %d: %w", length, ErrMalformedPacket)
+	}
+
+	p := &Packet{
+		Type:  header >> 4,   //nolint:mnd // packet type is the upper nibble.
+		Flags: header & 0x0f, //nolint:mnd // flags are the lower nibble.
+		Body:  make([]byte, length),
+	}
+	if _, err = io.ReadFull(r, p.Body); err != nil {
+		return nil, fmt.Errorf("unable to read packet: %w", err)
+	}
+
+	return p, nil
+}
+
+// Bytes returns the encoded packet.
+func (p *Packet) Bytes() []byte {
+	buf := make([]byte, 0, len(p.Body)+binary.MaxVarintLen32+1)
+	buf = append(buf, p.Type<<4|p.Flags) //nolint:mnd // packet type is the upper nibble.
+	buf = binary.AppendUvarint(buf, uint64(len(p.Body)))
+	return append(buf, p.Body...)
+}
+
+// UserProperty is an MQTT 5 user property, a key may appear more than once.
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// Properties are the MQTT 5 properties of a PUBLISH packet.
+type Properties struct {
+	// ContentType is the MIME type of the payload, e.g. application/json.
+	ContentType string
+	// MessageExpiry is the lifetime of the message, zero for no expiry.
+	MessageExpiry time.Duration
+	// User are the user properties.
+	User []UserProperty
+}
+
+// Publish is a PUBLISH packet.
+type Publish struct {
+	Topic      string
+	Payload    []byte
+	QoS        byte
+	Retain     bool
+	PacketID   uint16
+	Properties Properties
+}
+
+// EncodePublish encodes a PUBLISH packet.
+func EncodePublish(pub *Publish) *Packet {
+	var body bytes.Buffer
+	writeString(&body, pub.Topic)
+	if pub.QoS > 0 {
+		_ = binary.Write(&body, binary.BigEndian, pub.PacketID)
+	}
+
+	var props bytes.Buffer
+	if pub.Properties.ContentType != "" {
+		props.WriteByte(propContentType)
+		writeString(&props, pub.Properties.ContentType)
+	}
+	if pub.Properties.MessageExpiry > 0 {
+		props.WriteByte(propMessageExpiry)
+		_ = binary.Write(&props, binary.BigEndian, uint32(pub.Properties.MessageExpiry/time.Second))
+	}
+	for _, prop := range pub.Properties.User {
+		props.WriteByte(propUser)
+		writeString(&props, prop.Key)
+		writeString(&props, prop.Value)
+	}
+	writeProperties(&body, props.Bytes())
+
+	body.Write(pub.Payload)
+
+	flags := pub.QoS << 1
+	if pub.Retain {
+		flags |= 0x01
+	}
+
+	return &Packet{Type: TypePublish, Flags: flags, Body: body.Bytes()}
+}
+
+// DecodePublish decodes a PUBLISH packet.
+func DecodePublish(p *Packet) (*Publish, error) {
+	r := bytes.NewReader(p.Body)
+	pub := &Publish{
+		QoS:    (p.Flags >> 1) & 0x03, //nolint:mnd // QoS is bits 1 and 2.
+		Retain: p.Flags&0x01 == 1,
+	}
+
+	var err error
+	if pub.Topic, err = readString(r); err != nil {
+		return nil, err
+	}
+	if pub.QoS > 0 {
+		if err = binary.Read(r, binary.BigEndian, &pub.PacketID); err != nil {
+			return nil, ErrMalformedPacket
+		}
+	}
+
+	props, err := readProperties(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = decodePublishProperties(props, &pub.Properties); err != nil {
+		return nil, err
+	}
+
+	pub.Payload = make([]byte, r.Len())
+	_, _ = r.Read(pub.Payload)
+
+	return pub, nil
+}
+
+func decodePublishProperties(props []byte, out *Properties) error {
+	r := bytes.NewReader(props)
+	for r.Len() > 0 {
+		id, _ := r.ReadByte()
+		switch id {
+		case propPayloadFormat:
+			if _, err := r.ReadByte(); err != nil {
+				return ErrMalformedPacket
+			}
+		case propMessageExpiry:
+			var expiry uint32
+			if err := binary.Read(r, binary.BigEndian, &expiry); err != nil {
+				return ErrMalformedPacket
+			}
+			out.MessageExpiry = time.Duration(expiry) * time.Second
+		case propContentType:
+			v, err := readString(r)
+			if err != nil {
+				return err
+			}
+			out.ContentType = v
+		case propUser:
+			k, err := readString(r)
+			if err != nil {
+				return err
+			}
+			v, err := readString(r)
+			if err != nil {
+				return err
+			}
+			out.User = append(out.User, UserProperty{Key: k, Value: v})
+		default:
+			if err := skipProperty(r, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// skipProperty skips the value of a property that is not decoded.
+func skipProperty(r *bytes.Reader, id byte) error {
+	var n int
+	switch id {
+	case 0x17, 0x19, 0x24, 0x25, 0x28, 0x29, 0x2A: // byte
+		n = 1
+	case 0x13, 0x21, 0x22, 0x23: // two byte integer
+		n = 2
+	case 0x11, 0x18, 0x27: // four byte integer
+		n = 4
+	case 0x0B: // variable byte integer
+		_, err := binary.ReadUvarint(r)
+		return err
+	case 0x08, 0x09, 0x12, 0x15, 0x16, 0x1A, 0x1C, 0x1F: // string or binary data
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return ErrMalformedPacket
+		}
+		n = int(length)
+	default:
+		return fmt.Errorf("property 0x%02x: %w", id, ErrUnknownProperty)
+	}
+
+	if r.Len() < n {
+		return ErrMalformedPacket
+	}
+	_, _ = r.Seek(int64(n), io.SeekCurrent)
+
+	return nil
+}
+
+// encodeConnect encodes a CONNECT packet with clean start.
+func encodeConnect(clientID, username, password string, keepalive time.Duration) *Packet {
+	var body bytes.Buffer
+	writeString(&body, "MQTT")
+	body.WriteByte(protocolLevel)
+
+	flags := byte(0x02) // clean start.
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	body.WriteByte(flags)
+	_ = binary.Write(&body, binary.BigEndian, uint16(keepalive/time.Second))
+	writeProperties(&body, nil)
+
+	writeString(&body, clientID)
+	if username != "" {
+		writeString(&body, username)
+		if password != "" {
+			writeString(&body, password)
+		}
+	}
+
+	return &Packet{Type: TypeConnect, Body: body.Bytes()}
+}
+
+// decodeConnack returns the reason code of a CONNACK packet.
+func decodeConnack(p *Packet) (byte, error) {
+	if p.Type != TypeConnack || len(p.Body) < 2 { //nolint:mnd // flags and reason code.
+		return 0, ErrMalformedPacket
+	}
+
+	return p.Body[1], nil
+}
+
+// encodeSubscribe encodes a SUBSCRIBE packet for a single topic filter.
+func encodeSubscribe(packetID uint16, topic string, qos byte) *Packet {
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, packetID)
+	writeProperties(&body, nil)
+	writeString(&body, topic)
+	body.WriteByte(qos)
+
+	return &Packet{Type: TypeSubscribe, Flags: 0x02, Body: body.Bytes()}
+}
+
+// decodeSuback returns the packet ID and the reason code of the first topic filter of a SUBACK packet.
+func decodeSuback(p *Packet) (uint16, byte, error) {
+	r := bytes.NewReader(p.Body)
+
+	var packetID uint16
+	if err := binary.Read(r, binary.BigEndian, &packetID); err != nil {
+		return 0, 0, ErrMalformedPacket
+	}
+	if _, err := readProperties(r); err != nil {
+		return 0, 0, err
+	}
+
+	code, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, ErrMalformedPacket
+	}
+
+	return packetID, code, nil
+}
+
+// encodePuback encodes a PUBACK packet with success.
+func encodePuback(packetID uint16) *Packet {
+	return &Packet{Type: TypePuback, Body: binary.BigEndian.AppendUint16(nil, packetID)}
+}
+
+func writeString(w *bytes.Buffer, s string) {
+	_ = binary.Write(w, binary.BigEndian, uint16(len(s))) //nolint:gosec // strings are limited by the caller.
+	w.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", ErrMalformedPacket
+	}
+	if r.Len() < int(length) {
+		return "", ErrMalformedPacket
+	}
+
+	buf := make([]byte, length)
+	_, _ = r.Read(buf)
+
+	return string(buf), nil
+}
+
+func writeProperties(w *bytes.Buffer, props []byte) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(props))))
+	w.Write(props)
+}
+
+func readProperties(r *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil || uint64(r.Len()) < length {
+		return nil, ErrMalformedPacket
+	}
+
+	props := make([]byte, length)
+	_, _ = r.Read(props)
+
+	return props, nil
+}
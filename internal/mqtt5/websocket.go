@@ -0,0 +1,94 @@
+package mqtt5
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketSubprotocol is the WebSocket subprotocol MQTT is carried over.
+const websocketSubprotocol = "mqtt"
+
+// dialWebsocket opens a WebSocket connection to the broker, carrying MQTT packets in binary messages.
+func dialWebsocket(ctx context.Context, u *url.URL, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: timeout,
+		TLSClientConfig:  tlsConfig,
+		Subprotocols:     []string{websocketSubprotocol},
+	}
+
+	ws, resp, err := dialer.DialContext(ctx, u.String(), nil)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open WebSocket: %w", err)
+	}
+
+	return &websocketConn{Conn: ws}, nil
+}
+
+// websocketConn adapts a WebSocket connection to a net.Conn, an MQTT packet may span several binary messages and a
+// message may hold several packets.
+type websocketConn struct {
+	*websocket.Conn
+
+	readLock  sync.Mutex
+	reader    io.Reader
+	writeLock sync.Mutex
+}
+
+// Read reads from the current message, moving on to the next message when it is exhausted.
+func (c *websocketConn) Read(p []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for {
+		if c.reader == nil {
+			_, r, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+// Write writes p as a single binary message.
+func (c *websocketConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if err := c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *websocketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.SetWriteDeadline(t)
+}
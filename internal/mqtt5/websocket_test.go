@@ -0,0 +1,101 @@
+package mqtt5_test
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqtt5"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebsocket(t *testing.T) {
+	packets := make(chan *mqtt5.Packet, 2)
+	received := make(chan *mqtt5.Publish, 1)
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{"mqtt"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade() error: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		if conn.Subprotocol() != "mqtt" {
+			t.Errorf("Subprotocol(): got %q, want %q", conn.Subprotocol(), "mqtt")
+		}
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			p, err := mqtt5.ReadPacket(bufio.NewReader(bytes.NewReader(data)))
+			if err != nil {
+				t.Errorf("ReadPacket() error: %v", err)
+				return
+			}
+			packets <- p
+
+			if p.Type != mqtt5.TypeConnect {
+				continue
+			}
+
+			// CONNACK and a PUBLISH in one message, read by the client as two packets.
+			reply := (&mqtt5.Packet{Type: mqtt5.TypeConnack, Body: []byte{0, 0, 0}}).Bytes()
+			reply = append(reply, mqtt5.EncodePublish(&mqtt5.Publish{Topic: "msh/ANZ/2/map/", Payload: []byte("map")}).Bytes()...)
+			if err = conn.WriteMessage(websocket.BinaryMessage, reply); err != nil {
+				t.Errorf("WriteMessage() error: %v", err)
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := mqtt5.NewClient(mqtt5.Options{
+		Address:   "ws" + strings.TrimPrefix(srv.URL, "http") + "/mqtt",
+		ClientID:  "relay",
+		OnMessage: func(pub *mqtt5.Publish) { received <- pub },
+	})
+	t.Cleanup(client.Disconnect)
+
+	if err := client.Connect(t.Context()); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if p := <-packets; p.Type != mqtt5.TypeConnect {
+		t.Fatalf("first packet: got type %d, want CONNECT", p.Type)
+	}
+
+	select {
+	case pub := <-received:
+		if pub.Topic != "msh/ANZ/2/map/" || string(pub.Payload) != "map" {
+			t.Errorf("received: got %q %q", pub.Topic, pub.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	if err := client.Publish(t.Context(), &mqtt5.Publish{Topic: "msh/ANZ/2/json/map/", Payload: []byte("{}")}); err != nil {
+		t.Fatalf("Publish() error: %v", err)
+	}
+
+	select {
+	case p := <-packets:
+		pub, err := mqtt5.DecodePublish(p)
+		if err != nil {
+			t.Fatalf("DecodePublish() error: %v", err)
+		}
+		if pub.Topic != "msh/ANZ/2/json/map/" {
+			t.Errorf("published topic: got %q", pub.Topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for PUBLISH")
+	}
+}
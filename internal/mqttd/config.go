@@ -0,0 +1,34 @@
+package mqttd
+
+import "time"
+
+const (
+	// DefaultAddress is the address the broker listens on for MQTT clients.
+	DefaultAddress = ":1883"
+	// DefaultQueueSize is the number of messages queued for a client before messages to it are dropped.
+	DefaultQueueSize = 1000
+	// DefaultMaxPacketSize is the largest packet accepted from a client.
+	DefaultMaxPacketSize = 1 << 20
+	// DefaultConnectTimeout is the time allowed for a client to send CONNECT after connecting.
+	DefaultConnectTimeout = 10 * time.Second
+)
+
+// Config holds the embedded broker configuration.
+type Config struct {
+	// Address is the address MQTT clients connect to, e.g. :1883, empty to only accept TLS and in-process clients.
+	Address string
+	// TLSAddress is the address MQTT clients connect to over TLS, e.g. :8883, optional.
+	TLSAddress string
+	// CertFile and KeyFile are the certificate and key of the TLS listener.
+	CertFile string
+	KeyFile  string
+	// Users are the usernames and passwords clients authenticate with, anonymous clients are accepted when empty.
+	// In-process clients are not authenticated.
+	Users map[string]string
+	// QueueSize is the number of messages queued for a client before messages to it are dropped.
+	QueueSize int
+	// MaxPacketSize is the largest packet accepted from a client, larger packets close the connection.
+	MaxPacketSize int
+	// ConnectTimeout is the time allowed for a client to send CONNECT after connecting.
+	ConnectTimeout time.Duration
+}
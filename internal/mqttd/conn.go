@@ -0,0 +1,283 @@
+package mqttd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqtt5"
+
+	"github.com/na4ma4/go-slogtool"
+)
+
+const (
+	// writeTimeout is the time allowed to write a packet to a client.
+	writeTimeout = 10 * time.Second
+	// keepaliveGrace is the factor of the keepalive interval after which a silent client is disconnected.
+	keepaliveGrace = 3 / 2.0
+)
+
+// errProtocol is returned when a client sends a packet it is not allowed to send.
+var errProtocol = errors.New("protocol violation")
+
+// conn is the network connection of a client.
+type conn struct {
+	net.Conn
+
+	version   byte
+	keepalive time.Duration
+	writeLock sync.Mutex
+}
+
+// write writes the packet to the client.
+func (c *conn) write(p *mqtt5.Packet) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	_ = c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.Write(p.Bytes())
+
+	return err
+}
+
+// serve handles a network client until it disconnects, the will message of the client is published if it
+// disconnects without sending DISCONNECT.
+func (s *Server) serve(ctx context.Context, nc net.Conn) {
+	defer nc.Close()
+
+	logger := s.Logger.With(slog.String("remote_addr", nc.RemoteAddr().String()))
+	c := &conn{Conn: nc}
+	r := bufio.NewReader(nc)
+
+	_ = nc.SetReadDeadline(time.Now().Add(s.Config.ConnectTimeout))
+	p, err := readPacket(r, s.Config.MaxPacketSize)
+	if err != nil || p.Type != mqtt5.TypeConnect {
+		logger.DebugContext(ctx, "Embedded broker client did not connect", slogtool.ErrorAttr(err))
+		return
+	}
+
+	sess, will, err := s.connect(c, p)
+	if err != nil {
+		logger.WarnContext(ctx, "Embedded broker client refused", slogtool.ErrorAttr(err))
+		return
+	}
+	logger = logger.With(slog.String("client_id", sess.clientID))
+	logger.DebugContext(ctx, "Embedded broker client connected")
+
+	go func() {
+		for {
+			select {
+			case <-sess.done:
+				return
+			case msg := <-sess.queue:
+				if writeErr := c.write(encodePublish(c.version, msg)); writeErr != nil {
+					sess.close()
+					return
+				}
+			}
+		}
+	}()
+
+	err = s.read(sess, c, r)
+	s.unregister(sess)
+
+	if dropped := sess.dropped.Load(); dropped > 0 {
+		logger.WarnContext(ctx, "Embedded broker client fell behind, dropped messages", slog.Uint64("dropped", dropped))
+	}
+
+	if err == nil {
+		logger.DebugContext(ctx, "Embedded broker client disconnected")
+		return
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		logger.DebugContext(ctx, "Embedded broker client connection lost", slogtool.ErrorAttr(err))
+	}
+
+	if will != nil {
+		if err = s.publish(will); err != nil {
+			logger.WarnContext(ctx, "Failed to publish will message", slogtool.ErrorAttr(err))
+		}
+	}
+}
+
+// connect authenticates the client and registers its session, returning the session and the will message of the
+// client, if any.
+func (s *Server) connect(c *conn, p *mqtt5.Packet) (*session, *broker.Message, error) {
+	req, err := decodeConnect(p)
+	if errors.Is(err, errUnsupportedProtocol) {
+		code := connackBadProtocolV311
+		if req.Version == protocolV5 {
+			code = connackBadProtocolV5
+		}
+		_ = c.write(encodeConnack(protocolV311, code, ""))
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	c.version = req.Version
+	c.keepalive = time.Duration(float64(req.Keepalive) * keepaliveGrace)
+
+	if !s.authenticate(req.Username, req.Password) {
+		code := connackBadCredentialsV311
+		if c.version == protocolV5 {
+			code = connackBadCredentialsV5
+		}
+		_ = c.write(encodeConnack(c.version, code, ""))
+		return nil, nil, fmt.Errorf("bad username or password for '%s'", req.Username)
+	}
+
+	var assignedID string
+	if req.ClientID == "" {
+		// MQTT 3.1.1 only allows an empty client ID with a clean session.
+		if c.version == protocolV311 && !req.CleanStart {
+			_ = c.write(encodeConnack(c.version, connackBadClientIDV311, ""))
+			return nil, nil, errors.New("empty client ID without a clean session")
+		}
+		req.ClientID = "mqttd-" + strconv.FormatUint(s.nextID.Add(1), 10)
+		assignedID = req.ClientID
+	}
+
+	if req.Will != nil && !ValidTopic(req.Will.Topic) {
+		return nil, nil, fmt.Errorf("%w: will %w: %s", errProtocol, ErrInvalidTopic, req.Will.Topic)
+	}
+
+	sess := newSession(req.ClientID, s.Config.QueueSize)
+	sess.onClose = func() { _ = c.Close() }
+	if err = s.register(sess); err != nil {
+		return nil, nil, err
+	}
+
+	if err = c.write(encodeConnack(c.version, connackAccepted, assignedID)); err != nil {
+		s.unregister(sess)
+		return nil, nil, err
+	}
+
+	return sess, req.Will, nil
+}
+
+// read handles the packets from the client until it disconnects, returning nil if it sent DISCONNECT.
+func (s *Server) read(sess *session, c *conn, r *bufio.Reader) error {
+	// Packet IDs of QoS 2 messages received and not yet released, so a resent message is not delivered twice.
+	received := make(map[uint16]struct{})
+
+	for {
+		if c.keepalive > 0 {
+			_ = c.SetReadDeadline(time.Now().Add(c.keepalive))
+		} else {
+			_ = c.SetReadDeadline(time.Time{})
+		}
+
+		p, err := readPacket(r, s.Config.MaxPacketSize)
+		if err != nil {
+			return err
+		}
+
+		switch p.Type {
+		case mqtt5.TypePublish:
+			err = s.handlePublish(c, p, received)
+		case typePubrel:
+			var packetID uint16
+			if packetID, err = decodePacketID(p); err == nil {
+				delete(received, packetID)
+				err = c.write(encodeAck(typePubcomp, packetID))
+			}
+		case mqtt5.TypeSubscribe:
+			err = s.handleSubscribe(sess, c, p)
+		case typeUnsubscribe:
+			err = s.handleUnsubscribe(sess, c, p)
+		case mqtt5.TypePingreq:
+			err = c.write(&mqtt5.Packet{Type: mqtt5.TypePingresp})
+		case mqtt5.TypeDisconnect:
+			return nil
+		default:
+			err = fmt.Errorf("%w: packet type %d", errProtocol, p.Type)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handlePublish publishes a message from the client, acknowledging QoS 1 and QoS 2 messages.
+func (s *Server) handlePublish(c *conn, p *mqtt5.Packet, received map[uint16]struct{}) error {
+	msg, qos, packetID, err := decodePublish(c.version, p)
+	if err != nil {
+		return err
+	}
+
+	switch qos {
+	case 0:
+		return s.publish(msg)
+	case 1:
+		if err = s.publish(msg); err != nil {
+			return err
+		}
+		return c.write(encodeAck(mqtt5.TypePuback, packetID))
+	case 2: //nolint:mnd // QoS 2.
+		if _, ok := received[packetID]; !ok {
+			if err = s.publish(msg); err != nil {
+				return err
+			}
+			received[packetID] = struct{}{}
+		}
+		return c.write(encodeAck(typePubrec, packetID))
+	default:
+		return fmt.Errorf("%w: QoS %d", errProtocol, qos)
+	}
+}
+
+// handleSubscribe subscribes the client to each topic filter, messages are delivered at QoS 0.
+func (s *Server) handleSubscribe(sess *session, c *conn, p *mqtt5.Packet) error {
+	packetID, filters, err := decodeSubscribe(c.version, p)
+	if err != nil {
+		return err
+	}
+
+	codes := make([]byte, len(filters))
+	var retained []*broker.Message
+	for i, filter := range filters {
+		msgs, subErr := s.subscribe(sess, filter)
+		if subErr != nil {
+			codes[i] = subackFailure
+			if c.version == protocolV5 {
+				codes[i] = subackBadFilterV5
+			}
+			continue
+		}
+		retained = append(retained, msgs...)
+	}
+
+	if err = c.write(encodeSuback(c.version, packetID, codes)); err != nil {
+		return err
+	}
+
+	for _, msg := range retained {
+		sess.deliver(msg)
+	}
+
+	return nil
+}
+
+// handleUnsubscribe unsubscribes the client from each topic filter.
+func (s *Server) handleUnsubscribe(sess *session, c *conn, p *mqtt5.Packet) error {
+	packetID, filters, err := decodeSubscribe(c.version, p)
+	if err != nil {
+		return err
+	}
+
+	for _, filter := range filters {
+		s.unsubscribe(sess, filter)
+	}
+
+	return c.write(encodeUnsuback(c.version, packetID, len(filters)))
+}
@@ -0,0 +1,143 @@
+package mqttd
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+)
+
+// dropReportInterval is the interval at which messages dropped for an in-process client are logged.
+const dropReportInterval = 10 * time.Second
+
+// NewClient returns an in-process client of the server, messages are passed to it without a network hop.
+//
+// Messages are passed to hooks.OnMessage one at a time in the order they are received, or concurrently if
+// hooks.Concurrent is set. Messages are dropped while the queue of the client is full and the number dropped is
+// logged, the client is not blocked on instead as messages are queued with the broker locked and the in-process
+// consumer publishes back to the broker.
+func (s *Server) NewClient(clientID string, hooks broker.Hooks) broker.Client {
+	return &localClient{
+		server:   s,
+		clientID: clientID,
+		hooks:    hooks,
+	}
+}
+
+// Address returns broker.LocalAddress, in-process clients do not connect to a network address.
+func (s *Server) Address() string {
+	return broker.LocalAddress
+}
+
+// localClient is an in-process client, it does not reconnect once disconnected.
+type localClient struct {
+	server   *Server
+	clientID string
+	hooks    broker.Hooks
+
+	lock sync.Mutex
+	sess *session
+}
+
+func (c *localClient) Connect(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess := newSession(c.clientID, c.server.Config.QueueSize)
+	if err := c.server.register(sess); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.sess = sess
+	c.lock.Unlock()
+
+	go c.receive(sess)
+
+	if c.hooks.OnConnect != nil {
+		go c.hooks.OnConnect(c)
+	}
+
+	return nil
+}
+
+// receive passes queued messages to the hooks until the session ends, logging the messages dropped since the last
+// report every dropReportInterval and when the session ends.
+func (c *localClient) receive(sess *session) {
+	ticker := time.NewTicker(dropReportInterval)
+	defer ticker.Stop()
+
+	var reported uint64
+	report := func() {
+		if dropped := sess.dropped.Load(); dropped > reported {
+			c.server.Logger.Warn("Embedded broker in-process client fell behind, dropped messages",
+				slog.String("client_id", c.clientID),
+				slog.Uint64("dropped", dropped-reported),
+			)
+			reported = dropped
+		}
+	}
+	defer report()
+
+	for {
+		select {
+		case <-sess.done:
+			return
+		case <-ticker.C:
+			report()
+		case msg := <-sess.queue:
+			c.hooks.Deliver(msg)
+		}
+	}
+}
+
+// session returns the session of the client, or nil if it is not connected.
+func (c *localClient) session() *session {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.sess == nil || c.sess.closed() {
+		return nil
+	}
+
+	return c.sess
+}
+
+func (c *localClient) IsConnected() bool {
+	return c.session() != nil
+}
+
+func (c *localClient) Subscribe(_ context.Context, topic string) error {
+	sess := c.session()
+	if sess == nil {
+		return ErrNotConnected
+	}
+
+	retained, err := c.server.subscribe(sess, topic)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range retained {
+		sess.deliver(msg)
+	}
+
+	return nil
+}
+
+func (c *localClient) Publish(_ context.Context, msg *broker.Message) error {
+	if c.session() == nil {
+		return ErrNotConnected
+	}
+
+	return c.server.publish(msg)
+}
+
+func (c *localClient) Disconnect() {
+	if sess := c.session(); sess != nil {
+		c.server.unregister(sess)
+	}
+}
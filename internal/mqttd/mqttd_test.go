@@ -0,0 +1,370 @@
+package mqttd_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqttd"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"msh/ANZ/2/e/#", "msh/ANZ/2/e/LongFast/!44be043f", true},
+		{"msh/ANZ/2/e/#", "msh/ANZ/2/e", true},
+		{"msh/ANZ/2/e/#", "msh/ANZ/2/json/LongFast/!44be043f", false},
+		{"msh/+/2/e/+/!44be043f", "msh/ANZ/2/e/LongFast/!44be043f", true},
+		{"msh/+/2/e/+", "msh/ANZ/2/e/LongFast/!44be043f", false},
+		{"msh/ANZ", "msh/ANZ", true},
+		{"msh/ANZ", "msh/ANZ/2", false},
+		{"#", "msh/ANZ", true},
+		{"#", "$SYS/broker", false},
+		{"+/broker", "$SYS/broker", false},
+		{"$SYS/#", "$SYS/broker", true},
+	}
+
+	for _, tt := range tests {
+		if got := mqttd.Match(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("Match(%s, %s): got '%t', want '%t'", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestValidFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"msh/ANZ/2/e/#", true},
+		{"msh/+/2/e/+", true},
+		{"$share/relay/msh/ANZ/2/e/#", true},
+		{"", false},
+		{"msh/#/e", false},
+		{"msh/ANZ#", false},
+		{"msh/AN+/2", false},
+	}
+
+	for _, tt := range tests {
+		if got := mqttd.ValidFilter(tt.filter); got != tt.want {
+			t.Errorf("ValidFilter(%s): got '%t', want '%t'", tt.filter, got, tt.want)
+		}
+	}
+}
+
+// start starts a server listening on a random port, returning it and its address.
+func start(t *testing.T, config mqttd.Config) (*mqttd.Server, string) {
+	t.Helper()
+
+	config.Address = "127.0.0.1:0"
+	s := mqttd.New(config, slog.New(slog.DiscardHandler))
+	if err := s.Start(t.Context()); err != nil {
+		t.Fatalf("Start(): error got '%v', want 'nil'", err)
+	}
+	t.Cleanup(s.Stop)
+
+	return s, "tcp://" + s.Addrs()[0].String()
+}
+
+// receiver collects the messages received by a client.
+type receiver struct {
+	lock sync.Mutex
+	msgs []*broker.Message
+}
+
+func (r *receiver) handle(msg *broker.Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.msgs = append(r.msgs, msg)
+}
+
+// wait waits for n messages, returning their topics and payloads.
+func (r *receiver) wait(t *testing.T, n int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.lock.Lock()
+		if len(r.msgs) >= n {
+			var out []string
+			for _, msg := range r.msgs {
+				out = append(out, msg.Topic+" "+string(msg.Payload))
+			}
+			r.lock.Unlock()
+			return out
+		}
+		r.lock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %d messages", n)
+	return nil
+}
+
+// connect connects a client to the broker, subscribing to the topics.
+func connect(t *testing.T, cfg broker.Config, r *receiver, topics ...string) broker.Client {
+	t.Helper()
+
+	hooks := broker.Hooks{}
+	if r != nil {
+		hooks.OnMessage = r.handle
+	}
+
+	client, err := cfg.NewClient(hooks)
+	if err != nil {
+		t.Fatalf("NewClient(): error got '%v', want 'nil'", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	if err = client.Connect(ctx); err != nil {
+		t.Fatalf("Connect(): error got '%v', want 'nil'", err)
+	}
+	t.Cleanup(client.Disconnect)
+
+	for _, topic := range topics {
+		if err = client.Subscribe(ctx, topic); err != nil {
+			t.Fatalf("Subscribe(%s): error got '%v', want 'nil'", topic, err)
+		}
+	}
+
+	return client
+}
+
+func TestServer(t *testing.T) {
+	s, address := start(t, mqttd.Config{})
+
+	// The relay consumes in-process, external subscribers read the JSON topics over MQTT 3.1.1 and MQTT 5.
+	relay := &receiver{}
+	local := connect(t, broker.Config{ClientID: "relay"}.WithLocal(s), relay, "msh/ANZ/2/e/#")
+
+	v311 := &receiver{}
+	connect(t, broker.Config{Address: address, ClientID: "v311"}, v311, "msh/ANZ/2/json/#")
+
+	v5 := &receiver{}
+	connect(t, broker.Config{Address: address, ClientID: "v5", Protocol: broker.ProtocolV5}, v5, "msh/ANZ/2/json/#")
+
+	gateway := connect(t, broker.Config{Address: address, ClientID: "gateway"}, nil)
+	if err := gateway.Publish(t.Context(), &broker.Message{
+		Topic:   "msh/ANZ/2/e/LongFast/!44be043f",
+		Payload: []byte("envelope"),
+	}); err != nil {
+		t.Fatalf("Publish(): error got '%v', want 'nil'", err)
+	}
+
+	if diff := cmp.Diff([]string{"msh/ANZ/2/e/LongFast/!44be043f envelope"}, relay.wait(t, 1)); diff != "" {
+		t.Errorf("in-process client mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := local.Publish(t.Context(), &broker.Message{
+		Topic:      "msh/ANZ/2/json/LongFast/!44be043f",
+		Payload:    []byte("{}"),
+		Properties: broker.Properties{ContentType: "application/json"},
+	}); err != nil {
+		t.Fatalf("Publish(): error got '%v', want 'nil'", err)
+	}
+
+	want := []string{"msh/ANZ/2/json/LongFast/!44be043f {}"}
+	if diff := cmp.Diff(want, v311.wait(t, 1)); diff != "" {
+		t.Errorf("MQTT 3.1.1 client mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, v5.wait(t, 1)); diff != "" {
+		t.Errorf("MQTT 5 client mismatch (-want +got):\n%s", diff)
+	}
+	if got := v5.msgs[0].Properties.ContentType; got != "application/json" {
+		t.Errorf("MQTT 5 content type: got '%s', want 'application/json'", got)
+	}
+
+	local.Disconnect()
+	if local.IsConnected() {
+		t.Error("IsConnected() after Disconnect(): got 'true', want 'false'")
+	}
+	if err := local.Publish(t.Context(), &broker.Message{Topic: "msh/ANZ"}); !errors.Is(err, mqttd.ErrNotConnected) {
+		t.Errorf("Publish() after Disconnect(): error got '%v', want '%v'", err, mqttd.ErrNotConnected)
+	}
+}
+
+func TestRetained(t *testing.T) {
+	s, address := start(t, mqttd.Config{})
+
+	local := connect(t, broker.Config{ClientID: "relay"}.WithLocal(s), nil)
+	for _, msg := range []*broker.Message{
+		{Topic: "homeassistant/sensor/a/config", Payload: []byte("a"), Retain: true},
+		{Topic: "homeassistant/sensor/b/config", Payload: []byte("b"), Retain: true},
+		{Topic: "homeassistant/sensor/b/config", Retain: true},
+		{Topic: "homeassistant/sensor/c/config", Payload: []byte("c")},
+	} {
+		if err := local.Publish(t.Context(), msg); err != nil {
+			t.Fatalf("Publish(): error got '%v', want 'nil'", err)
+		}
+	}
+
+	r := &receiver{}
+	connect(t, broker.Config{Address: address, ClientID: "homeassistant"}, r, "homeassistant/#")
+
+	if diff := cmp.Diff([]string{"homeassistant/sensor/a/config a"}, r.wait(t, 1)); diff != "" {
+		t.Errorf("retained mismatch (-want +got):\n%s", diff)
+	}
+	if !r.msgs[0].Retain {
+		t.Error("retained message: got retain 'false', want 'true'")
+	}
+}
+
+func TestSharedSubscription(t *testing.T) {
+	s, _ := start(t, mqttd.Config{})
+
+	a, b := &receiver{}, &receiver{}
+	connect(t, broker.Config{ClientID: "a"}.WithLocal(s), a, broker.SharedTopic("relay", "msh/#"))
+	connect(t, broker.Config{ClientID: "b"}.WithLocal(s), b, broker.SharedTopic("relay", "msh/#"))
+
+	gateway := connect(t, broker.Config{ClientID: "gateway"}.WithLocal(s), nil)
+	for range 4 {
+		if err := gateway.Publish(t.Context(), &broker.Message{Topic: "msh/ANZ", Payload: []byte("x")}); err != nil {
+			t.Fatalf("Publish(): error got '%v', want 'nil'", err)
+		}
+	}
+
+	if got := len(a.wait(t, 2)) + len(b.wait(t, 2)); got != 4 {
+		t.Errorf("shared subscription: got '%d' messages, want '4'", got)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	_, address := start(t, mqttd.Config{Users: map[string]string{"gateway": "secret"}})
+
+	for _, tt := range []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"valid", "secret", true},
+		{"invalid", "wrong", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := broker.Config{
+				Address:  address,
+				ClientID: tt.name,
+				Username: "gateway",
+				Password: tt.password,
+			}.NewClient(broker.Hooks{})
+			if err != nil {
+				t.Fatalf("NewClient(): error got '%v', want 'nil'", err)
+			}
+			defer client.Disconnect()
+
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+
+			if err = client.Connect(ctx); (err == nil) != tt.ok {
+				t.Errorf("Connect(): error got '%v', want ok '%t'", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestWill(t *testing.T) {
+	s, address := start(t, mqttd.Config{})
+
+	r := &receiver{}
+	connect(t, broker.Config{ClientID: "relay"}.WithLocal(s), r, "msh/ANZ/2/stat/#")
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(address, "tcp://"))
+	if err != nil {
+		t.Fatalf("Dial(): %v", err)
+	}
+
+	// CONNECT, MQTT 3.1.1 with a clean session, a will and no keepalive.
+	str := func(s string) []byte { return append([]byte{0, byte(len(s))}, s...) }
+	body := slices.Concat(str("MQTT"), []byte{0x04, 0x06, 0x00, 0x00},
+		str("node"), str("msh/ANZ/2/stat/!44be043f"), str("offline"),
+	)
+	connectPacket := append([]byte{0x10, byte(len(body))}, body...)
+	if _, err = conn.Write(connectPacket); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+
+	connack := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Read(connack); err != nil || connack[3] != 0 {
+		t.Fatalf("CONNACK: got '%x', error '%v'", connack, err)
+	}
+
+	// Closing without DISCONNECT publishes the will.
+	_ = conn.Close()
+
+	if diff := cmp.Diff([]string{"msh/ANZ/2/stat/!44be043f offline"}, r.wait(t, 1)); diff != "" {
+		t.Errorf("will mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// logBuffer collects log output written from several goroutines.
+type logBuffer struct {
+	lock sync.Mutex
+	buf  strings.Builder
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.String()
+}
+
+func TestLocalClientDropped(t *testing.T) {
+	logs := &logBuffer{}
+	s := mqttd.New(mqttd.Config{QueueSize: 1}, slog.New(slog.NewTextHandler(logs, nil)))
+
+	// The relay is stalled handling the first message, so the queue fills and later messages are dropped.
+	release := make(chan struct{})
+	relay, err := broker.Config{ClientID: "relay"}.WithLocal(s).NewClient(broker.Hooks{
+		OnMessage: func(*broker.Message) { <-release },
+	})
+	if err != nil {
+		t.Fatalf("NewClient(): error got '%v', want 'nil'", err)
+	}
+	if err = relay.Connect(t.Context()); err != nil {
+		t.Fatalf("Connect(): error got '%v', want 'nil'", err)
+	}
+	if err = relay.Subscribe(t.Context(), "msh/#"); err != nil {
+		t.Fatalf("Subscribe(): error got '%v', want 'nil'", err)
+	}
+
+	gateway := connect(t, broker.Config{ClientID: "gateway"}.WithLocal(s), nil)
+	for range 5 {
+		if err = gateway.Publish(t.Context(), &broker.Message{Topic: "msh/ANZ", Payload: []byte("x")}); err != nil {
+			t.Fatalf("Publish(): error got '%v', want 'nil'", err)
+		}
+	}
+
+	relay.Disconnect()
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "dropped messages") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := logs.String(); !strings.Contains(got, "client_id=relay") || !strings.Contains(got, "dropped=") {
+		t.Errorf("log: got '%s', want dropped messages of client 'relay'", got)
+	}
+}
@@ -0,0 +1,356 @@
+package mqttd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqtt5"
+)
+
+// Protocol levels.
+const (
+	protocolV311 byte = 4
+	protocolV5   byte = 5
+)
+
+// Packet types not sent or received by the mqtt5 client.
+const (
+	typePubrec      byte = 5
+	typePubrel      byte = 6
+	typePubcomp     byte = 7
+	typeUnsubscribe byte = 10
+	typeUnsuback    byte = 11
+)
+
+// CONNACK return codes, MQTT 3.1.1 and MQTT 5 reason codes differ.
+const (
+	connackAccepted           byte = 0x00
+	connackBadProtocolV311    byte = 0x01
+	connackBadClientIDV311    byte = 0x02
+	connackBadCredentialsV311 byte = 0x04
+	connackBadProtocolV5      byte = 0x84
+	connackBadClientIDV5      byte = 0x85
+	connackBadCredentialsV5   byte = 0x86
+)
+
+const (
+	// subackFailure is the SUBACK return code for a topic filter that was not subscribed to.
+	subackFailure byte = 0x80
+	// subackBadFilterV5 is the SUBACK reason code for an invalid topic filter.
+	subackBadFilterV5 byte = 0x8f
+	// propAssignedClientID is the MQTT 5 property returning the client ID assigned by the broker.
+	propAssignedClientID byte = 0x12
+)
+
+var (
+	// ErrPacketTooLarge is returned when a client sends a packet larger than MaxPacketSize.
+	ErrPacketTooLarge = errors.New("packet too large")
+	// errUnsupportedProtocol is returned for a CONNECT packet that is not MQTT 3.1.1 or MQTT 5.
+	errUnsupportedProtocol = errors.New("unsupported protocol")
+)
+
+// readPacket reads a packet from r, rejecting packets larger than maxSize before reading them.
+func readPacket(r *bufio.Reader, maxSize int) (*mqtt5.Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read packet length: %w", err)
+	}
+	if length > uint64(maxSize) { //nolint:gosec // maxSize is positive.
+		return nil, fmt.Errorf("%w: %d bytes", ErrPacketTooLarge, length)
+	}
+
+	p := &mqtt5.Packet{
+		Type:  header >> 4,   //nolint:mnd // packet type is the upper nibble.
+		Flags: header & 0x0f, //nolint:mnd // flags are the lower nibble.
+		Body:  make([]byte, length),
+	}
+	if _, err = io.ReadFull(r, p.Body); err != nil {
+		return nil, fmt.Errorf("unable to read packet: %w", err)
+	}
+
+	return p, nil
+}
+
+// connect is a decoded CONNECT packet.
+type connect struct {
+	Version     byte
+	ClientID    string
+	Username    string
+	Password    string
+	HasUsername bool
+	CleanStart  bool
+	Keepalive   time.Duration
+	// Will is published if the client disconnects without sending DISCONNECT, optional.
+	Will *broker.Message
+}
+
+// decodeConnect decodes a CONNECT packet, returning errUnsupportedProtocol with the version set if it is not MQTT
+// 3.1.1 or MQTT 5.
+func decodeConnect(p *mqtt5.Packet) (*connect, error) {
+	r := bytes.NewReader(p.Body)
+	c := &connect{}
+
+	name, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	if c.Version, err = r.ReadByte(); err != nil {
+		return nil, mqtt5.ErrMalformedPacket
+	}
+	if name != "MQTT" || (c.Version != protocolV311 && c.Version != protocolV5) {
+		return c, fmt.Errorf("%w: %s level %d", errUnsupportedProtocol, name, c.Version)
+	}
+
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, mqtt5.ErrMalformedPacket
+	}
+	c.CleanStart = flags&0x02 != 0
+
+	var keepalive uint16
+	if err = binary.Read(r, binary.BigEndian, &keepalive); err != nil {
+		return nil, mqtt5.ErrMalformedPacket
+	}
+	c.Keepalive = time.Duration(keepalive) * time.Second
+
+	if err = skipProperties(c.Version, r); err != nil {
+		return nil, err
+	}
+
+	if c.ClientID, err = readString(r); err != nil {
+		return nil, err
+	}
+
+	if flags&0x04 != 0 {
+		c.Will = &broker.Message{Retain: flags&0x20 != 0}
+		if err = skipProperties(c.Version, r); err != nil {
+			return nil, err
+		}
+		if c.Will.Topic, err = readString(r); err != nil {
+			return nil, err
+		}
+		var payload string
+		if payload, err = readString(r); err != nil {
+			return nil, err
+		}
+		c.Will.Payload = []byte(payload)
+	}
+
+	if flags&0x80 != 0 {
+		c.HasUsername = true
+		if c.Username, err = readString(r); err != nil {
+			return nil, err
+		}
+	}
+	if flags&0x40 != 0 {
+		if c.Password, err = readString(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// encodeConnack encodes a CONNACK packet, assignedID is returned to MQTT 5 clients that connected without a client
+// ID.
+func encodeConnack(version, code byte, assignedID string) *mqtt5.Packet {
+	var body bytes.Buffer
+	body.WriteByte(0) // no session present.
+	body.WriteByte(code)
+
+	if version == protocolV5 {
+		var props bytes.Buffer
+		if assignedID != "" {
+			props.WriteByte(propAssignedClientID)
+			writeString(&props, assignedID)
+		}
+		writeProperties(&body, props.Bytes())
+	}
+
+	return &mqtt5.Packet{Type: mqtt5.TypeConnack, Body: body.Bytes()}
+}
+
+// decodePublish decodes a PUBLISH packet, returning the message, its QoS and packet ID.
+func decodePublish(version byte, p *mqtt5.Packet) (*broker.Message, byte, uint16, error) {
+	if version == protocolV5 {
+		pub, err := mqtt5.DecodePublish(p)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		return &broker.Message{
+			Topic:      pub.Topic,
+			Payload:    pub.Payload,
+			Retain:     pub.Retain,
+			Properties: pub.Properties,
+		}, pub.QoS, pub.PacketID, nil
+	}
+
+	r := bytes.NewReader(p.Body)
+	qos := (p.Flags >> 1) & 0x03 //nolint:mnd // QoS is bits 1 and 2.
+	msg := &broker.Message{Retain: p.Flags&0x01 == 1}
+
+	var err error
+	if msg.Topic, err = readString(r); err != nil {
+		return nil, 0, 0, err
+	}
+
+	var packetID uint16
+	if qos > 0 {
+		if err = binary.Read(r, binary.BigEndian, &packetID); err != nil {
+			return nil, 0, 0, mqtt5.ErrMalformedPacket
+		}
+	}
+
+	msg.Payload = make([]byte, r.Len())
+	_, _ = r.Read(msg.Payload)
+
+	return msg, qos, packetID, nil
+}
+
+// encodePublish encodes a QoS 0 PUBLISH packet, properties are only sent to MQTT 5 clients.
+func encodePublish(version byte, msg *broker.Message) *mqtt5.Packet {
+	if version == protocolV5 {
+		return mqtt5.EncodePublish(&mqtt5.Publish{
+			Topic:      msg.Topic,
+			Payload:    msg.Payload,
+			Retain:     msg.Retain,
+			Properties: msg.Properties,
+		})
+	}
+
+	var body bytes.Buffer
+	writeString(&body, msg.Topic)
+	body.Write(msg.Payload)
+
+	var flags byte
+	if msg.Retain {
+		flags |= 0x01
+	}
+
+	return &mqtt5.Packet{Type: mqtt5.TypePublish, Flags: flags, Body: body.Bytes()}
+}
+
+// decodeSubscribe decodes a SUBSCRIBE or UNSUBSCRIBE packet, returning the packet ID and topic filters.
+func decodeSubscribe(version byte, p *mqtt5.Packet) (uint16, []string, error) {
+	r := bytes.NewReader(p.Body)
+
+	var packetID uint16
+	if err := binary.Read(r, binary.BigEndian, &packetID); err != nil {
+		return 0, nil, mqtt5.ErrMalformedPacket
+	}
+	if err := skipProperties(version, r); err != nil {
+		return 0, nil, err
+	}
+
+	var filters []string
+	for r.Len() > 0 {
+		filter, err := readString(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		filters = append(filters, filter)
+
+		// SUBSCRIBE has the requested QoS, or the subscription options for MQTT 5, after each filter.
+		if p.Type == mqtt5.TypeSubscribe {
+			if _, err = r.ReadByte(); err != nil {
+				return 0, nil, mqtt5.ErrMalformedPacket
+			}
+		}
+	}
+	if len(filters) == 0 {
+		return 0, nil, mqtt5.ErrMalformedPacket
+	}
+
+	return packetID, filters, nil
+}
+
+// encodeSuback encodes a SUBACK packet with a return code for each topic filter.
+func encodeSuback(version byte, packetID uint16, codes []byte) *mqtt5.Packet {
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, packetID)
+	if version == protocolV5 {
+		writeProperties(&body, nil)
+	}
+	body.Write(codes)
+
+	return &mqtt5.Packet{Type: mqtt5.TypeSuback, Body: body.Bytes()}
+}
+
+// encodeUnsuback encodes an UNSUBACK packet, MQTT 5 clients receive a success reason code for each of the n topic
+// filters.
+func encodeUnsuback(version byte, packetID uint16, n int) *mqtt5.Packet {
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, packetID)
+	if version == protocolV5 {
+		writeProperties(&body, nil)
+		body.Write(make([]byte, n))
+	}
+
+	return &mqtt5.Packet{Type: typeUnsuback, Body: body.Bytes()}
+}
+
+// encodeAck encodes a PUBACK, PUBREC or PUBCOMP packet with success.
+func encodeAck(packetType byte, packetID uint16) *mqtt5.Packet {
+	return &mqtt5.Packet{Type: packetType, Body: binary.BigEndian.AppendUint16(nil, packetID)}
+}
+
+// decodePacketID returns the packet ID of a PUBREL packet.
+func decodePacketID(p *mqtt5.Packet) (uint16, error) {
+	if len(p.Body) < 2 { //nolint:mnd // packet ID is two bytes.
+		return 0, mqtt5.ErrMalformedPacket
+	}
+
+	return binary.BigEndian.Uint16(p.Body), nil
+}
+
+func writeString(w *bytes.Buffer, s string) {
+	_ = binary.Write(w, binary.BigEndian, uint16(len(s))) //nolint:gosec // strings are limited by the caller.
+	w.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", mqtt5.ErrMalformedPacket
+	}
+	if r.Len() < int(length) {
+		return "", mqtt5.ErrMalformedPacket
+	}
+
+	buf := make([]byte, length)
+	_, _ = r.Read(buf)
+
+	return string(buf), nil
+}
+
+func writeProperties(w *bytes.Buffer, props []byte) {
+	w.Write(binary.AppendUvarint(nil, uint64(len(props))))
+	w.Write(props)
+}
+
+// skipProperties skips the properties of an MQTT 5 packet, MQTT 3.1.1 packets have none.
+func skipProperties(version byte, r *bytes.Reader) error {
+	if version != protocolV5 {
+		return nil
+	}
+
+	length, err := binary.ReadUvarint(r)
+	if err != nil || uint64(r.Len()) < length {
+		return mqtt5.ErrMalformedPacket
+	}
+	_, _ = r.Seek(int64(length), io.SeekCurrent) //nolint:gosec // length is less than the packet length.
+
+	return nil
+}
@@ -0,0 +1,350 @@
+// Package mqttd is a minimal embedded MQTT broker for MQTT 3.1.1 and MQTT 5 clients, supporting QoS 0 delivery,
+// retained messages, shared subscriptions and in-process clients.
+package mqttd
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+
+	"github.com/na4ma4/go-slogtool"
+)
+
+var (
+	// ErrServerClosed is returned when starting a server or connecting a client after the server is stopped.
+	ErrServerClosed = errors.New("broker closed")
+	// ErrNotConnected is returned when an in-process client publishes or subscribes while not connected.
+	ErrNotConnected = errors.New("not connected")
+	// ErrInvalidTopic is returned when publishing to an invalid topic or subscribing to an invalid topic filter.
+	ErrInvalidTopic = errors.New("invalid topic")
+)
+
+// Server is an MQTT broker, clients connect over TCP, TLS or in-process with NewClient.
+type Server struct {
+	Config Config
+	Logger *slog.Logger
+
+	lock      sync.Mutex
+	listeners []net.Listener
+	sessions  map[string]*session
+	retained  map[string]*broker.Message
+	shared    map[string]uint64
+	closed    bool
+	wg        sync.WaitGroup
+	nextID    atomic.Uint64
+}
+
+// New returns a server, Start must be called to accept network clients.
+func New(config Config, logger *slog.Logger) *Server {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	if config.MaxPacketSize <= 0 {
+		config.MaxPacketSize = DefaultMaxPacketSize
+	}
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = DefaultConnectTimeout
+	}
+
+	return &Server{
+		Config:   config,
+		Logger:   logger,
+		sessions: make(map[string]*session),
+		retained: make(map[string]*broker.Message),
+		shared:   make(map[string]uint64),
+	}
+}
+
+// Start listens on the configured addresses and accepts clients in the background until Stop is called.
+func (s *Server) Start(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrServerClosed
+	}
+
+	var lc net.ListenConfig
+	if s.Config.Address != "" {
+		l, err := lc.Listen(ctx, "tcp", s.Config.Address)
+		if err != nil {
+			return fmt.Errorf("unable to listen on %s: %w", s.Config.Address, err)
+		}
+		s.listeners = append(s.listeners, l)
+	}
+
+	if s.Config.TLSAddress != "" {
+		cert, err := tls.LoadX509KeyPair(s.Config.CertFile, s.Config.KeyFile)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("unable to load broker certificate: %w", err)
+		}
+
+		l, err := lc.Listen(ctx, "tcp", s.Config.TLSAddress)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("unable to listen on %s: %w", s.Config.TLSAddress, err)
+		}
+		s.listeners = append(s.listeners, tls.NewListener(l, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}))
+	}
+
+	for _, l := range s.listeners {
+		s.Logger.InfoContext(ctx, "Starting embedded MQTT broker", slog.String("bind", l.Addr().String()))
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.accept(ctx, l)
+		}()
+	}
+
+	return nil
+}
+
+// Addrs returns the addresses the server is listening on.
+func (s *Server) Addrs() []net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+
+	return addrs
+}
+
+// Stop stops accepting clients, disconnects every client and waits for their connections to close.
+func (s *Server) Stop() {
+	s.lock.Lock()
+	s.closed = true
+	s.closeListeners()
+	for _, sess := range s.sessions {
+		sess.close()
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+}
+
+// closeListeners closes the listeners, the lock must be held.
+func (s *Server) closeListeners() {
+	for _, l := range s.listeners {
+		_ = l.Close()
+	}
+	s.listeners = nil
+}
+
+// accept accepts clients until the listener is closed.
+func (s *Server) accept(ctx context.Context, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.Logger.ErrorContext(ctx, "Embedded broker stopped accepting clients", slogtool.ErrorAttr(err))
+			}
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(ctx, conn)
+		}()
+	}
+}
+
+// authenticate returns true if the username and password are valid, or no users are configured.
+func (s *Server) authenticate(username, password string) bool {
+	if len(s.Config.Users) == 0 {
+		return true
+	}
+
+	want, ok := s.Config.Users[username]
+	return ok && subtle.ConstantTimeCompare([]byte(want), []byte(password)) == 1
+}
+
+// register adds the session, disconnecting an existing session with the same client ID.
+func (s *Server) register(sess *session) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrServerClosed
+	}
+
+	if old, ok := s.sessions[sess.clientID]; ok {
+		old.close()
+	}
+	s.sessions[sess.clientID] = sess
+
+	return nil
+}
+
+// unregister removes the session and its subscriptions, unless it has been taken over by another session.
+func (s *Server) unregister(sess *session) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sess.close()
+	if s.sessions[sess.clientID] == sess {
+		delete(s.sessions, sess.clientID)
+	}
+}
+
+// subscribe adds the subscription to the session, returning the retained messages matching the filter to deliver
+// once the subscription is acknowledged, shared subscriptions do not receive retained messages.
+func (s *Server) subscribe(sess *session, filter string) ([]*broker.Message, error) {
+	if !ValidFilter(filter) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTopic, filter)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	group, f, _ := parseShared(filter)
+	sess.subs[filter] = subscription{group: group, filter: f}
+	if group != "" {
+		return nil, nil
+	}
+
+	var retained []*broker.Message
+	for topic, msg := range s.retained {
+		if Match(f, topic) {
+			retained = append(retained, msg)
+		}
+	}
+	slices.SortFunc(retained, func(a, b *broker.Message) int { return cmp.Compare(a.Topic, b.Topic) })
+
+	return retained, nil
+}
+
+// unsubscribe removes the subscription from the session.
+func (s *Server) unsubscribe(sess *session, filter string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(sess.subs, filter)
+}
+
+// publish stores the message if it is retained and delivers it to the matching subscriptions, a shared subscription
+// receives it on one session of the group in turn.
+func (s *Server) publish(msg *broker.Message) error {
+	if !ValidTopic(msg.Topic) {
+		return fmt.Errorf("%w: %s", ErrInvalidTopic, msg.Topic)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if msg.Retain {
+		if len(msg.Payload) == 0 {
+			delete(s.retained, msg.Topic)
+		} else {
+			retained := *msg
+			s.retained[msg.Topic] = &retained
+		}
+	}
+
+	// Messages are forwarded to existing subscriptions without the retain flag.
+	out := &broker.Message{Topic: msg.Topic, Payload: msg.Payload, Properties: msg.Properties}
+
+	groups := make(map[string][]*session)
+	for _, sess := range s.sessions {
+		matched := false
+		for _, sub := range sess.subs {
+			if !Match(sub.filter, msg.Topic) {
+				continue
+			}
+
+			if sub.group != "" {
+				key := sub.group + "/" + sub.filter
+				groups[key] = append(groups[key], sess)
+				continue
+			}
+			matched = true
+		}
+
+		if matched {
+			sess.deliver(out)
+		}
+	}
+
+	for key, members := range groups {
+		slices.SortFunc(members, func(a, b *session) int { return cmp.Compare(a.clientID, b.clientID) })
+		members[s.shared[key]%uint64(len(members))].deliver(out)
+		s.shared[key]++
+	}
+
+	return nil
+}
+
+// subscription is a topic filter subscribed to by a session, group is set for a shared subscription.
+type subscription struct {
+	group  string
+	filter string
+}
+
+// session is a connected client, messages are queued for it and dropped if the queue is full.
+type session struct {
+	clientID string
+	// subs are the subscriptions by the topic filter subscribed to, guarded by the server lock.
+	subs    map[string]subscription
+	queue   chan *broker.Message
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+	// onClose is called once when the session is closed, optional.
+	onClose func()
+}
+
+func newSession(clientID string, queueSize int) *session {
+	return &session{
+		clientID: clientID,
+		subs:     make(map[string]subscription),
+		queue:    make(chan *broker.Message, queueSize),
+		done:     make(chan struct{}),
+	}
+}
+
+// deliver queues the message, dropping it if the queue is full.
+func (sess *session) deliver(msg *broker.Message) {
+	select {
+	case sess.queue <- msg:
+	default:
+		sess.dropped.Add(1)
+	}
+}
+
+// close ends the session.
+func (sess *session) close() {
+	sess.once.Do(func() {
+		close(sess.done)
+		if sess.onClose != nil {
+			sess.onClose()
+		}
+	})
+}
+
+// closed returns true if the session has ended.
+func (sess *session) closed() bool {
+	select {
+	case <-sess.done:
+		return true
+	default:
+		return false
+	}
+}
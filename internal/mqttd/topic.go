@@ -0,0 +1,75 @@
+package mqttd
+
+import "strings"
+
+// sharePrefix is the prefix of a shared subscription, $share/<group>/<filter>.
+const sharePrefix = "$share/"
+
+// Match returns true if the topic matches the topic filter.
+//
+// Topics starting with $ are only matched by filters starting with $, not by wildcards.
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && !strings.HasPrefix(filter, "$") {
+		return false
+	}
+
+	filters := strings.Split(filter, "/")
+	topics := strings.Split(topic, "/")
+
+	for i, f := range filters {
+		switch {
+		case f == "#":
+			return true
+		case i >= len(topics):
+			return false
+		case f != "+" && f != topics[i]:
+			return false
+		}
+	}
+
+	return len(filters) == len(topics)
+}
+
+// ValidTopic returns true if the topic can be published to, it must not be empty or contain wildcards.
+func ValidTopic(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#\x00")
+}
+
+// ValidFilter returns true if the topic filter can be subscribed to, wildcards must be a whole level and # must be
+// the last level.
+func ValidFilter(filter string) bool {
+	if _, f, ok := parseShared(filter); ok {
+		filter = f
+	}
+
+	if filter == "" || strings.Contains(filter, "\x00") {
+		return false
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) > 1 {
+			return false
+		}
+		if level == "#" && i != len(levels)-1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseShared returns the group and filter of a shared subscription, ok is false if the filter is not shared.
+func parseShared(filter string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(filter, sharePrefix)
+	if !ok {
+		return "", filter, false
+	}
+
+	group, f, ok := strings.Cut(rest, "/")
+	if !ok || group == "" || strings.ContainsAny(group, "+#") {
+		return "", filter, false
+	}
+
+	return group, f, true
+}
@@ -0,0 +1,33 @@
+package mtypes
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// DeadLetterEnvelope is the reason for a message that could not be unmarshalled as a ServiceEnvelope.
+	DeadLetterEnvelope = "envelope"
+	// DeadLetterMessage is the reason for a packet that could not be converted to a Message.
+	DeadLetterMessage = "message"
+	// DeadLetterJSON is the reason for a Message that could not be encoded as JSON.
+	DeadLetterJSON = "json"
+	// DeadLetterPublish is the reason for a message that could not be published to the destination broker.
+	DeadLetterPublish = "publish"
+)
+
+// DeadLetter is a message that could not be decoded or published.
+type DeadLetter struct {
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+	// Topic is the topic the message was received on, or would have been published to.
+	Topic string `json:"topic"`
+	// Payload is the received protobuf, or the JSON that would have been published.
+	Payload []byte    `json:"payload"`
+	Time    time.Time `json:"time"`
+}
+
+// ToJSON encodes the dead letter as JSON, the payload is base64 encoded.
+func (d *DeadLetter) ToJSON() ([]byte, error) {
+	return json.Marshal(d)
+}
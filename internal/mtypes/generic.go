@@ -0,0 +1,19 @@
+package mtypes
+
+import "encoding/json"
+
+// Generic converts a value to the generic types produced by decoding JSON, so fields are found by their JSON name.
+//
+// It returns nil if the value can not be encoded as JSON.
+func Generic(in any) any {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil
+	}
+
+	var out any
+	if err = json.Unmarshal(b, &out); err != nil {
+		return nil
+	}
+	return out
+}
@@ -10,20 +10,39 @@ import (
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
 )
 
+const (
+	// TypeEncrypted is the message type for channel encrypted packets that could not be decrypted.
+	TypeEncrypted = "ENCRYPTED"
+	// TypePKIEncrypted is the message type for PKI encrypted packets that could not be decrypted.
+	TypePKIEncrypted = "PKI_ENCRYPTED"
+)
+
 type Message struct {
-	Bitfield  *uint32                   `json:"bitfield,omitempty"`
-	Channel   uint32                    `json:"channel"`
-	From      uint32                    `json:"from"`
-	HopStart  uint32                    `json:"hop_start"`
-	HopsAway  uint32                    `json:"hops_away"`
-	ID        uint32                    `json:"id"`
-	Payload   any                       `json:"payload"`
-	RSSI      int32                     `json:"rssi"`
-	Sender    string                    `json:"sender"`
-	SNR       translator.SpecialFloat64 `json:"snr"`
-	Timestamp uint32                    `json:"timestamp"`
-	To        uint32                    `json:"to"`
-	Type      string                    `json:"type"`
+	Bitfield     *uint32                   `json:"bitfield,omitempty"`
+	Channel      uint32                    `json:"channel"`
+	From         uint32                    `json:"from"`
+	HopStart     uint32                    `json:"hop_start"`
+	HopsAway     uint32                    `json:"hops_away"`
+	ID           uint32                    `json:"id"`
+	Payload      any                       `json:"payload"`
+	PKIEncrypted bool                      `json:"pki_encrypted,omitempty"`
+	RSSI         int32                     `json:"rssi"`
+	Sender       string                    `json:"sender"`
+	SNR          translator.SpecialFloat64 `json:"snr"`
+	Timestamp    uint32                    `json:"timestamp"`
+	To           uint32                    `json:"to"`
+	Type         string                    `json:"type"`
+	FromNode     *NodeSummary              `json:"from_node,omitempty"`
+	ToNode       *NodeSummary              `json:"to_node,omitempty"`
+	Gateways     []Gateway                 `json:"gateways,omitempty"`
+}
+
+// Gateway is a gateway that received a message and the signal it was received with.
+type Gateway struct {
+	ID       string                    `json:"id"`
+	RSSI     int32                     `json:"rssi"`
+	SNR      translator.SpecialFloat64 `json:"snr"`
+	HopsAway uint32                    `json:"hops_away"`
 }
 
 func (m *Message) GetFrom() uint32 {
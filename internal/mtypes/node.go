@@ -0,0 +1,74 @@
+package mtypes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Node is the known state of a node on the mesh.
+type Node struct {
+	Num       uint32 `json:"num"`
+	ID        string `json:"id"`
+	LongName  string `json:"long_name,omitempty"`
+	ShortName string `json:"short_name,omitempty"`
+	HwModel   string `json:"hw_model,omitempty"`
+	Role      string `json:"role,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+
+	FirmwareVersion     string  `json:"firmware_version,omitempty"`
+	Region              string  `json:"region,omitempty"`
+	ModemPreset         string  `json:"modem_preset,omitempty"`
+	NumOnlineLocalNodes *uint32 `json:"num_online_local_nodes,omitempty"`
+
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Altitude  *int32   `json:"altitude,omitempty"`
+
+	BatteryLevel       *uint32  `json:"battery_level,omitempty"`
+	Voltage            *float64 `json:"voltage,omitempty"`
+	ChannelUtilization *float64 `json:"channel_utilization,omitempty"`
+	AirUtilTx          *float64 `json:"air_util_tx,omitempty"`
+	UptimeSeconds      *uint32  `json:"uptime_seconds,omitempty"`
+
+	LastHeard time.Time `json:"last_heard"`
+	RSSI      int32     `json:"rssi"`
+	SNR       float64   `json:"snr"`
+	HopsAway  uint32    `json:"hops_away"`
+	Gateway   string    `json:"gateway,omitempty"`
+}
+
+// Summary returns the identifying details of the node used to enrich messages.
+func (n *Node) Summary() *NodeSummary {
+	return &NodeSummary{
+		ID:        n.ID,
+		LongName:  n.LongName,
+		ShortName: n.ShortName,
+		HwModel:   n.HwModel,
+		Role:      n.Role,
+	}
+}
+
+// Value Marshal.
+func (n *Node) Value() (driver.Value, error) {
+	return json.Marshal(n)
+}
+
+// Scan Unmarshal.
+func (n *Node) Scan(value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(b, n)
+}
+
+// NodeSummary is the identifying details of a node included in messages.
+type NodeSummary struct {
+	ID        string `json:"id"`
+	LongName  string `json:"long_name,omitempty"`
+	ShortName string `json:"short_name,omitempty"`
+	HwModel   string `json:"hw_model,omitempty"`
+	Role      string `json:"role,omitempty"`
+}
@@ -0,0 +1,34 @@
+package mtypes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BroadcastNode is the node number used for packets sent to all nodes.
+const BroadcastNode uint32 = 0xffffffff
+
+// FormatNodeID formats a node number as a Meshtastic node ID (e.g. !44be043f).
+func FormatNodeID(node uint32) string {
+	return fmt.Sprintf("!%08x", node)
+}
+
+// ParseNodeID parses a node number from a Meshtastic node ID (e.g. !44be043f) or decimal string.
+func ParseNodeID(in string) (uint32, error) {
+	in = strings.TrimSpace(in)
+
+	if hex, ok := strings.CutPrefix(in, "!"); ok {
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid node ID %q: %w", in, err)
+		}
+		return uint32(v), nil
+	}
+
+	v, err := strconv.ParseUint(in, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid node ID %q: %w", in, err)
+	}
+	return uint32(v), nil
+}
@@ -0,0 +1,306 @@
+package nodedb
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+
+	"github.com/na4ma4/go-slogtool"
+)
+
+// DefaultFlushInterval is the default interval between persisting changed nodes.
+const DefaultFlushInterval = time.Minute
+
+// Config holds the node database configuration.
+type Config struct {
+	// Store persists the node database, optional.
+	Store store.NodeStore
+	// FlushInterval is the interval between persisting changed nodes.
+	FlushInterval time.Duration
+}
+
+// DB is an in-memory database of every node seen on the mesh.
+type DB struct {
+	Config Config
+	Logger *slog.Logger
+	lock   sync.RWMutex
+	nodes  map[uint32]*mtypes.Node
+	dirty  map[uint32]struct{}
+}
+
+// NewDB creates a new node database.
+func NewDB(config Config, logger *slog.Logger) *DB {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+
+	return &DB{
+		Config: config,
+		Logger: logger.With(slog.String("type", "nodedb")),
+		nodes:  map[uint32]*mtypes.Node{},
+		dirty:  map[uint32]struct{}{},
+	}
+}
+
+// Load populates the node database from the store.
+func (db *DB) Load(ctx context.Context) error {
+	if db.Config.Store == nil {
+		return nil
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := db.Config.Store.IterateNodes(ctx, func(node *mtypes.Node) error {
+		db.nodes[node.Num] = node
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to load nodes: %w", err)
+	}
+
+	db.Logger.InfoContext(ctx, "Loaded node database", slog.Int("nodes", len(db.nodes)))
+
+	return nil
+}
+
+// Flush persists nodes changed since the last flush to the store.
+func (db *DB) Flush(ctx context.Context) error {
+	if db.Config.Store == nil {
+		return nil
+	}
+
+	db.lock.Lock()
+	pending := make([]*mtypes.Node, 0, len(db.dirty))
+	for num := range db.dirty {
+		if node, ok := db.nodes[num]; ok {
+			n := *node
+			pending = append(pending, &n)
+		}
+	}
+	clear(db.dirty)
+	db.lock.Unlock()
+
+	var errs []error
+	for _, node := range pending {
+		if err := db.Config.Store.SaveNode(ctx, node); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save node %s: %w", node.ID, err))
+			db.markDirty(node.Num)
+		}
+	}
+
+	if len(pending) > 0 {
+		db.Logger.DebugContext(ctx, "Flushed node database", slog.Int("nodes", len(pending)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Run periodically persists changed nodes until the context is done.
+func (db *DB) Run(ctx context.Context) {
+	if db.Config.Store == nil {
+		return
+	}
+
+	ticker := time.NewTicker(db.Config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := db.Flush(ctx); err != nil {
+				db.Logger.ErrorContext(ctx, "Failed to flush node database", slogtool.ErrorAttr(err))
+			}
+		}
+	}
+}
+
+func (db *DB) markDirty(num uint32) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.dirty[num] = struct{}{}
+}
+
+// Get returns a copy of the node with the given node number.
+func (db *DB) Get(num uint32) (*mtypes.Node, bool) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	node, ok := db.nodes[num]
+	if !ok {
+		return nil, false
+	}
+
+	n := *node
+	return &n, true
+}
+
+// List returns a copy of every known node, ordered by node number.
+func (db *DB) List() []*mtypes.Node {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	out := make([]*mtypes.Node, 0, len(db.nodes))
+	for _, node := range db.nodes {
+		n := *node
+		out = append(out, &n)
+	}
+
+	slices.SortFunc(out, func(a, b *mtypes.Node) int {
+		return cmp.Compare(a.Num, b.Num)
+	})
+
+	return out
+}
+
+// Len returns the number of known nodes.
+func (db *DB) Len() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return len(db.nodes)
+}
+
+// Observe updates the sending node from the packet metadata and payload of a message.
+func (db *DB) Observe(msg *mtypes.Message) {
+	if msg == nil || msg.From == 0 || msg.From == mtypes.BroadcastNode {
+		return
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	node, ok := db.nodes[msg.From]
+	if !ok {
+		node = &mtypes.Node{
+			Num: msg.From,
+			ID:  mtypes.FormatNodeID(msg.From),
+		}
+		db.nodes[msg.From] = node
+	}
+
+	node.LastHeard = time.Now()
+	if msg.Timestamp > 0 {
+		node.LastHeard = time.Unix(int64(msg.Timestamp), 0)
+	}
+	node.RSSI = msg.RSSI
+	node.SNR = float64(msg.SNR)
+	node.HopsAway = msg.HopsAway
+	node.Gateway = msg.Sender
+
+	switch payload := msg.Payload.(type) {
+	case *translator.User:
+		observeUser(node, payload)
+	case *translator.PositionApp:
+		observePosition(node, payload)
+	case *translator.TelemetryDeviceMetrics:
+		observeDeviceMetrics(node, payload)
+	case *translator.MapReportApp:
+		observeMapReport(node, payload)
+	}
+
+	db.dirty[msg.From] = struct{}{}
+}
+
+// Enrich adds the known details of the sending and receiving nodes to a message.
+func (db *DB) Enrich(msg *mtypes.Message) {
+	if msg == nil {
+		return
+	}
+
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if node, ok := db.nodes[msg.From]; ok {
+		msg.FromNode = node.Summary()
+	}
+
+	if msg.To != mtypes.BroadcastNode {
+		if node, ok := db.nodes[msg.To]; ok {
+			msg.ToNode = node.Summary()
+		}
+	}
+}
+
+func observeUser(node *mtypes.Node, user *translator.User) {
+	if user == nil {
+		return
+	}
+
+	node.LongName = user.LongName
+	node.ShortName = user.ShortName
+	node.HwModel = user.HwModel
+	node.Role = user.Role
+	if len(user.PublicKey) > 0 {
+		node.PublicKey = user.PublicKey
+	}
+}
+
+func observePosition(node *mtypes.Node, pos *translator.PositionApp) {
+	if pos == nil || pos.LatitudeI == nil || pos.LongitudeI == nil {
+		return
+	}
+
+	lat := float64(*pos.LatitudeI) * translator.CoordinateScale
+	lon := float64(*pos.LongitudeI) * translator.CoordinateScale
+	node.Latitude = &lat
+	node.Longitude = &lon
+	node.Altitude = pos.Altitude
+}
+
+func observeMapReport(node *mtypes.Node, report *translator.MapReportApp) {
+	if report == nil {
+		return
+	}
+
+	if report.LongName != "" {
+		node.LongName = report.LongName
+		node.ShortName = report.ShortName
+	}
+	node.HwModel = report.HwModel
+	node.Role = report.Role
+	node.FirmwareVersion = report.FirmwareVersion
+	node.Region = report.Region
+	node.ModemPreset = report.ModemPreset
+	node.NumOnlineLocalNodes = translator.Ptr(report.NumOnlineLocalNodes)
+
+	if report.Latitude != nil && report.Longitude != nil {
+		node.Latitude = report.Latitude
+		node.Longitude = report.Longitude
+		node.Altitude = translator.Ptr(report.Altitude)
+	}
+}
+
+func observeDeviceMetrics(node *mtypes.Node, tm *translator.TelemetryDeviceMetrics) {
+	if tm == nil || tm.DeviceMetrics == nil {
+		return
+	}
+
+	dm := tm.DeviceMetrics
+	if dm.BatteryLevel != nil {
+		node.BatteryLevel = dm.BatteryLevel
+	}
+	if dm.Voltage != nil {
+		node.Voltage = translator.Ptr(float64(*dm.Voltage))
+	}
+	if dm.ChannelUtilization != nil {
+		node.ChannelUtilization = translator.Ptr(float64(*dm.ChannelUtilization))
+	}
+	if dm.AirUtilTx != nil {
+		node.AirUtilTx = translator.Ptr(float64(*dm.AirUtilTx))
+	}
+	if dm.UptimeSeconds != nil {
+		node.UptimeSeconds = dm.UptimeSeconds
+	}
+}
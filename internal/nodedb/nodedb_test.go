@@ -0,0 +1,145 @@
+package nodedb_test
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestObserveAndEnrich(t *testing.T) {
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+
+	db.Observe(&mtypes.Message{
+		From:      0x44be043f,
+		To:        mtypes.BroadcastNode,
+		Timestamp: 1762934875,
+		RSSI:      -86,
+		SNR:       10.5,
+		HopsAway:  2,
+		Sender:    "!11111111",
+		Payload: &translator.User{
+			ID:        "!44be043f",
+			LongName:  "Gateway Node",
+			ShortName: "GW",
+			HwModel:   "HELTEC_V3",
+			Role:      "ROUTER",
+		},
+	})
+	db.Observe(&mtypes.Message{
+		From: 0x44be043f,
+		To:   mtypes.BroadcastNode,
+		Payload: &translator.PositionApp{
+			LatitudeI:  ptr(int32(-275513344)),
+			LongitudeI: ptr(int32(1530658816)),
+		},
+	})
+	db.Observe(&mtypes.Message{
+		From: 0x44be043f,
+		To:   mtypes.BroadcastNode,
+		Payload: &translator.TelemetryDeviceMetrics{
+			DeviceMetrics: &translator.DeviceMetrics{
+				BatteryLevel: ptr(uint32(87)),
+			},
+		},
+	})
+
+	node, ok := db.Get(0x44be043f)
+	if !ok {
+		t.Fatal("Expected node to be known")
+	}
+	if node.LongName != "Gateway Node" || node.HwModel != "HELTEC_V3" {
+		t.Errorf("Unexpected node user details: %+v", node)
+	}
+	if node.Latitude == nil || *node.Latitude > -27.55 || *node.Latitude < -27.56 {
+		t.Errorf("Unexpected node latitude: %v", node.Latitude)
+	}
+	if node.BatteryLevel == nil || *node.BatteryLevel != 87 {
+		t.Errorf("Unexpected node battery level: %v", node.BatteryLevel)
+	}
+
+	msg := &mtypes.Message{From: 0x44be043f, To: 0x44be043f}
+	db.Enrich(msg)
+	if msg.FromNode == nil || msg.FromNode.LongName != "Gateway Node" {
+		t.Errorf("Expected FromNode to be enriched, got %+v", msg.FromNode)
+	}
+	if msg.ToNode == nil || msg.ToNode.ShortName != "GW" {
+		t.Errorf("Expected ToNode to be enriched, got %+v", msg.ToNode)
+	}
+
+	broadcast := &mtypes.Message{From: 0x12345678, To: mtypes.BroadcastNode}
+	db.Enrich(broadcast)
+	if broadcast.FromNode != nil || broadcast.ToNode != nil {
+		t.Errorf("Expected unknown and broadcast nodes to not be enriched, got %+v", broadcast)
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	st := store.NewJSONDirStore(t.TempDir(), store.Config{})
+
+	db := nodedb.NewDB(nodedb.Config{Store: st}, slog.New(slog.DiscardHandler))
+	db.Observe(&mtypes.Message{
+		From:    0x44be043f,
+		Payload: &translator.User{LongName: "Gateway Node"},
+	})
+	if err := db.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	loaded := nodedb.NewDB(nodedb.Config{Store: st}, slog.New(slog.DiscardHandler))
+	if err := loaded.Load(t.Context()); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	node, ok := loaded.Get(0x44be043f)
+	if !ok {
+		t.Fatal("Expected node to be loaded")
+	}
+	if node.LongName != "Gateway Node" || node.ID != "!44be043f" {
+		t.Errorf("Unexpected loaded node: %+v", node)
+	}
+}
+
+func TestObserveMapReport(t *testing.T) {
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+
+	db.Observe(&mtypes.Message{
+		From: 0xa0cbc3a8,
+		Payload: &translator.MapReportApp{
+			LongName:            "Luy Paper Home",
+			ShortName:           "LUY0",
+			HwModel:             "HELTEC_WIRELESS_PAPER",
+			Role:                "ROUTER_LATE",
+			FirmwareVersion:     "2.6.11.60ec05e",
+			Region:              "ANZ",
+			ModemPreset:         "MEDIUM_FAST",
+			Latitude:            ptr(-27.5513344),
+			Longitude:           ptr(153.0658816),
+			NumOnlineLocalNodes: 17,
+		},
+	})
+
+	node, ok := db.Get(0xa0cbc3a8)
+	if !ok {
+		t.Fatal("Expected node to be known")
+	}
+	if node.FirmwareVersion != "2.6.11.60ec05e" || node.Region != "ANZ" || node.ModemPreset != "MEDIUM_FAST" {
+		t.Errorf("Unexpected node radio details: %+v", node)
+	}
+	if node.LongName != "Luy Paper Home" || node.HwModel != "HELTEC_WIRELESS_PAPER" {
+		t.Errorf("Unexpected node user details: %+v", node)
+	}
+	if node.Latitude == nil || *node.Latitude != -27.5513344 {
+		t.Errorf("Unexpected node latitude: %v", node.Latitude)
+	}
+	if node.NumOnlineLocalNodes == nil || *node.NumOnlineLocalNodes != 17 {
+		t.Errorf("Unexpected node online local nodes: %v", node.NumOnlineLocalNodes)
+	}
+}
@@ -0,0 +1,199 @@
+// Package outbox implements a disk-backed queue of messages waiting for the destination broker.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+)
+
+const (
+	// DefaultMaxMessages is the default number of messages held before the outbox is full.
+	DefaultMaxMessages = 10000
+
+	// fileSuffix is the suffix of the file each queued message is written to.
+	fileSuffix = ".msg"
+	// tmpSuffix is the suffix of a message file while it is being written.
+	tmpSuffix = ".tmp"
+	// dirMode is the mode the outbox directory is created with.
+	dirMode = 0o750
+	// fileMode is the mode message files are created with.
+	fileMode = 0o640
+)
+
+// ErrFull is returned by Push when the outbox holds MaxMessages messages.
+var ErrFull = errors.New("outbox is full")
+
+// Config holds the outbox configuration.
+type Config struct {
+	// Dir is the directory queued messages are written to, it is created if it does not exist.
+	Dir string
+	// MaxMessages is the number of messages held before Push returns ErrFull, DefaultMaxMessages if zero.
+	MaxMessages int
+}
+
+// Outbox is a first-in first-out queue of broker messages, each message is written to its own file so the queue
+// survives a restart.
+type Outbox struct {
+	Config Config
+
+	lock sync.Mutex
+	seqs []uint64
+	next uint64
+}
+
+// Open opens the outbox, loading messages queued before a restart.
+func Open(cfg Config) (*Outbox, error) {
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = DefaultMaxMessages
+	}
+
+	if err := os.MkdirAll(cfg.Dir, dirMode); err != nil {
+		return nil, fmt.Errorf("unable to create outbox directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read outbox directory: %w", err)
+	}
+
+	o := &Outbox{Config: cfg}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), fileSuffix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		seq, parseErr := strconv.ParseUint(name, 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		o.seqs = append(o.seqs, seq)
+		o.next = max(o.next, seq+1)
+	}
+	slices.Sort(o.seqs)
+
+	return o, nil
+}
+
+// Len returns the number of queued messages.
+func (o *Outbox) Len() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return len(o.seqs)
+}
+
+// Push adds a message to the end of the queue.
+func (o *Outbox) Push(msg *broker.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("unable to encode message: %w", err)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.seqs) >= o.Config.MaxMessages {
+		return ErrFull
+	}
+
+	// Write to a temporary file and rename it, so a crash never leaves a partial message in the queue.
+	seq := o.next
+	tmpName := o.fileName(seq) + tmpSuffix
+	if err = os.WriteFile(tmpName, data, fileMode); err != nil {
+		return fmt.Errorf("unable to write queued message: %w", err)
+	}
+	if err = os.Rename(tmpName, o.fileName(seq)); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("unable to write queued message: %w", err)
+	}
+
+	o.next++
+	o.seqs = append(o.seqs, seq)
+
+	return nil
+}
+
+// Drain passes each queued message, in order, to f and removes it from the queue once f returns nil.
+//
+// Drain stops at the first error, leaving that message at the front of the queue, and returns the number of
+// messages drained. Messages pushed while draining are drained too.
+func (o *Outbox) Drain(ctx context.Context, f func(msg *broker.Message) error) (int, error) {
+	var n int
+
+	for ctx.Err() == nil {
+		seq, msg, err := o.front()
+		if err != nil {
+			return n, err
+		}
+		if msg == nil {
+			return n, nil
+		}
+
+		if err = f(msg); err != nil {
+			return n, err
+		}
+
+		if err = o.remove(seq); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, ctx.Err()
+}
+
+// front returns the message at the front of the queue, or nil if it is empty.
+//
+// A message that can not be read is removed from the queue and the error returned.
+func (o *Outbox) front() (uint64, *broker.Message, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.seqs) == 0 {
+		return 0, nil, nil
+	}
+
+	seq := o.seqs[0]
+	data, err := os.ReadFile(o.fileName(seq))
+	if err == nil {
+		msg := &broker.Message{}
+		if err = json.Unmarshal(data, msg); err == nil {
+			return seq, msg, nil
+		}
+	}
+
+	o.seqs = o.seqs[1:]
+	_ = os.Remove(o.fileName(seq))
+
+	return seq, nil, fmt.Errorf("unable to read queued message %d: %w", seq, err)
+}
+
+// remove removes the message from the front of the queue.
+func (o *Outbox) remove(seq uint64) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.seqs) > 0 && o.seqs[0] == seq {
+		o.seqs = o.seqs[1:]
+	}
+
+	if err := os.Remove(o.fileName(seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove queued message %d: %w", seq, err)
+	}
+
+	return nil
+}
+
+func (o *Outbox) fileName(seq uint64) string {
+	return filepath.Join(o.Config.Dir, fmt.Sprintf("%020d%s", seq, fileSuffix))
+}
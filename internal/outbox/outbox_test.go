@@ -0,0 +1,140 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/outbox"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func open(t *testing.T, cfg outbox.Config) *outbox.Outbox {
+	t.Helper()
+
+	o, err := outbox.Open(cfg)
+	if err != nil {
+		t.Fatalf("Open(): error got '%v', want 'nil'", err)
+	}
+
+	return o
+}
+
+func push(t *testing.T, o *outbox.Outbox, topics ...string) {
+	t.Helper()
+
+	for _, topic := range topics {
+		if err := o.Push(&broker.Message{Topic: topic, Payload: []byte(topic), Retain: true}); err != nil {
+			t.Fatalf("Push(%s): error got '%v', want 'nil'", topic, err)
+		}
+	}
+}
+
+func drain(t *testing.T, o *outbox.Outbox) []string {
+	t.Helper()
+
+	var got []string
+	if _, err := o.Drain(t.Context(), func(msg *broker.Message) error {
+		got = append(got, msg.Topic)
+		return nil
+	}); err != nil {
+		t.Fatalf("Drain(): error got '%v', want 'nil'", err)
+	}
+
+	return got
+}
+
+func TestDrainInOrder(t *testing.T) {
+	o := open(t, outbox.Config{Dir: t.TempDir()})
+	push(t, o, "a", "b", "c")
+
+	if got, want := o.Len(), 3; got != want {
+		t.Errorf("Len(): got '%d', want '%d'", got, want)
+	}
+
+	if diff := cmp.Diff([]string{"a", "b", "c"}, drain(t, o)); diff != "" {
+		t.Errorf("Drain() mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := o.Len(); got != 0 {
+		t.Errorf("Len(): got '%d', want '0'", got)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	push(t, open(t, outbox.Config{Dir: dir}), "a", "b")
+
+	o := open(t, outbox.Config{Dir: dir})
+	push(t, o, "c")
+
+	var msgs []*broker.Message
+	if _, err := o.Drain(t.Context(), func(msg *broker.Message) error {
+		msgs = append(msgs, msg)
+		return nil
+	}); err != nil {
+		t.Fatalf("Drain(): error got '%v', want 'nil'", err)
+	}
+
+	want := []*broker.Message{
+		{Topic: "a", Payload: []byte("a"), Retain: true},
+		{Topic: "b", Payload: []byte("b"), Retain: true},
+		{Topic: "c", Payload: []byte("c"), Retain: true},
+	}
+	if diff := cmp.Diff(want, msgs); diff != "" {
+		t.Errorf("Drain() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDrainStopsOnError(t *testing.T) {
+	o := open(t, outbox.Config{Dir: t.TempDir()})
+	push(t, o, "a", "b", "c")
+
+	errPublish := errors.New("publish failed")
+	n, err := o.Drain(t.Context(), func(msg *broker.Message) error {
+		if msg.Topic == "b" {
+			return errPublish
+		}
+		return nil
+	})
+	if !errors.Is(err, errPublish) {
+		t.Errorf("Drain(): error got '%v', want '%v'", err, errPublish)
+	}
+	if n != 1 {
+		t.Errorf("Drain(): drained got '%d', want '1'", n)
+	}
+
+	if diff := cmp.Diff([]string{"b", "c"}, drain(t, o)); diff != "" {
+		t.Errorf("Drain() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDrainContextDone(t *testing.T) {
+	o := open(t, outbox.Config{Dir: t.TempDir()})
+	push(t, o, "a")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := o.Drain(ctx, func(*broker.Message) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("Drain(): error got '%v', want '%v'", err, context.Canceled)
+	}
+	if got := o.Len(); got != 1 {
+		t.Errorf("Len(): got '%d', want '1'", got)
+	}
+}
+
+func TestFull(t *testing.T) {
+	o := open(t, outbox.Config{Dir: t.TempDir(), MaxMessages: 2})
+	push(t, o, "a", "b")
+
+	if err := o.Push(&broker.Message{Topic: "c"}); !errors.Is(err, outbox.ErrFull) {
+		t.Errorf("Push(): error got '%v', want '%v'", err, outbox.ErrFull)
+	}
+
+	drain(t, o)
+	push(t, o, "c")
+}
@@ -1,9 +1,23 @@
 package parser
 
+import (
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+)
+
 type OptionFunc func(*Config)
 
-func WithOnParseHandler(f OnParseHandler) OptionFunc {
+// WithKeyring sets the channel keyring used to decrypt encrypted packets.
+func WithKeyring(k *meshcrypto.Keyring) OptionFunc {
+	return func(c *Config) {
+		c.Keyring = k
+	}
+}
+
+// WithMetrics sets the metrics payload decode errors are counted against, recorded with the client type.
+func WithMetrics(m *metrics.Metrics, typ string) OptionFunc {
 	return func(c *Config) {
-		c.OnParseHandler = f
+		c.Metrics = m
+		c.MetricsType = typ
 	}
 }
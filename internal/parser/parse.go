@@ -2,21 +2,25 @@ package parser
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"strings"
 
 	"github.com/na4ma4/go-slogtool"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
 	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
-type OnParseHandler func(ctx context.Context, envelope *meshtastic.ServiceEnvelope, payload []byte, data *mtypes.Message) error
-
 type Config struct {
-	OnParseHandler OnParseHandler
+	Keyring     *meshcrypto.Keyring
+	Metrics     *metrics.Metrics
+	MetricsType string
 }
 
 type Parser struct {
@@ -35,7 +39,61 @@ func NewParser(logger *slog.Logger, opts ...OptionFunc) *Parser {
 	}
 }
 
+// Decrypt replaces the encrypted payload of the envelope packet with the decoded data if
+// a configured channel key or node private key can decrypt it, returning true if the packet was decrypted.
+func (p *Parser) Decrypt(ctx context.Context, envelope *meshtastic.ServiceEnvelope) bool {
+	packet := envelope.GetPacket()
+	if p.Config.Keyring == nil || packet.GetEncrypted() == nil {
+		return false
+	}
+
+	if isPKI(envelope) {
+		return p.decryptPKI(ctx, packet)
+	}
+
+	decoded, err := p.Config.Keyring.DecryptPacket(envelope.GetChannelId(), packet)
+	if err != nil {
+		if p.Config.Keyring.OwnsNode(packet.GetTo()) {
+			return p.decryptPKI(ctx, packet)
+		}
+
+		p.Logger.DebugContext(ctx, "Unable to decrypt packet",
+			slog.String("channel_id", envelope.GetChannelId()),
+			slog.Uint64("channel_hash", uint64(packet.GetChannel())),
+			slogtool.ErrorAttr(err),
+		)
+		return false
+	}
+
+	packet.PayloadVariant = &meshtastic.MeshPacket_Decoded{Decoded: decoded}
+	return true
+}
+
+// decryptPKI decrypts a packet encrypted with the public key of a node we hold the private key for.
+func (p *Parser) decryptPKI(ctx context.Context, packet *meshtastic.MeshPacket) bool {
+	decoded, err := p.Config.Keyring.DecryptPKIPacket(packet)
+	if err != nil {
+		p.Logger.DebugContext(ctx, "Unable to decrypt PKI packet",
+			slog.String("from", mtypes.FormatNodeID(packet.GetFrom())),
+			slog.String("to", mtypes.FormatNodeID(packet.GetTo())),
+			slogtool.ErrorAttr(err),
+		)
+		return false
+	}
+
+	packet.PayloadVariant = &meshtastic.MeshPacket_Decoded{Decoded: decoded}
+	packet.PkiEncrypted = true
+	return true
+}
+
+// isPKI returns true if the envelope carries a PKI (direct message) encrypted packet.
+func isPKI(envelope *meshtastic.ServiceEnvelope) bool {
+	return envelope.GetPacket().GetPkiEncrypted() || envelope.GetChannelId() == meshcrypto.PKIChannelID
+}
+
 // ConvertToMessage converts a ServiceEnvelope to a Message.
+//
+// The envelope is expected to have already been passed to Decrypt.
 func (p *Parser) ConvertToMessage(
 	ctx context.Context,
 	topic string,
@@ -71,16 +129,26 @@ func (p *Parser) ConvertToMessage(
 	if decoded := envelope.GetPacket().GetDecoded(); decoded != nil {
 		data.Bitfield = decoded.Bitfield
 		data.Type = decoded.GetPortnum().String()
+		data.PKIEncrypted = envelope.GetPacket().GetPkiEncrypted()
 
 		payloadData, payloadErr := p.decodePayload(ctx, decoded)
 
-		if payloadData != nil {
-			if payloadErr == nil {
-				data.Payload = payloadData
-			} else {
-				p.Logger.ErrorContext(ctx, "Error converting payload", slogtool.ErrorAttr(payloadErr))
-				data.Payload = decoded.GetPayload()
-			}
+		if payloadErr != nil {
+			p.Logger.ErrorContext(ctx, "Error converting payload",
+				slog.String("portnum", data.Type),
+				slogtool.ErrorAttr(payloadErr),
+			)
+			p.Config.Metrics.DecodeError(p.Config.MetricsType, metrics.StageMessage)
+			data.Payload = decoded.GetPayload()
+		} else if payloadData != nil {
+			data.Payload = payloadData
+		}
+
+		p.recordPublicKey(data)
+	} else if envelope.GetPacket().GetEncrypted() != nil {
+		data.Type = mtypes.TypeEncrypted
+		if isPKI(envelope) {
+			data.Type = mtypes.TypePKIEncrypted
 		}
 	}
 
@@ -89,14 +157,19 @@ func (p *Parser) ConvertToMessage(
 	// 	data["type"] = decoded.Portnum.String()
 	// }
 
-	// p.conditionalStore(ctx, envelope, payload, data)
-	if p.Config.OnParseHandler != nil {
-		if err := p.Config.OnParseHandler(ctx, envelope, payload, data); err != nil {
-			return nil, fmt.Errorf("OnParseHandler error: %w", err)
-		}
+	return data, nil
+}
+
+// recordPublicKey records the public key advertised in NODEINFO_APP packets so PKI
+// packets from that node can be decrypted.
+func (p *Parser) recordPublicKey(data *mtypes.Message) {
+	if p.Config.Keyring == nil {
+		return
 	}
 
-	return data, nil
+	if user, ok := data.Payload.(*translator.User); ok && user != nil {
+		p.Config.Keyring.SetPublicKey(data.From, user.PublicKey)
+	}
 }
 
 // decodePayload decodes the payload based on the port number.
@@ -116,25 +189,32 @@ func (p *Parser) decodePayload(ctx context.Context, decoded *meshtastic.Data) (a
 		return translator.New(translator.NewTracerouteApp).Decode(decoded.GetPayload())
 	case meshtastic.PortNum_ROUTING_APP: // Protocol control packets for mesh protocol use.
 		return translator.New(translator.NewRoutingApp).Decode(decoded.GetPayload())
+	case meshtastic.PortNum_WAYPOINT_APP: // Waypoint payloads.
+		return translator.New(translator.NewWaypointApp).Decode(decoded.GetPayload())
+	case meshtastic.PortNum_NEIGHBORINFO_APP: // Aggregates edge info for the network by sending out a list of each node's neighbors
+		return translator.New(translator.NewNeighborInfoApp).Decode(decoded.GetPayload())
+	case meshtastic.PortNum_MAP_REPORT_APP: // Provides unencrypted information about a node for consumption by a map via MQTT
+		return translator.New(translator.NewMapReportApp).Decode(decoded.GetPayload())
+	case meshtastic.PortNum_PAXCOUNTER_APP: // Paxcounter lib included in the firmware
+		return translator.New(translator.NewPaxcounterApp).Decode(decoded.GetPayload())
+	case meshtastic.PortNum_DETECTION_SENSOR_APP: // Detection sensor payloads.
+		return translator.NewDetectionSensorApp(decoded.GetPayload()), nil
+	case meshtastic.PortNum_ALERT_APP: // Same as Text Message but used for critical alerts.
+		return translator.NewAlertApp(decoded.GetPayload()), nil
+	case meshtastic.PortNum_RANGE_TEST_APP: // Optional port for messages for the range test module.
+		return translator.NewRangeTestApp(decoded.GetPayload()), nil
 	case meshtastic.PortNum_UNKNOWN_APP, //nolint:staticcheck // deprecated field
 		meshtastic.PortNum_REMOTE_HARDWARE_APP,         // reserved for GPIO remote hardware
 		meshtastic.PortNum_ADMIN_APP,                   // Admin control packets.
 		meshtastic.PortNum_TEXT_MESSAGE_COMPRESSED_APP, // Compressed TEXT_MESSAGE payloads. (handled in firmware)
-		meshtastic.PortNum_WAYPOINT_APP,                // TODO: Waypoint payloads.
 		meshtastic.PortNum_AUDIO_APP,                   // Audio payloads (2.4GHz only).
-		meshtastic.PortNum_DETECTION_SENSOR_APP,        // TODO: Detection sensor payloads.
-		meshtastic.PortNum_ALERT_APP,                   // TODO: Same as Text Message but used for critical alerts.
 		meshtastic.PortNum_KEY_VERIFICATION_APP,        // TODO: Module/port for handling key verification requests.
 		meshtastic.PortNum_REPLY_APP,                   // TODO: Provides a 'ping' service that replies to any packet it receives.
 		meshtastic.PortNum_IP_TUNNEL_APP,               // TODO: Used for the python IP tunnel feature
-		meshtastic.PortNum_PAXCOUNTER_APP,              // TODO: Paxcounter lib included in the firmware
 		meshtastic.PortNum_SERIAL_APP,                  // TODO: Provides a hardware serial interface to send and receive from the Meshtastic network.
-		meshtastic.PortNum_RANGE_TEST_APP,              // Optional port for messages for the range test module.
 		meshtastic.PortNum_ZPS_APP,                     // TODO: Experimental tools for estimating node position without a GPS
 		meshtastic.PortNum_SIMULATOR_APP,               // Used to let multiple instances of Linux native applications communicate
-		meshtastic.PortNum_NEIGHBORINFO_APP,            // TODO: Aggregates edge info for the network by sending out a list of each node's neighbors
 		meshtastic.PortNum_ATAK_PLUGIN,                 // TODO: ATAK Plugin
-		meshtastic.PortNum_MAP_REPORT_APP,              // TODO: Provides unencrypted information about a node for consumption by a map via MQTT
 		meshtastic.PortNum_POWERSTRESS_APP,             // PowerStress based monitoring support (for automated power consumption testing)
 		meshtastic.PortNum_RETICULUM_TUNNEL_APP,        // TODO: Reticulum Network Stack Tunnel App
 		meshtastic.PortNum_CAYENNE_APP,                 // App for transporting Cayenne Low Power Payload, popular for LoRaWAN sensor nodes.
@@ -177,7 +257,15 @@ func (p *Parser) decodeTelemetry(ctx context.Context, payload []byte) (any, erro
 	case *meshtastic.Telemetry_AirQualityMetrics:
 		p.Logger.DebugContext(ctx, "Decoding AirQualityMetrics telemetry")
 		return translator.New(translator.NewAirQualityMetrics).Convert(variant.AirQualityMetrics)
+	case *meshtastic.Telemetry_HealthMetrics:
+		p.Logger.DebugContext(ctx, "Decoding HealthMetrics telemetry")
+		return translator.New(translator.NewHealthMetrics).Convert(variant.HealthMetrics)
 	default:
-		return fmt.Sprintf("unknown telemetry variant: %T", variant), nil
+		p.Logger.DebugContext(ctx, "Decoding unknown telemetry variant", slog.String("variant", fmt.Sprintf("%T", variant)))
+		data, err := protojson.Marshal(telemetry)
+		if err != nil {
+			return nil, fmt.Errorf("unable to render unknown telemetry variant: %w", err)
+		}
+		return json.RawMessage(data), nil
 	}
 }
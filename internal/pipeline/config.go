@@ -0,0 +1,55 @@
+package pipeline
+
+import (
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/dedup"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/downlink"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/outbox"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/topology"
+)
+
+// Config holds the pipeline configuration.
+type Config struct {
+	// Source is the broker messages are received from.
+	Source broker.Config
+	// Dest is the broker outputs publish to.
+	Dest  broker.Config
+	Topic string
+	// MapTopic is subscribed to as well as Topic, map reports are published unencrypted to msh/<region>/2/map/
+	// rather than a channel topic, optional.
+	MapTopic string
+	// SharedGroup subscribes to Topic and MapTopic as shared subscriptions in this group, optional.
+	SharedGroup string
+	Keyring     *meshcrypto.Keyring
+	// NodeDB is updated from every packet and used to enrich messages before they reach the outputs, optional.
+	NodeDB *nodedb.DB
+	// Topology is updated from every packet and periodically published, optional.
+	Topology *topology.Graph
+	Metrics  *metrics.Metrics
+	Dedup    dedup.Config
+	// Workers is the pool of workers decoding packets and writing them to the outputs.
+	Workers WorkerConfig
+	// Downlink encodes JSON requests received on the destination broker into envelopes published to the source
+	// broker, optional.
+	Downlink *downlink.Downlink
+	// Retry is the backoff applied when a publish to the destination broker fails.
+	Retry RetryConfig
+	// Outbox queues messages while the destination broker is disconnected, they are replayed in order on
+	// reconnect, optional.
+	Outbox *outbox.Outbox
+	// DeadLetter receives messages that could not be decoded or published.
+	DeadLetter DeadLetterConfig
+	DryRun     bool
+}
+
+// DeadLetterConfig is where messages that could not be decoded or published are sent, both are optional.
+type DeadLetterConfig struct {
+	// Topic dead letters are published to on the destination broker as JSON.
+	Topic string
+	// Store dead letters are saved to.
+	Store store.DeadLetterStore
+}
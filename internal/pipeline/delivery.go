@@ -0,0 +1,240 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+
+	"github.com/na4ma4/go-slogtool"
+)
+
+// delivery counts the retried, queued and dead lettered messages reported in the status.
+type delivery struct {
+	retries     atomic.Uint64
+	queued      atomic.Uint64
+	replayed    atomic.Uint64
+	deadLetters atomic.Uint64
+	replaying   atomic.Bool
+}
+
+// DeliveryStatus is the status of publishing to the destination broker.
+type DeliveryStatus struct {
+	// Retries is the number of retried publishes.
+	Retries uint64 `json:"retries"`
+	// Queued is the number of messages queued in the outbox while the destination broker was disconnected.
+	Queued uint64 `json:"queued"`
+	// Replayed is the number of queued messages published on reconnect.
+	Replayed uint64 `json:"replayed"`
+	// Outbox is the number of messages currently in the outbox.
+	Outbox int `json:"outbox"`
+	// DeadLetters is the number of messages that could not be decoded or published.
+	DeadLetters uint64 `json:"dead_letters"`
+}
+
+// Publish publishes a message to the destination broker, it is the Publisher used by MQTT outputs.
+//
+// A failed publish is retried with exponential backoff. While the destination broker is disconnected, or earlier
+// messages are still queued, messages are queued in the outbox if there is one. A message that can not be
+// published or queued is dead lettered and the error returned.
+func (p *Pipeline) Publish(ctx context.Context, msg *broker.Message) error {
+	if p.Config.DryRun {
+		p.Logger.DebugContext(ctx, "Dry run enabled, not publishing message", slog.String("topic", msg.Topic))
+		return nil
+	}
+
+	if p.Config.Outbox != nil && (p.Config.Outbox.Len() > 0 || !p.destConnected()) {
+		return p.enqueue(ctx, msg)
+	}
+
+	if err := p.publishRetry(ctx, msg); err != nil {
+		if p.Config.Outbox != nil && !p.destConnected() {
+			return p.enqueue(ctx, msg)
+		}
+
+		p.deadLetter(ctx, mtypes.DeadLetterPublish, msg.Topic, msg.Payload, err)
+		return err
+	}
+
+	p.Logger.InfoContext(ctx, ">", slog.String("topic", msg.Topic))
+	p.Logger.DebugContext(ctx, "Published message",
+		slog.String("topic", msg.Topic),
+		slog.String("payload", string(msg.Payload)),
+	)
+
+	return nil
+}
+
+// destConnected returns true if the destination broker is connected.
+func (p *Pipeline) destConnected() bool {
+	return p.destClient != nil && p.destClient.IsConnected()
+}
+
+// publishRetry publishes a message, retrying with exponential backoff until the attempts are exhausted or the
+// context is done.
+func (p *Pipeline) publishRetry(ctx context.Context, msg *broker.Message) error {
+	for attempt := 0; ; attempt++ {
+		err := ErrDestNotConnected
+		if p.destClient != nil {
+			if err = p.destClient.Publish(ctx, msg); err == nil {
+				return nil
+			}
+		}
+
+		if attempt+1 >= p.Config.Retry.MaxAttempts() {
+			return err
+		}
+
+		delay := p.Config.Retry.Delay(attempt)
+		p.delivery.retries.Add(1)
+		p.Config.Metrics.PublishRetry(metricsType)
+		p.Logger.WarnContext(ctx, "Retrying publish",
+			slog.String("topic", msg.Topic),
+			slog.Int("attempt", attempt+1),
+			slog.Duration("delay", delay),
+			slogtool.ErrorAttr(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// enqueue adds a message to the outbox, dead lettering it if the outbox is full.
+func (p *Pipeline) enqueue(ctx context.Context, msg *broker.Message) error {
+	if err := p.Config.Outbox.Push(msg); err != nil {
+		p.deadLetter(ctx, mtypes.DeadLetterPublish, msg.Topic, msg.Payload, err)
+		return fmt.Errorf("unable to queue message: %w", err)
+	}
+
+	p.delivery.queued.Add(1)
+	p.Config.Metrics.SetOutboxSize(p.Config.Outbox.Len())
+	p.Logger.DebugContext(ctx, "Queued message", slog.String("topic", msg.Topic))
+
+	p.startReplay()
+
+	return nil
+}
+
+// startReplay replays the outbox in the background if the destination broker is connected and it is not already
+// being replayed.
+func (p *Pipeline) startReplay() {
+	if p.Config.Outbox == nil || !p.destConnected() {
+		return
+	}
+
+	if p.delivery.replaying.CompareAndSwap(false, true) {
+		go p.replayOutbox(p.Context)
+	}
+}
+
+// replayOutbox publishes the queued messages in order until the outbox is empty or the destination broker
+// disconnects.
+func (p *Pipeline) replayOutbox(ctx context.Context) {
+	for {
+		n, err := p.Config.Outbox.Drain(ctx, func(msg *broker.Message) error {
+			return p.replay(ctx, msg)
+		})
+		p.Config.Metrics.SetOutboxSize(p.Config.Outbox.Len())
+		if n > 0 {
+			p.Logger.InfoContext(ctx, "Replayed queued messages",
+				slog.Int("messages", n),
+				slog.Int("outbox", p.Config.Outbox.Len()),
+			)
+		}
+		if err != nil {
+			p.Logger.WarnContext(ctx, "Stopped replaying queued messages", slogtool.ErrorAttr(err))
+		}
+
+		// A message queued after the outbox was drained, but before replaying was cleared, is replayed here.
+		p.delivery.replaying.Store(false)
+		if err != nil || p.Config.Outbox.Len() == 0 || !p.destConnected() ||
+			!p.delivery.replaying.CompareAndSwap(false, true) {
+			return
+		}
+	}
+}
+
+// replay publishes a queued message, a message that fails while the destination broker is connected is dead
+// lettered so it does not block the messages behind it.
+func (p *Pipeline) replay(ctx context.Context, msg *broker.Message) error {
+	if err := p.publishRetry(ctx, msg); err != nil {
+		if !p.destConnected() {
+			return err
+		}
+
+		p.deadLetter(ctx, mtypes.DeadLetterPublish, msg.Topic, msg.Payload, err)
+		return nil
+	}
+
+	p.delivery.replayed.Add(1)
+	p.Logger.InfoContext(ctx, "> [replay]", slog.String("topic", msg.Topic))
+
+	return nil
+}
+
+// deadLetter records a message that could not be decoded or published, saving it to the dead letter store and
+// publishing it to the dead letter topic if they are configured.
+func (p *Pipeline) deadLetter(ctx context.Context, reason, topic string, payload []byte, cause error) {
+	p.delivery.deadLetters.Add(1)
+	p.Config.Metrics.DeadLetter(reason)
+
+	cfg := p.Config.DeadLetter
+	if cfg.Store == nil && cfg.Topic == "" {
+		return
+	}
+
+	letter := &mtypes.DeadLetter{
+		Reason:  reason,
+		Error:   cause.Error(),
+		Topic:   topic,
+		Payload: payload,
+		Time:    time.Now(),
+	}
+
+	if cfg.Store != nil {
+		if err := cfg.Store.SaveDeadLetter(ctx, letter); err != nil {
+			p.Logger.ErrorContext(ctx, "Failed to save dead letter", slogtool.ErrorAttr(err))
+		}
+	}
+
+	if cfg.Topic == "" || p.Config.DryRun || !p.destConnected() {
+		return
+	}
+
+	data, err := letter.ToJSON()
+	if err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to encode dead letter", slogtool.ErrorAttr(err))
+		return
+	}
+
+	if err = p.destClient.Publish(ctx, &broker.Message{
+		Topic:      cfg.Topic,
+		Payload:    data,
+		Properties: broker.Properties{ContentType: contentTypeJSON},
+	}); err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to publish dead letter", slogtool.ErrorAttr(err))
+	}
+}
+
+// deliveryStatus returns the status of publishing to the destination broker.
+func (p *Pipeline) deliveryStatus() DeliveryStatus {
+	st := DeliveryStatus{
+		Retries:     p.delivery.retries.Load(),
+		Queued:      p.delivery.queued.Load(),
+		Replayed:    p.delivery.replayed.Load(),
+		DeadLetters: p.delivery.deadLetters.Load(),
+	}
+	if p.Config.Outbox != nil {
+		st.Outbox = p.Config.Outbox.Len()
+	}
+
+	return st
+}
@@ -0,0 +1,209 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/outbox"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var errPublish = errors.New("publish failed")
+
+// fakeClient is a broker.Client recording published messages, publishes fail while failures is positive.
+type fakeClient struct {
+	connected atomic.Bool
+	failures  atomic.Int32
+
+	lock sync.Mutex
+	msgs []*broker.Message
+}
+
+func (c *fakeClient) Connect(context.Context) error           { return nil }
+func (c *fakeClient) IsConnected() bool                       { return c.connected.Load() }
+func (c *fakeClient) Subscribe(context.Context, string) error { return nil }
+func (c *fakeClient) Disconnect()                             {}
+
+func (c *fakeClient) Publish(_ context.Context, msg *broker.Message) error {
+	if !c.connected.Load() {
+		return pipeline.ErrDestNotConnected
+	}
+	if c.failures.Add(-1) >= 0 {
+		return errPublish
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+func (c *fakeClient) topics() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	topics := make([]string, 0, len(c.msgs))
+	for _, msg := range c.msgs {
+		topics = append(topics, msg.Topic)
+	}
+	return topics
+}
+
+// deadLetters is a store.DeadLetterStore recording what it is given.
+type deadLetters struct {
+	lock    sync.Mutex
+	letters []*mtypes.DeadLetter
+}
+
+func (d *deadLetters) SaveDeadLetter(_ context.Context, letter *mtypes.DeadLetter) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.letters = append(d.letters, letter)
+	return nil
+}
+
+func (d *deadLetters) reasons() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var reasons []string
+	for _, letter := range d.letters {
+		reasons = append(reasons, letter.Reason)
+	}
+	return reasons
+}
+
+var fastRetry = pipeline.RetryConfig{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+func TestPublishRetry(t *testing.T) {
+	p := newPipeline(t, pipeline.Config{Retry: fastRetry})
+
+	client := &fakeClient{}
+	client.connected.Store(true)
+	client.failures.Store(2)
+	p.SetDestClient(client)
+
+	if err := p.Publish(t.Context(), &broker.Message{Topic: "a"}); err != nil {
+		t.Fatalf("Publish(): error got '%v', want 'nil'", err)
+	}
+
+	if diff := cmp.Diff([]string{"a"}, client.topics()); diff != "" {
+		t.Errorf("Publish() mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := p.GetStatus().Delivery; got.Retries != 2 || got.DeadLetters != 0 {
+		t.Errorf("GetStatus().Delivery: got '%+v', want 2 retries and no dead letters", got)
+	}
+}
+
+func TestPublishDeadLetter(t *testing.T) {
+	store := &deadLetters{}
+	p := newPipeline(t, pipeline.Config{
+		Retry:      fastRetry,
+		DeadLetter: pipeline.DeadLetterConfig{Topic: "dead", Store: store},
+	})
+
+	client := &fakeClient{}
+	client.connected.Store(true)
+	client.failures.Store(3)
+	p.SetDestClient(client)
+
+	if err := p.Publish(t.Context(), &broker.Message{Topic: "a", Payload: []byte("{}")}); !errors.Is(err, errPublish) {
+		t.Fatalf("Publish(): error got '%v', want '%v'", err, errPublish)
+	}
+
+	if diff := cmp.Diff([]string{mtypes.DeadLetterPublish}, store.reasons()); diff != "" {
+		t.Errorf("SaveDeadLetter() mismatch (-want +got):\n%s", diff)
+	}
+	if letter := store.letters[0]; letter.Topic != "a" || string(letter.Payload) != "{}" || letter.Error == "" {
+		t.Errorf("SaveDeadLetter(): got '%+v'", letter)
+	}
+
+	// The broker recovered, so the dead letter itself is published.
+	if diff := cmp.Diff([]string{"dead"}, client.topics()); diff != "" {
+		t.Errorf("Publish() mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := p.GetStatus().Delivery; got.Retries != 2 || got.DeadLetters != 1 {
+		t.Errorf("GetStatus().Delivery: got '%+v', want 2 retries and 1 dead letter", got)
+	}
+}
+
+func TestPublishOutbox(t *testing.T) {
+	ob, err := outbox.Open(outbox.Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("outbox.Open(): error got '%v', want 'nil'", err)
+	}
+
+	p := newPipeline(t, pipeline.Config{Retry: fastRetry, Outbox: ob})
+
+	client := &fakeClient{}
+	p.SetDestClient(client)
+
+	for _, topic := range []string{"a", "b", "c"} {
+		if err = p.Publish(t.Context(), &broker.Message{Topic: topic}); err != nil {
+			t.Fatalf("Publish(%s): error got '%v', want 'nil'", topic, err)
+		}
+	}
+
+	if got := p.GetStatus().Delivery; got.Queued != 3 || got.Outbox != 3 {
+		t.Errorf("GetStatus().Delivery: got '%+v', want 3 queued", got)
+	}
+
+	// Once connected, a new message is queued behind the others and the outbox replayed in order.
+	client.connected.Store(true)
+	if err = p.Publish(t.Context(), &broker.Message{Topic: "d"}); err != nil {
+		t.Fatalf("Publish(d): error got '%v', want 'nil'", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ob.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if diff := cmp.Diff([]string{"a", "b", "c", "d"}, client.topics()); diff != "" {
+		t.Errorf("replay mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := p.GetStatus().Delivery; got.Replayed != 4 || got.Outbox != 0 {
+		t.Errorf("GetStatus().Delivery: got '%+v', want 4 replayed", got)
+	}
+}
+
+func TestDecodeDeadLetter(t *testing.T) {
+	store := &deadLetters{}
+	p := newPipeline(t, pipeline.Config{DeadLetter: pipeline.DeadLetterConfig{Store: store}})
+
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: []byte("not a protobuf")})
+
+	if diff := cmp.Diff([]string{mtypes.DeadLetterEnvelope}, store.reasons()); diff != "" {
+		t.Errorf("SaveDeadLetter() mismatch (-want +got):\n%s", diff)
+	}
+	if got := p.GetStatus().Delivery.DeadLetters; got != 1 {
+		t.Errorf("GetStatus().Delivery.DeadLetters: got '%d', want '1'", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	cfg := pipeline.RetryConfig{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	var got []time.Duration
+	for attempt := range 5 {
+		got = append(got, cfg.Delay(attempt))
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Delay() mismatch (-want +got):\n%s", diff)
+	}
+}
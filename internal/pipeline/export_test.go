@@ -0,0 +1,15 @@
+package pipeline
+
+import "github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+
+// SetDestClient sets the destination broker client without connecting.
+func (p *Pipeline) SetDestClient(client broker.Client) {
+	p.destClient = client
+}
+
+// StartWorkers starts the worker pool without connecting to the brokers.
+func (p *Pipeline) StartWorkers() {
+	if p.workers != nil {
+		p.workers.start()
+	}
+}
@@ -0,0 +1,35 @@
+package pipeline
+
+import (
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+)
+
+// Output is a named destination for decoded packets, e.g. the relay, fanout or message store.
+type Output struct {
+	// Name identifies the output in logs, metrics and status.
+	Name string
+	// Stages are applied in order before the packet is written, any stage may drop the packet.
+	Stages []Stage
+	// Sink writes the packets that pass every stage.
+	Sink Sink
+}
+
+// packetLabels returns the metric labels for a packet.
+func packetLabels(pkt *Packet) metrics.Labels {
+	return metrics.Labels{
+		PortNum: pkt.Message.Type,
+		Channel: pkt.Envelope.GetChannelId(),
+	}
+}
+
+// StoreOutputName is the name of the message store output.
+const StoreOutputName = "store"
+
+// NewStoreOutput returns the output saving every packet to the message store.
+func NewStoreOutput(st store.Store, m *metrics.Metrics) *Output {
+	return &Output{
+		Name: StoreOutputName,
+		Sink: &StoreSink{Store: st, Metrics: m},
+	}
+}
@@ -0,0 +1,505 @@
+// Package pipeline receives Meshtastic packets from the source broker, decodes each packet once and passes it
+// through a list of named outputs, each with its own stages and sink.
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/cmdconst"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/dedup"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/parser"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"github.com/na4ma4/go-contextual"
+	"github.com/na4ma4/go-slogtool"
+	"google.golang.org/protobuf/proto"
+)
+
+// metricsType is the type label recorded against metrics that are not specific to an output.
+const metricsType = "pipeline"
+
+var (
+	// ErrDestNotConnected is returned when publishing before the destination broker is connected.
+	ErrDestNotConnected = errors.New("destination broker not connected")
+	// errSourceNotConnected is logged when a downlink request arrives while the source broker is disconnected.
+	errSourceNotConnected = errors.New("source broker not connected")
+)
+
+// Pipeline subscribes to the source broker and passes each decoded packet to its outputs.
+type Pipeline struct {
+	Context      contextual.Context
+	Config       Config
+	Logger       *slog.Logger
+	Parser       *parser.Parser
+	stages       []Stage
+	outputs      []*Output
+	sourceClient broker.Client
+	destClient   broker.Client
+	dedup        *dedup.Cache
+	workers      *workerPool
+	delivery     delivery
+	wg           sync.WaitGroup
+	errChan      chan error
+}
+
+// NewPipeline creates a new pipeline, outputs are added with AddOutput before it is started.
+//
+// The node database and topology graph are updated once per packet, before the outputs.
+func NewPipeline(ctx contextual.Context, config Config, logger *slog.Logger) (*Pipeline, error) {
+	p := &Pipeline{
+		Context: ctx,
+		Config:  config,
+		Logger:  logger,
+		errChan: make(chan error, cmdconst.DefaultErrorChannelBufferSize),
+	}
+	p.Parser = parser.NewParser(logger,
+		parser.WithKeyring(config.Keyring),
+		parser.WithMetrics(config.Metrics, metricsType),
+	)
+	if config.NodeDB != nil {
+		p.stages = append(p.stages, ObserveNodes(config.NodeDB), EnrichNodes(config.NodeDB))
+	}
+	if config.Topology != nil {
+		p.stages = append(p.stages, ObserveTopology(config.Topology))
+	}
+	if config.Dedup.Mode != dedup.ModeOff {
+		p.dedup = dedup.NewCache(config.Dedup, p.dispatch)
+	}
+	if config.Workers.Workers > 0 {
+		p.workers = newWorkerPool(config.Workers, config.Metrics, logger, p.handle)
+	}
+	return p, nil
+}
+
+// AddOutput adds an output, packets are passed to the outputs in the order they are added.
+func (p *Pipeline) AddOutput(out *Output) {
+	p.outputs = append(p.outputs, out)
+}
+
+// Outputs returns the names of the outputs.
+func (p *Pipeline) Outputs() []string {
+	names := make([]string, 0, len(p.outputs))
+	for _, out := range p.outputs {
+		names = append(names, out.Name)
+	}
+	return names
+}
+
+func (p *Pipeline) connectDest(ctx context.Context) {
+	defer p.Logger.DebugContext(ctx, "connectDest(): finished")
+	p.Logger.DebugContext(ctx, "connectDest(): starting")
+
+	hooks := broker.Hooks{
+		OnConnect:      p.destOnConnectHandler,
+		OnReconnecting: p.reconnectingHandler("dest"),
+	}
+	if p.Config.Downlink != nil {
+		hooks.OnMessage = p.downlinkHandler
+	}
+
+	client, err := p.Config.Dest.NewClient(hooks)
+	if err != nil {
+		p.errChan <- fmt.Errorf("failed to configure destination broker: %w", err)
+		return
+	}
+
+	p.destClient = client
+	if err = client.Connect(ctx); err != nil {
+		if ctx.Err() != nil {
+			p.Logger.InfoContext(ctx, "Context done before destination broker connected")
+			return
+		}
+
+		p.errChan <- fmt.Errorf("failed to connect to destination broker: %w", err)
+		return
+	}
+
+	if p.Config.DryRun {
+		p.Logger.InfoContext(ctx, "Dry run enabled, not publishing to destination broker")
+		p.destClient.Disconnect()
+	}
+}
+
+func (p *Pipeline) connectSrc(ctx context.Context) {
+	defer p.Logger.DebugContext(ctx, "connectSrc(): finished")
+	p.Logger.DebugContext(ctx, "connectSrc(): starting")
+
+	client, err := p.Config.Source.NewClient(broker.Hooks{
+		OnConnect:      p.srcOnConnectHandler,
+		OnReconnecting: p.reconnectingHandler("source"),
+		OnMessage:      p.HandleMessage,
+		// Without workers messages are handled as they are received, including retrying publishes, so they are
+		// handled concurrently rather than stalling the source client. Workers keep the messages of a node in order.
+		Concurrent: p.workers == nil,
+	})
+	if err != nil {
+		p.errChan <- fmt.Errorf("failed to configure source broker: %w", err)
+		return
+	}
+
+	p.sourceClient = client
+	if err = client.Connect(ctx); err != nil {
+		if ctx.Err() != nil {
+			p.Logger.InfoContext(ctx, "Context done before source broker connected")
+			return
+		}
+
+		p.errChan <- fmt.Errorf("failed to connect to source broker: %w", err)
+	}
+}
+
+// Start begins the pipeline operation.
+func (p *Pipeline) Start(ctx context.Context) {
+	if p.workers != nil {
+		p.workers.start()
+	}
+
+	// Connect to destination broker first
+	go p.connectDest(ctx)
+
+	// Connect to source broker
+	go p.connectSrc(ctx)
+
+	if p.dedup != nil {
+		go p.dedup.Run(ctx)
+	}
+
+	if p.Config.Topology != nil && p.Config.Topology.Config.Topic != "" {
+		go p.runTopology(ctx)
+	}
+}
+
+// runTopology periodically publishes the mesh adjacency graph as a retained message until the context is done.
+func (p *Pipeline) runTopology(ctx context.Context) {
+	ticker := time.NewTicker(p.Config.Topology.Config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.publishTopology(ctx)
+		}
+	}
+}
+
+// publishTopology publishes the mesh adjacency graph as a retained message.
+func (p *Pipeline) publishTopology(ctx context.Context) {
+	if p.Config.DryRun || p.destClient == nil || !p.destClient.IsConnected() {
+		return
+	}
+
+	payload, err := p.Config.Topology.Snapshot().ToJSON()
+	if err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to encode topology", slogtool.ErrorAttr(err))
+		return
+	}
+
+	msg := &broker.Message{
+		Topic:      p.Config.Topology.Config.Topic,
+		Payload:    payload,
+		Retain:     true,
+		Properties: broker.Properties{ContentType: contentTypeJSON},
+	}
+	if err = p.destClient.Publish(ctx, msg); err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to publish topology", slogtool.ErrorAttr(err))
+		return
+	}
+
+	p.Logger.DebugContext(ctx, "Published topology", slog.String("topic", msg.Topic))
+}
+
+func (p *Pipeline) reconnectingHandler(client string) func() {
+	return func() {
+		p.Logger.Warn("Reconnecting to MQTT broker", slog.String("client", client))
+		p.Config.Metrics.Reconnect(metricsType, client)
+	}
+}
+
+func (p *Pipeline) destOnConnectHandler(client broker.Client) {
+	p.Logger.Info("Connected to destination MQTT broker", slog.Bool("dest.connected", client.IsConnected()))
+	p.startReplay()
+
+	if p.Config.Downlink == nil || p.Config.Downlink.Config.Topic == "" {
+		return
+	}
+
+	// Subscribe to downlink topic
+	topic := p.Config.Downlink.Config.Topic
+	if err := client.Subscribe(p.Context, topic); err != nil {
+		if p.Context.Err() != nil {
+			p.Logger.InfoContext(p.Context, "Context done before downlink subscription completed")
+			return
+		}
+
+		p.errChan <- fmt.Errorf("failed to subscribe to downlink topic: %w", err)
+		return
+	}
+	p.Logger.Info("Subscribed to downlink topic", slog.String("topic", topic))
+}
+
+func (p *Pipeline) srcOnConnectHandler(client broker.Client) {
+	p.Logger.Info("Connected to source MQTT broker", slog.Bool("src.connected", client.IsConnected()))
+	// Subscribe to source topics
+	for _, filter := range []string{p.Config.Topic, p.Config.MapTopic} {
+		if filter == "" {
+			continue
+		}
+
+		topic := broker.SharedTopic(p.Config.SharedGroup, filter)
+		if err := client.Subscribe(p.Context, topic); err != nil {
+			if p.Context.Err() != nil {
+				p.Logger.InfoContext(p.Context, "Context done before subscription completed")
+				return
+			}
+
+			p.errChan <- fmt.Errorf("failed to subscribe to topic: %w", err)
+			return
+		}
+		p.Logger.Info("Subscribed to topic", slog.String("topic", topic))
+	}
+}
+
+func (p *Pipeline) Run(ctx context.Context) <-chan error {
+	defer p.Logger.DebugContext(ctx, "Run(): finished")
+	p.Logger.DebugContext(ctx, "Run(): starting")
+
+	outErrChan := make(chan error, 1)
+
+	go func() {
+		defer p.Logger.DebugContext(ctx, "Run().go func(): finished")
+		p.Logger.DebugContext(ctx, "Run().go func(): starting")
+
+		defer close(outErrChan)
+		defer p.Stop(ctx)
+		p.Start(ctx)
+
+		for {
+			select {
+			case <-ctx.Done():
+				outErrChan <- ctx.Err()
+				return
+			case err := <-p.errChan:
+				outErrChan <- err
+				return
+			}
+		}
+	}()
+
+	return outErrChan
+}
+
+// Stop stops the pipeline.
+func (p *Pipeline) Stop(ctx context.Context) {
+	if p.sourceClient != nil && p.sourceClient.IsConnected() {
+		p.sourceClient.Disconnect()
+		p.Logger.InfoContext(ctx, "Disconnected from source MQTT broker")
+	}
+	// Wait for in-flight messages so packets they add to the de-duplication cache are flushed.
+	p.wg.Wait()
+	if p.workers != nil {
+		p.workers.close()
+	}
+	if p.dedup != nil {
+		// Publish packets still waiting for their settle window before disconnecting.
+		p.dedup.Flush(context.WithoutCancel(ctx))
+	}
+	if p.destClient != nil && p.destClient.IsConnected() {
+		p.destClient.Disconnect()
+		p.Logger.InfoContext(ctx, "Disconnected from destination MQTT broker")
+	}
+}
+
+// HandleMessage decodes a message received from the source broker and passes it to the outputs.
+//
+// With a worker pool the envelope is unmarshalled and the message queued for the worker of the sending node,
+// otherwise the message is handled before HandleMessage returns.
+func (p *Pipeline) HandleMessage(msg *broker.Message) {
+	p.wg.Add(1)
+	defer p.wg.Done()
+
+	envelope := p.unmarshalEnvelope(p.Context, msg)
+	if envelope == nil {
+		return
+	}
+
+	j := &job{msg: msg, envelope: envelope}
+	if p.workers == nil {
+		p.handle(j)
+		return
+	}
+
+	p.workers.submit(p.Context, j)
+}
+
+// handle decodes the packet of a job and passes it to the outputs.
+func (p *Pipeline) handle(j *job) {
+	ctx, cancel := contextual.WithTimeout(p.Context, time.Minute)
+	defer cancel()
+
+	pkt := p.decodePacket(ctx, j.envelope, j.msg.Payload, j.msg.Topic)
+	if pkt == nil {
+		return
+	}
+
+	if p.dedup != nil {
+		if !p.dedup.Add(ctx, pkt) {
+			p.Logger.DebugContext(ctx, "Duplicate packet",
+				slog.String("topic", j.msg.Topic),
+				slog.String("from", mtypes.FormatNodeID(pkt.Message.From)),
+				slog.Uint64("id", uint64(pkt.Message.ID)),
+			)
+			p.Config.Metrics.Duplicate(metricsType)
+		}
+		return
+	}
+
+	p.dispatch(ctx, pkt)
+}
+
+// dispatch runs the shared stages and passes the packet to each output.
+func (p *Pipeline) dispatch(ctx context.Context, pkt *Packet) {
+	for _, stage := range p.stages {
+		if !stage.Process(ctx, pkt) {
+			return
+		}
+	}
+
+	for _, out := range p.outputs {
+		p.write(ctx, out, pkt)
+	}
+}
+
+// write runs the stages of an output and writes the packet to its sink.
+func (p *Pipeline) write(ctx context.Context, out *Output, pkt *Packet) {
+	labels := packetLabels(pkt)
+	p.Config.Metrics.MessageReceived(out.Name, labels)
+
+	for _, stage := range out.Stages {
+		if !stage.Process(ctx, pkt) {
+			p.Config.Metrics.MessageDropped(out.Name, labels)
+			return
+		}
+	}
+
+	if err := out.Sink.Write(ctx, pkt); err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to write message",
+			slog.String("output", out.Name),
+			slogtool.ErrorAttr(err),
+		)
+		if errors.Is(err, ErrEncodeJSON) {
+			p.Config.Metrics.DecodeError(out.Name, metrics.StageJSON)
+			p.deadLetter(ctx, mtypes.DeadLetterJSON, pkt.Topic, pkt.Payload, err)
+			return
+		}
+		p.Config.Metrics.MessageFailed(out.Name, labels)
+		return
+	}
+
+	p.Config.Metrics.MessagePublished(out.Name, labels)
+}
+
+// downlinkHandler encodes JSON downlink requests and publishes them to the source broker for gateways to transmit.
+func (p *Pipeline) downlinkHandler(msg *broker.Message) {
+	p.wg.Add(1)
+	defer p.wg.Done()
+
+	ctx, cancel := contextual.WithTimeout(p.Context, time.Minute)
+	defer cancel()
+
+	out, err := p.Config.Downlink.Encode(msg.Payload)
+	if err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to encode downlink request",
+			slog.String("topic", msg.Topic),
+			slogtool.ErrorAttr(err),
+		)
+		p.Config.Metrics.DecodeError(metricsType, metrics.StageDownlink)
+		return
+	}
+
+	if p.Config.DryRun {
+		p.Logger.DebugContext(ctx, "Dry run enabled, not publishing downlink", slog.String("topic", out.Topic))
+		return
+	}
+
+	if p.sourceClient == nil || !p.sourceClient.IsConnected() {
+		p.Logger.ErrorContext(ctx, "Failed to publish downlink", slogtool.ErrorAttr(errSourceNotConnected))
+		return
+	}
+
+	if err = p.sourceClient.Publish(ctx, out); err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to publish downlink", slogtool.ErrorAttr(err))
+		return
+	}
+
+	p.Logger.InfoContext(ctx, "> [downlink]", slog.String("topic", out.Topic))
+}
+
+// unmarshalEnvelope unmarshals the message payload as a ServiceEnvelope (the standard Meshtastic MQTT format),
+// returning nil if it could not be unmarshalled.
+func (p *Pipeline) unmarshalEnvelope(ctx context.Context, msg *broker.Message) *meshtastic.ServiceEnvelope {
+	envelope := &meshtastic.ServiceEnvelope{}
+	if err := proto.Unmarshal(msg.Payload, envelope); err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to unmarshal ServiceEnvelope", slogtool.ErrorAttr(err))
+		p.Config.Metrics.DecodeError(metricsType, metrics.StageEnvelope)
+		p.deadLetter(ctx, mtypes.DeadLetterEnvelope, msg.Topic, msg.Payload, err)
+		return nil
+	}
+
+	return envelope
+}
+
+// decodePacket decrypts the envelope and converts it to a message, returning nil if it could not be decoded.
+func (p *Pipeline) decodePacket(
+	ctx context.Context,
+	envelope *meshtastic.ServiceEnvelope,
+	payload []byte,
+	topic string,
+) *Packet {
+	p.Parser.Decrypt(ctx, envelope)
+
+	if p.Logger.Enabled(ctx, slog.LevelDebug) {
+		p.Logger.DebugContext(ctx, "Received message",
+			slog.String("topic", topic),
+			slog.String("payload", base64.StdEncoding.EncodeToString(payload)),
+		)
+	}
+	if envelope.GetPacket() == nil {
+		p.Logger.InfoContext(ctx, "<", slog.String("topic", topic))
+	} else {
+		if dc := envelope.GetPacket().GetDecoded(); dc != nil {
+			p.Logger.InfoContext(ctx, "<", slog.String("topic", topic), slog.String("portnum", dc.GetPortnum().String()))
+		} else {
+			p.Logger.InfoContext(ctx, "< [encrypted]", slog.String("topic", topic))
+		}
+	}
+
+	message, err := p.Parser.ConvertToMessage(ctx, topic, payload, envelope)
+	if err != nil {
+		p.Logger.ErrorContext(ctx, "Failed to convert to Message", slogtool.ErrorAttr(err))
+		p.Config.Metrics.DecodeError(metricsType, metrics.StageMessage)
+		p.deadLetter(ctx, mtypes.DeadLetterMessage, topic, payload, err)
+		return nil
+	}
+
+	pkt := &Packet{
+		Topic:    topic,
+		Payload:  payload,
+		Envelope: envelope,
+		Message:  message,
+	}
+
+	p.Config.Metrics.ObserveNode(message)
+
+	return pkt
+}
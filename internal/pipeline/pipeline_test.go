@@ -0,0 +1,262 @@
+package pipeline_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/filter"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/parser"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/na4ma4/go-contextual"
+	"google.golang.org/protobuf/proto"
+)
+
+const testTopic = "msh/ANZ/2/e/MediumFast/!44be043f"
+
+// recorder is a pipeline.Sink and pipeline.Publisher recording what it is given.
+type recorder struct {
+	lock    sync.Mutex
+	packets []*pipeline.Packet
+	msgs    []*broker.Message
+}
+
+func (r *recorder) Write(_ context.Context, pkt *pipeline.Packet) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.packets = append(r.packets, pkt)
+	return nil
+}
+
+func (r *recorder) Publish(_ context.Context, msg *broker.Message) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func loadPayload(t *testing.T, filename string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path.Join("..", "..", "testdata", "msgs", filename+".enc"))
+	if err != nil {
+		t.Fatalf("Failed to read test case file %s: %v", filename, err)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	return payload
+}
+
+func newPipeline(t *testing.T, config pipeline.Config) *pipeline.Pipeline {
+	t.Helper()
+
+	p, err := pipeline.NewPipeline(contextual.New(t.Context()), config, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+
+	return p
+}
+
+func TestSharedDecode(t *testing.T) {
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+	p := newPipeline(t, pipeline.Config{NodeDB: db})
+
+	first, second := &recorder{}, &recorder{}
+	p.AddOutput(&pipeline.Output{Name: "first", Sink: first})
+	p.AddOutput(&pipeline.Output{Name: "second", Sink: second})
+
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-03")})
+
+	if len(first.packets) != 1 || len(second.packets) != 1 {
+		t.Fatalf("Expected each output to receive 1 packet, got %d and %d", len(first.packets), len(second.packets))
+	}
+	if first.packets[0] != second.packets[0] {
+		t.Error("Expected outputs to share the decoded packet")
+	}
+	if db.Len() != 1 {
+		t.Errorf("Expected 1 node in database, got %d", db.Len())
+	}
+	if msg := first.packets[0].Message; msg.FromNode == nil || msg.FromNode.LongName == "" {
+		t.Errorf("Expected FromNode to be enriched before the outputs, got %+v", msg.FromNode)
+	}
+
+	if diff := cmp.Diff([]string{"first", "second"}, p.Outputs()); diff != "" {
+		t.Errorf("Outputs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOutputStages(t *testing.T) {
+	p := newPipeline(t, pipeline.Config{})
+
+	textOnly := pipeline.StageFunc(func(_ context.Context, pkt *pipeline.Packet) bool {
+		return pkt.Message.Type == meshtastic.PortNum_TEXT_MESSAGE_APP.String()
+	})
+
+	all, text := &recorder{}, &recorder{}
+	p.AddOutput(&pipeline.Output{Name: "all", Sink: all})
+	p.AddOutput(&pipeline.Output{Name: "text", Stages: []pipeline.Stage{textOnly}, Sink: text})
+
+	for _, file := range []string{"message-01", "message-03", "message-04", "message-12"} {
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, file)})
+	}
+
+	if len(all.packets) != 4 {
+		t.Errorf("Expected 4 packets, got %d", len(all.packets))
+	}
+	if len(text.packets) != 1 || text.packets[0].Message.Type != meshtastic.PortNum_TEXT_MESSAGE_APP.String() {
+		t.Errorf("Expected only the text message, got %d packets", len(text.packets))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	p := newPipeline(t, pipeline.Config{})
+
+	rec := &recorder{}
+	p.AddOutput(&pipeline.Output{
+		Name:   "filtered",
+		Stages: []pipeline.Stage{pipeline.Filter(filter.MustCompile(`from == "!a0cbc3a8" && type != "POSITION_APP"`))},
+		Sink:   rec,
+	})
+
+	for _, file := range []string{"message-01", "message-03", "message-04", "message-12"} {
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, file)})
+	}
+
+	var got []string
+	for _, pkt := range rec.packets {
+		got = append(got, pkt.Message.Type)
+	}
+	if diff := cmp.Diff([]string{"NODEINFO_APP", "WAYPOINT_APP"}, got); diff != "" {
+		t.Errorf("Filter() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDropEncrypted(t *testing.T) {
+	p := newPipeline(t, pipeline.Config{})
+
+	rec := &recorder{}
+	p.AddOutput(&pipeline.Output{Name: "decoded", Stages: []pipeline.Stage{pipeline.DropEncrypted()}, Sink: rec})
+
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-11")})
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-03")})
+
+	if len(rec.packets) != 1 || rec.packets[0].Message.Type == mtypes.TypeEncrypted {
+		t.Errorf("Expected only the decoded packet, got %d packets", len(rec.packets))
+	}
+}
+
+func TestMQTTSink(t *testing.T) {
+	p := newPipeline(t, pipeline.Config{})
+
+	rec := &recorder{}
+	p.AddOutput(&pipeline.Output{Name: "mqtt", Sink: &pipeline.MQTTSink{
+		Publisher: rec,
+		Topic:     func(pkt *pipeline.Packet) string { return "out/" + pkt.Message.Type },
+		Retain:    true,
+	}})
+
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-03")})
+
+	if len(rec.msgs) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(rec.msgs))
+	}
+	msg := rec.msgs[0]
+	if msg.Topic != "out/NODEINFO_APP" || !msg.Retain || !json.Valid(msg.Payload) {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+	if msg.Properties.ContentType != "application/json" {
+		t.Errorf("Unexpected content type %q", msg.Properties.ContentType)
+	}
+}
+
+func TestStoreOutput(t *testing.T) {
+	dir := t.TempDir()
+	p := newPipeline(t, pipeline.Config{})
+	p.AddOutput(pipeline.NewStoreOutput(store.NewJSONDirStore(dir, store.Config{}), nil))
+
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-12")})
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*_WAYPOINT_APP.json"))
+	if err != nil {
+		t.Fatalf("Glob() error: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("Expected 1 stored message, got %v", matches)
+	}
+}
+
+func TestMessageProperties(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		expiry time.Duration
+		want   broker.Properties
+	}{
+		{"telemetry", "message-19", time.Hour, broker.Properties{
+			ContentType:   "application/json",
+			MessageExpiry: time.Hour,
+			User: []broker.UserProperty{
+				{Key: "portnum", Value: "TELEMETRY_APP"},
+				{Key: "from", Value: "!a0cbc3a8"},
+				{Key: "to", Value: "!ffffffff"},
+				{Key: "channel", Value: "MediumFast"},
+				{Key: "gateway", Value: "!44be043f"},
+				{Key: "packet_id", Value: "1234567897"},
+			},
+		}},
+		{"not-telemetry", "message-15", time.Hour, broker.Properties{
+			ContentType: "application/json",
+			User: []broker.UserProperty{
+				{Key: "portnum", Value: "PAXCOUNTER_APP"},
+				{Key: "from", Value: "!a0cbc3a8"},
+				{Key: "to", Value: "!ffffffff"},
+				{Key: "channel", Value: "MediumFast"},
+				{Key: "gateway", Value: "!44be043f"},
+				{Key: "packet_id", Value: "1234567893"},
+			},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := loadPayload(t, tt.file)
+
+			var envelope meshtastic.ServiceEnvelope
+			if err := proto.Unmarshal(payload, &envelope); err != nil {
+				t.Fatalf("Failed to unmarshal envelope: %v", err)
+			}
+
+			p := parser.NewParser(slog.New(slog.DiscardHandler))
+			msg, err := p.ConvertToMessage(context.Background(), testTopic, payload, &envelope)
+			if err != nil {
+				t.Fatalf("ConvertToMessage() error: %v", err)
+			}
+
+			got := pipeline.MessageProperties(&pipeline.Packet{Envelope: &envelope, Message: msg}, tt.expiry)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("MessageProperties() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+// contentTypeJSON is the content type of the published JSON messages.
+const contentTypeJSON = "application/json"
+
+// MessageProperties returns the MQTT 5 properties published with a packet, describing the packet in user
+// properties so subscribers can route messages without decoding them.
+//
+// Telemetry expires after telemetryExpiry, if set, so stale readings are not delivered to offline subscribers.
+func MessageProperties(pkt *Packet, telemetryExpiry time.Duration) broker.Properties {
+	msg := pkt.Message
+	props := broker.Properties{
+		ContentType: contentTypeJSON,
+		User: []broker.UserProperty{
+			{Key: "portnum", Value: msg.Type},
+			{Key: "from", Value: mtypes.FormatNodeID(msg.From)},
+			{Key: "to", Value: mtypes.FormatNodeID(msg.To)},
+			{Key: "channel", Value: pkt.Envelope.GetChannelId()},
+			{Key: "gateway", Value: pkt.Envelope.GetGatewayId()},
+			{Key: "packet_id", Value: strconv.FormatUint(uint64(msg.ID), 10)},
+		},
+	}
+
+	if msg.Type == meshtastic.PortNum_TELEMETRY_APP.String() {
+		props.MessageExpiry = telemetryExpiry
+	}
+
+	return props
+}
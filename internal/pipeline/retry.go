@@ -0,0 +1,44 @@
+package pipeline
+
+import "time"
+
+const (
+	// DefaultRetryAttempts is the default number of attempts to publish a message.
+	DefaultRetryAttempts = 3
+	// DefaultRetryBackoff is the default delay before the first retry.
+	DefaultRetryBackoff = 500 * time.Millisecond
+	// DefaultRetryMaxBackoff is the default maximum delay between retries.
+	DefaultRetryMaxBackoff = 10 * time.Second
+)
+
+// RetryConfig is the exponential backoff applied when a publish to the destination broker, or a write by an
+// output sink, fails.
+type RetryConfig struct {
+	// Attempts is the number of attempts to publish a message, a message is attempted once if zero.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled for each retry after it.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries, uncapped if zero.
+	MaxBackoff time.Duration
+}
+
+// MaxAttempts returns the number of attempts to publish a message.
+func (c RetryConfig) MaxAttempts() int {
+	return max(c.Attempts, 1)
+}
+
+// Delay returns the delay before the retry following the attempt, counting from zero.
+func (c RetryConfig) Delay(attempt int) time.Duration {
+	d := c.Backoff
+	for range attempt {
+		d *= 2
+		if c.MaxBackoff > 0 && d >= c.MaxBackoff {
+			return c.MaxBackoff
+		}
+	}
+
+	if c.MaxBackoff > 0 {
+		return min(d, c.MaxBackoff)
+	}
+	return d
+}
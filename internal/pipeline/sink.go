@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
+)
+
+// ErrEncodeJSON is returned by a sink when the message can not be encoded as JSON.
+var ErrEncodeJSON = errors.New("unable to convert message to JSON")
+
+// Sink writes packets that passed the stages of an output.
+type Sink interface {
+	Write(ctx context.Context, pkt *Packet) error
+}
+
+// Publisher publishes messages to a broker.
+type Publisher interface {
+	Publish(ctx context.Context, msg *broker.Message) error
+}
+
+// TopicFunc returns the topic a packet is published to.
+type TopicFunc func(pkt *Packet) string
+
+// MQTTSink publishes the JSON translation of each packet.
+type MQTTSink struct {
+	Publisher Publisher
+	Topic     TopicFunc
+	Retain    bool
+	// TelemetryExpiry is the MQTT 5 message expiry of published telemetry, optional.
+	TelemetryExpiry time.Duration
+}
+
+// Write publishes the JSON translation of the packet.
+func (s *MQTTSink) Write(ctx context.Context, pkt *Packet) error {
+	payload, err := pkt.Message.ToJSON()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEncodeJSON, err)
+	}
+
+	return s.Publisher.Publish(ctx, &broker.Message{
+		Topic:      s.Topic(pkt),
+		Payload:    payload,
+		Retain:     s.Retain,
+		Properties: MessageProperties(pkt, s.TelemetryExpiry),
+	})
+}
+
+// StoreSink saves each packet to a message store.
+type StoreSink struct {
+	Store   store.Store
+	Metrics *metrics.Metrics
+}
+
+// Write saves the packet, keyed by packet ID, with its raw payload and translated message.
+func (s *StoreSink) Write(ctx context.Context, pkt *Packet) error {
+	messageID := strconv.FormatInt(int64(pkt.Envelope.GetPacket().GetId()), 10)
+
+	portNum := pkt.Message.Type
+	if dc := pkt.Envelope.GetPacket().GetDecoded(); dc != nil {
+		portNum = dc.GetPortnum().String()
+	}
+
+	start := time.Now()
+	err := s.Store.Save(ctx, messageID, portNum, pkt.Payload, pkt.Message)
+	s.Metrics.ObserveStoreSave(time.Since(start))
+	if err != nil {
+		return fmt.Errorf("unable to save message %s: %w", messageID, err)
+	}
+
+	return nil
+}
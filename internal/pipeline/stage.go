@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/dedup"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/filter"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/topology"
+)
+
+// Packet is a decoded packet passed through the pipeline stages.
+type Packet = dedup.Packet
+
+// Stage processes a packet, returning false to drop it.
+type Stage interface {
+	Process(ctx context.Context, pkt *Packet) bool
+}
+
+// StageFunc adapts a function to a Stage.
+type StageFunc func(ctx context.Context, pkt *Packet) bool
+
+// Process calls f(ctx, pkt).
+func (f StageFunc) Process(ctx context.Context, pkt *Packet) bool {
+	return f(ctx, pkt)
+}
+
+// ObserveNodes updates the node database from the packet.
+//
+// Run it once per packet, before the outputs, so each packet is observed once however many outputs there are.
+func ObserveNodes(db *nodedb.DB) Stage {
+	return StageFunc(func(_ context.Context, pkt *Packet) bool {
+		db.Observe(pkt.Message)
+		return true
+	})
+}
+
+// EnrichNodes adds the names of the sending and receiving nodes from the node database to the message.
+func EnrichNodes(db *nodedb.DB) Stage {
+	return StageFunc(func(_ context.Context, pkt *Packet) bool {
+		db.Enrich(pkt.Message)
+		return true
+	})
+}
+
+// ObserveTopology updates the mesh adjacency graph from the packet.
+func ObserveTopology(g *topology.Graph) Stage {
+	return StageFunc(func(_ context.Context, pkt *Packet) bool {
+		g.Observe(pkt.Message)
+		return true
+	})
+}
+
+// DropEncrypted drops packets without a packet or that could not be decrypted.
+func DropEncrypted() Stage {
+	return StageFunc(func(_ context.Context, pkt *Packet) bool {
+		return pkt.Envelope.GetPacket().GetDecoded() != nil
+	})
+}
+
+// Filter drops packets that do not match the filter expression.
+func Filter(f *filter.Filter) Stage {
+	return StageFunc(func(_ context.Context, pkt *Packet) bool {
+		return f.Match(pkt)
+	})
+}
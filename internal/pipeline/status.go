@@ -0,0 +1,34 @@
+package pipeline
+
+import "github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+
+type Status struct {
+	SourceBrokerConnected bool           `json:"source_broker_connected"`
+	DestBrokerConnected   bool           `json:"dest_broker_connected"`
+	Source                broker.Status  `json:"source"`
+	Dest                  broker.Status  `json:"dest"`
+	Outputs               []string       `json:"outputs"`
+	Delivery              DeliveryStatus `json:"delivery"`
+	Queue                 *QueueStatus   `json:"queue,omitempty"`
+	Status                bool           `json:"status"`
+}
+
+func (p *Pipeline) GetStatus() Status {
+	source := p.Config.Source.Status(p.sourceClient)
+	dest := p.Config.Dest.Status(p.destClient)
+	var queue *QueueStatus
+	if p.workers != nil {
+		st := p.workers.status()
+		queue = &st
+	}
+	return Status{
+		SourceBrokerConnected: source.Connected,
+		DestBrokerConnected:   dest.Connected,
+		Source:                source,
+		Dest:                  dest,
+		Outputs:               p.Outputs(),
+		Delivery:              p.deliveryStatus(),
+		Queue:                 queue,
+		Status:                source.Connected && dest.Connected,
+	}
+}
@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+// QueuePolicy is what happens to a message received when the queue of its worker is full.
+type QueuePolicy string
+
+const (
+	// QueueBlock waits for room in the queue, slowing the source broker client down.
+	QueueBlock QueuePolicy = "block"
+	// QueueDropNewest drops the message received.
+	QueueDropNewest QueuePolicy = "drop-newest"
+	// QueueDropOldest drops the oldest message in the queue to make room for the message received.
+	QueueDropOldest QueuePolicy = "drop-oldest"
+)
+
+const (
+	// DefaultWorkers is the default number of workers.
+	DefaultWorkers = 4
+	// DefaultQueueSize is the default capacity of the queue of each worker.
+	DefaultQueueSize = 256
+)
+
+// ErrInvalidQueuePolicy is returned when parsing an unknown queue policy.
+var ErrInvalidQueuePolicy = errors.New("invalid queue policy")
+
+// ParseQueuePolicy parses a queue policy, "" is QueueBlock.
+func ParseQueuePolicy(in string) (QueuePolicy, error) {
+	switch QueuePolicy(in) {
+	case "", QueueBlock:
+		return QueueBlock, nil
+	case QueueDropNewest, QueueDropOldest:
+		return QueuePolicy(in), nil
+	default:
+		return QueueBlock, fmt.Errorf("%w: %q", ErrInvalidQueuePolicy, in)
+	}
+}
+
+// WorkerConfig holds the worker pool configuration.
+type WorkerConfig struct {
+	// Workers is the number of workers decoding packets and writing them to the outputs, if zero each message is
+	// handled in its own goroutine as it is received, and messages are not kept in order.
+	Workers int
+	// QueueSize is the capacity of the queue of each worker, DefaultQueueSize if zero.
+	QueueSize int
+	// Policy is applied when the queue of a worker is full.
+	Policy QueuePolicy
+}
+
+// QueueStatus is the status of the worker pool.
+type QueueStatus struct {
+	Workers int `json:"workers"`
+	// Capacity is the total capacity of the worker queues.
+	Capacity int `json:"capacity"`
+	// Depth is the number of messages waiting in the worker queues.
+	Depth int `json:"depth"`
+	// Dropped is the number of messages dropped because a worker queue was full.
+	Dropped uint64 `json:"dropped"`
+}
+
+// job is a message received from the source broker, with its envelope, waiting for a worker.
+type job struct {
+	msg      *broker.Message
+	envelope *meshtastic.ServiceEnvelope
+}
+
+// workerPool passes messages to a fixed number of workers, each with a bounded queue.
+//
+// Messages are assigned a worker by the node that sent them, so the messages from a node are handled in order.
+type workerPool struct {
+	config  WorkerConfig
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+	handle  func(j *job)
+
+	queues  []chan *job
+	dropped atomic.Uint64
+	lock    sync.RWMutex
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func newWorkerPool(cfg WorkerConfig, m *metrics.Metrics, logger *slog.Logger, handle func(j *job)) *workerPool {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = QueueBlock
+	}
+
+	w := &workerPool{
+		config:  cfg,
+		metrics: m,
+		logger:  logger,
+		handle:  handle,
+		queues:  make([]chan *job, cfg.Workers),
+	}
+	for i := range w.queues {
+		w.queues[i] = make(chan *job, cfg.QueueSize)
+	}
+
+	return w
+}
+
+// start starts the workers, they run until the pool is closed.
+func (w *workerPool) start() {
+	for i, queue := range w.queues {
+		w.wg.Add(1)
+		go w.run(strconv.Itoa(i), queue)
+	}
+}
+
+func (w *workerPool) run(worker string, queue chan *job) {
+	defer w.wg.Done()
+
+	for j := range queue {
+		w.metrics.SetQueueDepth(worker, len(queue))
+		w.handle(j)
+	}
+}
+
+// submit queues the job on the worker for the sending node, applying the queue policy if the queue is full.
+func (w *workerPool) submit(ctx context.Context, j *job) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.closed {
+		w.drop(ctx, j)
+		return
+	}
+
+	i := int(j.envelope.GetPacket().GetFrom() % uint32(len(w.queues))) //nolint:gosec // len is a small positive int.
+	queue := w.queues[i]
+	worker := strconv.Itoa(i)
+	defer func() { w.metrics.SetQueueDepth(worker, len(queue)) }()
+
+	select {
+	case queue <- j:
+		return
+	default:
+	}
+
+	switch w.config.Policy {
+	case QueueDropNewest:
+		w.drop(ctx, j)
+	case QueueDropOldest:
+		for {
+			select {
+			case queue <- j:
+				return
+			case old := <-queue:
+				w.drop(ctx, old)
+			}
+		}
+	default:
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+			w.drop(ctx, j)
+		}
+	}
+}
+
+func (w *workerPool) drop(ctx context.Context, j *job) {
+	w.dropped.Add(1)
+	w.metrics.QueueDropped()
+	w.logger.WarnContext(ctx, "Worker queue full, dropped message",
+		slog.String("topic", j.msg.Topic),
+		slog.String("from", mtypes.FormatNodeID(j.envelope.GetPacket().GetFrom())),
+		slog.String("policy", string(w.config.Policy)),
+	)
+}
+
+// close stops accepting messages and waits for the workers to handle the messages already queued.
+func (w *workerPool) close() {
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		for _, queue := range w.queues {
+			close(queue)
+		}
+	}
+	w.lock.Unlock()
+
+	w.wg.Wait()
+}
+
+// status returns the status of the worker pool.
+func (w *workerPool) status() QueueStatus {
+	st := QueueStatus{
+		Workers:  len(w.queues),
+		Capacity: len(w.queues) * w.config.QueueSize,
+		Dropped:  w.dropped.Load(),
+	}
+	for _, queue := range w.queues {
+		st.Depth += len(queue)
+	}
+
+	return st
+}
@@ -0,0 +1,190 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+)
+
+// withPacketID returns the fixture with the packet ID replaced, so copies of a fixture can be told apart.
+func withPacketID(t *testing.T, filename string, id uint32) []byte {
+	t.Helper()
+
+	envelope := &meshtastic.ServiceEnvelope{}
+	if err := proto.Unmarshal(loadPayload(t, filename), envelope); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", filename, err)
+	}
+	envelope.Packet.Id = id
+
+	payload, err := proto.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal %s: %v", filename, err)
+	}
+
+	return payload
+}
+
+// blockingSink is a pipeline.Sink recording packet IDs, each write waits for release.
+type blockingSink struct {
+	started chan struct{}
+	release chan struct{}
+
+	lock sync.Mutex
+	ids  []uint32
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{started: make(chan struct{}, 10), release: make(chan struct{}, 10)}
+}
+
+func (s *blockingSink) Write(_ context.Context, pkt *pipeline.Packet) error {
+	s.started <- struct{}{}
+	<-s.release
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.ids = append(s.ids, pkt.Message.ID)
+	return nil
+}
+
+func TestWorkersNodeOrder(t *testing.T) {
+	p := newPipeline(t, pipeline.Config{Workers: pipeline.WorkerConfig{Workers: 4, QueueSize: 8}})
+
+	var lock sync.Mutex
+	ids := map[uint32][]uint32{}
+	p.AddOutput(&pipeline.Output{Name: "ordered", Sink: sinkFunc(func(pkt *pipeline.Packet) {
+		time.Sleep(time.Duration(rand.IntN(100)) * time.Microsecond) //nolint:gosec // jitter only.
+
+		lock.Lock()
+		defer lock.Unlock()
+		ids[pkt.Message.From] = append(ids[pkt.Message.From], pkt.Message.ID)
+	})})
+	p.StartWorkers()
+
+	var want []uint32
+	for i := range uint32(50) {
+		want = append(want, i)
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: withPacketID(t, "message-01", i)})
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: withPacketID(t, "message-04", i)})
+	}
+	p.Stop(t.Context())
+
+	if len(ids) != 2 {
+		t.Fatalf("Expected packets from 2 nodes, got %d", len(ids))
+	}
+	for from, got := range ids {
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("node %08x order mismatch (-want +got):\n%s", from, diff)
+		}
+	}
+}
+
+func TestWorkersQueuePolicy(t *testing.T) {
+	tests := []struct {
+		policy pipeline.QueuePolicy
+		want   []uint32
+	}{
+		{pipeline.QueueDropNewest, []uint32{1, 2}},
+		{pipeline.QueueDropOldest, []uint32{1, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			p := newPipeline(t, pipeline.Config{
+				Workers: pipeline.WorkerConfig{Workers: 1, QueueSize: 1, Policy: tt.policy},
+			})
+
+			sink := newBlockingSink()
+			p.AddOutput(&pipeline.Output{Name: "blocking", Sink: sink})
+			p.StartWorkers()
+
+			// The worker takes the first message and blocks, the second fills the queue.
+			p.HandleMessage(&broker.Message{Topic: testTopic, Payload: withPacketID(t, "message-01", 1)})
+			<-sink.started
+			p.HandleMessage(&broker.Message{Topic: testTopic, Payload: withPacketID(t, "message-01", 2)})
+			p.HandleMessage(&broker.Message{Topic: testTopic, Payload: withPacketID(t, "message-01", 3)})
+
+			if got := p.GetStatus().Queue; got == nil || got.Dropped != 1 || got.Depth != 1 || got.Capacity != 1 {
+				t.Errorf("GetStatus().Queue: got '%+v', want 1 dropped and 1 queued", got)
+			}
+
+			sink.release <- struct{}{}
+			sink.release <- struct{}{}
+			p.Stop(t.Context())
+
+			if diff := cmp.Diff(tt.want, sink.ids); diff != "" {
+				t.Errorf("processed mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWorkersBlock(t *testing.T) {
+	p := newPipeline(t, pipeline.Config{Workers: pipeline.WorkerConfig{Workers: 1, QueueSize: 1}})
+
+	sink := newBlockingSink()
+	p.AddOutput(&pipeline.Output{Name: "blocking", Sink: sink})
+	p.StartWorkers()
+
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: withPacketID(t, "message-01", 1)})
+	<-sink.started
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: withPacketID(t, "message-01", 2)})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: withPacketID(t, "message-01", 3)})
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("HandleMessage() returned with a full queue, want it to block")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	for range 3 {
+		sink.release <- struct{}{}
+	}
+	<-done
+	p.Stop(t.Context())
+
+	if diff := cmp.Diff([]uint32{1, 2, 3}, sink.ids); diff != "" {
+		t.Errorf("processed mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseQueuePolicy(t *testing.T) {
+	for in, want := range map[string]pipeline.QueuePolicy{
+		"":            pipeline.QueueBlock,
+		"block":       pipeline.QueueBlock,
+		"drop-newest": pipeline.QueueDropNewest,
+		"drop-oldest": pipeline.QueueDropOldest,
+	} {
+		if got, err := pipeline.ParseQueuePolicy(in); err != nil || got != want {
+			t.Errorf("ParseQueuePolicy(%q): got '%s', '%v', want '%s'", in, got, err, want)
+		}
+	}
+
+	if _, err := pipeline.ParseQueuePolicy("drop-all"); !errors.Is(err, pipeline.ErrInvalidQueuePolicy) {
+		t.Errorf("ParseQueuePolicy(): error got '%v', want '%v'", err, pipeline.ErrInvalidQueuePolicy)
+	}
+}
+
+// sinkFunc adapts a function to a pipeline.Sink.
+type sinkFunc func(pkt *pipeline.Packet)
+
+func (f sinkFunc) Write(_ context.Context, pkt *pipeline.Packet) error {
+	f(pkt)
+	return nil
+}
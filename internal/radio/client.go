@@ -0,0 +1,347 @@
+package radio
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mqttd"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"github.com/na4ma4/go-slogtool"
+	"google.golang.org/protobuf/proto"
+)
+
+// errRebooted is returned when the node reboots, its configuration is requested again after reconnecting.
+var errRebooted = errors.New("radio rebooted")
+
+// client is a connection to a node, it reconnects until Disconnect is called.
+type client struct {
+	source *Source
+	logger *slog.Logger
+	hooks  broker.Hooks
+	// ctx is cancelled by Disconnect, stopping reconnection attempts.
+	ctx    context.Context //nolint:containedctx // outlives the Connect call.
+	cancel context.CancelFunc
+
+	lock    sync.Mutex
+	conn    conn
+	config  *nodeConfig
+	filters []string
+
+	writeLock sync.Mutex
+}
+
+func (c *client) Connect(ctx context.Context) error {
+	nc, r, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	go c.run(nc, r)
+
+	return nil
+}
+
+// connect opens a stream to the node and requests its configuration, calling hooks.OnConnect once it is received.
+func (c *client) connect(ctx context.Context) (conn, *bufio.Reader, error) {
+	nc, err := c.source.dial(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to radio: %w", err)
+	}
+
+	cfg, r, err := c.handshake(ctx, nc)
+	if err != nil {
+		_ = nc.Close()
+		return nil, nil, err
+	}
+
+	c.lock.Lock()
+	if err = c.ctx.Err(); err != nil {
+		c.lock.Unlock()
+		_ = nc.Close()
+		return nil, nil, err
+	}
+	c.conn = nc
+	c.config = cfg
+	c.lock.Unlock()
+
+	c.logger.InfoContext(ctx, "Connected to radio",
+		slog.String("radio.address", c.source.Config.Address),
+		slog.String("radio.node", cfg.gateway()),
+		slog.Int("radio.channels", len(cfg.channels)),
+	)
+
+	if c.hooks.OnConnect != nil {
+		go c.hooks.OnConnect(c)
+	}
+
+	return nc, r, nil
+}
+
+// handshake requests the configuration of the node and reads it, seeding the node database with the nodes it knows.
+func (c *client) handshake(ctx context.Context, nc conn) (*nodeConfig, *bufio.Reader, error) {
+	stop := context.AfterFunc(ctx, func() { _ = nc.Close() })
+	defer stop()
+
+	_ = nc.SetReadDeadline(time.Now().Add(c.source.Config.ConfigTimeout))
+
+	nonce := max(rand.Uint32(), 1) //nolint:gosec // the nonce only matches the request to the response.
+	if err := c.write(nc, &meshtastic.ToRadio{
+		PayloadVariant: &meshtastic.ToRadio_WantConfigId{WantConfigId: nonce},
+	}); err != nil {
+		return nil, nil, fmt.Errorf("unable to request radio config: %w", err)
+	}
+
+	cfg := newNodeConfig()
+	r := bufio.NewReader(nc)
+	for {
+		msg, err := readFrame(r)
+		if errors.Is(err, ErrInvalidFrame) {
+			c.logger.DebugContext(ctx, "Skipped invalid frame from radio", slogtool.ErrorAttr(err))
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read radio config: %w", err)
+		}
+
+		if v, ok := msg.GetPayloadVariant().(*meshtastic.FromRadio_ConfigCompleteId); ok && v.ConfigCompleteId == nonce {
+			_ = nc.SetReadDeadline(time.Time{})
+			return cfg, r, nil
+		}
+
+		cfg.observe(msg)
+		if info := msg.GetNodeInfo(); info != nil {
+			c.seed(cfg, info)
+		}
+	}
+}
+
+// run passes packets from the node to hooks.OnMessage, reconnecting when the connection is lost until Disconnect is
+// called.
+func (c *client) run(nc conn, r *bufio.Reader) {
+	for nc != nil {
+		err := c.serve(nc, r)
+
+		c.lock.Lock()
+		if c.conn == nc {
+			c.conn = nil
+		}
+		c.lock.Unlock()
+		_ = nc.Close()
+
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.logger.WarnContext(c.ctx, "Lost connection to radio", slogtool.ErrorAttr(err))
+
+		nc, r = c.reconnect()
+	}
+}
+
+// reconnect connects to the node, doubling the delay between attempts up to MaxReconnectDelay, it returns nil once
+// Disconnect is called.
+func (c *client) reconnect() (conn, *bufio.Reader) {
+	delay := c.source.Config.ReconnectDelay
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil, nil
+		case <-time.After(delay):
+		}
+
+		if c.hooks.OnReconnecting != nil {
+			c.hooks.OnReconnecting()
+		}
+
+		nc, r, err := c.connect(c.ctx)
+		if err == nil {
+			return nc, r
+		}
+		if c.ctx.Err() != nil {
+			return nil, nil
+		}
+
+		delay = min(delay*2, c.source.Config.MaxReconnectDelay) //nolint:mnd // exponential backoff.
+		c.logger.WarnContext(c.ctx, "Failed to reconnect to radio",
+			slog.Duration("retry", delay),
+			slogtool.ErrorAttr(err),
+		)
+	}
+}
+
+// serve handles frames from the node until the connection is lost, sending heartbeats so the node does not drop the
+// connection while the mesh is quiet.
+func (c *client) serve(nc conn, r *bufio.Reader) error {
+	done := make(chan struct{})
+	defer close(done)
+	go c.heartbeat(nc, done)
+
+	for {
+		msg, err := readFrame(r)
+		if errors.Is(err, ErrInvalidFrame) {
+			c.logger.DebugContext(c.ctx, "Skipped invalid frame from radio", slogtool.ErrorAttr(err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch v := msg.GetPayloadVariant().(type) {
+		case *meshtastic.FromRadio_Packet:
+			c.receive(v.Packet)
+		case *meshtastic.FromRadio_NodeInfo:
+			c.seed(c.nodeConfig(), v.NodeInfo)
+		case *meshtastic.FromRadio_Rebooted:
+			return errRebooted
+		}
+	}
+}
+
+// heartbeat sends a heartbeat to the node each HeartbeatInterval until done is closed, closing the connection if it
+// can not be sent.
+func (c *client) heartbeat(nc conn, done <-chan struct{}) {
+	ticker := time.NewTicker(c.source.Config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.write(nc, &meshtastic.ToRadio{
+				PayloadVariant: &meshtastic.ToRadio_Heartbeat{Heartbeat: &meshtastic.Heartbeat{}},
+			}); err != nil {
+				_ = nc.Close()
+				return
+			}
+		}
+	}
+}
+
+// receive passes the packet to hooks.OnMessage in a ServiceEnvelope as if the node had uplinked it, if its topic
+// is subscribed to.
+func (c *client) receive(packet *meshtastic.MeshPacket) {
+	c.lock.Lock()
+	cfg, filters := c.config, c.filters
+	c.lock.Unlock()
+
+	channel := cfg.channelName(packet.GetChannel())
+	topic := c.source.Config.Topic + channel + "/" + cfg.gateway()
+	if c.hooks.OnMessage == nil || !slices.ContainsFunc(filters, func(f string) bool { return mqttd.Match(f, topic) }) {
+		return
+	}
+
+	payload, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+		Packet:    packet,
+		ChannelId: channel,
+		GatewayId: cfg.gateway(),
+	})
+	if err != nil {
+		c.logger.ErrorContext(c.ctx, "Failed to marshal ServiceEnvelope", slogtool.ErrorAttr(err))
+		return
+	}
+
+	c.hooks.Deliver(&broker.Message{Topic: topic, Payload: payload})
+}
+
+// nodeConfig returns the configuration of the node received when last connected.
+func (c *client) nodeConfig() *nodeConfig {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.config
+}
+
+// write writes the message to the node.
+func (c *client) write(nc conn, msg *meshtastic.ToRadio) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return writeFrame(nc, msg)
+}
+
+func (c *client) IsConnected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.conn != nil
+}
+
+// Subscribe subscribes to packets received on the topic, a shared subscription is treated as a plain subscription.
+func (c *client) Subscribe(_ context.Context, topic string) error {
+	filter := topic
+	if rest, ok := strings.CutPrefix(topic, "$share/"); ok {
+		_, filter, _ = strings.Cut(rest, "/")
+	}
+	if !mqttd.ValidFilter(filter) {
+		return fmt.Errorf("%w: %s", mqttd.ErrInvalidTopic, topic)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	// The slice is replaced, not appended to, so receive can use it without holding the lock.
+	c.filters = append(slices.Clone(c.filters), filter)
+
+	return nil
+}
+
+// Publish transmits the packet of a ServiceEnvelope (e.g. a downlink) on the channel of the node named by the
+// envelope.
+func (c *client) Publish(_ context.Context, msg *broker.Message) error {
+	envelope := &meshtastic.ServiceEnvelope{}
+	if err := proto.Unmarshal(msg.Payload, envelope); err != nil {
+		return fmt.Errorf("unable to unmarshal ServiceEnvelope: %w", err)
+	}
+	if envelope.GetPacket() == nil {
+		return fmt.Errorf("unable to publish to radio: %s has no packet", msg.Topic)
+	}
+
+	c.lock.Lock()
+	nc, cfg := c.conn, c.config
+	c.lock.Unlock()
+
+	if nc == nil {
+		return ErrNotConnected
+	}
+
+	index, ok := cfg.channelIndex(envelope.GetChannelId())
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownChannel, envelope.GetChannelId())
+	}
+
+	packet := proto.CloneOf(envelope.GetPacket())
+	packet.Channel = index
+
+	return c.write(nc, &meshtastic.ToRadio{PayloadVariant: &meshtastic.ToRadio_Packet{Packet: packet}})
+}
+
+// Disconnect tells the node the client is disconnecting and closes the connection.
+func (c *client) Disconnect() {
+	c.cancel()
+
+	c.lock.Lock()
+	nc := c.conn
+	c.conn = nil
+	c.lock.Unlock()
+
+	if nc == nil {
+		return
+	}
+
+	_ = c.write(nc, &meshtastic.ToRadio{PayloadVariant: &meshtastic.ToRadio_Disconnect{Disconnect: true}})
+	_ = nc.Close()
+}
@@ -0,0 +1,42 @@
+package radio
+
+import (
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+)
+
+const (
+	// DefaultPort is the port of the TCP API of a node.
+	DefaultPort = 4403
+	// DefaultBaudRate is the speed of the serial API of a node.
+	DefaultBaudRate = 115200
+	// DefaultConfigTimeout is the time allowed for a node to send its configuration after connecting.
+	DefaultConfigTimeout = 30 * time.Second
+	// DefaultHeartbeatInterval is the interval heartbeats are sent to a node, it drops idle API clients.
+	DefaultHeartbeatInterval = time.Minute
+	// DefaultReconnectDelay is the delay before the first reconnection attempt, it doubles after each failed attempt.
+	DefaultReconnectDelay = time.Second
+	// DefaultMaxReconnectDelay is the longest delay between reconnection attempts.
+	DefaultMaxReconnectDelay = time.Minute
+)
+
+// Config holds the radio source configuration.
+type Config struct {
+	// Address is the node to connect to, tcp://host[:port] for the TCP API or serial:///dev/ttyUSB0 for a serial port.
+	Address string
+	// Topic is the topic prefix packets are received on, e.g. msh/ANZ/2/e/, the channel name and the ID of the node
+	// are appended as if the node uplinked the packet over MQTT.
+	Topic string
+	// BaudRate is the speed of a serial port.
+	BaudRate int
+	// NodeDB is seeded with the nodes known to the node each time it connects, optional.
+	NodeDB *nodedb.DB
+	// ConfigTimeout is the time allowed for the node to send its configuration after connecting.
+	ConfigTimeout time.Duration
+	// HeartbeatInterval is the interval heartbeats are sent to the node.
+	HeartbeatInterval time.Duration
+	// ReconnectDelay and MaxReconnectDelay bound the delay between reconnection attempts.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
@@ -0,0 +1,93 @@
+package radio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Frames are a two byte header, the big-endian length of the protobuf and the protobuf.
+const (
+	start1 = 0x94
+	start2 = 0xc3
+	// maxFrameSize is the largest protobuf carried by a frame.
+	maxFrameSize = 512
+)
+
+// ErrInvalidFrame is returned for a frame that is too large or does not carry a protobuf, the stream is still in
+// sync and the next frame can be read.
+var ErrInvalidFrame = errors.New("invalid frame")
+
+// writeFrame writes the message as a frame.
+func writeFrame(w io.Writer, msg *meshtastic.ToRadio) error {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("unable to marshal ToRadio: %w", err)
+	}
+	if len(b) > maxFrameSize {
+		return fmt.Errorf("%w: %d bytes", ErrInvalidFrame, len(b))
+	}
+
+	frame := make([]byte, 0, len(b)+4) //nolint:mnd // header and length.
+	frame = append(frame, start1, start2)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(b))) //nolint:gosec // length is at most maxFrameSize.
+	_, err = w.Write(append(frame, b...))
+
+	return err
+}
+
+// readFrame reads the next frame, bytes outside a frame (e.g. the debug log on a serial port) are skipped.
+func readFrame(r *bufio.Reader) (*meshtastic.FromRadio, error) {
+	if err := skipToFrame(r); err != nil {
+		return nil, err
+	}
+
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidFrame, length)
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+
+	msg := &meshtastic.FromRadio{}
+	if err := proto.Unmarshal(b, msg); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFrame, err)
+	}
+
+	return msg, nil
+}
+
+// skipToFrame reads up to and including the header of the next frame.
+func skipToFrame(r *bufio.Reader) error {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != start1 {
+			continue
+		}
+
+		if b, err = r.ReadByte(); err != nil {
+			return err
+		}
+		if b == start2 {
+			return nil
+		}
+		if b == start1 {
+			_ = r.UnreadByte()
+		}
+	}
+}
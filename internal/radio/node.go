@@ -0,0 +1,123 @@
+package radio
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+// presetNames are the channel names of the modem presets, used for channels without a name.
+var presetNames = map[meshtastic.Config_LoRaConfig_ModemPreset]string{
+	meshtastic.Config_LoRaConfig_LONG_FAST:      "LongFast",
+	meshtastic.Config_LoRaConfig_LONG_SLOW:      "LongSlow",
+	meshtastic.Config_LoRaConfig_VERY_LONG_SLOW: "VLongSlow",
+	meshtastic.Config_LoRaConfig_MEDIUM_SLOW:    "MediumSlow",
+	meshtastic.Config_LoRaConfig_MEDIUM_FAST:    "MediumFast",
+	meshtastic.Config_LoRaConfig_SHORT_SLOW:     "ShortSlow",
+	meshtastic.Config_LoRaConfig_SHORT_FAST:     "ShortFast",
+	meshtastic.Config_LoRaConfig_LONG_MODERATE:  "LongMod",
+	meshtastic.Config_LoRaConfig_SHORT_TURBO:    "ShortTurbo",
+}
+
+// nodeConfig is the configuration received from the node after connecting.
+type nodeConfig struct {
+	// num is the node number of the node, packets are passed on as if uplinked by it.
+	num uint32
+	// channels are the names of the enabled channels by index, empty for an unnamed channel.
+	channels map[uint32]string
+	// preset is the channel name of the modem preset.
+	preset string
+}
+
+func newNodeConfig() *nodeConfig {
+	return &nodeConfig{
+		channels: make(map[uint32]string),
+		preset:   presetNames[meshtastic.Config_LoRaConfig_LONG_FAST],
+	}
+}
+
+// observe updates the configuration from a message received while the node sends its configuration.
+func (cfg *nodeConfig) observe(msg *meshtastic.FromRadio) {
+	switch v := msg.GetPayloadVariant().(type) {
+	case *meshtastic.FromRadio_MyInfo:
+		cfg.num = v.MyInfo.GetMyNodeNum()
+	case *meshtastic.FromRadio_Channel:
+		index := uint32(v.Channel.GetIndex()) //nolint:gosec // channel indexes are 0 to 7.
+		if v.Channel.GetRole() == meshtastic.Channel_DISABLED {
+			delete(cfg.channels, index)
+			return
+		}
+		cfg.channels[index] = v.Channel.GetSettings().GetName()
+	case *meshtastic.FromRadio_Config:
+		if lora := v.Config.GetLora(); lora != nil {
+			if name, ok := presetNames[lora.GetModemPreset()]; ok {
+				cfg.preset = name
+			}
+		}
+	}
+}
+
+// gateway returns the ID of the node, e.g. !44be043f.
+func (cfg *nodeConfig) gateway() string {
+	return mtypes.FormatNodeID(cfg.num)
+}
+
+// channelName returns the name of the channel at the index, unnamed and unknown channels are named after the modem
+// preset as the firmware does.
+func (cfg *nodeConfig) channelName(index uint32) string {
+	if name := cfg.channels[index]; name != "" {
+		return name
+	}
+
+	return cfg.preset
+}
+
+// channelIndex returns the lowest index of the named channel.
+func (cfg *nodeConfig) channelIndex(name string) (uint32, bool) {
+	for _, index := range slices.Sorted(maps.Keys(cfg.channels)) {
+		if cfg.channelName(index) == name {
+			return index, true
+		}
+	}
+
+	return 0, false
+}
+
+// seed adds a node known to the radio to the node database, unless the database heard from it more recently.
+func (c *client) seed(cfg *nodeConfig, info *meshtastic.NodeInfo) {
+	db := c.source.Config.NodeDB
+	if db == nil {
+		return
+	}
+
+	lastHeard := time.Unix(int64(info.GetLastHeard()), 0)
+	if node, ok := db.Get(info.GetNum()); ok && !lastHeard.After(node.LastHeard) {
+		return
+	}
+
+	msg := mtypes.Message{
+		From:      info.GetNum(),
+		Timestamp: info.GetLastHeard(),
+		SNR:       translator.SpecialFloat64(info.GetSnr()),
+		HopsAway:  info.GetHopsAway(),
+		Sender:    cfg.gateway(),
+	}
+	db.Observe(&msg)
+
+	if user := info.GetUser(); user != nil {
+		msg.Payload = translator.NewUser(user)
+		db.Observe(&msg)
+	}
+	if position := info.GetPosition(); position != nil {
+		msg.Payload = translator.NewPositionApp(position)
+		db.Observe(&msg)
+	}
+	if metrics := info.GetDeviceMetrics(); metrics != nil {
+		msg.Payload = translator.NewDeviceMetrics(metrics)
+		db.Observe(&msg)
+	}
+}
@@ -0,0 +1,114 @@
+// Package radio receives packets directly from a Meshtastic node over the TCP or serial stream API, passing them on as
+// ServiceEnvelopes as if the node had uplinked them over MQTT.
+package radio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+)
+
+var (
+	// ErrNotConnected is returned when publishing while not connected to the node.
+	ErrNotConnected = errors.New("not connected to radio")
+	// ErrUnsupportedAddress is returned for an address that is not a tcp:// or serial:// URL.
+	ErrUnsupportedAddress = errors.New("unsupported radio address")
+	// ErrUnsupportedBaudRate is returned for a serial port speed that can not be set.
+	ErrUnsupportedBaudRate = errors.New("unsupported baud rate")
+	// ErrSerialUnsupported is returned when opening a serial port on a platform without serial port support.
+	ErrSerialUnsupported = errors.New("serial ports are not supported on this platform")
+	// ErrUnknownChannel is returned when publishing an envelope for a channel the node does not have.
+	ErrUnknownChannel = errors.New("unknown channel")
+)
+
+// Source is a node packets are received from, its clients are used as the source broker of the pipeline.
+type Source struct {
+	Config Config
+	Logger *slog.Logger
+}
+
+// New returns a source for the node at the configured address.
+func New(config Config, logger *slog.Logger) *Source {
+	if config.BaudRate <= 0 {
+		config.BaudRate = DefaultBaudRate
+	}
+	if config.ConfigTimeout <= 0 {
+		config.ConfigTimeout = DefaultConfigTimeout
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.ReconnectDelay <= 0 {
+		config.ReconnectDelay = DefaultReconnectDelay
+	}
+	if config.MaxReconnectDelay <= 0 {
+		config.MaxReconnectDelay = DefaultMaxReconnectDelay
+	}
+
+	return &Source{
+		Config: config,
+		Logger: logger,
+	}
+}
+
+// Address returns the address of the node.
+func (s *Source) Address() string {
+	return s.Config.Address
+}
+
+// NewClient returns a client of the node, packets are passed to hooks.OnMessage once subscribed to their topic.
+//
+// The client reconnects to the node once connected, each time it connects the node configuration is requested and
+// the node database is seeded with the nodes known to the node.
+func (s *Source) NewClient(clientID string, hooks broker.Hooks) broker.Client {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &client{
+		source: s,
+		logger: s.Logger.With(slog.String("client_id", clientID)),
+		hooks:  hooks,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// conn is a stream to a node.
+type conn interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// dial opens a stream to the node.
+func (s *Source) dial(ctx context.Context) (conn, error) {
+	u, err := url.Parse(s.Config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedAddress, err)
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		address := u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(u.Hostname(), strconv.Itoa(DefaultPort))
+		}
+
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", address)
+	case "serial":
+		f, serialErr := openSerial(u.Path, s.Config.BaudRate)
+		if serialErr != nil {
+			return nil, serialErr
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAddress, s.Config.Address)
+	}
+}
@@ -0,0 +1,403 @@
+package radio_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/radio"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	gatewayNum = 0x44be043f
+	remoteNum  = 0x12345678
+)
+
+// fakeRadio is a node speaking the stream API on one connection.
+type fakeRadio struct {
+	rw io.ReadWriter
+	r  *bufio.Reader
+}
+
+func newFakeRadio(rw io.ReadWriter) *fakeRadio {
+	return &fakeRadio{rw: rw, r: bufio.NewReader(rw)}
+}
+
+// send sends the message as a frame.
+func (f *fakeRadio) send(msg *meshtastic.FromRadio) error {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	frame := binary.BigEndian.AppendUint16([]byte{0x94, 0xc3}, uint16(len(b))) //nolint:gosec // test frames are small.
+	_, err = f.rw.Write(append(frame, b...))
+
+	return err
+}
+
+// next reads the next frame from the client, skipping heartbeats.
+func (f *fakeRadio) next() (*meshtastic.ToRadio, error) {
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(f.r, header); err != nil {
+			return nil, err
+		}
+		if header[0] != 0x94 || header[1] != 0xc3 {
+			return nil, fmt.Errorf("frame header: got '%x', want '94c3'", header[:2])
+		}
+
+		b := make([]byte, binary.BigEndian.Uint16(header[2:]))
+		if _, err := io.ReadFull(f.r, b); err != nil {
+			return nil, err
+		}
+
+		msg := &meshtastic.ToRadio{}
+		if err := proto.Unmarshal(b, msg); err != nil {
+			return nil, err
+		}
+		if msg.GetHeartbeat() == nil {
+			return msg, nil
+		}
+	}
+}
+
+// serveConfig answers the config request of the client with a node that has an unnamed primary channel, an Admin
+// secondary channel and knows one other node.
+func (f *fakeRadio) serveConfig() error {
+	req, err := f.next()
+	if err != nil {
+		return err
+	}
+	nonce := req.GetWantConfigId()
+	if nonce == 0 {
+		return fmt.Errorf("expected want_config_id request, got '%v'", req)
+	}
+
+	for _, msg := range []*meshtastic.FromRadio{
+		{PayloadVariant: &meshtastic.FromRadio_MyInfo{MyInfo: &meshtastic.MyNodeInfo{MyNodeNum: gatewayNum}}},
+		{PayloadVariant: &meshtastic.FromRadio_NodeInfo{NodeInfo: &meshtastic.NodeInfo{
+			Num:       remoteNum,
+			User:      &meshtastic.User{Id: "!12345678", LongName: "Remote Node", ShortName: "RN"},
+			LastHeard: uint32(time.Now().Unix()), //nolint:gosec // test time.
+			HopsAway:  proto.Uint32(2),
+		}}},
+		{PayloadVariant: &meshtastic.FromRadio_Config{Config: &meshtastic.Config{
+			PayloadVariant: &meshtastic.Config_Lora{Lora: &meshtastic.Config_LoRaConfig{
+				ModemPreset: meshtastic.Config_LoRaConfig_MEDIUM_FAST,
+			}},
+		}}},
+		{PayloadVariant: &meshtastic.FromRadio_Channel{Channel: &meshtastic.Channel{
+			Index:    0,
+			Role:     meshtastic.Channel_PRIMARY,
+			Settings: &meshtastic.ChannelSettings{},
+		}}},
+		{PayloadVariant: &meshtastic.FromRadio_Channel{Channel: &meshtastic.Channel{
+			Index:    1,
+			Role:     meshtastic.Channel_SECONDARY,
+			Settings: &meshtastic.ChannelSettings{Name: "Admin"},
+		}}},
+		{PayloadVariant: &meshtastic.FromRadio_Channel{Channel: &meshtastic.Channel{
+			Index: 2,
+			Role:  meshtastic.Channel_DISABLED,
+		}}},
+		{PayloadVariant: &meshtastic.FromRadio_ConfigCompleteId{ConfigCompleteId: nonce}},
+	} {
+		if err = f.send(msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendPacket sends a text message packet received on the channel index.
+func (f *fakeRadio) sendPacket(id, channel uint32) error {
+	return f.send(&meshtastic.FromRadio{PayloadVariant: &meshtastic.FromRadio_Packet{Packet: &meshtastic.MeshPacket{
+		From:    remoteNum,
+		To:      0xffffffff,
+		Id:      id,
+		Channel: channel,
+		PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+			Portnum: meshtastic.PortNum_TEXT_MESSAGE_APP,
+			Payload: []byte("hello"),
+		}},
+	}}})
+}
+
+// receiver collects the messages received by a client.
+type receiver struct {
+	lock sync.Mutex
+	msgs []*broker.Message
+}
+
+func (r *receiver) handle(msg *broker.Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.msgs = append(r.msgs, msg)
+}
+
+// wait waits for n messages, returning their topics and the gateway and text of their envelopes.
+func (r *receiver) wait(t *testing.T, n int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.lock.Lock()
+		if len(r.msgs) >= n {
+			var out []string
+			for _, msg := range r.msgs {
+				envelope := &meshtastic.ServiceEnvelope{}
+				if err := proto.Unmarshal(msg.Payload, envelope); err != nil {
+					t.Errorf("Unmarshal(): %v", err)
+				}
+				out = append(out, msg.Topic+" "+envelope.GetGatewayId()+" "+string(envelope.GetPacket().GetDecoded().GetPayload()))
+			}
+			r.lock.Unlock()
+			return out
+		}
+		r.lock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %d messages", n)
+	return nil
+}
+
+// listen returns a listener for the fake radio and the address of the radio.
+func listen(t *testing.T) (net.Listener, string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen(): %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	return l, "tcp://" + l.Addr().String()
+}
+
+// accept accepts connections to the fake radio until the listener is closed, passing each to serve in the
+// background.
+func accept(t *testing.T, l net.Listener, serve func(f *fakeRadio, nc net.Conn)) {
+	t.Helper()
+
+	var lock sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		for _, nc := range conns {
+			_ = nc.Close()
+		}
+	})
+
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			lock.Lock()
+			conns = append(conns, nc)
+			lock.Unlock()
+
+			go serve(newFakeRadio(nc), nc)
+		}
+	}()
+}
+
+// connect connects a client to the radio, subscribing to the topic.
+func connect(t *testing.T, config radio.Config, hooks broker.Hooks, topic string) broker.Client {
+	t.Helper()
+
+	client, err := broker.Config{ClientID: "relay"}.WithLocal(radio.New(config, slog.New(slog.DiscardHandler))).NewClient(hooks)
+	if err != nil {
+		t.Fatalf("NewClient(): error got '%v', want 'nil'", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	if err = client.Connect(ctx); err != nil {
+		t.Fatalf("Connect(): error got '%v', want 'nil'", err)
+	}
+	t.Cleanup(client.Disconnect)
+
+	if err = client.Subscribe(ctx, topic); err != nil {
+		t.Fatalf("Subscribe(%s): error got '%v', want 'nil'", topic, err)
+	}
+
+	return client
+}
+
+func TestSource(t *testing.T) {
+	l, address := listen(t)
+
+	toRadio := make(chan *meshtastic.ToRadio, 10)
+	subscribed := make(chan struct{})
+	accept(t, l, func(f *fakeRadio, nc net.Conn) {
+		if f.serveConfig() != nil {
+			return
+		}
+		<-subscribed
+
+		// Debug log output between frames is skipped.
+		_, _ = nc.Write([]byte("DEBUG | 12:00:00 [Router] received\r\n\x94"))
+		_ = f.sendPacket(1, 0)
+		_ = f.sendPacket(2, 1)
+
+		for {
+			msg, err := f.next()
+			if err != nil {
+				return
+			}
+			toRadio <- msg
+		}
+	})
+
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+	r := &receiver{}
+	cfg := broker.Config{ClientID: "relay"}.WithLocal(radio.New(radio.Config{Address: address}, nil))
+	if cfg.Address != address {
+		t.Errorf("WithLocal() address: got '%s', want '%s'", cfg.Address, address)
+	}
+
+	client := connect(t, radio.Config{
+		Address: address,
+		Topic:   "msh/ANZ/2/e/",
+		NodeDB:  db,
+	}, broker.Hooks{OnMessage: r.handle}, broker.SharedTopic("relay", "msh/ANZ/2/e/#"))
+	close(subscribed)
+
+	want := []string{
+		"msh/ANZ/2/e/MediumFast/!44be043f !44be043f hello",
+		"msh/ANZ/2/e/Admin/!44be043f !44be043f hello",
+	}
+	if diff := cmp.Diff(want, r.wait(t, 2)); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+
+	node, ok := db.Get(remoteNum)
+	if !ok {
+		t.Fatal("node database: remote node not seeded")
+	}
+	if node.LongName != "Remote Node" || node.HopsAway != 2 || node.Gateway != "!44be043f" {
+		t.Errorf("seeded node: got '%s' hops '%d' via '%s', want 'Remote Node' hops '2' via '!44be043f'",
+			node.LongName, node.HopsAway, node.Gateway)
+	}
+
+	payload, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+		Packet:    &meshtastic.MeshPacket{To: remoteNum, Id: 3},
+		ChannelId: "Admin",
+		GatewayId: "!00000001",
+	})
+	if err != nil {
+		t.Fatalf("Marshal(): %v", err)
+	}
+	if err = client.Publish(t.Context(), &broker.Message{Topic: "msh/ANZ/2/e/Admin/!00000001", Payload: payload}); err != nil {
+		t.Fatalf("Publish(): error got '%v', want 'nil'", err)
+	}
+	if packet := (<-toRadio).GetPacket(); packet.GetId() != 3 || packet.GetChannel() != 1 {
+		t.Errorf("published packet: got id '%d' channel '%d', want id '3' channel '1'", packet.GetId(), packet.GetChannel())
+	}
+
+	payload, _ = proto.Marshal(&meshtastic.ServiceEnvelope{Packet: &meshtastic.MeshPacket{}, ChannelId: "Unknown"})
+	if err = client.Publish(t.Context(), &broker.Message{Payload: payload}); err == nil {
+		t.Error("Publish() to unknown channel: error got 'nil', want error")
+	}
+
+	client.Disconnect()
+	if client.IsConnected() {
+		t.Error("IsConnected() after Disconnect(): got 'true', want 'false'")
+	}
+	if msg := <-toRadio; !msg.GetDisconnect() {
+		t.Errorf("after Disconnect(): got '%v', want disconnect", msg)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	l, address := listen(t)
+
+	var accepted atomic.Int32
+	subscribed := make(chan struct{})
+	accept(t, l, func(f *fakeRadio, nc net.Conn) {
+		if f.serveConfig() != nil {
+			return
+		}
+		<-subscribed
+		_ = f.sendPacket(uint32(accepted.Add(1)), 0) //nolint:gosec // test count.
+
+		// The first connection is dropped after a packet, the node reboots on the second.
+		if accepted.Load() == 1 {
+			_ = nc.Close()
+			return
+		}
+		_ = f.send(&meshtastic.FromRadio{PayloadVariant: &meshtastic.FromRadio_Rebooted{Rebooted: true}})
+	})
+
+	var reconnects atomic.Int32
+	connected := make(chan struct{}, 10)
+	r := &receiver{}
+	client := connect(t, radio.Config{
+		Address:        address,
+		Topic:          "msh/ANZ/2/e/",
+		ReconnectDelay: 10 * time.Millisecond,
+	}, broker.Hooks{
+		OnConnect: func(broker.Client) {
+			select {
+			case connected <- struct{}{}:
+			default:
+			}
+		},
+		OnReconnecting: func() {
+			reconnects.Add(1)
+		},
+		OnMessage: r.handle,
+	}, "msh/ANZ/2/e/#")
+	close(subscribed)
+
+	r.wait(t, 3)
+	if got := reconnects.Load(); got < 2 {
+		t.Errorf("OnReconnecting(): got '%d' calls, want at least '2'", got)
+	}
+	for i := range 3 {
+		select {
+		case <-connected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnConnect(): got '%d' calls, want at least '3'", i)
+		}
+	}
+
+	client.Disconnect()
+	if client.IsConnected() {
+		t.Error("IsConnected() after Disconnect(): got 'true', want 'false'")
+	}
+}
+
+func TestConnectErrors(t *testing.T) {
+	for _, address := range []string{"udp://127.0.0.1:4403", "serial:///dev/does-not-exist"} {
+		client := radio.New(radio.Config{Address: address}, slog.New(slog.DiscardHandler)).
+			NewClient("relay", broker.Hooks{})
+		if err := client.Connect(t.Context()); err == nil {
+			t.Errorf("Connect(%s): error got 'nil', want error", address)
+		}
+	}
+}
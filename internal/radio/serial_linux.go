@@ -0,0 +1,69 @@
+package radio
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// baudRates are the termios speeds of the supported serial port speeds.
+var baudRates = map[int]uint32{
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+	460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+// openSerial opens the serial port in raw mode at the baud rate.
+func openSerial(path string, baudRate int) (*os.File, error) {
+	speed, ok := baudRates[baudRate]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedBaudRate, baudRate)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open serial port: %w", err)
+	}
+
+	// Fd() would put the file in blocking mode, so read deadlines would no longer apply.
+	rc, err := f.SyscallConn()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to configure serial port: %w", err)
+	}
+
+	var termErr error
+	if err = rc.Control(func(fd uintptr) { termErr = makeRaw(int(fd), speed) }); err != nil { //nolint:gosec // fd fits.
+		termErr = err
+	}
+	if termErr != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("unable to configure serial port: %w", termErr)
+	}
+
+	return f, nil
+}
+
+// makeRaw puts the terminal in raw 8N1 mode at the speed, reads return as soon as a byte is available.
+func makeRaw(fd int, speed uint32) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CRTSCTS | unix.CBAUD
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | speed
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
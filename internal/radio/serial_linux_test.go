@@ -0,0 +1,79 @@
+package radio_test
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/radio"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+)
+
+// openPTY returns the master of a new pseudo-terminal and the path of its slave.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals unavailable: %v", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+
+	rc, err := master.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn(): %v", err)
+	}
+
+	var n int
+	var ioctlErr error
+	if err = rc.Control(func(fd uintptr) {
+		if ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ioctlErr != nil {
+			return
+		}
+		n, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	}); err != nil || ioctlErr != nil {
+		t.Skipf("pseudo-terminal unlock failed: %v %v", err, ioctlErr)
+	}
+
+	return master, "/dev/pts/" + strconv.Itoa(n)
+}
+
+func TestSerial(t *testing.T) {
+	master, slave := openPTY(t)
+
+	subscribed := make(chan struct{})
+	go func() {
+		f := newFakeRadio(master)
+		if f.serveConfig() != nil {
+			return
+		}
+		<-subscribed
+		_ = f.sendPacket(1, 1)
+	}()
+
+	r := &receiver{}
+	connect(t, radio.Config{
+		Address: "serial://" + slave,
+		Topic:   "msh/ANZ/2/e/",
+	}, broker.Hooks{OnMessage: r.handle}, "msh/ANZ/2/e/#")
+	close(subscribed)
+
+	if diff := cmp.Diff([]string{"msh/ANZ/2/e/Admin/!44be043f !44be043f hello"}, r.wait(t, 1)); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSerialBaudRate(t *testing.T) {
+	_, slave := openPTY(t)
+
+	client := radio.New(radio.Config{Address: "serial://" + slave, BaudRate: 12345}, slog.New(slog.DiscardHandler)).
+		NewClient("relay", broker.Hooks{})
+	if err := client.Connect(t.Context()); !errors.Is(err, radio.ErrUnsupportedBaudRate) {
+		t.Errorf("Connect(): error got '%v', want '%v'", err, radio.ErrUnsupportedBaudRate)
+	}
+}
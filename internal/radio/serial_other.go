@@ -0,0 +1,10 @@
+//go:build !linux
+
+package radio
+
+import "os"
+
+// openSerial returns ErrSerialUnsupported, serial ports are only supported on Linux.
+func openSerial(string, int) (*os.File, error) {
+	return nil, ErrSerialUnsupported
+}
@@ -1,20 +1,10 @@
 package relay
 
-import (
-	"time"
+import "time"
 
-	"github.com/na4ma4/meshtastic-mqtt-translate/internal/store"
-)
-
-// Config holds the relay configuration.
+// Config holds the relay output configuration.
 type Config struct {
-	Broker     string
-	ClientID   string
-	Topic      string
-	Username   string
-	Password   string
-	Store      store.Store
-	DryRun     bool
-	Keepalive  time.Duration
-	RetainFlag bool
+	// TelemetryExpiry is the MQTT 5 message expiry of published telemetry, optional.
+	TelemetryExpiry time.Duration
+	RetainFlag      bool
 }
@@ -6,37 +6,80 @@ import (
 	"encoding/json"
 	"log/slog"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/na4ma4/meshtastic-mqtt-translate/internal/parser"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/downlink"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/meshcrypto"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/metrics"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/relay"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/na4ma4/go-contextual"
+	"google.golang.org/protobuf/proto"
 )
 
 const testTopic = "msh/ANZ/2/json/MediumFast/!44be043f"
 
-func TestNewRelay(t *testing.T) {
-	config := relay.Config{
-		Broker:   "tcp://localhost:1883",
-		ClientID: "test-client",
-		Topic:    "test/topic",
-	}
+// recorder is a pipeline.Publisher recording the published messages.
+type recorder struct {
+	lock sync.Mutex
+	msgs []*broker.Message
+}
+
+func (r *recorder) Publish(_ context.Context, msg *broker.Message) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
 
-	relayClient, err := relay.NewRelay(contextual.New(context.TODO()), config, slog.New(slog.DiscardHandler))
+// translate passes a payload through a pipeline with only the relay output, returning the published message.
+func translate(t *testing.T, config pipeline.Config, payload []byte, topic string) ([]byte, string) {
+	t.Helper()
+
+	p, err := pipeline.NewPipeline(contextual.New(t.Context()), config, slog.New(slog.DiscardHandler))
 	if err != nil {
-		t.Fatalf("Failed to create relay: %v", err)
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+
+	rec := &recorder{}
+	p.AddOutput(relay.NewOutput(rec, relay.Config{}))
+	p.HandleMessage(&broker.Message{Topic: topic, Payload: payload})
+
+	if len(rec.msgs) == 0 {
+		return nil, ""
 	}
 
-	if relayClient == nil {
-		t.Fatal("Expected relay to be non-nil")
+	return rec.msgs[0].Payload, rec.msgs[0].Topic
+}
+
+func TestNewOutput(t *testing.T) {
+	rec := &recorder{}
+	out := relay.NewOutput(rec, relay.Config{RetainFlag: true, TelemetryExpiry: time.Hour})
+
+	if out.Name != relay.OutputName {
+		t.Errorf("Expected name %q, got %q", relay.OutputName, out.Name)
 	}
 
-	if relayClient.Config.Broker != config.Broker {
-		t.Errorf("Expected Broker to be %s, got %s", config.Broker, relayClient.Config.Broker)
+	sink, ok := out.Sink.(*pipeline.MQTTSink)
+	if !ok {
+		t.Fatalf("Expected MQTT sink, got %T", out.Sink)
+	}
+	if !sink.Retain || sink.TelemetryExpiry != time.Hour || sink.Publisher != rec {
+		t.Errorf("Unexpected sink: %+v", sink)
 	}
 }
 
@@ -45,18 +88,36 @@ func TestConvertToJSONExamples(t *testing.T) {
 	// const encodedMessage = `Ck4NoBJToBX/////IiEIQxIdDbxCFGkSFghlFTeJhUAdTxtYQCVhxI08KLb50gE1VKDu4z22QRRpRQAA0EBIAmDC//////////8BeAeYATgSCk1lZGl1bUZhc3QaCSE0NGJlMDQzZg==`
 	// const encodedMessage = `Co8BDVBWMEoV/////yJiCAQSXAoJITRhMzA1NjUwEhxUZXN0IFNlbWktUGVybWFuZW50IFJlcGVhdGVyGgNsbzAiBiTsSjBWUCgQQiCcQ4D2/JjAwtC31HoIzbngJRmLWcsdxcO043jvDPRdf0gASAA1243IIz2lQhRpRQAAMEFIB2DO//////////8BeAeYAVASCk1lZGl1bUZhc3QaCSE0NGJlMDQzZg==`
 	const encodedMessage = `CooBDSDPWnwVSASN9yJdCAQSVAoJITdjNWFjZjIwEhVBbm5lcmxleSBKdW5jdGlvbiBXU0waBGNmMjAiBiRYfFrPICgsQiBy9dXwrv1cheaZadLd6mkQc8qaIOyVAbhtziuwmcQTfjVCWQJ6NYe3B1A9YEMUaUUAADRBSAFg0P//////////AXgFmAFQEgpNZWRpdW1GYXN0GgkhNDRiZTA0M2Y=`
-	relayClient := &relay.Relay{
-		Logger: slog.New(slog.DiscardHandler),
-		Parser: parser.NewParser(slog.New(slog.DiscardHandler)),
-	}
-
 	data, err := base64.StdEncoding.DecodeString(encodedMessage)
 	if err != nil {
 		t.Fatalf("Failed to decode base64 message: %v", err)
 	}
 
-	relayClient.HandleMessagePayload(t.Context(), data, "msh/ANZ/2/e/MediumFast/!44be043f")
-	// t.Fail()
+	if payload, _ := translate(t, pipeline.Config{}, data, "msh/ANZ/2/e/MediumFast/!44be043f"); payload == nil {
+		t.Error("Expected payload to be non-nil")
+	}
+}
+
+func jsonCmpOptions() []cmp.Option {
+	return []cmp.Option{
+		cmp.Comparer(func(x, y float64) bool {
+			if x == y {
+				return true
+			}
+			delta := math.Abs(x - y)
+			mean := math.Abs(x+y) / 2.0
+			return delta/mean < 0.001
+		}),
+		cmp.FilterValues(func(x, y []byte) bool {
+			return json.Valid(x) && json.Valid(y)
+		}, cmp.Transformer("ParseJSON", func(in []byte) interface{} {
+			var out any
+			if err := json.Unmarshal(in, &out); err != nil {
+				panic(err) // should never occur given previous filter to ensure valid JSON
+			}
+			return out
+		})),
+	}
 }
 
 type convertJSONTestCase struct {
@@ -101,45 +162,26 @@ func TestConvertToJSONTable(t *testing.T) {
 		loadTestCase(t, "message-08"),
 		loadTestCase(t, "message-09"),
 		loadTestCase(t, "message-10"),
+		loadTestCase(t, "message-12"),
+		loadTestCase(t, "message-13"),
+		loadTestCase(t, "message-14"),
+		loadTestCase(t, "message-15"),
+		loadTestCase(t, "message-16"),
+		loadTestCase(t, "message-17"),
+		loadTestCase(t, "message-18"),
+		loadTestCase(t, "message-19"),
 	}
 
-	transformJSON := []cmp.Option{
-		cmp.Comparer(func(x, y float64) bool {
-			if x == y {
-				return true
-			}
-			delta := math.Abs(x - y)
-			mean := math.Abs(x+y) / 2.0
-			return delta/mean < 0.001
-		}),
-		cmp.FilterValues(func(x, y []byte) bool {
-			return json.Valid(x) && json.Valid(y)
-		}, cmp.Transformer("ParseJSON", func(in []byte) interface{} {
-			var out any
-			if err := json.Unmarshal(in, &out); err != nil {
-				panic(err) // should never occur given previous filter to ensure valid JSON
-			}
-			return out
-		})),
-	}
+	transformJSON := jsonCmpOptions()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			logger := slog.New(slog.NewTextHandler(t.Output(), &slog.HandlerOptions{
-				Level: slog.LevelDebug,
-			}))
-			relayClient := &relay.Relay{
-				// Logger: slog.New(slog.DiscardHandler),
-				Logger: logger,
-				Parser: parser.NewParser(logger),
-			}
-
 			data, err := base64.StdEncoding.DecodeString(tt.encodedMessage)
 			if err != nil {
 				t.Fatalf("Failed to decode base64 message: %v", err)
 			}
 
-			payload, _ := relayClient.HandleMessagePayload(t.Context(), data, testTopic)
+			payload, _ := translate(t, pipeline.Config{}, data, testTopic)
 
 			// log.Printf("Converted JSON: %s", payload)
 			// log.Printf("Expected JSON: %s", tt.expectedJSON)
@@ -150,3 +192,216 @@ func TestConvertToJSONTable(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertEncryptedToJSON(t *testing.T) {
+	tt := loadTestCase(t, "message-11")
+
+	keyring := meshcrypto.NewKeyring()
+	if err := keyring.AddChannel("LongFast", "AQ=="); err != nil {
+		t.Fatalf("Failed to add channel key: %v", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(tt.encodedMessage)
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	payload, _ := translate(t, pipeline.Config{Keyring: keyring}, data, testTopic)
+
+	if diff := cmp.Diff(payload, tt.expectedJSON, jsonCmpOptions()...); diff != "" {
+		t.Errorf("Converted JSON does not match expected (-got +want):\n%s", diff)
+	}
+}
+
+func TestConvertUndecryptableType(t *testing.T) {
+	tests := []struct {
+		name      string
+		channelID string
+		pki       bool
+		wantType  string
+	}{
+		{"channel", "LongFast", false, mtypes.TypeEncrypted},
+		{"pki-channel-id", meshcrypto.PKIChannelID, false, mtypes.TypePKIEncrypted},
+		{"pki-flag", "", true, mtypes.TypePKIEncrypted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+				ChannelId: tt.channelID,
+				Packet: &meshtastic.MeshPacket{
+					From:           1,
+					To:             2,
+					Id:             3,
+					PkiEncrypted:   tt.pki,
+					PayloadVariant: &meshtastic.MeshPacket_Encrypted{Encrypted: []byte("not decryptable")},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to marshal envelope: %v", err)
+			}
+
+			payload, _ := translate(t, pipeline.Config{Keyring: meshcrypto.NewKeyring()}, data, testTopic)
+
+			var msg mtypes.Message
+			if err = json.Unmarshal(payload, &msg); err != nil {
+				t.Fatalf("Failed to unmarshal JSON: %v", err)
+			}
+
+			if msg.Type != tt.wantType {
+				t.Errorf("Type = %q, want %q", msg.Type, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestHandleMessagePayloadMetrics(t *testing.T) {
+	tt := loadTestCase(t, "message-11")
+
+	keyring := meshcrypto.NewKeyring()
+	if err := keyring.AddChannel("LongFast", "AQ=="); err != nil {
+		t.Fatalf("Failed to add channel key: %v", err)
+	}
+
+	m := metrics.New()
+	data, err := base64.StdEncoding.DecodeString(tt.encodedMessage)
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	translate(t, pipeline.Config{Keyring: keyring, Metrics: m}, data, testTopic)
+	translate(t, pipeline.Config{Keyring: keyring, Metrics: m}, []byte("not a protobuf"), testTopic)
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`meshtastic_messages_received_total{channel="LongFast",portnum="TEXT_MESSAGE_APP",type="relay"} 1`,
+		`meshtastic_messages_published_total{channel="LongFast",portnum="TEXT_MESSAGE_APP",type="relay"} 1`,
+		`meshtastic_decode_errors_total{stage="envelope",type="pipeline"} 1`,
+		`meshtastic_node_last_seen_timestamp_seconds{node="!a1b2c3d4"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics missing %q", want)
+		}
+	}
+}
+
+func TestHandleMessagePayloadNodeDB(t *testing.T) {
+	tt := loadTestCase(t, "message-03")
+
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+	data, err := base64.StdEncoding.DecodeString(tt.encodedMessage)
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	payload, _ := translate(t, pipeline.Config{NodeDB: db}, data, testTopic)
+
+	var msg mtypes.Message
+	if err = json.Unmarshal(payload, &msg); err != nil {
+		t.Fatalf("Failed to unmarshal JSON: %v", err)
+	}
+
+	if db.Len() != 1 {
+		t.Fatalf("Expected 1 node in database, got %d", db.Len())
+	}
+	if msg.FromNode == nil || msg.FromNode.LongName == "" {
+		t.Errorf("Expected FromNode to be enriched from the packet, got %+v", msg.FromNode)
+	}
+}
+
+func TestHandleMessagePayloadDecodeErrorMetrics(t *testing.T) {
+	data, err := proto.Marshal(&meshtastic.ServiceEnvelope{
+		ChannelId: "LongFast",
+		Packet: &meshtastic.MeshPacket{
+			From: 0x44be043f,
+			To:   mtypes.BroadcastNode,
+			Id:   1,
+			PayloadVariant: &meshtastic.MeshPacket_Decoded{Decoded: &meshtastic.Data{
+				Portnum: meshtastic.PortNum_TELEMETRY_APP,
+				Payload: []byte{0xff, 0xff, 0xff},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal envelope: %v", err)
+	}
+
+	m := metrics.New()
+	payload, _ := translate(t, pipeline.Config{Metrics: m}, data, testTopic)
+	if payload == nil {
+		t.Fatal("Expected message with the raw payload to still be relayed")
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	const want = `meshtastic_decode_errors_total{stage="message",type="pipeline"} 1`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("Metrics missing %q", want)
+	}
+}
+
+func TestHandleMessagePayloadTopic(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		topic string
+		want  string
+	}{
+		{"channel", "message-12", "msh/ANZ/2/e/MediumFast/!44be043f", "msh/ANZ/2/json/MediumFast/!44be043f"},
+		{"map-report", "message-14", "msh/ANZ/2/map/", "msh/ANZ/2/json/map/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := loadTestCase(t, tt.file)
+
+			data, err := base64.StdEncoding.DecodeString(tc.encodedMessage)
+			if err != nil {
+				t.Fatalf("Failed to decode base64 message: %v", err)
+			}
+
+			if _, topic := translate(t, pipeline.Config{}, data, tt.topic); topic != tt.want {
+				t.Errorf("Topic = %q, want %q", topic, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownlinkRoundTrip(t *testing.T) {
+	keyring := meshcrypto.NewKeyring()
+	if err := keyring.AddChannel("MediumFast", "AQ=="); err != nil {
+		t.Fatalf("Failed to add channel key: %v", err)
+	}
+
+	d := downlink.NewDownlink(downlink.Config{
+		RootTopic: "msh/ANZ",
+		Channel:   "MediumFast",
+		Gateway:   "!deadbeef",
+		Keyring:   keyring,
+	})
+	msg, err := d.Encode([]byte(`{"from":"!a0cbc3a8","type":"sendtext","payload":"hello mesh"}`))
+	if err != nil {
+		t.Fatalf("Encode() error: %v", err)
+	}
+
+	payload, topic := translate(t, pipeline.Config{Keyring: keyring}, msg.Payload, msg.Topic)
+	if topic != "msh/ANZ/2/json/MediumFast/!deadbeef" {
+		t.Errorf("Unexpected topic: got %q", topic)
+	}
+
+	var got struct {
+		From    uint32 `json:"from"`
+		Type    string `json:"type"`
+		Payload string `json:"payload"`
+	}
+	if err = json.Unmarshal(payload, &got); err != nil {
+		t.Fatalf("Failed to unmarshal JSON %s: %v", payload, err)
+	}
+	if got.From != 0xa0cbc3a8 || got.Type != "TEXT_MESSAGE_APP" || got.Payload != "hello mesh" {
+		t.Errorf("Unexpected message: %s", payload)
+	}
+}
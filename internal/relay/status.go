@@ -1,17 +0,0 @@
-package relay
-
-type Status struct {
-	SourceBrokerConnected bool `json:"source_broker_connected"`
-	DestBrokerConnected   bool `json:"dest_broker_connected"`
-	Status                bool `json:"status"`
-}
-
-func (r *Relay) GetStatus() Status {
-	sourceConnected := r.sourceClient != nil && r.sourceClient.IsConnected()
-	destConnected := r.destClient != nil && r.destClient.IsConnected()
-	return Status{
-		SourceBrokerConnected: sourceConnected,
-		DestBrokerConnected:   destConnected,
-		Status:                sourceConnected && destConnected,
-	}
-}
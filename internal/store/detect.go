@@ -30,6 +30,12 @@ func (e *Error) Error() string {
 // ErrUnsupportedStore is returned when the store type is not supported.
 var ErrUnsupportedStore = &Error{"unsupported store type"}
 
+// ErrNotFound is returned when the requested message does not exist in the store.
+var ErrNotFound = &Error{"message not found"}
+
+// ErrNotImplemented is returned when the store does not support the operation.
+var ErrNotImplemented = &Error{"operation not supported by store"}
+
 // Factory defines the interface for store factories.
 type Factory interface {
 	Match(in *url.URL) bool
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -31,6 +33,34 @@ func (gormMessage) TableName() string {
 	return "messages"
 }
 
+type gormNode struct {
+	Num       uint32       `gorm:"primaryKey;autoIncrement:false"`
+	NodeID    string       `gorm:"index"`
+	JSONData  *mtypes.Node `gorm:"type:jsonb"`
+	LastHeard time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName overrides the table name used by gormNode to `nodes`.
+func (gormNode) TableName() string {
+	return "nodes"
+}
+
+type gormDeadLetter struct {
+	ID        uint   `gorm:"primaryKey"`
+	Reason    string `gorm:"index"`
+	Error     string
+	Topic     string
+	Payload   []byte
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// TableName overrides the table name used by gormDeadLetter to `dead_letters`.
+func (gormDeadLetter) TableName() string {
+	return "dead_letters"
+}
+
 type GormStore struct {
 	db     *gorm.DB
 	Logger *slog.Logger
@@ -54,7 +84,7 @@ func newGormStore(in gorm.Dialector, cfg Config) (*GormStore, error) {
 		}
 	}
 
-	if err := db.AutoMigrate(&gormMessage{}); err != nil {
+	if err := db.AutoMigrate(&gormMessage{}, &gormNode{}, &gormDeadLetter{}); err != nil {
 		return nil, fmt.Errorf("failed to migrate gorm DB: %w", err)
 	}
 
@@ -93,7 +123,9 @@ func (s *GormStore) Save(ctx context.Context, messageID, portNum string, payload
 
 func (s *GormStore) Get(ctx context.Context, messageID string) (*mtypes.Message, error) {
 	item, err := gorm.G[gormMessage](s.db).Where("message_id = ?", messageID).First(ctx)
-	if err != nil {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get message by ID %s: %w", messageID, ErrNotFound)
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to get message by ID %s: %w", messageID, err)
 	}
 	return item.JSONData, nil
@@ -101,7 +133,9 @@ func (s *GormStore) Get(ctx context.Context, messageID string) (*mtypes.Message,
 
 func (s *GormStore) GetPayload(ctx context.Context, messageID string) ([]byte, error) {
 	item, err := gorm.G[gormMessage](s.db).Where("message_id = ?", messageID).First(ctx)
-	if err != nil {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get message by ID %s: %w", messageID, ErrNotFound)
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to get message by ID %s: %w", messageID, err)
 	}
 	return item.Payload, nil
@@ -120,6 +154,75 @@ func (s *GormStore) Iterate(ctx context.Context, f func(*mtypes.Message) error)
 	return nil
 }
 
+func (s *GormStore) Query(ctx context.Context, q Query) ([]*mtypes.Message, error) {
+	tx := s.db.WithContext(ctx).Order("created_at desc")
+	if q.From != nil {
+		tx = tx.Where("node_from = ?", *q.From)
+	}
+	if q.PortNum != "" {
+		tx = tx.Where("port_num = ?", q.PortNum)
+	}
+	if !q.Since.IsZero() {
+		tx = tx.Where("created_at >= ?", q.Since)
+	}
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+
+	var messages []gormMessage
+	if err := tx.Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+
+	out := make([]*mtypes.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.JSONData != nil {
+			out = append(out, msg.JSONData)
+		}
+	}
+	return out, nil
+}
+
+func (s *GormStore) SaveNode(ctx context.Context, node *mtypes.Node) error {
+	item := gormNode{
+		Num:       node.Num,
+		NodeID:    node.ID,
+		JSONData:  node,
+		LastHeard: node.LastHeard,
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "num"}},
+		DoUpdates: clause.AssignmentColumns([]string{"node_id", "json_data", "last_heard", "updated_at"}),
+	}).Create(&item).Error
+}
+
+func (s *GormStore) IterateNodes(ctx context.Context, f func(*mtypes.Node) error) error {
+	var nodes []gormNode
+	if err := s.db.WithContext(ctx).Order("num").Find(&nodes).Error; err != nil {
+		return fmt.Errorf("failed to iterate nodes: %w", err)
+	}
+	for _, node := range nodes {
+		if node.JSONData == nil {
+			continue
+		}
+		if err := f(node.JSONData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *GormStore) SaveDeadLetter(ctx context.Context, letter *mtypes.DeadLetter) error {
+	item := gormDeadLetter{
+		Reason:    letter.Reason,
+		Error:     letter.Error,
+		Topic:     letter.Topic,
+		Payload:   letter.Payload,
+		CreatedAt: letter.Time,
+	}
+	return s.db.WithContext(ctx).Create(&item).Error
+}
+
 // // JSONB Interface for JSONB Field of yourTableName Table
 // type JSONB map[string]any
 
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 
 	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
 )
@@ -92,7 +93,7 @@ func (s *JSONDirStore) Save(_ context.Context, messageID, portNum string, payloa
 
 func (s *JSONDirStore) Get(_ context.Context, _ string) (*mtypes.Message, error) {
 	// Implementation for retrieving a message by ID
-	return nil, errors.New("Get method not implemented")
+	return nil, fmt.Errorf("get message: %w", ErrNotImplemented)
 }
 
 func (s *JSONDirStore) GetPayload(_ context.Context, _ string) ([]byte, error) {
@@ -105,6 +106,64 @@ func (s *JSONDirStore) Iterate(_ context.Context, _ func(*mtypes.Message) error)
 	return errors.New("Iterate method not implemented")
 }
 
+func (s *JSONDirStore) Query(_ context.Context, _ Query) ([]*mtypes.Message, error) {
+	// Implementation for querying messages
+	return nil, fmt.Errorf("query messages: %w", ErrNotImplemented)
+}
+
+func (s *JSONDirStore) SaveNode(_ context.Context, node *mtypes.Node) error {
+	jsonData, err := json.Marshal(node)
+	if err != nil {
+		return fmt.Errorf("failed to encode node JSON data: %w", err)
+	}
+
+	fileName := path.Join(s.config.Directory, fmt.Sprintf("node_%08x.json", node.Num))
+	if err = writeFileAtomic(fileName, jsonData); err != nil {
+		return fmt.Errorf("failed to write node file %s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+func (s *JSONDirStore) IterateNodes(_ context.Context, f func(*mtypes.Node) error) error {
+	fileNames, err := filepath.Glob(path.Join(s.config.Directory, "node_*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list node files: %w", err)
+	}
+
+	for _, fileName := range fileNames {
+		data, readErr := os.ReadFile(fileName)
+		if readErr != nil {
+			return fmt.Errorf("failed to read node file %s: %w", fileName, readErr)
+		}
+
+		node := &mtypes.Node{}
+		if err = json.Unmarshal(data, node); err != nil {
+			return fmt.Errorf("failed to decode node file %s: %w", fileName, err)
+		}
+
+		if err = f(node); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONDirStore) SaveDeadLetter(_ context.Context, letter *mtypes.DeadLetter) error {
+	jsonData, err := letter.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter JSON data: %w", err)
+	}
+
+	fileName := path.Join(s.config.Directory, fmt.Sprintf("deadletter_%d_%s.json", letter.Time.UnixNano(), letter.Reason))
+	if err = writeFileAtomic(fileName, jsonData); err != nil {
+		return fmt.Errorf("failed to write dead letter file %s: %w", fileName, err)
+	}
+
+	return nil
+}
+
 func writeFileAtomic(filename string, data []byte) error {
 	var tmpFile *os.File
 	{
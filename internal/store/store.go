@@ -22,9 +22,29 @@ type Store interface {
 	Get(ctx context.Context, messageID string) (*mtypes.Message, error)
 	GetPayload(ctx context.Context, messageID string) ([]byte, error)
 	Iterate(ctx context.Context, f func(*mtypes.Message) error) error
+	Query(ctx context.Context, q Query) ([]*mtypes.Message, error)
 	Close() error
 }
 
+// Query filters the messages returned by Store.Query, zero values are not filtered on.
+type Query struct {
+	From    *uint32
+	PortNum string
+	Since   time.Time
+	Limit   int
+}
+
+// NodeStore is implemented by stores that can persist the node database.
+type NodeStore interface {
+	SaveNode(ctx context.Context, node *mtypes.Node) error
+	IterateNodes(ctx context.Context, f func(*mtypes.Node) error) error
+}
+
+// DeadLetterStore is implemented by stores that can persist messages that failed to decode or publish.
+type DeadLetterStore interface {
+	SaveDeadLetter(ctx context.Context, letter *mtypes.DeadLetter) error
+}
+
 type Config struct {
 	SlowThreshold time.Duration
 	LogLevel      slog.Level
@@ -0,0 +1,154 @@
+// Package stream is the pipeline output broadcasting the JSON translation of each packet to live subscribers, e.g.
+// the /ws and /events endpoints of the health server.
+package stream
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+)
+
+// OutputName is the name of the stream output.
+const OutputName = "stream"
+
+// DefaultBufferSize is the number of messages buffered for a subscriber before messages are dropped.
+const DefaultBufferSize = 64
+
+// Filter selects the messages sent to a subscriber, zero values are not filtered on.
+type Filter struct {
+	// PortNum is the port of the message, e.g. TEXT_MESSAGE_APP.
+	PortNum string
+	// Node is the node that sent the message.
+	Node *uint32
+	// Channel is the channel ID, e.g. LongFast, or the channel number of the message.
+	Channel string
+}
+
+// Match returns true if the message matches the filter, channelID is the channel ID of the envelope or empty if it
+// is not known.
+func (f Filter) Match(msg *mtypes.Message, channelID string) bool {
+	if f.PortNum != "" && !strings.EqualFold(f.PortNum, msg.Type) {
+		return false
+	}
+
+	if f.Node != nil && *f.Node != msg.From {
+		return false
+	}
+
+	if f.Channel != "" && f.Channel != channelID && f.Channel != strconv.FormatUint(uint64(msg.Channel), 10) {
+		return false
+	}
+
+	return true
+}
+
+// NewOutput returns the stream output, broadcasting on h.
+func NewOutput(h *Hub) *pipeline.Output {
+	return &pipeline.Output{
+		Name: OutputName,
+		Sink: h,
+	}
+}
+
+// Hub broadcasts messages to its subscribers, a subscriber that falls behind misses messages rather than slowing
+// the pipeline down.
+type Hub struct {
+	lock sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewHub returns a hub without subscribers.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Len returns the number of subscribers.
+func (h *Hub) Len() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return len(h.subs)
+}
+
+// Write sends the JSON translation of the packet to the subscribers whose filter it matches.
+func (h *Hub) Write(_ context.Context, pkt *pipeline.Packet) error {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	if len(h.subs) == 0 {
+		return nil
+	}
+
+	payload, err := pkt.Message.ToJSON()
+	if err != nil {
+		return fmt.Errorf("%w: %w", pipeline.ErrEncodeJSON, err)
+	}
+	payload = bytes.TrimSpace(payload)
+
+	for sub := range h.subs {
+		if !sub.Filter.Match(pkt.Message, pkt.Envelope.GetChannelId()) {
+			continue
+		}
+
+		select {
+		case sub.messages <- payload:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns a subscription to the messages matching the filter, it must be closed when done.
+func (h *Hub) Subscribe(f Filter) *Subscription {
+	sub := &Subscription{
+		Filter:   f,
+		hub:      h,
+		messages: make(chan []byte, DefaultBufferSize),
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// Subscription receives the JSON messages matching its filter.
+type Subscription struct {
+	Filter Filter
+
+	hub      *Hub
+	messages chan []byte
+	dropped  atomic.Uint64
+	once     sync.Once
+}
+
+// Messages returns the channel messages are received on, it is closed when the subscription is closed.
+func (s *Subscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+// Dropped returns the number of messages dropped because the subscriber fell behind.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close removes the subscription from the hub.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.hub.lock.Lock()
+		defer s.hub.lock.Unlock()
+
+		delete(s.hub.subs, s)
+		close(s.messages)
+	})
+}
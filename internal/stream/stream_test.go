@@ -0,0 +1,107 @@
+package stream_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/stream"
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+func packet(from uint32, portNum string, channel uint32) *pipeline.Packet {
+	return &pipeline.Packet{
+		Message:  &mtypes.Message{From: from, Type: portNum, Channel: channel, Payload: "hello"},
+		Envelope: &meshtastic.ServiceEnvelope{ChannelId: "LongFast"},
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	node := uint32(1244681808)
+	msg := &mtypes.Message{From: node, Type: "TEXT_MESSAGE_APP", Channel: 8}
+	other := uint32(1)
+
+	tests := []struct {
+		name   string
+		filter stream.Filter
+		want   bool
+	}{
+		{"empty", stream.Filter{}, true},
+		{"port", stream.Filter{PortNum: "text_message_app"}, true},
+		{"other port", stream.Filter{PortNum: "POSITION_APP"}, false},
+		{"node", stream.Filter{Node: &node}, true},
+		{"other node", stream.Filter{Node: &other}, false},
+		{"channel id", stream.Filter{Channel: "LongFast"}, true},
+		{"channel number", stream.Filter{Channel: "8"}, true},
+		{"other channel", stream.Filter{Channel: "MediumFast"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(msg, "LongFast"); got != tt.want {
+				t.Errorf("Match(): got '%t', want '%t'", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHub(t *testing.T) {
+	h := stream.NewHub()
+	if out := stream.NewOutput(h); out.Name != stream.OutputName {
+		t.Errorf("NewOutput(): got name '%s', want '%s'", out.Name, stream.OutputName)
+	}
+
+	all := h.Subscribe(stream.Filter{})
+	text := h.Subscribe(stream.Filter{PortNum: "TEXT_MESSAGE_APP"})
+	if got := h.Len(); got != 2 {
+		t.Fatalf("Len(): got '%d', want '2'", got)
+	}
+
+	for _, pkt := range []*pipeline.Packet{packet(1, "TEXT_MESSAGE_APP", 0), packet(2, "POSITION_APP", 0)} {
+		if err := h.Write(context.Background(), pkt); err != nil {
+			t.Fatalf("Write(): error got '%v', want 'nil'", err)
+		}
+	}
+
+	if got := len(all.Messages()); got != 2 {
+		t.Errorf("unfiltered subscriber: got '%d' messages, want '2'", got)
+	}
+	if got := len(text.Messages()); got != 1 {
+		t.Errorf("filtered subscriber: got '%d' messages, want '1'", got)
+	}
+	msg := &mtypes.Message{}
+	if err := json.Unmarshal(<-text.Messages(), msg); err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if msg.From != 1 || msg.Payload != "hello" {
+		t.Errorf("message: got '%+v'", msg)
+	}
+
+	text.Close()
+	text.Close()
+	if got := h.Len(); got != 1 {
+		t.Errorf("Len() after Close(): got '%d', want '1'", got)
+	}
+	if _, open := <-text.Messages(); open {
+		t.Error("Messages() after Close(): channel is open")
+	}
+	all.Close()
+}
+
+func TestHubDropped(t *testing.T) {
+	h := stream.NewHub()
+	sub := h.Subscribe(stream.Filter{})
+	defer sub.Close()
+
+	for range stream.DefaultBufferSize + 3 {
+		if err := h.Write(context.Background(), packet(1, "TEXT_MESSAGE_APP", 0)); err != nil {
+			t.Fatalf("Write(): error got '%v', want 'nil'", err)
+		}
+	}
+
+	if got := sub.Dropped(); got != 3 {
+		t.Errorf("Dropped(): got '%d', want '3'", got)
+	}
+}
@@ -0,0 +1,184 @@
+package topology
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+)
+
+const (
+	// DefaultInterval is the default interval between publishing the graph.
+	DefaultInterval = time.Minute
+	// DefaultMaxAge is the default age after which a node's neighbor list is dropped from the graph.
+	DefaultMaxAge = 24 * time.Hour
+)
+
+// Config holds the topology configuration.
+type Config struct {
+	// NodeDB is used to add node names to the graph, optional.
+	NodeDB *nodedb.DB
+	// Topic is the topic the graph is periodically published to, publishing is disabled if empty.
+	Topic string
+	// Interval is the interval between publishing the graph.
+	Interval time.Duration
+	// MaxAge is the age after which a node's neighbor list is dropped from the graph.
+	MaxAge time.Duration
+}
+
+type neighbors struct {
+	list    []translator.Neighbor
+	updated time.Time
+}
+
+// Graph is the mesh adjacency graph, built from the latest neighbor list reported by each node.
+type Graph struct {
+	Config Config
+	lock   sync.Mutex
+	nodes  map[uint32]*neighbors
+}
+
+// NewGraph creates a new, empty, adjacency graph.
+func NewGraph(config Config) *Graph {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = DefaultMaxAge
+	}
+
+	return &Graph{
+		Config: config,
+		nodes:  map[uint32]*neighbors{},
+	}
+}
+
+// Observe replaces the neighbor list of the sending node from a NEIGHBORINFO_APP message.
+func (g *Graph) Observe(msg *mtypes.Message) {
+	if msg == nil {
+		return
+	}
+
+	info, ok := msg.Payload.(*translator.NeighborInfoApp)
+	if !ok || info == nil {
+		return
+	}
+
+	node := info.NodeID
+	if node == 0 {
+		node = msg.From
+	}
+
+	updated := time.Now()
+	if msg.Timestamp > 0 {
+		updated = time.Unix(int64(msg.Timestamp), 0)
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.nodes[node] = &neighbors{
+		list:    slices.Clone(info.Neighbors),
+		updated: updated,
+	}
+}
+
+// Node is a node in the graph, field names follow the Grafana node graph panel.
+type Node struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Subtitle  string `json:"subtitle,omitempty"`
+	Neighbors int    `json:"mainstat"`
+	// LastUpdated is the time the node last reported its neighbors, omitted for nodes only seen as a neighbor.
+	LastUpdated *time.Time `json:"last_updated,omitempty"`
+}
+
+// Edge is a directed edge from a reporting node to a neighbor it heard.
+type Edge struct {
+	ID         string                    `json:"id"`
+	Source     string                    `json:"source"`
+	Target     string                    `json:"target"`
+	SNR        translator.SpecialFloat64 `json:"mainstat"`
+	LastRxTime *time.Time                `json:"last_rx_time,omitempty"`
+}
+
+// Snapshot is the adjacency graph at a point in time.
+type Snapshot struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// ToJSON converts the Snapshot to JSON bytes.
+func (s *Snapshot) ToJSON() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// Snapshot returns the current graph, dropping neighbor lists older than the maximum age.
+func (g *Graph) Snapshot() *Snapshot {
+	cutoff := time.Now().Add(-g.Config.MaxAge)
+
+	g.lock.Lock()
+	for num, n := range g.nodes {
+		if n.updated.Before(cutoff) {
+			delete(g.nodes, num)
+		}
+	}
+
+	out := &Snapshot{Nodes: []Node{}, Edges: []Edge{}}
+	nodes := map[uint32]*Node{}
+	addNode := func(num uint32) *Node {
+		if n, ok := nodes[num]; ok {
+			return n
+		}
+		n := &Node{ID: mtypes.FormatNodeID(num), Title: mtypes.FormatNodeID(num)}
+		nodes[num] = n
+		return n
+	}
+
+	for num, n := range g.nodes {
+		source := addNode(num)
+		source.Neighbors = len(n.list)
+		source.LastUpdated = translator.Ptr(n.updated.UTC())
+
+		for _, neighbor := range n.list {
+			addNode(neighbor.NodeID)
+			edge := Edge{
+				ID:     fmt.Sprintf("%s-%s", mtypes.FormatNodeID(num), mtypes.FormatNodeID(neighbor.NodeID)),
+				Source: mtypes.FormatNodeID(num),
+				Target: mtypes.FormatNodeID(neighbor.NodeID),
+				SNR:    neighbor.Snr,
+			}
+			if neighbor.LastRxTime > 0 {
+				edge.LastRxTime = translator.Ptr(time.Unix(int64(neighbor.LastRxTime), 0).UTC())
+			}
+			out.Edges = append(out.Edges, edge)
+		}
+	}
+	g.lock.Unlock()
+
+	for num, n := range nodes {
+		if g.Config.NodeDB != nil {
+			if known, ok := g.Config.NodeDB.Get(num); ok && known.LongName != "" {
+				n.Title = known.LongName
+				n.Subtitle = known.ShortName
+			}
+		}
+
+		out.Nodes = append(out.Nodes, *n)
+	}
+
+	slices.SortFunc(out.Nodes, func(a, b Node) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	slices.SortFunc(out.Edges, func(a, b Edge) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return out
+}
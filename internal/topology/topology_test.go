@@ -0,0 +1,86 @@
+package topology_test
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/nodedb"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/topology"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/translator"
+)
+
+func neighborInfo(from uint32, timestamp time.Time, neighbors ...uint32) *mtypes.Message {
+	info := &translator.NeighborInfoApp{NodeID: from}
+	for i, n := range neighbors {
+		info.Neighbors = append(info.Neighbors, translator.Neighbor{
+			NodeID:     n,
+			Snr:        translator.SpecialFloat64(float64(i) + 0.5),
+			LastRxTime: uint32(timestamp.Unix()),
+		})
+	}
+
+	return &mtypes.Message{
+		From:      from,
+		To:        mtypes.BroadcastNode,
+		Timestamp: uint32(timestamp.Unix()),
+		Type:      "NEIGHBORINFO_APP",
+		Payload:   info,
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	db := nodedb.NewDB(nodedb.Config{}, slog.New(slog.DiscardHandler))
+	db.Observe(&mtypes.Message{
+		From:    0x44be043f,
+		Payload: &translator.User{LongName: "Gateway Node", ShortName: "GW"},
+	})
+
+	g := topology.NewGraph(topology.Config{NodeDB: db})
+	now := time.Now()
+
+	g.Observe(neighborInfo(0x44be043f, now, 0xa0cbc3a8, 0x7c5acf20))
+	g.Observe(neighborInfo(0xa0cbc3a8, now, 0x44be043f))
+	g.Observe(&mtypes.Message{From: 0x11111111, Payload: "not neighbor info"})
+
+	snap := g.Snapshot()
+
+	if len(snap.Nodes) != 3 {
+		t.Fatalf("Expected 3 nodes, got %d: %+v", len(snap.Nodes), snap.Nodes)
+	}
+	if len(snap.Edges) != 3 {
+		t.Fatalf("Expected 3 edges, got %d: %+v", len(snap.Edges), snap.Edges)
+	}
+
+	gw := snap.Nodes[0]
+	if gw.ID != "!44be043f" || gw.Title != "Gateway Node" || gw.Subtitle != "GW" || gw.Neighbors != 2 {
+		t.Errorf("Unexpected gateway node: %+v", gw)
+	}
+	if snap.Nodes[1].ID != "!7c5acf20" || snap.Nodes[1].LastUpdated != nil {
+		t.Errorf("Expected neighbor-only node to have no last updated time: %+v", snap.Nodes[1])
+	}
+
+	edge := snap.Edges[0]
+	if edge.Source != "!44be043f" || edge.Target != "!7c5acf20" || edge.SNR != 1.5 {
+		t.Errorf("Unexpected edge: %+v", edge)
+	}
+
+	// A new report replaces the previous neighbor list of the node.
+	g.Observe(neighborInfo(0x44be043f, now, 0xa0cbc3a8))
+	if snap = g.Snapshot(); len(snap.Edges) != 2 {
+		t.Errorf("Expected 2 edges after neighbor list replaced, got %d: %+v", len(snap.Edges), snap.Edges)
+	}
+}
+
+func TestSnapshotMaxAge(t *testing.T) {
+	g := topology.NewGraph(topology.Config{MaxAge: time.Hour})
+
+	g.Observe(neighborInfo(0x44be043f, time.Now().Add(-2*time.Hour), 0xa0cbc3a8))
+	g.Observe(neighborInfo(0x7c5acf20, time.Now(), 0xa0cbc3a8))
+
+	snap := g.Snapshot()
+	if len(snap.Edges) != 1 || snap.Edges[0].Source != "!7c5acf20" {
+		t.Errorf("Expected stale neighbor list to be dropped, got %+v", snap.Edges)
+	}
+}
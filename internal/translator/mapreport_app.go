@@ -0,0 +1,71 @@
+//nolint:protogetter // copying structures
+package translator
+
+import (
+	"encoding/json"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+type MapReportApp struct {
+	// A full name for this user.
+	LongName string `json:"long_name,omitempty"`
+	// A very short name, ideally two characters.
+	ShortName string `json:"short_name,omitempty"`
+	// Role of the node.
+	Role string `json:"role,omitempty"`
+	// Hardware model of the node.
+	HwModel string `json:"hw_model,omitempty"`
+	// Device firmware version string.
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+	// The region code for the radio (US, ANZ, EU_433, etc).
+	Region string `json:"region,omitempty"`
+	// Modem preset used by the radio (LONG_FAST, MEDIUM_SLOW, etc).
+	ModemPreset string `json:"modem_preset,omitempty"`
+	// Whether the node has a channel with the default PSK and name, on the default frequency slot.
+	HasDefaultChannel bool `json:"has_default_channel"`
+	// Latitude in degrees, omitted if the node does not share its location.
+	Latitude *float64 `json:"latitude,omitempty"`
+	// Longitude in degrees, omitted if the node does not share its location.
+	Longitude *float64 `json:"longitude,omitempty"`
+	// Altitude in meters above MSL.
+	Altitude int32 `json:"altitude,omitempty"`
+	// Bits of precision of the latitude and longitude set by the sending node.
+	PositionPrecision uint32 `json:"position_precision,omitempty"`
+	// Number of online nodes heard locally (not via MQTT) in the last 2 hours.
+	NumOnlineLocalNodes uint32 `json:"num_online_local_nodes"`
+	// Whether the user has opted in to share their location with the MQTT server.
+	HasOptedReportLocation bool `json:"has_opted_report_location,omitempty"`
+}
+
+func NewMapReportApp(in *meshtastic.MapReport) *MapReportApp {
+	if in == nil {
+		return nil
+	}
+
+	out := &MapReportApp{
+		LongName:               in.LongName,
+		ShortName:              in.ShortName,
+		Role:                   in.Role.String(),
+		HwModel:                in.HwModel.String(),
+		FirmwareVersion:        in.FirmwareVersion,
+		Region:                 in.Region.String(),
+		ModemPreset:            in.ModemPreset.String(),
+		HasDefaultChannel:      in.HasDefaultChannel,
+		Altitude:               in.Altitude,
+		PositionPrecision:      in.PositionPrecision,
+		NumOnlineLocalNodes:    in.NumOnlineLocalNodes,
+		HasOptedReportLocation: in.HasOptedReportLocation,
+	}
+
+	if in.LatitudeI != 0 || in.LongitudeI != 0 {
+		out.Latitude = Ptr(float64(in.LatitudeI) * CoordinateScale)
+		out.Longitude = Ptr(float64(in.LongitudeI) * CoordinateScale)
+	}
+
+	return out
+}
+
+func (p *MapReportApp) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
@@ -0,0 +1,62 @@
+//nolint:protogetter // copying structures
+package translator
+
+import (
+	"encoding/json"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+type Neighbor struct {
+	// Node ID of the neighbor.
+	NodeID uint32 `json:"node_id"`
+	// SNR of the last message heard from the neighbor.
+	Snr SpecialFloat64 `json:"snr"`
+	// Reception time (in secs since 1970) of the last message heard from the neighbor.
+	LastRxTime uint32 `json:"last_rx_time,omitempty"`
+	// Broadcast interval of the neighbor (in seconds).
+	NodeBroadcastIntervalSecs uint32 `json:"node_broadcast_interval_secs,omitempty"`
+}
+
+type NeighborInfoApp struct {
+	// The node ID of the node sending info on its neighbors.
+	NodeID uint32 `json:"node_id"`
+	// Node ID of the node that last sent this neighbor info.
+	LastSentByID uint32 `json:"last_sent_by_id,omitempty"`
+	// Broadcast interval of the node (in seconds).
+	NodeBroadcastIntervalSecs uint32 `json:"node_broadcast_interval_secs,omitempty"`
+	// The list of out edges from this node.
+	Neighbors []Neighbor `json:"neighbors"`
+}
+
+func NewNeighborInfoApp(in *meshtastic.NeighborInfo) *NeighborInfoApp {
+	if in == nil {
+		return nil
+	}
+
+	out := &NeighborInfoApp{
+		NodeID:                    in.NodeId,
+		LastSentByID:              in.LastSentById,
+		NodeBroadcastIntervalSecs: in.NodeBroadcastIntervalSecs,
+		Neighbors:                 make([]Neighbor, 0, len(in.Neighbors)),
+	}
+
+	for _, n := range in.Neighbors {
+		if n == nil {
+			continue
+		}
+
+		out.Neighbors = append(out.Neighbors, Neighbor{
+			NodeID:                    n.NodeId,
+			Snr:                       SpecialFloat64(n.Snr),
+			LastRxTime:                n.LastRxTime,
+			NodeBroadcastIntervalSecs: n.NodeBroadcastIntervalSecs,
+		})
+	}
+
+	return out
+}
+
+func (p *NeighborInfoApp) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
@@ -0,0 +1,33 @@
+//nolint:protogetter // copying structures
+package translator
+
+import (
+	"encoding/json"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+type PaxcounterApp struct {
+	// Number of WiFi devices seen.
+	Wifi uint32 `json:"wifi"`
+	// Number of BLE devices seen.
+	Ble uint32 `json:"ble"`
+	// Uptime of the node in seconds.
+	Uptime uint32 `json:"uptime"`
+}
+
+func NewPaxcounterApp(in *meshtastic.Paxcount) *PaxcounterApp {
+	if in == nil {
+		return nil
+	}
+
+	return &PaxcounterApp{
+		Wifi:   in.Wifi,
+		Ble:    in.Ble,
+		Uptime: in.Uptime,
+	}
+}
+
+func (p *PaxcounterApp) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
@@ -7,6 +7,9 @@ import (
 	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
 )
 
+// CoordinateScale converts the integer latitude/longitude of positions to degrees.
+const CoordinateScale = 1e-7
+
 type PositionApp struct {
 	LatitudeI      *int32  `json:"latitude_i"`
 	LongitudeI     *int32  `json:"longitude_i"`
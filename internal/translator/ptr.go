@@ -7,3 +7,8 @@ func ptrFloat[T ~float32 | ~float64](f *T) *float64 {
 	v := float64(*f)
 	return &v
 }
+
+// Ptr returns a pointer to a copy of v.
+func Ptr[T any](v T) *T {
+	return &v
+}
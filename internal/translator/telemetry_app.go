@@ -411,3 +411,33 @@ func NewAirQualityMetrics(in *meshtastic.AirQualityMetrics) *TelemetryAirQuality
 func (p *TelemetryAirQualityMetrics) ToJSON() ([]byte, error) {
 	return json.Marshal(p)
 }
+
+// HealthMetrics - Health telemetry metrics.
+type HealthMetrics struct {
+	// Heart rate (beats per minute)
+	HeartBpm *uint32 `json:"heart_bpm,omitempty"`
+	// SpO2 (blood oxygen saturation) level
+	SpO2 *uint32 `json:"spo2,omitempty"`
+	// Body temperature in degrees Celsius
+	Temperature *SpecialFloat64 `json:"temperature,omitempty"`
+}
+type TelemetryHealthMetrics struct {
+	HealthMetrics *HealthMetrics `json:"health_metrics,omitempty"`
+}
+
+func NewHealthMetrics(in *meshtastic.HealthMetrics) *TelemetryHealthMetrics {
+	if in == nil {
+		return nil
+	}
+	return &TelemetryHealthMetrics{
+		HealthMetrics: &HealthMetrics{
+			HeartBpm:    in.HeartBpm,
+			SpO2:        in.SpO2,
+			Temperature: specialPtrFloat(in.Temperature),
+		},
+	}
+}
+
+func (p *TelemetryHealthMetrics) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
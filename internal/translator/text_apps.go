@@ -0,0 +1,59 @@
+package translator
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+)
+
+// rangeTestSequence matches the "seq <n>" text sent by the range test module.
+var rangeTestSequence = regexp.MustCompile(`^seq (\d+)`)
+
+type DetectionSensorApp struct {
+	// Text sent by the detection sensor module, e.g. "Front Door detected".
+	Text string `json:"text"`
+}
+
+func NewDetectionSensorApp(in []byte) *DetectionSensorApp {
+	return &DetectionSensorApp{Text: string(in)}
+}
+
+func (p *DetectionSensorApp) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+type AlertApp struct {
+	// Text of the critical alert.
+	Text string `json:"text"`
+}
+
+func NewAlertApp(in []byte) *AlertApp {
+	return &AlertApp{Text: string(in)}
+}
+
+func (p *AlertApp) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+type RangeTestApp struct {
+	// Text sent by the range test module.
+	Text string `json:"text"`
+	// Sequence number parsed from the text, omitted if the text is not a range test sequence.
+	Sequence *uint32 `json:"sequence,omitempty"`
+}
+
+func NewRangeTestApp(in []byte) *RangeTestApp {
+	out := &RangeTestApp{Text: string(in)}
+
+	if m := rangeTestSequence.FindStringSubmatch(out.Text); m != nil {
+		if seq, err := strconv.ParseUint(m[1], 10, 32); err == nil {
+			out.Sequence = Ptr(uint32(seq))
+		}
+	}
+
+	return out
+}
+
+func (p *RangeTestApp) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
@@ -0,0 +1,64 @@
+//nolint:protogetter // copying structures
+package translator
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/pkg/meshtastic"
+)
+
+type WaypointApp struct {
+	// Id of the waypoint, used to update or delete an existing waypoint.
+	ID uint32 `json:"id"`
+	// Name of the waypoint, max 30 chars.
+	Name string `json:"name,omitempty"`
+	// Description of the waypoint, max 100 chars.
+	Description string `json:"description,omitempty"`
+	// Icon of the waypoint, a single emoji.
+	Icon string `json:"icon,omitempty"`
+	// Time the waypoint is to expire, omitted if it never expires.
+	Expire *time.Time `json:"expire,omitempty"`
+	// Node number the waypoint is locked to (only that node may edit it), 0 if anyone can edit it.
+	LockedTo uint32 `json:"locked_to,omitempty"`
+	// Latitude in degrees.
+	Latitude *float64 `json:"latitude,omitempty"`
+	// Longitude in degrees.
+	Longitude *float64 `json:"longitude,omitempty"`
+}
+
+func NewWaypointApp(in *meshtastic.Waypoint) *WaypointApp {
+	if in == nil {
+		return nil
+	}
+
+	out := &WaypointApp{
+		ID:          in.Id,
+		Name:        in.Name,
+		Description: in.Description,
+		LockedTo:    in.LockedTo,
+	}
+
+	if in.Icon != 0 && utf8.ValidRune(rune(in.Icon)) {
+		out.Icon = string(rune(in.Icon))
+	}
+
+	if in.Expire != 0 {
+		out.Expire = Ptr(time.Unix(int64(in.Expire), 0).UTC())
+	}
+
+	if in.LatitudeI != nil {
+		out.Latitude = Ptr(float64(*in.LatitudeI) * CoordinateScale)
+	}
+
+	if in.LongitudeI != nil {
+		out.Longitude = Ptr(float64(*in.LongitudeI) * CoordinateScale)
+	}
+
+	return out
+}
+
+func (p *WaypointApp) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
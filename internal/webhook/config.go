@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/filter"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+)
+
+const (
+	// DefaultTimeout is the default timeout of a request.
+	DefaultTimeout = 10 * time.Second
+	// DefaultQueueSize is the default number of messages queued while requests are in flight.
+	DefaultQueueSize = 1000
+	// DefaultBatchInterval is the default time a partial batch waits for more messages.
+	DefaultBatchInterval = time.Second
+)
+
+// Config holds the configuration of a webhook.
+type Config struct {
+	// Name identifies the webhook, the output is named webhook-<name>.
+	Name string
+	URL  string
+	// Headers are added to every request, e.g. Authorization.
+	Headers map[string]string
+	// Secret signs the body of each request with HMAC-SHA256, optional.
+	Secret string
+	// Filter selects the messages posted, optional.
+	Filter *filter.Filter
+	// Timeout is the timeout of a request, DefaultTimeout if zero.
+	Timeout time.Duration
+	// QueueSize is the number of messages queued before new messages are dropped, DefaultQueueSize if zero.
+	QueueSize int
+	// BatchSize is the number of messages posted in one request as a JSON array, if one or less each message is
+	// posted on its own as a JSON object.
+	BatchSize int
+	// BatchInterval is the time a partial batch waits for more messages, DefaultBatchInterval if zero.
+	BatchInterval time.Duration
+	// Retry is the backoff applied when a request fails.
+	Retry pipeline.RetryConfig
+}
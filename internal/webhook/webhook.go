@@ -0,0 +1,280 @@
+// Package webhook is the pipeline output posting the JSON translation of each packet, or batches of them, to an
+// HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+
+	"github.com/na4ma4/go-slogtool"
+)
+
+// OutputPrefix is the prefix of the name of each webhook output.
+const OutputPrefix = "webhook-"
+
+const (
+	// SignatureHeader is the header holding the HMAC-SHA256 signature of the body, sha256=<hex>.
+	SignatureHeader = "X-Meshtastic-Signature"
+	// userAgent is the user agent of every request.
+	userAgent = "meshtastic-mqtt-relay"
+	// maxErrorBody is the number of bytes of an error response included in the error.
+	maxErrorBody = 512
+)
+
+var (
+	// ErrQueueFull is returned when a message is dropped because the queue of the webhook is full.
+	ErrQueueFull = errors.New("webhook queue is full")
+	// ErrPost is returned when a request fails and may succeed if retried.
+	ErrPost = errors.New("unable to post to webhook")
+	// ErrRejected is returned when the endpoint rejects a request, it is not retried.
+	ErrRejected = errors.New("webhook rejected request")
+)
+
+// NewOutput returns the output posting to the webhook, with a filter stage if the webhook has a filter.
+func NewOutput(h *Webhook) *pipeline.Output {
+	out := &pipeline.Output{
+		Name: OutputPrefix + h.Config.Name,
+		Sink: h,
+	}
+	if h.Config.Filter != nil {
+		out.Stages = append(out.Stages, pipeline.Filter(h.Config.Filter))
+	}
+
+	return out
+}
+
+// Webhook queues messages and posts them to an HTTP endpoint in the background.
+type Webhook struct {
+	Config Config
+	Logger *slog.Logger
+
+	client  *http.Client
+	queue   chan []byte
+	posted  atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// New returns a webhook, Run must be called to post the queued messages.
+func New(config Config, logger *slog.Logger) *Webhook {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	if config.BatchInterval <= 0 {
+		config.BatchInterval = DefaultBatchInterval
+	}
+	config.BatchSize = max(config.BatchSize, 1)
+
+	return &Webhook{
+		Config: config,
+		Logger: logger.With(slog.String("webhook", config.Name)),
+		client: &http.Client{Timeout: config.Timeout},
+		queue:  make(chan []byte, config.QueueSize),
+	}
+}
+
+// Write queues the JSON translation of the packet, returning ErrQueueFull if the queue is full.
+func (h *Webhook) Write(_ context.Context, pkt *pipeline.Packet) error {
+	payload, err := pkt.Message.ToJSON()
+	if err != nil {
+		return fmt.Errorf("%w: %w", pipeline.ErrEncodeJSON, err)
+	}
+
+	select {
+	case h.queue <- bytes.TrimSpace(payload):
+		return nil
+	default:
+		h.dropped.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Run posts the queued messages until the context is done, a batch that still fails after retrying is dropped.
+//
+// Call Flush after Run returns to post the remaining messages.
+func (h *Webhook) Run(ctx context.Context) {
+	for {
+		var batch [][]byte
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-h.queue:
+			batch = h.fill(ctx, append(batch, msg))
+		}
+
+		if err := h.postRetry(ctx, batch); err != nil {
+			h.Logger.ErrorContext(ctx, "Failed to post to webhook",
+				slog.Int("messages", len(batch)),
+				slogtool.ErrorAttr(err),
+			)
+		}
+	}
+}
+
+// fill adds queued messages to the batch until it is full, BatchInterval passes or the context is done.
+func (h *Webhook) fill(ctx context.Context, batch [][]byte) [][]byte {
+	if len(batch) >= h.Config.BatchSize {
+		return batch
+	}
+
+	timer := time.NewTimer(h.Config.BatchInterval)
+	defer timer.Stop()
+
+	for len(batch) < h.Config.BatchSize {
+		select {
+		case <-ctx.Done():
+			return batch
+		case <-timer.C:
+			return batch
+		case msg := <-h.queue:
+			batch = append(batch, msg)
+		}
+	}
+
+	return batch
+}
+
+// Flush posts the queued messages without waiting for more, once each.
+func (h *Webhook) Flush(ctx context.Context) error {
+	var errs []error
+	for {
+		var batch [][]byte
+	fill:
+		for len(batch) < h.Config.BatchSize {
+			select {
+			case msg := <-h.queue:
+				batch = append(batch, msg)
+			default:
+				break fill
+			}
+		}
+
+		if len(batch) == 0 {
+			return errors.Join(errs...)
+		}
+
+		if err := h.post(ctx, batch); err != nil {
+			h.failed.Add(uint64(len(batch)))
+			errs = append(errs, err)
+			continue
+		}
+		h.posted.Add(uint64(len(batch)))
+	}
+}
+
+// postRetry posts a batch, retrying with exponential backoff until the attempts are exhausted, the endpoint rejects
+// the batch or the context is done.
+func (h *Webhook) postRetry(ctx context.Context, batch [][]byte) error {
+	for attempt := 0; ; attempt++ {
+		err := h.post(ctx, batch)
+		if err == nil {
+			h.posted.Add(uint64(len(batch)))
+			return nil
+		}
+
+		if errors.Is(err, ErrRejected) || attempt+1 >= h.Config.Retry.MaxAttempts() {
+			h.failed.Add(uint64(len(batch)))
+			return err
+		}
+
+		delay := h.Config.Retry.Delay(attempt)
+		h.Logger.WarnContext(ctx, "Retrying webhook",
+			slog.Int("messages", len(batch)),
+			slog.Int("attempt", attempt+1),
+			slog.Duration("delay", delay),
+			slogtool.ErrorAttr(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			h.failed.Add(uint64(len(batch)))
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// post sends a batch, a single message as a JSON object when BatchSize is one, otherwise a JSON array.
+func (h *Webhook) post(ctx context.Context, batch [][]byte) error {
+	body := batch[0]
+	if h.Config.BatchSize > 1 {
+		body = append(append([]byte{'['}, bytes.Join(batch, []byte{','})...), ']')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for key, value := range h.Config.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(h.Config.Secret, body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPost, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	switch {
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %s: %s", ErrPost, resp.Status, strings.TrimSpace(string(respBody)))
+	default:
+		return fmt.Errorf("%w: %s: %s", ErrRejected, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+}
+
+// Sign returns the signature of the body, sha256=<hex HMAC-SHA256 of the body keyed with the secret>.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Status is the status of a webhook.
+type Status struct {
+	// Posted is the number of messages posted.
+	Posted uint64 `json:"posted"`
+	// Failed is the number of messages dropped after failing to post.
+	Failed uint64 `json:"failed"`
+	// Dropped is the number of messages dropped because the queue was full.
+	Dropped uint64 `json:"dropped"`
+	// Queued is the number of messages waiting to be posted.
+	Queued int `json:"queued"`
+}
+
+// Status returns the status of the webhook.
+func (h *Webhook) Status() Status {
+	return Status{
+		Posted:  h.posted.Load(),
+		Failed:  h.failed.Load(),
+		Dropped: h.dropped.Load(),
+		Queued:  len(h.queue),
+	}
+}
@@ -0,0 +1,271 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/broker"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/filter"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/mtypes"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/pipeline"
+	"github.com/na4ma4/meshtastic-mqtt-translate/internal/webhook"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/na4ma4/go-contextual"
+)
+
+const testTopic = "msh/ANZ/2/e/MediumFast/!44be043f"
+
+var fastRetry = pipeline.RetryConfig{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+// server is a webhook endpoint, responding with the queued status codes and then 200.
+type server struct {
+	*httptest.Server
+
+	statuses []int
+	calls    atomic.Int32
+	lock     sync.Mutex
+	headers  []http.Header
+	bodies   []string
+}
+
+func newServer(t *testing.T, statuses ...int) *server {
+	t.Helper()
+
+	s := &server{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(s.calls.Add(1)) - 1
+		if n < len(s.statuses) {
+			w.WriteHeader(s.statuses[n])
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		s.headers = append(s.headers, r.Header)
+		s.bodies = append(s.bodies, string(body))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// wait waits for n requests to be accepted, returning their bodies.
+func (s *server) wait(t *testing.T, n int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.lock.Lock()
+		if len(s.bodies) >= n {
+			bodies := append([]string(nil), s.bodies...)
+			s.lock.Unlock()
+			return bodies
+		}
+		s.lock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %d requests", n)
+	return nil
+}
+
+// status waits for the webhook to count n posted messages, returning its status.
+func status(t *testing.T, h *webhook.Webhook, n uint64) webhook.Status {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Status().Posted < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	return h.Status()
+}
+
+func loadPayload(t *testing.T, filename string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(path.Join("..", "..", "testdata", "msgs", filename+".enc"))
+	if err != nil {
+		t.Fatalf("Failed to read test case file %s: %v", filename, err)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		t.Fatalf("Failed to decode base64 message: %v", err)
+	}
+
+	return payload
+}
+
+// start runs the webhook as the only output of a pipeline, returning the pipeline.
+func start(t *testing.T, h *webhook.Webhook) *pipeline.Pipeline {
+	t.Helper()
+
+	p, err := pipeline.NewPipeline(contextual.New(t.Context()), pipeline.Config{}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+	p.AddOutput(webhook.NewOutput(h))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return p
+}
+
+func TestPost(t *testing.T) {
+	s := newServer(t)
+	h := webhook.New(webhook.Config{
+		Name:    "test",
+		URL:     s.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Secret:  "secret",
+		Filter:  filter.MustCompile(`type == "TEXT_MESSAGE_APP"`),
+		Retry:   fastRetry,
+	}, slog.New(slog.DiscardHandler))
+
+	if out := webhook.NewOutput(h); out.Name != "webhook-test" || len(out.Stages) != 1 {
+		t.Errorf("NewOutput(): got name '%s' and %d stages", out.Name, len(out.Stages))
+	}
+
+	p := start(t, h)
+	for _, name := range []string{"message-02", "message-04"} {
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, name)})
+	}
+
+	body := s.wait(t, 1)[0]
+
+	msg := &mtypes.Message{}
+	if err := json.Unmarshal([]byte(body), msg); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	if msg.Type != "TEXT_MESSAGE_APP" || msg.From != 1244681808 || msg.Payload != "Maybe Ping" {
+		t.Errorf("posted message: got '%+v'", msg)
+	}
+
+	headers := s.headers[0]
+	if got := headers.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization header: got '%s', want 'Bearer token'", got)
+	}
+	if got := headers.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type header: got '%s', want 'application/json'", got)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	if got, want := headers.Get(webhook.SignatureHeader), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature header: got '%s', want '%s'", got, want)
+	}
+
+	if got := status(t, h, 1); got.Posted != 1 {
+		t.Errorf("Status(): got '%+v', want 1 posted", got)
+	}
+}
+
+func TestPostBatch(t *testing.T) {
+	s := newServer(t)
+	h := webhook.New(webhook.Config{
+		Name:          "batch",
+		URL:           s.URL,
+		BatchSize:     2,
+		BatchInterval: time.Minute,
+		Retry:         fastRetry,
+	}, slog.New(slog.DiscardHandler))
+
+	p := start(t, h)
+	for _, name := range []string{"message-02", "message-04"} {
+		p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, name)})
+	}
+
+	var msgs []*mtypes.Message
+	if err := json.Unmarshal([]byte(s.wait(t, 1)[0]), &msgs); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+
+	var from []uint32
+	for _, msg := range msgs {
+		from = append(from, msg.From)
+	}
+	if diff := cmp.Diff([]uint32{2962229339, 1244681808}, from); diff != "" {
+		t.Errorf("batch mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPostRetry(t *testing.T) {
+	s := newServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	h := webhook.New(webhook.Config{Name: "retry", URL: s.URL, Retry: fastRetry}, slog.New(slog.DiscardHandler))
+
+	p := start(t, h)
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-04")})
+
+	s.wait(t, 1)
+	if got := s.calls.Load(); got != 3 {
+		t.Errorf("requests: got '%d', want '3'", got)
+	}
+}
+
+func TestPostRejected(t *testing.T) {
+	s := newServer(t, http.StatusBadRequest)
+	h := webhook.New(webhook.Config{Name: "rejected", URL: s.URL, Retry: fastRetry}, slog.New(slog.DiscardHandler))
+
+	p := start(t, h)
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-04")})
+	p.HandleMessage(&broker.Message{Topic: testTopic, Payload: loadPayload(t, "message-02")})
+
+	// The rejected message is dropped without retrying, the message after it is still posted.
+	s.wait(t, 1)
+	if got := s.calls.Load(); got != 2 {
+		t.Errorf("requests: got '%d', want '2'", got)
+	}
+	if got := status(t, h, 1); got.Posted != 1 || got.Failed != 1 {
+		t.Errorf("Status(): got '%+v', want 1 posted and 1 failed", got)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	s := newServer(t)
+	h := webhook.New(webhook.Config{Name: "full", URL: s.URL, QueueSize: 1}, slog.New(slog.DiscardHandler))
+
+	pkt := &pipeline.Packet{Message: &mtypes.Message{From: 1, Type: "TEXT_MESSAGE_APP", Payload: "one"}}
+	if err := h.Write(t.Context(), pkt); err != nil {
+		t.Fatalf("Write(): error got '%v', want 'nil'", err)
+	}
+	if err := h.Write(t.Context(), pkt); !errors.Is(err, webhook.ErrQueueFull) {
+		t.Fatalf("Write(): error got '%v', want '%v'", err, webhook.ErrQueueFull)
+	}
+
+	if err := h.Flush(t.Context()); err != nil {
+		t.Fatalf("Flush(): error got '%v', want 'nil'", err)
+	}
+
+	if got := h.Status(); got.Posted != 1 || got.Dropped != 1 || got.Queued != 0 {
+		t.Errorf("Status(): got '%+v', want 1 posted and 1 dropped", got)
+	}
+}